@@ -1,6 +1,6 @@
 module github.com/xufeisofly/leveldb-go
 
-go 1.18
+go 1.19
 
 require (
 	github.com/spf13/cast v1.5.1
@@ -0,0 +1,88 @@
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const kBlockSize = 4096
+
+// Arena hands out byte slices carved from large blocks so that a memtable
+// does not pay one heap allocation per entry. Memory is only released
+// when the whole Arena becomes unreachable.
+//
+// Allocate is not safe for concurrent use, AllocateConcurrently and
+// MemoryUsage are.
+type Arena struct {
+	mu sync.Mutex // guards allocation state in AllocateConcurrently
+
+	// allocation state
+	allocPtr []byte // unused tail of the current block
+
+	// all blocks allocated so far
+	blocks [][]byte
+
+	// total memory usage of the arena
+	memoryUsage uint64
+}
+
+func NewArena() *Arena {
+	return &Arena{}
+}
+
+// Allocate returns a slice of length and capacity bytes
+// REQUIRES: bytes > 0
+func (a *Arena) Allocate(bytes int) []byte {
+	if bytes <= 0 {
+		panic("arena allocate bytes <= 0")
+	}
+	if bytes <= len(a.allocPtr) {
+		result := a.allocPtr[:bytes:bytes]
+		a.allocPtr = a.allocPtr[bytes:]
+		return result
+	}
+	return a.allocateFallback(bytes)
+}
+
+// AllocateConcurrently is the same as Allocate but can be called from
+// several goroutines at the same time. It must not be mixed with
+// concurrent calls of Allocate.
+func (a *Arena) AllocateConcurrently(bytes int) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Allocate(bytes)
+}
+
+// MemoryUsage returns an estimate of the total memory usage of data
+// allocated by the arena.
+func (a *Arena) MemoryUsage() uint64 {
+	return atomic.LoadUint64(&a.memoryUsage)
+}
+
+func (a *Arena) allocateFallback(bytes int) []byte {
+	if bytes > kBlockSize/4 {
+		// Object is more than a quarter of our block size.  Allocate it separately
+		// to avoid wasting too much space in leftover bytes.
+		return a.allocateNewBlock(bytes)
+	}
+
+	// We waste the remaining space in the current block.
+	a.allocPtr = a.allocateNewBlock(kBlockSize)
+	result := a.allocPtr[:bytes:bytes]
+	a.allocPtr = a.allocPtr[bytes:]
+	return result
+}
+
+func (a *Arena) allocateNewBlock(blockBytes int) []byte {
+	result := make([]byte, blockBytes)
+	a.blocks = append(a.blocks, result)
+	a.charge(uint64(blockBytes) + uint64(unsafe.Sizeof(result)))
+	return result
+}
+
+// charge records memory that belongs to the arena's owner but can not be
+// carved out of a byte block, e.g. pointer-carrying skiplist nodes.
+func (a *Arena) charge(bytes uint64) {
+	atomic.AddUint64(&a.memoryUsage, bytes)
+}
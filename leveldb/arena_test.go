@@ -0,0 +1,64 @@
+package leveldb_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/leveldb"
+)
+
+func TestArena_Empty(t *testing.T) {
+	arena := leveldb.NewArena()
+	assert.Equal(t, uint64(0), arena.MemoryUsage())
+}
+
+func TestArena_Simple(t *testing.T) {
+	type allocated struct {
+		size int
+		data []byte
+	}
+	var allocs []allocated
+	arena := leveldb.NewArena()
+	N := 100000
+	var bytes uint64
+	rnd := rand.New(rand.NewSource(301))
+
+	for i := 0; i < N; i++ {
+		var s int
+		if i%(N/10) == 0 {
+			s = i
+		} else if rnd.Intn(4000) == 0 {
+			s = rnd.Intn(6000)
+		} else if rnd.Intn(10) == 0 {
+			s = rnd.Intn(100)
+		} else {
+			s = rnd.Intn(20)
+		}
+		if s == 0 {
+			// Our arena disallows size 0 allocations.
+			s = 1
+		}
+		r := arena.Allocate(s)
+		assert.Equal(t, s, len(r))
+		assert.Equal(t, s, cap(r))
+
+		for b := 0; b < s; b++ {
+			// Fill the "i"th allocation with a known bit pattern
+			r[b] = byte(i % 256)
+		}
+		bytes += uint64(s)
+		allocs = append(allocs, allocated{size: s, data: r})
+		assert.GreaterOrEqual(t, arena.MemoryUsage(), bytes)
+		if i > N/10 {
+			assert.LessOrEqual(t, float64(arena.MemoryUsage()), float64(bytes)*1.10)
+		}
+	}
+
+	for i, alloc := range allocs {
+		for b := 0; b < alloc.size; b++ {
+			// Check the "i"th allocation for the known bit pattern
+			assert.Equal(t, byte(i%256), alloc.data[b])
+		}
+	}
+}
@@ -6,24 +6,46 @@ type block struct {
 	data          []byte
 	size          int
 	restartOffset uint64 // offset in data of restart array
+	numRestarts   uint64
+	hashIndex     []byte // buckets of the data block hash index, nil if none
 	owned         bool   // block owns data
+	format        Format
 }
 
 func NewBlock(contents *blockContents) *block {
 	data := contents.data
 	size := len(contents.data)
 	owned := contents.heapAllocated
-	var restartOffset uint64
+	format := contents.format
+	offsetSize := format.offsetSize()
+	var restartOffset, numRestarts uint64
+	var hashIndex []byte
 
-	if size < Uint64Size {
+	if size < offsetSize {
 		size = 0
 	} else {
-		maxRestartsAllowed := int((size - Uint64Size) / Uint64Size)
-		numRestarts := util.DecodeUint64Fixed(data[(size - Uint64Size):])
-		if numRestarts > uint64(maxRestartsAllowed) {
+		numRestarts = format.decodeOffset(data[(size - offsetSize):])
+		trailerEnd := size - offsetSize
+		if flag := dataBlockHashIndexFlag(format); numRestarts&flag != 0 {
+			numRestarts &^= flag
+			if trailerEnd < Uint16Size {
+				trailerEnd = -1
+			} else {
+				numBuckets := int(format.byteOrder().Uint16(data[trailerEnd-Uint16Size:]))
+				trailerEnd -= Uint16Size + numBuckets
+				if numBuckets > 0 && trailerEnd >= 0 {
+					hashIndex = data[trailerEnd : trailerEnd+numBuckets]
+				} else {
+					trailerEnd = -1
+				}
+			}
+		}
+		if trailerEnd < 0 || numRestarts > uint64(trailerEnd/offsetSize) {
 			size = 0
+			numRestarts = 0
+			hashIndex = nil
 		} else {
-			restartOffset = uint64(size) - (1+numRestarts)*Uint64Size
+			restartOffset = uint64(trailerEnd) - numRestarts*uint64(offsetSize)
 		}
 	}
 
@@ -31,15 +53,19 @@ func NewBlock(contents *blockContents) *block {
 		data:          data,
 		size:          size,
 		restartOffset: restartOffset,
+		numRestarts:   numRestarts,
+		hashIndex:     hashIndex,
 		owned:         owned,
+		format:        format,
 	}
 }
 
 func (b *block) NumRestarts() uint64 {
-	if b.size < Uint64Size {
-		panic("block size < Uint64Size")
+	offsetSize := b.format.offsetSize()
+	if b.size < offsetSize {
+		panic("block size < offset size")
 	}
-	return util.DecodeUint64Fixed(b.data[(b.size - Uint64Size):])
+	return b.numRestarts
 }
 
 func (b *block) Size() int {
@@ -47,17 +73,28 @@ func (b *block) Size() int {
 }
 
 func (b *block) NewIterator(comp Comparator) Iterator {
-	if b.size < Uint64Size {
-		return NewEmptyIterator()
+	if b.size < b.format.offsetSize() {
+		return NewErrorIterator(Error(Code_Corruption, "bad block contents"))
 	}
 	numRestarts := b.NumRestarts()
 	if numRestarts == 0 {
 		return NewEmptyIterator()
 	}
-	return NewBlockIterator(comp, b.data, b.restartOffset, numRestarts)
+	iter := NewBlockIterator(comp, b.data, b.restartOffset, numRestarts)
+	_, internalKeys := userKeyComparator(comp)
+	if b.format == Format_LevelDB {
+		iter.format = b.format
+		iter.swapTags = internalKeys
+	}
+	if b.hashIndex != nil && numRestarts <= kMaxRestartSupportedByHashIndex+1 {
+		iter.hashIndex = b.hashIndex
+		iter.internalKeys = internalKeys
+	}
+	return iter
 }
 
 type blockIter struct {
+	cleanupList
 	comparator  Comparator
 	data        []byte // underlying block contents
 	restarts    uint64 // offset of restart array
@@ -68,6 +105,16 @@ type blockIter struct {
 	key          []byte
 	value        []byte
 	valueOffset  uint64 // offset of value of current entry
+	err          error
+
+	format Format
+	// The keys are internal keys stored with the tag of Format_LevelDB,
+	// Key() returns them converted in internalKey
+	swapTags    bool
+	internalKey []byte
+	// Buckets of the data block hash index, see seekForGet
+	hashIndex    []byte
+	internalKeys bool
 }
 
 var _ Iterator = (*blockIter)(nil)
@@ -87,7 +134,12 @@ func NewBlockIterator(comp Comparator, data []byte, restarts, numRestarts uint64
 	}
 }
 
+// compare compares the key stored in the block a to the target b.
 func (biter *blockIter) compare(a, b []byte) int8 {
+	if biter.swapTags {
+		biter.internalKey = appendSwappedTag(biter.internalKey[:0], a)
+		a = biter.internalKey
+	}
 	return biter.comparator.Compare(a, b)
 }
 
@@ -99,7 +151,8 @@ func (biter *blockIter) getRestartPoint(index uint64) uint64 {
 	if index >= biter.numRestarts {
 		panic("index >= biter.numRestarts")
 	}
-	return util.DecodeUint64Fixed(biter.data[biter.restarts+index*Uint64Size:])
+	offsetSize := uint64(biter.format.offsetSize())
+	return biter.format.decodeOffset(biter.data[biter.restarts+index*offsetSize:])
 }
 
 func (biter *blockIter) seekToRestartPoint(index uint64) {
@@ -117,10 +170,28 @@ func (biter *blockIter) Valid() bool {
 	return biter.current < biter.restarts
 }
 
+func (biter *blockIter) Error() error {
+	return biter.err
+}
+
+// Close drops the reference to the block contents
+func (biter *blockIter) Close() error {
+	biter.data = nil
+	biter.key = nil
+	biter.value = nil
+	biter.current = biter.restarts
+	biter.runCleanups()
+	return nil
+}
+
 func (biter *blockIter) Key() []byte {
 	if !biter.Valid() {
 		panic("block iterator is invalid")
 	}
+	if biter.swapTags {
+		biter.internalKey = appendSwappedTag(biter.internalKey[:0], biter.key)
+		return biter.internalKey
+	}
 	return biter.key
 }
 
@@ -232,6 +303,45 @@ func (biter *blockIter) Seek(target []byte) {
 	}
 }
 
+// seekForGet positions at the first entry >= target like Seek, for a point
+// lookup of the user key of target: with a hash index the search starts at
+// the restart interval of the user key.  Returns false, leaving the
+// iterator invalid, if the block holds no entry of the user key.
+func (biter *blockIter) seekForGet(target []byte) bool {
+	if biter.hashIndex == nil {
+		biter.Seek(target)
+		return true
+	}
+	ucmp, _ := userKeyComparator(biter.comparator)
+	userKey := target
+	if biter.internalKeys {
+		userKey = ExtractUserKey(target)
+	}
+	userKey = stripTimestamp(ucmp, userKey)
+	entry := dataBlockHashIndexLookup(biter.hashIndex, userKey)
+	if entry == kHashIndexNoEntry {
+		biter.current = biter.restarts
+		biter.restartIndex = biter.numRestarts
+		return false
+	}
+	if entry == kHashIndexCollision || uint64(entry) >= biter.numRestarts {
+		biter.Seek(target)
+		return true
+	}
+
+	// The entries of the user key start in the interval, the first one
+	// >= target may be in the following ones
+	biter.seekToRestartPoint(uint64(entry))
+	for {
+		if ok, _ := biter.parseNextKey(); !ok {
+			return false
+		}
+		if biter.compare(biter.key, target) >= 0 {
+			return true
+		}
+	}
+}
+
 func (biter *blockIter) SeekToFirst() {
 	biter.seekToRestartPoint(0)
 	biter.parseNextKey()
@@ -257,7 +367,7 @@ func (biter *blockIter) parseNextKey() (bool, error) {
 		// no more entries to return, mark as invalid
 		biter.current = biter.restarts
 		biter.restartIndex = biter.numRestarts
-		return false, Error(Code_Corruption, "")
+		return false, nil
 	}
 
 	// decode next entry
@@ -283,7 +393,8 @@ func (biter *blockIter) corruptionError() error {
 	biter.restartIndex = biter.numRestarts
 	biter.key = []byte{}
 	biter.value = []byte{}
-	return Error(Code_Corruption, "")
+	biter.err = Error(Code_Corruption, "bad entry in block")
+	return biter.err
 }
 
 // DecodeEntry decodes entry from data bytes
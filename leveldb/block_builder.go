@@ -1,6 +1,8 @@
 package leveldb
 
 import (
+	"bytes"
+
 	"github.com/xufeisofly/leveldb-go/util"
 )
 
@@ -27,25 +29,49 @@ import (
 //     restarts: uint64[num_restarts]
 //     num_restarts: uint64
 // restarts[i] contains the offset within the block of the ith restart point.
+// Both are uint32 in Format_LevelDB, whose internal keys are also stored
+// with a little-endian tag.  Data blocks may have a hash index between the
+// two, see data_block_hash_index.go.
 
 type blockBuilder struct {
-	options  *Options
-	buffer   []byte   // Destination buffer
-	restarts []uint32 // Restart points
-	counter  int      // Number of entries emitted since restart
-	finished bool     // Has Finished been called?
-	lastKey  []byte
+	options        *Options
+	buffer         []byte   // Destination buffer
+	restarts       []uint32 // Restart points
+	counter        int      // Number of entries emitted since restart
+	finished       bool     // Has Finished been called?
+	lastKey        []byte   // as stored in the block
+	internalKeys   bool
+	userComparator Comparator
+	// The keys are internal keys stored with the tag of Format_LevelDB
+	swapTags bool
+	tmpKey   []byte
+	// Hash index of the user keys, nil unless building data blocks with one
+	hashIndex *dataBlockHashIndexBuilder
 }
 
 func NewBlockBuilder(options *Options) *blockBuilder {
+	ucmp, internalKeys := userKeyComparator(options.Comparator)
 	return &blockBuilder{
-		options:  options,
-		buffer:   make([]byte, 0),
-		restarts: []uint32{0}, // first restart point is at offset 0
-		counter:  0,
-		finished: false,
-		lastKey:  make([]byte, 0),
+		options:        options,
+		buffer:         make([]byte, 0),
+		restarts:       []uint32{0}, // first restart point is at offset 0
+		counter:        0,
+		finished:       false,
+		lastKey:        make([]byte, 0),
+		internalKeys:   internalKeys,
+		userComparator: ucmp,
+		swapTags:       internalKeys && options.Format == Format_LevelDB,
+	}
+}
+
+// newDataBlockBuilder returns a builder of the data blocks of a table, with
+// the hash index selected by options.DataBlockIndexType.
+func newDataBlockBuilder(options *Options) *blockBuilder {
+	bb := NewBlockBuilder(options)
+	if options.DataBlockIndexType == DataBlockIndexType_BinarySearchAndHash {
+		bb.hashIndex = newDataBlockHashIndexBuilder(options.DataBlockHashTableUtilRatio)
 	}
+	return bb
 }
 
 // Reset the contents as if the BlockBuilder was just constructed
@@ -55,6 +81,9 @@ func (bb *blockBuilder) Reset() {
 	bb.counter = 0
 	bb.finished = false
 	bb.lastKey = make([]byte, 0)
+	if bb.hashIndex != nil {
+		bb.hashIndex.Reset()
+	}
 }
 
 // REQUIRES: Finish() has not been called since the last call to Reset()
@@ -66,11 +95,33 @@ func (bb *blockBuilder) Add(key, value []byte) error {
 	if bb.counter > bb.options.BlockRestartInternal {
 		return Error(Code_Corruption, "")
 	}
-	if len(bb.buffer) != 0 && bb.options.Comparator.Compare(key, bb.lastKey) <= 0 {
+	lastKey := bb.lastKey
+	if bb.swapTags {
+		lastKey = appendSwappedTag(bb.tmpKey[:0], bb.lastKey)
+		bb.tmpKey = lastKey
+	}
+	if len(bb.buffer) != 0 && bb.options.Comparator.Compare(key, lastKey) <= 0 {
 		// imported from immutable memtable, blockbuilder add is always ordered
 		// so key is always larger than last key
 		return Error(Code_Corruption, "")
 	}
+	if bb.hashIndex != nil {
+		userKey := key
+		if bb.internalKeys {
+			userKey = ExtractUserKey(key)
+		}
+		// All the versions of a key share their restart interval
+		userKey = stripTimestamp(bb.userComparator, userKey)
+		restartIndex := len(bb.restarts) - 1
+		if bb.counter >= bb.options.BlockRestartInternal {
+			restartIndex++ // key starts a new restart interval
+		}
+		bb.hashIndex.Add(userKey, restartIndex)
+	}
+	if bb.swapTags {
+		key = appendLevelDBKey(bb.tmpKey[:0], key)
+		bb.tmpKey = key
+	}
 	var shared int
 
 	if bb.counter < bb.options.BlockRestartInternal {
@@ -100,7 +151,7 @@ func (bb *blockBuilder) Add(key, value []byte) error {
 	bb.lastKey = bb.lastKey[:shared]
 	bb.lastKey = append(bb.lastKey, key[shared:]...)
 
-	if bb.options.Comparator.Compare(bb.lastKey, key) != 0 {
+	if !bytes.Equal(bb.lastKey, key) {
 		return Error(Code_Corruption, "")
 	}
 	bb.counter++
@@ -114,9 +165,14 @@ func (bb *blockBuilder) Add(key, value []byte) error {
 func (bb *blockBuilder) Finish() []byte {
 	// add <restart1><restart2>...<restartn><restartsNumber>
 	for _, restart := range bb.restarts {
-		util.PutUint64Fixed(&bb.buffer, uint64(restart))
+		bb.options.Format.putOffset(&bb.buffer, uint64(restart))
+	}
+	numRestarts := uint64(len(bb.restarts))
+	if bb.hashIndex != nil && bb.hashIndex.Valid() {
+		bb.hashIndex.Finish(&bb.buffer, bb.options.Format)
+		numRestarts |= dataBlockHashIndexFlag(bb.options.Format)
 	}
-	util.PutUint64Fixed(&bb.buffer, uint64(len(bb.restarts)))
+	bb.options.Format.putOffset(&bb.buffer, numRestarts)
 	bb.finished = true
 	return bb.buffer
 }
@@ -124,9 +180,14 @@ func (bb *blockBuilder) Finish() []byte {
 // Returns an estimate of the current (uncompressed) size of the block
 // we are building.
 func (bb *blockBuilder) CurrentSizeEstimate() uint64 {
-	return uint64(len(bb.buffer) + // Raw data buffer
-		len(bb.restarts)*Uint64Size + // Restart array
-		Uint64Size) // Restart array length
+	offsetSize := bb.options.Format.offsetSize()
+	estimate := len(bb.buffer) + // Raw data buffer
+		len(bb.restarts)*offsetSize + // Restart array
+		offsetSize // Restart array length
+	if bb.hashIndex != nil {
+		estimate += bb.hashIndex.EstimateSize()
+	}
+	return uint64(estimate)
 }
 
 func (bb *blockBuilder) empty() bool {
@@ -1,8 +1,11 @@
 package leveldb
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/xufeisofly/leveldb-go/util"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -119,3 +122,83 @@ func TestBlockIterator_Seek(t *testing.T) {
 	assert.True(t, bIter.Valid())
 	assert.Equal(t, entrymap[testKeys[len(testKeys)-1]], string(bIter.Value()))
 }
+
+func TestBlockIterator_SeekForGet(t *testing.T) {
+	for _, format := range []Format{Format_Native, Format_LevelDB} {
+		options := &Options{
+			Comparator:           NewInternalKeyComparator(NewBytewiseComparator()),
+			BlockRestartInternal: 2,
+			DataBlockIndexType:   DataBlockIndexType_BinarySearchAndHash,
+			Format:               format,
+		}
+		// Versions 3, 2, 1 of key%02d: the versions of a key span restart
+		// intervals every other key
+		ikey := func(i int, seq SequenceNumber) []byte {
+			return DumpInternalKey(NewParsedInternalKey([]byte(fmt.Sprintf("key%02d", i)), seq, ValueType_Value))
+		}
+		bb := newDataBlockBuilder(options)
+		for i := 0; i < 50; i += 2 {
+			for seq := SequenceNumber(3); seq > 0; seq-- {
+				assert.NoError(t, bb.Add(ikey(i, seq), []byte(fmt.Sprintf("v%d.%d", i, seq))))
+			}
+		}
+		block := NewBlock(&blockContents{data: bb.Finish(), format: format})
+		assert.NotNil(t, block.hashIndex)
+		assert.Equal(t, uint64(len(bb.restarts)), block.NumRestarts())
+
+		iter := block.NewIterator(options.Comparator).(*blockIter)
+		n := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			n++
+		}
+		assert.Equal(t, 75, n)
+
+		for i := 0; i < 50; i++ {
+			for seq := SequenceNumber(4); seq > 0; seq-- {
+				mayExist := iter.seekForGet(ikey(i, seq))
+				if i%2 == 1 {
+					// Missing keys are either told by the index or found
+					// past the user key
+					assert.True(t, !mayExist || !iter.Valid() || string(ExtractUserKey(iter.Key())) != fmt.Sprintf("key%02d", i))
+					continue
+				}
+				assert.True(t, mayExist)
+				assert.True(t, iter.Valid())
+				expected := util.Min(seq, 3)
+				assert.Equal(t, ikey(i, expected), iter.Key())
+				assert.Equal(t, fmt.Sprintf("v%d.%d", i, expected), string(iter.Value()))
+			}
+		}
+	}
+}
+
+func TestBlockIterator_Corruption(t *testing.T) {
+	bb := prepareBlockBuilder()
+	data := append([]byte{}, bb.buffer...)
+	block := NewBlock(&blockContents{
+		data: data,
+	})
+	bIter := block.NewIterator(NewBytewiseComparator())
+
+	// the end of the block is not an error
+	for bIter.SeekToFirst(); bIter.Valid(); bIter.Next() {
+	}
+	assert.NoError(t, bIter.Error())
+
+	// first entry claims to share a prefix with the previous key
+	data[0] = 5
+	bIter.SeekToFirst()
+	assert.False(t, bIter.Valid())
+	assert.True(t, bIter.Error().(*LevelError).IsCorruption())
+	assert.NoError(t, bIter.Close())
+}
+
+func TestBlockIterator_BadContents(t *testing.T) {
+	block := NewBlock(&blockContents{
+		data: []byte{1, 2, 3},
+	})
+	bIter := block.NewIterator(NewBytewiseComparator())
+	bIter.SeekToFirst()
+	assert.False(t, bIter.Valid())
+	assert.True(t, bIter.Error().(*LevelError).IsCorruption())
+}
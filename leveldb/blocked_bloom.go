@@ -0,0 +1,100 @@
+package leveldb
+
+// kCacheLineSize is the size in bytes of the blocks of a blocked bloom filter.
+const kCacheLineSize = 64
+
+type blockedBloomFilterPolicy struct {
+	bitsPerKey int
+	k          int
+}
+
+var _ FilterPolicy = (*blockedBloomFilterPolicy)(nil)
+
+// NewBlockedBloomFilterPolicy returns a new filter policy that uses a
+// cache-local blocked bloom filter with approximately the specified number
+// of bits per key.  The filter is split into 64-byte blocks and all the
+// probes for a key fall into the block selected by its hash, so a lookup
+// costs a single cache miss instead of up to k for NewBloomFilterPolicy().
+// In exchange the false positive rate is slightly higher for the same
+// number of bits per key, since keys are unevenly spread over the blocks.
+//
+// The filters are not compatible with the ones of NewBloomFilterPolicy(),
+// the policy has its own name so both can coexist in a DB.  The note of
+// NewBloomFilterPolicy() about custom comparators applies as well.
+func NewBlockedBloomFilterPolicy(bitsPerKey int) FilterPolicy {
+	k := int(float64(bitsPerKey) * 0.69) // 0.69 =~ ln(2)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	return &blockedBloomFilterPolicy{
+		bitsPerKey: bitsPerKey,
+		k:          k,
+	}
+}
+
+func (f *blockedBloomFilterPolicy) Name() string {
+	return "leveldb.BlockedBloomFilter"
+}
+
+// blockedBloomProbes returns the block of a key hash among numBlocks and
+// the first bit position and the delta of its probes in the block.
+func blockedBloomProbes(h uint32, numBlocks int) (int, uint32, uint32) {
+	// The high bits of h select the block (multiply-shift instead of a
+	// modulo), a remix of h feeds the double-hashing of the probes.
+	block := int((uint64(h) * uint64(numBlocks)) >> 32)
+	h2 := h * 0x9e3779b9
+	delta := (h2 >> 17) | (h2 << 15) | 1 // odd so probes do not cycle early
+	return block, h2, delta
+}
+
+func (f *blockedBloomFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
+	// Compute the number of blocks, at least one
+	n := len(keys)
+	bits := n * f.bitsPerKey
+	numBlocks := (bits + kCacheLineSize*8 - 1) / (kCacheLineSize * 8)
+	if numBlocks < 1 {
+		numBlocks = 1
+	}
+
+	array := make([]byte, numBlocks*kCacheLineSize)
+	for i := 0; i < n; i++ {
+		block, h, delta := blockedBloomProbes(BloomHash(keys[i]), numBlocks)
+		line := array[block*kCacheLineSize : (block+1)*kCacheLineSize]
+		for j := 0; j < f.k; j++ {
+			bitpos := h % (kCacheLineSize * 8)
+			line[bitpos/8] |= (1 << (bitpos % 8))
+			h += delta
+		}
+	}
+	// encode k into end of filter array
+	array = append(array, byte(f.k))
+	*dst = append(*dst, array...)
+}
+
+func (f *blockedBloomFilterPolicy) KeyMayMatch(key, filter []byte) bool {
+	l := len(filter)
+	if l < kCacheLineSize+1 || (l-1)%kCacheLineSize != 0 {
+		return false
+	}
+
+	// Use the encoded k so that we can read filters generated by
+	// filters created using different parameters.
+	k := int(filter[l-1])
+	if k > 30 {
+		return true
+	}
+
+	block, h, delta := blockedBloomProbes(BloomHash(key), (l-1)/kCacheLineSize)
+	line := filter[block*kCacheLineSize : (block+1)*kCacheLineSize]
+	for j := 0; j < k; j++ {
+		bitpos := h % (kCacheLineSize * 8)
+		if (line[bitpos/8] & (1 << (bitpos % 8))) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
@@ -5,6 +5,7 @@ import "github.com/xufeisofly/leveldb-go/util"
 type bloomFilterPolicy struct {
 	bitsPerKey int
 	k          int
+	hash       func(key []byte) uint32 // BloomHash unless set
 }
 
 var _ FilterPolicy = (*bloomFilterPolicy)(nil)
@@ -37,6 +38,26 @@ func NewBloomFilterPolicy(bitsPerKey int) FilterPolicy {
 	}
 }
 
+// forFormat returns the policy building the bloom filters of format: the
+// filters of Format_LevelDB hash the keys with the hash of the C++ library.
+func (f *bloomFilterPolicy) forFormat(format Format) FilterPolicy {
+	if format != Format_LevelDB {
+		return f
+	}
+	policy := *f
+	policy.hash = func(key []byte) uint32 {
+		return util.LevelDBHash(key, 0xbc9f1d34)
+	}
+	return &policy
+}
+
+func (f *bloomFilterPolicy) bloomHash(key []byte) uint32 {
+	if f.hash != nil {
+		return f.hash(key)
+	}
+	return BloomHash(key)
+}
+
 func (f *bloomFilterPolicy) Name() string {
 	return "leveldb.BuiltinBloomFilter2"
 }
@@ -58,7 +79,7 @@ func (f *bloomFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
 	for i := 0; i < n; i++ {
 		// Use double-hashing to generate a sequence of hash values.
 		// See analysis in [Kirsch,Mitzenmacher 2006].
-		h := BloomHash(keys[i])
+		h := f.bloomHash(keys[i])
 		delta := (h >> 17) | (h << 15)
 		for j := 0; j < f.k; j++ {
 			bitpos := h % uint32(bits)
@@ -86,7 +107,7 @@ func (f *bloomFilterPolicy) KeyMayMatch(key, bloomFilter []byte) bool {
 		return true
 	}
 
-	h := BloomHash(key)
+	h := f.bloomHash(key)
 	delta := (h >> 17) | (h << 15)
 	for j := 0; j < k; j++ {
 		bitpos := h % uint32(bits)
@@ -19,8 +19,12 @@ type bloomTest struct {
 }
 
 func NewBloomTest() *bloomTest {
+	return newBloomTestWithPolicy(leveldb.NewBloomFilterPolicy(10))
+}
+
+func newBloomTestWithPolicy(policy leveldb.FilterPolicy) *bloomTest {
 	return &bloomTest{
-		policy: leveldb.NewBloomFilterPolicy(10),
+		policy: policy,
 	}
 }
 
@@ -151,3 +155,102 @@ func TestBloom_VaryingLengths(t *testing.T) {
 	}
 	assert.Less(t, mediocreFilters, goodFilters/5)
 }
+
+func TestBlockedBloom_Name(t *testing.T) {
+	assert.NotEqual(t, leveldb.NewBloomFilterPolicy(10).Name(), leveldb.NewBlockedBloomFilterPolicy(10).Name())
+}
+
+func TestBlockedBloom_EmptyFilter(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewBlockedBloomFilterPolicy(10))
+	assert.True(t, !b.Matches([]byte("hello")))
+	assert.True(t, !b.Matches([]byte("world")))
+}
+
+func TestBlockedBloom_Small(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewBlockedBloomFilterPolicy(10))
+	b.Add([]byte("hello"))
+	b.Add([]byte("world"))
+	assert.True(t, b.Matches([]byte("hello")))
+	assert.True(t, b.Matches([]byte("world")))
+	assert.True(t, !b.Matches([]byte("x")))
+	assert.True(t, !b.Matches([]byte("foo")))
+}
+
+func TestBlockedBloom_VaryingLengths(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewBlockedBloomFilterPolicy(10))
+
+	for length := 1; length < 10000; length = nextLength(length) {
+		b.Reset()
+		for i := 0; i < length; i++ {
+			b.Add(key(i))
+		}
+		b.Build()
+
+		// Whole cache lines plus the encoded k
+		assert.LessOrEqual(t, b.FilterSize(), (length*10/8+64)/64*64+1, length)
+
+		// All added keys must match
+		for i := 0; i < length; i++ {
+			assert.True(t, b.Matches(key(i)), fmt.Sprintf("Length %d; key %d", length, i))
+		}
+
+		// Check false positive rate
+		rate := b.FalsePositiveRate()
+		if kVerbose >= 1 {
+			fmt.Fprintf(os.Stderr, "False positives: %5.2f%% @ length = %6d ; bytes = %6d\n", rate*100.0, length, b.FilterSize())
+		}
+		assert.Less(t, rate, 0.03) // Must not be over 3%
+	}
+}
+
+// benchmarkFilterKeyMayMatch measures the lookups of present and absent keys
+// in a filter of n keys, large enough not to fit in the CPU caches, and
+// reports the measured false positive rate.
+func benchmarkFilterKeyMayMatch(b *testing.B, policy leveldb.FilterPolicy) {
+	const n = 1 << 20
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	var filter []byte
+	policy.CreateFilter(keys, &filter)
+
+	absent := make([][]byte, 1<<16)
+	present := make([][]byte, 1<<16)
+	for i := range absent {
+		absent[i] = key(i + 1000000000)
+		present[i] = keys[(i*7919)%n] // spread over the filter
+	}
+
+	lookup := func(b *testing.B, probes [][]byte) int {
+		var matches int
+		for i := 0; i < b.N; i++ {
+			if policy.KeyMayMatch(probes[i%len(probes)], filter) {
+				matches++
+			}
+		}
+		return matches
+	}
+	b.Run("present", func(b *testing.B) {
+		lookup(b, present)
+	})
+	b.Run("absent", func(b *testing.B) {
+		lookup(b, absent)
+		var fp int
+		for _, k := range absent {
+			if policy.KeyMayMatch(k, filter) {
+				fp++
+			}
+		}
+		b.ReportMetric(float64(fp)/float64(len(absent))*100, "fp%")
+		b.ReportMetric(float64(len(filter)*8)/n, "bits/key")
+	})
+}
+
+func BenchmarkBloom_KeyMayMatch(b *testing.B) {
+	benchmarkFilterKeyMayMatch(b, leveldb.NewBloomFilterPolicy(10))
+}
+
+func BenchmarkBlockedBloom_KeyMayMatch(b *testing.B) {
+	benchmarkFilterKeyMayMatch(b, leveldb.NewBlockedBloomFilterPolicy(10))
+}
@@ -0,0 +1,72 @@
+package leveldb
+
+// BuildTable builds a Table file from the contents of iter and the range
+// tombstones, sorted by start.  The generated file
+// will be named according to meta.number.  On success, the rest of
+// meta will be filled with metadata about the generated table.
+// If no data is present in iter nor tombstones, meta.fileSize will be set to
+// zero, and no Table file will be produced.
+func BuildTable(dbname string, env *Env, options *Options, tableCache *tableCache,
+	iter Iterator, tombstones []rangeTombstone, meta *FileMetaData) error {
+	meta.fileSize = 0
+	iter.SeekToFirst()
+
+	fname := TableFileName(dbname, meta.number)
+	var err error
+	if iter.Valid() || len(tombstones) > 0 {
+		var file WritableFile
+		file, err = env.NewWritableFile(fname)
+		if err != nil {
+			return err
+		}
+
+		builder := NewTableBuilder(options, file)
+		meta.smallest, meta.largest = nil, nil
+		if iter.Valid() {
+			meta.smallest = append([]byte{}, iter.Key()...)
+		}
+		var key []byte
+		for ; iter.Valid(); iter.Next() {
+			key = iter.Key()
+			builder.Add(key, iter.Value())
+		}
+		if len(key) != 0 {
+			meta.largest = append([]byte{}, key...)
+		}
+		for i := range tombstones {
+			builder.AddRangeTombstone(tombstones[i].internalKey(), tombstones[i].end)
+			extendBoundsToRangeTombstone(options.Comparator, &meta.smallest, &meta.largest, &tombstones[i])
+		}
+
+		// Finish and check for builder errors
+		err = iter.Error()
+		if err == nil {
+			err = builder.Finish()
+		} else {
+			builder.Abandon()
+		}
+		if err == nil {
+			meta.fileSize = builder.FileSize()
+		}
+
+		// Finish and check for file errors
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err == nil {
+			// Verify that the table is usable
+			it := tableCache.NewIterator(&ReadOptions{}, meta.number, meta.fileSize)
+			err = it.Error()
+			it.Close()
+		}
+	}
+
+	if err != nil || meta.fileSize == 0 {
+		env.RemoveFile(fname)
+	}
+	return err
+}
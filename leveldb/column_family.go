@@ -0,0 +1,191 @@
+package leveldb
+
+import "sort"
+
+// The default column family holds the entries written without a column
+// family, it always exists and cannot be dropped.
+const (
+	kDefaultColumnFamily     = 0
+	kDefaultColumnFamilyName = "default"
+)
+
+// ColumnFamilyOptions are the options a column family does not share with
+// the other families of the DB, the other Options of the DB apply to all of
+// them.
+type ColumnFamilyOptions struct {
+	// Orders the keys of the family, it must have the same name each time
+	// the DB is opened.
+	//
+	// Default: NewBytewiseComparator()
+	Comparator Comparator
+	// See Options.FilterPolicy
+	FilterPolicy FilterPolicy
+	// See Options.Compression
+	Compression CompressionType
+	// Size of the memtable of the family, see Options.WriteBufferSize
+	WriteBufferSize uint64
+}
+
+// ColumnFamilyDescriptor is a column family to open along with the DB, see
+// OpenColumnFamilies.
+type ColumnFamilyDescriptor struct {
+	Name    string
+	Options *ColumnFamilyOptions
+}
+
+// ColumnFamilyHandle refers to a column family of an open DB.  The handles
+// of the families created by CreateColumnFamily are returned by
+// OpenColumnFamilies when the DB is opened again.
+type ColumnFamilyHandle struct {
+	cfd *columnFamilyData
+}
+
+// ID returns the id of the column family, which tags its entries in the
+// WriteBatches.
+func (h *ColumnFamilyHandle) ID() uint32 {
+	return h.cfd.id
+}
+
+// Name returns the name of the column family.
+func (h *ColumnFamilyHandle) Name() string {
+	return h.cfd.name
+}
+
+// columnFamilyData is the state of a column family: its memtables and its
+// Versions, whose tables are apart from the ones of the other families.
+// All the families share the log and the MANIFEST of the DB.
+type columnFamilyData struct {
+	// Constant after construction
+	id      uint32
+	name    string
+	icmp    *internalKeyComparator
+	options *Options // options.Comparator == icmp
+
+	// tableCache provides its own synchronization
+	tableCache *tableCache
+
+	// State below is protected by the DB mutex
+	mem *MemTable
+	imm *MemTable // Memtable being compacted
+	// The log mem started at, which becomes logNumber once imm is compacted
+	memLogNumber uint64
+	// The entries of the family in the logs before logNumber are all in
+	// tables
+	logNumber uint64
+	dropped   bool
+
+	versions map[*Version]struct{} // live versions
+	current  *Version              // == newest live version, nil once dropped
+
+	// Per-level key at which the next compaction at that level should start.
+	// Either an empty string, or a valid InternalKey.
+	compactPointer [kNumLevels][]byte
+
+	// With a TimestampedComparator, the timestamp below which compactions
+	// keep only the newest version of a key, see IncreaseFullHistoryTsLow
+	fullHistoryTsLow []byte
+
+	stats [kNumLevels]compactionStats
+}
+
+// defaultColumnFamilyOptions returns the options of the default column
+// family, the ones of the DB.
+func defaultColumnFamilyOptions(options *Options) *ColumnFamilyOptions {
+	return &ColumnFamilyOptions{
+		Comparator:      options.Comparator,
+		FilterPolicy:    options.FilterPolicy,
+		Compression:     options.Compression,
+		WriteBufferSize: options.WriteBufferSize,
+	}
+}
+
+// newColumnFamilyData returns the column family id named name, with the
+// options of the DB, dbOptions, overridden by cfOptions.
+func newColumnFamilyData(id uint32, name, dbname string, dbOptions *Options, cfOptions *ColumnFamilyOptions) *columnFamilyData {
+	rawOptions := *dbOptions
+	rawOptions.Comparator = cfOptions.Comparator
+	if rawOptions.Comparator == nil {
+		rawOptions.Comparator = NewBytewiseComparator()
+	}
+	rawOptions.FilterPolicy = cfOptions.FilterPolicy
+	rawOptions.Compression = cfOptions.Compression
+	rawOptions.WriteBufferSize = cfOptions.WriteBufferSize
+	if id != kDefaultColumnFamily {
+		// The full history low is given for the comparator of the DB
+		rawOptions.FullHistoryTsLow = nil
+	}
+
+	icmp := NewInternalKeyComparator(rawOptions.Comparator)
+	var ipolicy *internalFilterPolicy
+	if rawOptions.FilterPolicy != nil {
+		ipolicy = newInternalFilterPolicy(rawOptions.FilterPolicy, timestampSize(rawOptions.Comparator))
+	}
+	options := sanitizeOptions(dbname, icmp, ipolicy, &rawOptions)
+	cfd := &columnFamilyData{
+		id:               id,
+		name:             name,
+		icmp:             icmp,
+		options:          options,
+		tableCache:       newTableCache(dbname, options),
+		versions:         make(map[*Version]struct{}),
+		fullHistoryTsLow: rawOptions.FullHistoryTsLow,
+	}
+	cfd.appendVersion(newVersion(cfd))
+	return cfd
+}
+
+func (cfd *columnFamilyData) newMemTable() *MemTable {
+	if cfd.options.AllowConcurrentMemtableWrite {
+		return NewConcurrentMemTable(cfd.icmp.comparator)
+	}
+	return NewMemTable(cfd.icmp.comparator)
+}
+
+func (cfd *columnFamilyData) appendVersion(v *Version) {
+	// Make "v" current
+	if v.refs != 0 {
+		panic("new version refs != 0")
+	}
+	if v == cfd.current {
+		panic("version is already current")
+	}
+	if cfd.current != nil {
+		cfd.current.Unref()
+	}
+	cfd.current = v
+	v.Ref()
+	cfd.versions[v] = struct{}{}
+}
+
+// drop marks the column family as dropped, its tables are obsolete once
+// the versions pinned by iterators are released.
+func (cfd *columnFamilyData) drop() {
+	cfd.dropped = true
+	cfd.current.Unref()
+	cfd.current = nil
+	cfd.mem = nil
+	cfd.imm = nil
+}
+
+// liveColumnFamilies returns the column families not dropped, by id.
+func (vs *VersionSet) liveColumnFamilies() []*columnFamilyData {
+	var live []*columnFamilyData
+	for _, cfd := range vs.columnFamilies {
+		if !cfd.dropped {
+			live = append(live, cfd)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].id < live[j].id })
+	return live
+}
+
+// columnFamilyByName returns the column family named name, nil if there is
+// none.
+func (vs *VersionSet) columnFamilyByName(name string) *columnFamilyData {
+	for _, cfd := range vs.columnFamilies {
+		if !cfd.dropped && cfd.name == name {
+			return cfd
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package leveldb
+
+// CompactionFilter lets the application drop or rewrite entries as they
+// are compacted, e.g. to garbage collect the records its business rules
+// consider obsolete.
+//
+// The filter is called for the newest entry of each user key of a
+// compaction, the one reads see, and not for deleted or expired keys.  The
+// older entries are hidden from every reader and dropped by the compaction
+// anyway.  The entries a live snapshot sees are not filtered, so the reads
+// as of the snapshot stay repeatable.  Open iterators keep reading the
+// tables they pinned, unfiltered.
+//
+// Filter is called from the background compaction goroutine, concurrently
+// with the reads and writes of the DB.
+type CompactionFilter interface {
+	// Name returns the name of the filter, for logging.
+	Name() string
+
+	// Filter decides the fate of the entry key->existingValue compacted
+	// from level.  With CompactionDecision_ChangeValue the entry gets
+	// newValue.  A removed entry is replaced by a deletion marker, so the
+	// older entries of the key in lower levels stay hidden.
+	// key and existingValue are only valid during the call.
+	Filter(level int, key, existingValue []byte) (decision CompactionDecision, newValue []byte)
+}
@@ -2,6 +2,7 @@ package leveldb
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/xufeisofly/leveldb-go/util"
 )
@@ -70,6 +71,265 @@ func (c *bytewiseComparator) FindShortSuccessor(key *[]byte) error {
 	return nil
 }
 
+type reverseBytewiseComparator struct{}
+
+var _ Comparator = (*reverseBytewiseComparator)(nil)
+
+// NewReverseBytewiseComparator returns a comparator ordering the keys in
+// decreasing lexicographic byte order.
+func NewReverseBytewiseComparator() *reverseBytewiseComparator {
+	return &reverseBytewiseComparator{}
+}
+
+func (c *reverseBytewiseComparator) Compare(a, b []byte) int8 {
+	return int8(-bytes.Compare(a, b))
+}
+
+func (c *reverseBytewiseComparator) Name() string {
+	return "leveldb.ReverseBytewiseComparator"
+}
+
+// FindShortestSeparator truncates *start after the first byte it differs
+// from limit at, if that keeps it < limit
+// eg.
+// *start: helloZookeeper
+// limit: helloWorld
+// because *start < limit in reverse order, start -> helloZ
+func (c *reverseBytewiseComparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	minLen := util.Min(len(*start), len(limit))
+	// Find length of common prefix
+	var diffIdx int
+	for diffIdx < minLen && (*start)[diffIdx] == limit[diffIdx] {
+		diffIdx++
+	}
+
+	if diffIdx >= minLen {
+		// Do not shorten if one string is a prefix of the other
+		return nil
+	}
+	if (*start)[diffIdx] > limit[diffIdx] && diffIdx+1 < len(*start) {
+		// A prefix of start is before start in bytewise order, so after
+		// it in reverse order
+		*start = (*start)[:diffIdx+1]
+		if c.Compare(*start, limit) >= 0 {
+			return Error(Code_Corruption, "start >= limit")
+		}
+	}
+	return nil
+}
+
+// FindShortSuccessor change key to its first byte, which is after key in
+// reverse order
+func (c *reverseBytewiseComparator) FindShortSuccessor(key *[]byte) error {
+	if len(*key) > 1 {
+		*key = (*key)[:1]
+	}
+	return nil
+}
+
+type uint64Comparator struct{}
+
+var _ Comparator = (*uint64Comparator)(nil)
+
+// NewUint64Comparator returns a comparator of keys that are big-endian
+// fixed64 integers, in increasing numerical order.  Keys of another length,
+// e.g. an empty seek target, are ordered by length first: the shorter keys
+// are before the fixed64 ones and the longer ones after, keys of the same
+// length are compared bytewise.
+func NewUint64Comparator() *uint64Comparator {
+	return &uint64Comparator{}
+}
+
+func (c *uint64Comparator) Compare(a, b []byte) int8 {
+	if len(a) != Uint64Size || len(b) != Uint64Size {
+		if len(a) < len(b) {
+			return -1
+		} else if len(a) > len(b) {
+			return +1
+		}
+		return int8(bytes.Compare(a, b))
+	}
+	x, y := util.DecodeUint64Fixed(a), util.DecodeUint64Fixed(b)
+	if x < y {
+		return -1
+	} else if x > y {
+		return +1
+	}
+	return 0
+}
+
+func (c *uint64Comparator) Name() string {
+	return "leveldb.Uint64Comparator"
+}
+
+// FindShortestSeparator leaves *start as is: keys are fixed-width
+func (c *uint64Comparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	return nil
+}
+
+// FindShortSuccessor leaves key as is: keys are fixed-width
+func (c *uint64Comparator) FindShortSuccessor(key *[]byte) error {
+	return nil
+}
+
+type lengthThenBytesComparator struct{}
+
+var _ Comparator = (*lengthThenBytesComparator)(nil)
+
+// NewLengthThenBytesComparator returns a comparator ordering the keys by
+// increasing length, then in lexicographic byte order.
+func NewLengthThenBytesComparator() *lengthThenBytesComparator {
+	return &lengthThenBytesComparator{}
+}
+
+func (c *lengthThenBytesComparator) Compare(a, b []byte) int8 {
+	if len(a) < len(b) {
+		return -1
+	} else if len(a) > len(b) {
+		return +1
+	}
+	return int8(bytes.Compare(a, b))
+}
+
+func (c *lengthThenBytesComparator) Name() string {
+	return "leveldb.LengthThenBytesComparator"
+}
+
+// FindShortestSeparator leaves *start as is: every shorter key is before it
+func (c *lengthThenBytesComparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	return nil
+}
+
+// FindShortSuccessor leaves key as is: every shorter key is before it
+func (c *lengthThenBytesComparator) FindShortSuccessor(key *[]byte) error {
+	return nil
+}
+
+// TimestampedComparator is implemented by the comparators of user keys
+// ending with a fixed-size timestamp, see NewTimestampComparator.
+type TimestampedComparator interface {
+	Comparator
+	// TimestampSize returns the size of the timestamps of the keys
+	TimestampSize() int
+	// CompareWithoutTimestamp compares a and b ignoring their timestamps
+	CompareWithoutTimestamp(a, b []byte) int8
+}
+
+type timestampComparator struct {
+	comparator    Comparator
+	timestampSize int
+}
+
+var _ TimestampedComparator = (*timestampComparator)(nil)
+
+// NewTimestampComparator returns a comparator of user keys made of a key
+// followed by a timestamp of timestampSize bytes, e.g. a big-endian fixed64
+// clock.  Keys are ordered by c, then by decreasing timestamp in
+// lexicographic byte order so that the newest version of a key comes
+// first.
+func NewTimestampComparator(c Comparator, timestampSize int) *timestampComparator {
+	return &timestampComparator{
+		comparator:    c,
+		timestampSize: timestampSize,
+	}
+}
+
+// split returns the key and the timestamp of a user key.
+func (c *timestampComparator) split(key []byte) ([]byte, []byte) {
+	n := len(key) - c.timestampSize
+	if n < 0 {
+		n = 0
+	}
+	return key[:n], key[n:]
+}
+
+func (c *timestampComparator) Compare(a, b []byte) int8 {
+	ka, tsa := c.split(a)
+	kb, tsb := c.split(b)
+	r := c.comparator.Compare(ka, kb)
+	if r == 0 {
+		r = int8(-bytes.Compare(tsa, tsb))
+	}
+	return r
+}
+
+func (c *timestampComparator) CompareWithoutTimestamp(a, b []byte) int8 {
+	ka, _ := c.split(a)
+	kb, _ := c.split(b)
+	return c.comparator.Compare(ka, kb)
+}
+
+func (c *timestampComparator) TimestampSize() int {
+	return c.timestampSize
+}
+
+func (c *timestampComparator) Name() string {
+	return fmt.Sprintf("%s.ts%d", c.comparator.Name(), c.timestampSize)
+}
+
+// FindShortestSeparator shortens the key part of *start with the wrapped
+// comparator, followed by the newest timestamp
+func (c *timestampComparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	startKey, _ := c.split(*start)
+	limitKey, _ := c.split(limit)
+	short := append([]byte{}, startKey...)
+	if err := c.comparator.FindShortestSeparator(&short, limitKey); err != nil {
+		return err
+	}
+	if len(short) < len(startKey) && c.comparator.Compare(startKey, short) < 0 &&
+		c.comparator.Compare(short, limitKey) < 0 {
+		*start = append(short, maxTimestamp(c.timestampSize)...)
+	}
+	return nil
+}
+
+// FindShortSuccessor shortens the key part of key with the wrapped
+// comparator, followed by the newest timestamp
+func (c *timestampComparator) FindShortSuccessor(key *[]byte) error {
+	k, _ := c.split(*key)
+	short := append([]byte{}, k...)
+	if err := c.comparator.FindShortSuccessor(&short); err != nil {
+		return err
+	}
+	if len(short) < len(k) && c.comparator.Compare(k, short) < 0 {
+		*key = append(short, maxTimestamp(c.timestampSize)...)
+	}
+	return nil
+}
+
+// maxTimestamp returns the newest timestamp of size bytes, the first one
+// in the order of timestampComparator.
+func maxTimestamp(size int) []byte {
+	return bytes.Repeat([]byte{0xff}, size)
+}
+
+// timestampSize returns the size of the timestamps of the user keys of
+// ucmp, 0 if they have none.
+func timestampSize(ucmp Comparator) int {
+	if tc, ok := ucmp.(TimestampedComparator); ok {
+		return tc.TimestampSize()
+	}
+	return 0
+}
+
+// stripTimestamp returns userKey without the timestamp of ucmp, if any.
+func stripTimestamp(ucmp Comparator, userKey []byte) []byte {
+	n := timestampSize(ucmp)
+	if n == 0 || len(userKey) < n {
+		return userKey
+	}
+	return userKey[:len(userKey)-n]
+}
+
+// sameUserKey returns true if a and b are versions of the same key, that
+// is equal ignoring their timestamps.
+func sameUserKey(ucmp Comparator, a, b []byte) bool {
+	if tc, ok := ucmp.(TimestampedComparator); ok {
+		return tc.CompareWithoutTimestamp(a, b) == 0
+	}
+	return ucmp.Compare(a, b) == 0
+}
+
 type internalKeyComparator struct {
 	comparator Comparator
 }
@@ -115,10 +375,10 @@ func (ic *internalKeyComparator) FindShortestSeparator(start *[]byte, limit []by
 	// if userStart is successfully shorten
 	if len(userStartShort) < len(userStart) && ic.comparator.Compare(userStartShort, userStart) > 0 {
 		userStartShort = append(userStartShort, util.EncodeUint64Fixed(PackSequenceAndType(KMaxSequenceNumber, ValueType_ForSeek))...)
-		if ic.comparator.Compare(*start, userStartShort) >= 0 {
+		if ic.Compare(*start, userStartShort) >= 0 {
 			return Error(Code_Corruption, "")
 		}
-		if ic.comparator.Compare(userStartShort, limit) >= 0 {
+		if ic.Compare(userStartShort, limit) >= 0 {
 			return Error(Code_Corruption, "")
 		}
 		*start = userStartShort
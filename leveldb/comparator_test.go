@@ -70,3 +70,91 @@ func TestInternalKeyComparator_ShortestSuccessor(t *testing.T) {
 		IKey([]byte("\xff\xff"), 100, leveldb.ValueType_Value),
 		ShortSuccessor(IKey([]byte("\xff\xff"), 100, leveldb.ValueType_Value)))
 }
+
+func TestReverseBytewiseComparator(t *testing.T) {
+	c := leveldb.NewReverseBytewiseComparator()
+	assert.Equal(t, int8(1), c.Compare([]byte("a"), []byte("b")))
+	assert.Equal(t, int8(-1), c.Compare([]byte("ab"), []byte("a")))
+	assert.Equal(t, int8(0), c.Compare([]byte("a"), []byte("a")))
+
+	shorten := func(s, l string) string {
+		start := []byte(s)
+		assert.NoError(t, c.FindShortestSeparator(&start, []byte(l)))
+		assert.True(t, c.Compare([]byte(s), start) <= 0)
+		assert.True(t, c.Compare(start, []byte(l)) < 0)
+		return string(start)
+	}
+	assert.Equal(t, "helloZ", shorten("helloZookeeper", "helloWorld"))
+	assert.Equal(t, "helloX", shorten("helloX", "helloWorld"))
+	assert.Equal(t, "foobar", shorten("foobar", "foo"))
+
+	key := []byte("foo")
+	assert.NoError(t, c.FindShortSuccessor(&key))
+	assert.Equal(t, "f", string(key))
+
+	// Through the internal key comparator
+	ic := leveldb.NewInternalKeyComparator(c)
+	start := IKey([]byte("helloZookeeper"), 100, leveldb.ValueType_Value)
+	assert.NoError(t, ic.FindShortestSeparator(&start, IKey([]byte("helloWorld"), 200, leveldb.ValueType_Value)))
+	assert.Equal(t, IKey([]byte("helloZ"), leveldb.KMaxSequenceNumber, leveldb.ValueType_ForSeek), start)
+	key = IKey([]byte("foo"), 100, leveldb.ValueType_Value)
+	assert.NoError(t, ic.FindShortSuccessor(&key))
+	assert.Equal(t, IKey([]byte("f"), leveldb.KMaxSequenceNumber, leveldb.ValueType_ForSeek), key)
+}
+
+func TestUint64Comparator(t *testing.T) {
+	c := leveldb.NewUint64Comparator()
+	u64 := func(v uint64) []byte {
+		return []byte{byte(v >> 56), byte(v >> 48), byte(v >> 40), byte(v >> 32),
+			byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	assert.Equal(t, int8(-1), c.Compare(u64(1), u64(2)))
+	assert.Equal(t, int8(-1), c.Compare(u64(255), u64(256)))
+	assert.Equal(t, int8(1), c.Compare(u64(1<<63), u64(1<<62)))
+	assert.Equal(t, int8(0), c.Compare(u64(42), u64(42)))
+
+	// Other lengths are ordered by length first
+	assert.Equal(t, int8(-1), c.Compare([]byte{}, u64(0)))
+	assert.Equal(t, int8(-1), c.Compare([]byte{0xff}, u64(0)))
+	assert.Equal(t, int8(1), c.Compare(append(u64(0), 0), u64(1<<63)))
+	assert.Equal(t, int8(-1), c.Compare([]byte{1, 2}, []byte{1, 3}))
+	assert.Equal(t, int8(0), c.Compare([]byte{1}, []byte{1}))
+
+	start := u64(100)
+	assert.NoError(t, c.FindShortestSeparator(&start, u64(200)))
+	assert.Equal(t, u64(100), start)
+	key := u64(100)
+	assert.NoError(t, c.FindShortSuccessor(&key))
+	assert.Equal(t, u64(100), key)
+}
+
+func TestLengthThenBytesComparator(t *testing.T) {
+	c := leveldb.NewLengthThenBytesComparator()
+	assert.Equal(t, int8(-1), c.Compare([]byte("z"), []byte("aa")))
+	assert.Equal(t, int8(1), c.Compare([]byte("ab"), []byte("aa")))
+	assert.Equal(t, int8(-1), c.Compare([]byte(""), []byte("a")))
+	assert.Equal(t, int8(0), c.Compare([]byte("ab"), []byte("ab")))
+
+	start := []byte("zz")
+	assert.NoError(t, c.FindShortestSeparator(&start, []byte("aaaa")))
+	assert.Equal(t, "zz", string(start))
+	key := []byte("zz")
+	assert.NoError(t, c.FindShortSuccessor(&key))
+	assert.Equal(t, "zz", string(key))
+}
+
+func TestTimestampComparator(t *testing.T) {
+	c := leveldb.NewTimestampComparator(leveldb.NewBytewiseComparator(), 2)
+	assert.Equal(t, "leveldb.BytewiseComparator.ts2", c.Name())
+	assert.Equal(t, int8(-1), c.Compare([]byte("a\x00\x09"), []byte("b\x00\x10")))
+	// Newer timestamps first
+	assert.Equal(t, int8(-1), c.Compare([]byte("a\x00\x10"), []byte("a\x00\x09")))
+	assert.Equal(t, int8(0), c.CompareWithoutTimestamp([]byte("a\x00\x10"), []byte("a\x00\x09")))
+
+	start := []byte("abcdef\x00\x01")
+	assert.NoError(t, c.FindShortestSeparator(&start, []byte("abzz\x00\x02")))
+	assert.Equal(t, "abd\xff\xff", string(start))
+	key := []byte("abc\x00\x01")
+	assert.NoError(t, c.FindShortSuccessor(&key))
+	assert.Equal(t, "b\xff\xff", string(key))
+}
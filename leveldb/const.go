@@ -8,9 +8,23 @@ type ValueType int
 const (
 	ValueType_Deletion ValueType = 0
 	ValueType_Value    ValueType = 1
+	// A value followed by the fixed64 time it expires at, in micro-seconds
+	// since the Unix epoch, see DB.PutWithTTL
+	ValueType_ValueWithExpiry ValueType = 2
+	// A merge operand, see DB.Merge
+	ValueType_Merge ValueType = 3
+	// A range tombstone: the user key starts the range, the value is the
+	// exclusive end of it, see DB.DeleteRange
+	ValueType_RangeDeletion ValueType = 4
+	// A deletion cancelling out with the value it deletes in compactions,
+	// see DB.SingleDelete
+	ValueType_SingleDeletion ValueType = 5
 )
 
-const ValueType_ForSeek = ValueType_Value
+// ValueType_ForSeek is the type of the internal keys built to seek to a
+// sequence number.  Sequence numbers are sorted in decreasing order with
+// the type packed in their low byte, so it must be the highest type.
+const ValueType_ForSeek = ValueType_SingleDeletion
 
 type CompressionType int
 
@@ -20,7 +34,60 @@ const (
 	CompressionType_Zstd          CompressionType = 0x2
 )
 
+// Format selects the encoding of the files of a DB
+type Format int
+
+const (
+	// The encoding of this package: big-endian fixed-width integers, 64-bit
+	// block restart offsets and filter offsets
+	Format_Native Format = 0x0
+	// The encoding of the C++ LevelDB library: little-endian fixed-width
+	// integers, 32-bit block restart offsets and filter offsets
+	Format_LevelDB Format = 0x1
+)
+
+// FilterType selects how the filters of a table are laid out
+type FilterType int
+
+const (
+	// One filter per 2KB of data block offsets
+	FilterType_Block FilterType = 0x0
+	// One filter for the whole table
+	FilterType_Full FilterType = 0x1
+	// The index and the filter are split into partitions plus a top-level
+	// index of the partitions each.  An index partition and its filter
+	// partition cover the same data blocks, whose index entries add up to
+	// about Options.MetadataBlockSize bytes.  The top-level indexes and the
+	// last partitions read stay in memory.  Tables with a partitioned
+	// index are not readable by older versions nor by the C++ library.
+	FilterType_Partitioned FilterType = 0x2
+)
+
+// DataBlockIndexType selects how the entries of the data blocks are looked up
+type DataBlockIndexType int
+
+const (
+	// Binary search of the restart array
+	DataBlockIndexType_BinarySearch DataBlockIndexType = 0x0
+	// Binary search, plus a hash index of the user keys to their restart
+	// interval for point lookups
+	DataBlockIndexType_BinarySearchAndHash DataBlockIndexType = 0x1
+)
+
+// CompactionDecision is the fate of an entry given by a CompactionFilter
+type CompactionDecision int
+
+const (
+	// Keep the entry as is
+	CompactionDecision_Keep CompactionDecision = 0x0
+	// Remove the entry, the key reads as deleted
+	CompactionDecision_Remove CompactionDecision = 0x1
+	// Keep the entry with the new value
+	CompactionDecision_ChangeValue CompactionDecision = 0x2
+)
+
 const (
 	Uint64Size = 8
 	Uint32Size = 4
+	Uint16Size = 2
 )
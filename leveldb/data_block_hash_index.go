@@ -0,0 +1,122 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+// A data block hash index maps the user keys of a block to the restart
+// interval holding their entries, so that point lookups jump straight to
+// the interval instead of binary searching the restart array.  It is
+// appended to the block after the restart array:
+//
+//	buckets:      uint8[num_buckets]
+//	num_buckets:  uint16
+//	num_restarts: packed with the kDataBlockHashIndexFlag bit
+//
+// A bucket holds the index of the restart interval of the keys hashed to
+// it, kHashIndexNoEntry if there is none, or kHashIndexCollision if they
+// are in several intervals.  Blocks with more restart intervals than a
+// bucket can tell are built without the index.
+const (
+	kHashIndexNoEntry               = 255
+	kHashIndexCollision             = 254
+	kMaxRestartSupportedByHashIndex = 253
+
+	kDefaultDataBlockHashTableUtilRatio = 0.75
+	kDataBlockHashIndexSeed             = 397
+)
+
+// dataBlockHashIndexFlag returns the bit of num_restarts telling that a
+// block of format has a hash index.
+func dataBlockHashIndexFlag(format Format) uint64 {
+	return 1 << (format.offsetSize()*8 - 1)
+}
+
+func dataBlockHashIndexHash(userKey []byte) uint32 {
+	return util.Hash(userKey, kDataBlockHashIndexSeed)
+}
+
+type hashAndRestart struct {
+	hash         uint32
+	restartIndex uint8
+}
+
+// dataBlockHashIndexBuilder collects the user keys of a data block along
+// with their restart interval.
+type dataBlockHashIndexBuilder struct {
+	utilRatio       float64 // keys per bucket
+	hashAndRestarts []hashAndRestart
+	valid           bool // false once the block has too many restarts
+}
+
+func newDataBlockHashIndexBuilder(utilRatio float64) *dataBlockHashIndexBuilder {
+	if utilRatio <= 0 {
+		utilRatio = kDefaultDataBlockHashTableUtilRatio
+	}
+	return &dataBlockHashIndexBuilder{
+		utilRatio: utilRatio,
+		valid:     true,
+	}
+}
+
+func (hb *dataBlockHashIndexBuilder) Add(userKey []byte, restartIndex int) {
+	if restartIndex > kMaxRestartSupportedByHashIndex {
+		hb.valid = false
+		return
+	}
+	hb.hashAndRestarts = append(hb.hashAndRestarts,
+		hashAndRestart{dataBlockHashIndexHash(userKey), uint8(restartIndex)})
+}
+
+// Valid returns true if the index can be built for the keys added.
+func (hb *dataBlockHashIndexBuilder) Valid() bool {
+	return hb.valid && len(hb.hashAndRestarts) > 0
+}
+
+func (hb *dataBlockHashIndexBuilder) numBuckets() int {
+	n := int(float64(len(hb.hashAndRestarts)) / hb.utilRatio)
+	if n > 0xffff {
+		n = 0xffff
+	}
+	// An odd number of buckets spreads the hashes better
+	return n | 1
+}
+
+// EstimateSize returns the size of the index if built now.
+func (hb *dataBlockHashIndexBuilder) EstimateSize() int {
+	if !hb.Valid() {
+		return 0
+	}
+	return hb.numBuckets() + Uint16Size
+}
+
+// Finish appends the buckets and their number to dst.
+// REQUIRES: Valid()
+func (hb *dataBlockHashIndexBuilder) Finish(dst *[]byte, format Format) {
+	numBuckets := hb.numBuckets()
+	buckets := make([]byte, numBuckets)
+	for i := range buckets {
+		buckets[i] = kHashIndexNoEntry
+	}
+	for _, hr := range hb.hashAndRestarts {
+		i := hr.hash % uint32(numBuckets)
+		switch buckets[i] {
+		case kHashIndexNoEntry:
+			buckets[i] = hr.restartIndex
+		case hr.restartIndex, kHashIndexCollision:
+		default:
+			buckets[i] = kHashIndexCollision
+		}
+	}
+	*dst = append(*dst, buckets...)
+	*dst = format.byteOrder().AppendUint16(*dst, uint16(numBuckets))
+}
+
+func (hb *dataBlockHashIndexBuilder) Reset() {
+	hb.hashAndRestarts = hb.hashAndRestarts[:0]
+	hb.valid = true
+}
+
+// dataBlockHashIndexLookup returns the bucket of userKey in buckets: the
+// index of its restart interval, kHashIndexNoEntry or kHashIndexCollision.
+func dataBlockHashIndexLookup(buckets []byte, userKey []byte) uint8 {
+	return buckets[dataBlockHashIndexHash(userKey)%uint32(len(buckets))]
+}
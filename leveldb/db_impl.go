@@ -0,0 +1,2133 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+const kNumNonTableCacheFiles = 10
+
+// DB is a persistent ordered map from keys to values.
+// A DB is safe for concurrent access from multiple goroutines without
+// any external synchronization.
+type DB struct {
+	// Constant after construction
+	env                 *Env
+	options             *Options // Of the default column family
+	dbname              string
+	defaultColumnFamily *ColumnFamilyHandle
+
+	// State below is protected by mu
+	mu            sync.Mutex
+	shuttingDown  atomic.Bool
+	bgCV          *sync.Cond  // Signalled when background work finishes
+	hasImm        atomic.Bool // So bg goroutine can detect a memtable being compacted
+	logfile       WritableFile
+	logfileNumber uint64
+	log           *logWriter
+
+	// Queue of writers, writers[0] is the leader of the next group commit.
+	writers  []*writer
+	tmpBatch *WriteBatch
+
+	snapshots snapshotList
+
+	// Set of table files to protect from deletion because they are
+	// part of ongoing compactions.
+	pendingOutputs map[uint64]struct{}
+
+	// Has a background compaction been scheduled or is running?
+	bgCompactionScheduled bool
+
+	versions *VersionSet
+
+	// Have we encountered a background error in paranoid mode?
+	bgError error
+
+	stallStats writeStallStats
+}
+
+// compactionStats is kept per level, it accumulates the work done by
+// compactions producing data for the level.
+type compactionStats struct {
+	micros       uint64
+	bytesRead    uint64
+	bytesWritten uint64
+}
+
+func (s *compactionStats) Add(c *compactionStats) {
+	s.micros += c.micros
+	s.bytesRead += c.bytesRead
+	s.bytesWritten += c.bytesWritten
+}
+
+// writeStallStats counts the writes delayed or blocked by makeRoomForWrite
+// and the time they spent waiting.
+type writeStallStats struct {
+	l0SlowdownCount    uint64
+	l0SlowdownMicros   uint64
+	memtableStopCount  uint64
+	memtableStopMicros uint64
+	l0StopCount        uint64
+	l0StopMicros       uint64
+}
+
+// Information kept for every waiting writer
+type writer struct {
+	batch *WriteBatch
+	sync  bool
+	done  bool
+	err   error
+	cv    *sync.Cond
+	// If non-nil, called once the writer leads the queue, before its batch
+	// is written, which it cancels by returning an error.  The batches of
+	// the writers ahead are applied by then, the ones behind wait.
+	callback func() error
+}
+
+func newWriter(mu *sync.Mutex, batch *WriteBatch, isSync bool) *writer {
+	return &writer{
+		batch: batch,
+		sync:  isSync,
+		cv:    sync.NewCond(mu),
+	}
+}
+
+func clipToRange[T int | uint64](ptr *T, minValue, maxValue T) {
+	if *ptr > maxValue {
+		*ptr = maxValue
+	}
+	if *ptr < minValue {
+		*ptr = minValue
+	}
+}
+
+func defaultTo[T int | uint64](ptr *T, value T) {
+	if *ptr == 0 {
+		*ptr = value
+	}
+}
+
+// sanitizeOptions returns a copy of src with defaults filled in and values
+// clipped to sane ranges, the comparator and filter policy are replaced by
+// their internal key wrappers.
+func sanitizeOptions(dbname string, icmp *internalKeyComparator, ipolicy *internalFilterPolicy, src *Options) *Options {
+	result := *src
+	result.Comparator = icmp
+	if ipolicy != nil {
+		result.FilterPolicy = ipolicy
+	} else {
+		result.FilterPolicy = nil
+	}
+	if result.Env == nil {
+		result.Env = &Env{}
+	}
+	defaultTo(&result.MaxOpenFiles, 1000)
+	defaultTo(&result.WriteBufferSize, 4<<20)
+	defaultTo(&result.MaxFileSize, 2<<20)
+	defaultTo(&result.BlockSize, 4<<10)
+	defaultTo(&result.MetadataBlockSize, 4<<10)
+	defaultTo(&result.BlockRestartInternal, 16)
+	defaultTo(&result.Level0SlowdownWritesTrigger, kL0_SlowdownWritesTrigger)
+	defaultTo(&result.Level0StopWritesTrigger, kL0_StopWritesTrigger)
+	clipToRange(&result.MaxOpenFiles, 64+kNumNonTableCacheFiles, 50000)
+	clipToRange(&result.WriteBufferSize, 64<<10, 1<<30)
+	clipToRange(&result.MaxFileSize, 1<<20, 1<<30)
+	clipToRange(&result.BlockSize, 1<<10, 4<<20)
+	// Writes must slow down before they are stopped
+	if result.Level0StopWritesTrigger < result.Level0SlowdownWritesTrigger {
+		result.Level0StopWritesTrigger = result.Level0SlowdownWritesTrigger
+	}
+	return &result
+}
+
+func newDBImpl(rawOptions *Options, dbname string) *DB {
+	cfd := newColumnFamilyData(kDefaultColumnFamily, kDefaultColumnFamilyName, dbname,
+		rawOptions, defaultColumnFamilyOptions(rawOptions))
+	options := cfd.options
+	db := &DB{
+		env:                 options.Env,
+		options:             options,
+		dbname:              dbname,
+		defaultColumnFamily: &ColumnFamilyHandle{cfd: cfd},
+		tmpBatch:            NewWriteBatch(),
+		pendingOutputs:      make(map[uint64]struct{}),
+		versions:            NewVersionSet(dbname, options, cfd),
+	}
+	db.bgCV = sync.NewCond(&db.mu)
+	return db
+}
+
+// Open opens the database with the specified "dbname".
+func Open(options *Options, dbname string) (*DB, error) {
+	db, _, err := OpenColumnFamilies(options, dbname, nil)
+	return db, err
+}
+
+// OpenColumnFamilies opens the database with the specified "dbname" along
+// with its column families other than the default one, which options
+// configures.  All the column families created in the database have to be
+// listed in families.  The handles of the families are returned in the
+// order of families.
+func OpenColumnFamilies(options *Options, dbname string, families []ColumnFamilyDescriptor) (*DB, []*ColumnFamilyHandle, error) {
+	if options.FullHistoryTsLow != nil && len(options.FullHistoryTsLow) != timestampSize(options.Comparator) {
+		return nil, nil, Error(Code_InvalidArgument, "full history low timestamp size mismatch")
+	}
+	familyOptions := make(map[string]*ColumnFamilyOptions)
+	for _, family := range families {
+		if family.Name == kDefaultColumnFamilyName {
+			return nil, nil, Error(Code_InvalidArgument, "the default column family is configured by Options")
+		}
+		if _, ok := familyOptions[family.Name]; ok {
+			return nil, nil, Error(Code_InvalidArgument, "column family "+family.Name+" listed twice")
+		}
+		familyOptions[family.Name] = family.Options
+	}
+	db := newDBImpl(options, dbname)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	edits := make(map[uint32]*VersionEdit)
+	// Recover handles createIfMissing, errorIfExists
+	saveManifest, err := db.recover(familyOptions, edits)
+	if err == nil {
+		// Create new log and the corresponding memtables.
+		newLogNumber := db.versions.NewFileNumber()
+		var logfile WritableFile
+		logfile, err = db.env.NewWritableFile(LogFileName(dbname, newLogNumber))
+		if err == nil {
+			db.logfile = logfile
+			db.logfileNumber = newLogNumber
+			db.log = NewLogWriter(logfile, db.options.Format)
+		}
+	}
+	for _, cfd := range db.versions.liveColumnFamilies() {
+		if err != nil {
+			break
+		}
+		cfd.mem = cfd.newMemTable()
+		cfd.memLogNumber = db.logfileNumber
+		if !saveManifest {
+			continue
+		}
+		edit := edits[cfd.id]
+		if edit == nil {
+			edit = NewVersionEdit()
+		}
+		edit.SetColumnFamily(cfd.id)
+		edit.SetPrevLogNumber(0) // No older logs needed after recovery.
+		edit.SetLogNumber(db.logfileNumber)
+		err = db.versions.LogAndApply(edit, &db.mu)
+	}
+	var handles []*ColumnFamilyHandle
+	if err == nil {
+		for _, family := range families {
+			handles = append(handles, &ColumnFamilyHandle{cfd: db.versions.columnFamilyByName(family.Name)})
+		}
+		db.deleteObsoleteFiles()
+		db.maybeScheduleCompaction()
+	}
+	if err != nil {
+		if db.logfile != nil {
+			db.logfile.Close()
+			db.logfile = nil
+		}
+		for _, cfd := range db.versions.columnFamilies {
+			cfd.tableCache.Close()
+		}
+		return nil, nil, err
+	}
+	return db, handles, nil
+}
+
+// DefaultColumnFamily returns the handle of the default column family.
+func (db *DB) DefaultColumnFamily() *ColumnFamilyHandle {
+	return db.defaultColumnFamily
+}
+
+// newDB writes the MANIFEST and CURRENT files of an empty database.
+func (db *DB) newDB() error {
+	newDB := NewVersionEdit()
+	newDB.SetComparatorName(db.defaultColumnFamily.cfd.icmp.comparator.Name())
+	newDB.SetLogNumber(0)
+	newDB.SetNextFile(2)
+	newDB.SetLastSequence(0)
+
+	manifest := DescriptorFileName(db.dbname, 1)
+	file, err := db.env.NewWritableFile(manifest)
+	if err != nil {
+		return err
+	}
+	log := NewLogWriter(file, db.options.Format)
+	var record []byte
+	newDB.encodeTo(&record, db.options.Format)
+	err = log.AddRecord(record)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Make "CURRENT" file that points to the new manifest file.
+		err = setCurrentFile(db.env, db.dbname, 1)
+	} else {
+		db.env.RemoveFile(manifest)
+	}
+	return err
+}
+
+// recover the descriptor from persistent storage, opening the column
+// families in families.  May do a significant amount of work to recover
+// recently logged updates.  Any changes to be made to the descriptor are
+// added to the edits of the column families, by id.
+// REQUIRES: db.mu is held
+func (db *DB) recover(families map[string]*ColumnFamilyOptions, edits map[uint32]*VersionEdit) (bool, error) {
+	// Ignore error from CreateDir since the creation of the DB is
+	// committed only when the descriptor is created, and this directory
+	// may already exist from a previous failed creation attempt.
+	db.env.CreateDir(db.dbname)
+
+	if !db.env.FileExists(CurrentFileName(db.dbname)) {
+		if !db.options.CreateIfMissing {
+			return false, Error(Code_InvalidArgument, db.dbname+": does not exist (create_if_missing is false)")
+		}
+		if err := db.newDB(); err != nil {
+			return false, err
+		}
+	} else if db.options.ErrorIfExsits {
+		return false, Error(Code_InvalidArgument, db.dbname+": exists (error_if_exists is true)")
+	}
+
+	saveManifest, err := db.versions.Recover(families)
+	if err != nil {
+		return false, err
+	}
+
+	// Recover from all newer log files than the ones named in the
+	// descriptor (new log files may have been added by the previous
+	// incarnation without registering them in the descriptor).
+	//
+	// Note that PrevLogNumber() is no longer used, but we pay
+	// attention to it in case we are recovering a database
+	// produced by an older version of leveldb.
+	minLog := db.versions.LogNumber()
+	prevLog := db.versions.PrevLogNumber()
+	filenames, err := db.env.GetChildren(db.dbname)
+	if err != nil {
+		return false, err
+	}
+	expected := make(map[uint64]struct{})
+	db.versions.AddLiveFiles(expected)
+	var logs []uint64
+	for _, filename := range filenames {
+		if number, t, ok := ParseFileName(filename); ok {
+			delete(expected, number)
+			if t == FileType_LogFile && (number >= minLog || number == prevLog) {
+				logs = append(logs, number)
+			}
+		}
+	}
+	if len(expected) != 0 {
+		return false, Error(Code_Corruption, fmt.Sprintf("%d missing files", len(expected)))
+	}
+
+	// Recover in the order in which the logs were generated
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	var maxSequence SequenceNumber
+	for i, number := range logs {
+		if err := db.recoverLogFile(number, i == len(logs)-1, &saveManifest, edits, &maxSequence); err != nil {
+			return false, err
+		}
+
+		// The previous incarnation may not have written any MANIFEST
+		// records after allocating this log number.  So we manually
+		// update the file number allocation counter in VersionSet.
+		db.versions.MarkFileNumberUsed(number)
+	}
+
+	if db.versions.LastSequence() < maxSequence {
+		db.versions.SetLastSequence(maxSequence)
+	}
+
+	return saveManifest, nil
+}
+
+// recoverLogFile replays the batches of a log file into the memtables of
+// the column families and flushes them to level-0 tables.  The entries of
+// the families whose tables have the log already are skipped.
+// REQUIRES: db.mu is held
+func (db *DB) recoverLogFile(logNumber uint64, lastLog bool, saveManifest *bool,
+	edits map[uint32]*VersionEdit, maxSequence *SequenceNumber) error {
+	// Open the log file
+	fname := LogFileName(db.dbname, logNumber)
+	file, err := db.env.NewSequentialFile(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// We intentionally make the log reader do checksumming even if
+	// ParanoidChecks is false so that corruptions cause entire commits
+	// to be skipped instead of propagating bad information (like overly
+	// large sequence numbers).
+	reader := NewLogReader(file, true, db.options.Format)
+
+	// Read all the records and add to the memtables
+	mems := make(map[uint32]*MemTable)
+	families := make(map[uint32]*columnFamilyData)
+	for _, cfd := range db.versions.liveColumnFamilies() {
+		if cfd.logNumber <= logNumber {
+			mems[cfd.id] = cfd.newMemTable()
+			families[cfd.id] = cfd
+		}
+	}
+	flush := func(cfd *columnFamilyData) error {
+		edit := edits[cfd.id]
+		if edit == nil {
+			edit = NewVersionEdit()
+			edits[cfd.id] = edit
+		}
+		*saveManifest = true
+		err := db.writeLevel0Table(cfd, mems[cfd.id], edit, nil)
+		mems[cfd.id] = cfd.newMemTable()
+		return err
+	}
+	compactions := 0
+	batch := NewWriteBatch()
+	for {
+		record, ok := reader.ReadRecord()
+		if !ok {
+			break
+		}
+		if len(record) < kWriteBatchHeader {
+			if db.options.ParanoidChecks {
+				return Error(Code_Corruption, "log record too small")
+			}
+			continue
+		}
+		batch.setEncodedContents(record, db.options.Format)
+
+		if err := batch.insertInto(mems); err != nil {
+			if db.options.ParanoidChecks {
+				return err
+			}
+			continue
+		}
+		lastSeq := batch.sequence() + SequenceNumber(batch.Count()) - 1
+		if lastSeq > *maxSequence {
+			*maxSequence = lastSeq
+		}
+
+		for id, mem := range mems {
+			cfd := families[id]
+			if mem.ApproximateMemoryUsage() > cfd.options.WriteBufferSize {
+				compactions++
+				if err := flush(cfd); err != nil {
+					// Reflect errors immediately so that conditions like full
+					// file-systems cause the DB::Open() to fail.
+					return err
+				}
+			}
+		}
+	}
+	if err := reader.Error(); err != nil && db.options.ParanoidChecks {
+		return err
+	}
+
+	// TODO: reuse the last log file when Options.ReuseLogs is set instead
+	// of always flushing the recovered memtables.
+	for id, mem := range mems {
+		if !mem.Empty() {
+			if err := flush(families[id]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeLevel0Table dumps the contents of mem, a memtable of the column
+// family cfd, to a new table file and records it in edit.  The table is
+// placed at the level picked by base, or level-0 if base is nil.
+// REQUIRES: db.mu is held
+func (db *DB) writeLevel0Table(cfd *columnFamilyData, mem *MemTable, edit *VersionEdit, base *Version) error {
+	startMicros := db.env.NowMicros()
+	meta := NewFileMetaData()
+	meta.number = db.versions.NewFileNumber()
+	db.pendingOutputs[meta.number] = struct{}{}
+	iter := mem.NewIterator()
+
+	db.mu.Unlock()
+	tombstones, err := mem.rangeTombstones()
+	if err == nil {
+		err = BuildTable(db.dbname, db.env, cfd.options, cfd.tableCache, iter, tombstones, meta)
+	}
+	iter.Close()
+	db.mu.Lock()
+
+	delete(db.pendingOutputs, meta.number)
+
+	// Note that if fileSize is zero, the file has been deleted and
+	// should not be added to the manifest.
+	level := 0
+	if err == nil && meta.fileSize > 0 {
+		minUserKey := ExtractUserKey(meta.smallest)
+		maxUserKey := ExtractUserKey(meta.largest)
+		if base != nil {
+			level = base.PickLevelForMemTableOutput(minUserKey, maxUserKey)
+		}
+		edit.AddFile(level, meta.number, meta.fileSize, meta.smallest, meta.largest)
+	}
+
+	cfd.stats[level].Add(&compactionStats{
+		micros:       db.env.NowMicros() - startMicros,
+		bytesWritten: meta.fileSize,
+	})
+	return err
+}
+
+// compactMemTable compacts the in-memory write buffers being compacted to
+// disk, one table per column family, and writes a new descriptor for each
+// iff successful.  Errors are recorded in bgError.
+// REQUIRES: db.mu is held
+func (db *DB) compactMemTable() {
+	if !db.hasImm.Load() {
+		panic("no immutable memtable to compact")
+	}
+
+	for _, cfd := range db.versions.liveColumnFamilies() {
+		imm := cfd.imm
+		if imm == nil {
+			continue
+		}
+
+		// Save the contents of the memtable as a new Table
+		edit := NewVersionEdit()
+		edit.SetColumnFamily(cfd.id)
+		base := cfd.current
+		base.Ref()
+		err := db.writeLevel0Table(cfd, imm, edit, base)
+		base.Unref()
+
+		if err == nil && db.shuttingDown.Load() {
+			err = Error(Code_IOError, "deleting DB during memtable compaction")
+		}
+
+		// Replace immutable memtable with the generated Table
+		if err == nil {
+			edit.SetPrevLogNumber(0)
+			edit.SetLogNumber(cfd.memLogNumber) // Earlier logs no longer needed
+			err = db.versions.LogAndApply(edit, &db.mu)
+		}
+
+		if err != nil {
+			db.recordBackgroundError(err)
+			return
+		}
+		// Commit to the new state, unless the family was dropped meanwhile
+		if cfd.imm == imm {
+			cfd.imm = nil
+		}
+	}
+	db.updateHasImm()
+	db.deleteObsoleteFiles()
+}
+
+// updateHasImm records whether a column family has a memtable being
+// compacted.
+// REQUIRES: db.mu is held
+func (db *DB) updateHasImm() {
+	hasImm := false
+	for _, cfd := range db.versions.liveColumnFamilies() {
+		hasImm = hasImm || cfd.imm != nil
+	}
+	db.hasImm.Store(hasImm)
+}
+
+// deleteObsoleteFiles deletes any unneeded files and stale in-memory entries.
+// REQUIRES: db.mu is held
+func (db *DB) deleteObsoleteFiles() {
+	if db.bgError != nil {
+		// After a background error, we don't know whether a new version may
+		// or may not have been committed, so we cannot safely garbage collect.
+		return
+	}
+
+	// Make a set of all of the live files
+	live := make(map[uint64]struct{})
+	for number := range db.pendingOutputs {
+		live[number] = struct{}{}
+	}
+	db.versions.AddLiveFiles(live)
+	minLogNumber := db.minLogNumberToKeep()
+
+	filenames, _ := db.env.GetChildren(db.dbname) // Ignoring errors on purpose
+	var filesToDelete []string
+	for _, filename := range filenames {
+		number, t, ok := ParseFileName(filename)
+		if !ok {
+			continue
+		}
+		keep := true
+		switch t {
+		case FileType_LogFile:
+			keep = number >= minLogNumber || number == db.versions.PrevLogNumber()
+		case FileType_DescriptorFile:
+			// Keep my manifest file, and any newer incarnations'
+			// (in case there is a race that allows other incarnations)
+			keep = number >= db.versions.ManifestFileNumber()
+		case FileType_TableFile, FileType_TempFile:
+			_, keep = live[number]
+		case FileType_CurrentFile, FileType_DBLockFile, FileType_InfoLogFile:
+			keep = true
+		}
+
+		if !keep {
+			filesToDelete = append(filesToDelete, filename)
+			if t == FileType_TableFile {
+				for _, cfd := range db.versions.columnFamilies {
+					cfd.tableCache.Evict(number)
+				}
+			}
+		}
+	}
+
+	// While deleting all files unblock other threads. All files being deleted
+	// have unique names which will not collide with newly created files and
+	// are therefore safe to delete while allowing other threads to proceed.
+	db.mu.Unlock()
+	for _, filename := range filesToDelete {
+		db.env.RemoveFile(db.dbname + "/" + filename)
+	}
+	db.mu.Lock()
+}
+
+// minLogNumberToKeep returns the number of the oldest log holding entries
+// missing from the tables.  The column families without entries in their
+// memtables have all their entries in tables, whatever their log number.
+// REQUIRES: db.mu is held
+func (db *DB) minLogNumberToKeep() uint64 {
+	minLogNumber := db.logfileNumber
+	for _, cfd := range db.versions.liveColumnFamilies() {
+		if (cfd.imm != nil || !cfd.mem.Empty()) && cfd.logNumber < minLogNumber {
+			minLogNumber = cfd.logNumber
+		}
+	}
+	return minLogNumber
+}
+
+func (db *DB) recordBackgroundError(err error) {
+	if db.bgError == nil {
+		db.bgError = err
+		db.bgCV.Broadcast()
+	}
+}
+
+// REQUIRES: db.mu is held
+func (db *DB) maybeScheduleCompaction() {
+	if db.bgCompactionScheduled {
+		// Already scheduled
+	} else if db.shuttingDown.Load() {
+		// DB is being deleted; no more background compactions
+	} else if db.bgError != nil {
+		// Already got an error; no more changes
+	} else if !db.hasImm.Load() && !db.versions.NeedsCompaction() {
+		// No work to be done
+	} else {
+		db.bgCompactionScheduled = true
+		go db.backgroundCall()
+	}
+}
+
+func (db *DB) backgroundCall() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.bgCompactionScheduled {
+		panic("background compaction is not scheduled")
+	}
+	if db.shuttingDown.Load() {
+		// No more background work when shutting down.
+	} else if db.bgError != nil {
+		// No more background work after a background error.
+	} else {
+		db.backgroundCompaction()
+	}
+
+	db.bgCompactionScheduled = false
+
+	// Previous compaction may have produced too many files in a level,
+	// so reschedule another compaction if needed.
+	db.maybeScheduleCompaction()
+	db.bgCV.Broadcast()
+}
+
+// REQUIRES: db.mu is held
+func (db *DB) backgroundCompaction() {
+	if db.hasImm.Load() {
+		db.compactMemTable()
+		return
+	}
+
+	c := db.versions.PickCompaction()
+	if c == nil {
+		// Nothing to do
+		return
+	}
+
+	var err error
+	if c.IsTrivialMove() {
+		// Move file to next level
+		f := c.Input(0, 0)
+		c.Edit().RemoveFile(c.Level(), f.number)
+		c.Edit().AddFile(c.Level()+1, f.number, f.fileSize, f.smallest, f.largest)
+		err = db.versions.LogAndApply(c.Edit(), &db.mu)
+		if err != nil {
+			db.recordBackgroundError(err)
+		}
+	} else {
+		compact := &compactionState{compaction: c}
+		err = db.doCompactionWork(compact)
+		if err != nil {
+			db.recordBackgroundError(err)
+		}
+		db.cleanupCompaction(compact)
+		c.ReleaseInputs()
+		db.deleteObsoleteFiles()
+	}
+}
+
+// Files produced by compaction
+type compactionOutput struct {
+	number   uint64
+	fileSize uint64
+	smallest []byte
+	largest  []byte
+}
+
+type compactionState struct {
+	compaction *Compaction
+
+	// Sequence numbers < smallestSnapshot are not significant since we
+	// will never have to service a snapshot below smallestSnapshot.
+	// Therefore if we have seen a sequence number S <= smallestSnapshot,
+	// we can drop all entries for the same key with sequence numbers < S.
+	smallestSnapshot SequenceNumber
+	// The sequence numbers of the live snapshots, oldest first
+	snapshots []SequenceNumber
+
+	outputs []compactionOutput
+
+	// The range tombstones of the inputs, and the ones the outputs keep
+	// sorted by start
+	rangeDels       *fragmentedRangeTombstones
+	outputRangeDels []rangeTombstone
+	// The user key the range tombstones of the current output start at,
+	// nil for the first output
+	rangeDelLower []byte
+
+	// State kept for output being generated
+	outfile    WritableFile
+	builder    *TableBuilder
+	stopBefore bool // Start a new output at the next user key
+
+	totalBytes uint64
+}
+
+func (compact *compactionState) currentOutput() *compactionOutput {
+	return &compact.outputs[len(compact.outputs)-1]
+}
+
+// inSameSnapshotStripe returns true if no snapshot sees the entry of a key
+// written at older without the one written at newer.
+func (compact *compactionState) inSameSnapshotStripe(older, newer SequenceNumber) bool {
+	i := sort.Search(len(compact.snapshots), func(i int) bool { return compact.snapshots[i] >= older })
+	return i == len(compact.snapshots) || compact.snapshots[i] >= newer
+}
+
+// visibleToSnapshot returns true if a snapshot sees the newest entry of a
+// key, written at seq.
+func (compact *compactionState) visibleToSnapshot(seq SequenceNumber) bool {
+	n := len(compact.snapshots)
+	return n > 0 && seq <= compact.snapshots[n-1]
+}
+
+// REQUIRES: db.mu is held
+func (db *DB) cleanupCompaction(compact *compactionState) {
+	if compact.builder != nil {
+		// May happen if we get a shutdown call in the middle of compaction
+		compact.builder.Abandon()
+	} else if compact.outfile != nil {
+		panic("compaction outfile without builder")
+	}
+	if compact.outfile != nil {
+		compact.outfile.Close()
+	}
+	for _, out := range compact.outputs {
+		delete(db.pendingOutputs, out.number)
+	}
+}
+
+func (db *DB) openCompactionOutputFile(compact *compactionState) error {
+	if compact.builder != nil {
+		panic("compaction builder is not nil")
+	}
+	db.mu.Lock()
+	fileNumber := db.versions.NewFileNumber()
+	db.pendingOutputs[fileNumber] = struct{}{}
+	compact.outputs = append(compact.outputs, compactionOutput{number: fileNumber})
+	db.mu.Unlock()
+
+	// Make the output file
+	fname := TableFileName(db.dbname, fileNumber)
+	file, err := db.env.NewWritableFile(fname)
+	if err == nil {
+		compact.outfile = file
+		compact.builder = NewTableBuilder(compact.compaction.cfd.options, file)
+	}
+	return err
+}
+
+// finishCompactionOutputFile finishes the current output, which holds the
+// range tombstones before the user key upper, nil if it is the last output.
+func (db *DB) finishCompactionOutputFile(compact *compactionState, input Iterator, upper []byte) error {
+	if compact.outfile == nil || compact.builder == nil {
+		panic("no compaction output file")
+	}
+
+	outputNumber := compact.currentOutput().number
+	if outputNumber == 0 {
+		panic("invalid compaction output number")
+	}
+
+	// Check for iterator errors
+	err := input.Error()
+	numEntries := compact.builder.NumEntries()
+	if err == nil {
+		err = db.addCompactionRangeTombstones(compact, upper)
+	}
+	if err == nil {
+		err = compact.builder.Finish()
+	} else {
+		compact.builder.Abandon()
+	}
+	currentBytes := compact.builder.FileSize()
+	compact.currentOutput().fileSize = currentBytes
+	compact.totalBytes += currentBytes
+	compact.builder = nil
+
+	// Finish and check for file errors
+	if err == nil {
+		err = compact.outfile.Sync()
+	}
+	if closeErr := compact.outfile.Close(); err == nil {
+		err = closeErr
+	}
+	compact.outfile = nil
+	compact.stopBefore = false
+
+	if err == nil && numEntries > 0 {
+		// Verify that the table is usable
+		iter := compact.compaction.cfd.tableCache.NewIterator(&ReadOptions{}, outputNumber, currentBytes)
+		err = iter.Error()
+		iter.Close()
+	}
+	return err
+}
+
+// REQUIRES: db.mu is held
+func (db *DB) installCompactionResults(compact *compactionState) error {
+	// Add compaction outputs
+	compact.compaction.AddInputDeletions(compact.compaction.Edit())
+	level := compact.compaction.Level()
+	for _, out := range compact.outputs {
+		compact.compaction.Edit().AddFile(level+1, out.number, out.fileSize, out.smallest, out.largest)
+	}
+	return db.versions.LogAndApply(compact.compaction.Edit(), &db.mu)
+}
+
+// readCompactionRangeTombstones reads and fragments the range tombstones of
+// the inputs of compact.  Of the tombstones of a fragment the outputs keep
+// the ones newer than the smallest snapshot, and the newest older one,
+// which hides the older entries for all snapshots, unless no lower level
+// holds entries of the fragment.
+func (db *DB) readCompactionRangeTombstones(compact *compactionState) error {
+	c := compact.compaction
+	cfd := c.cfd
+	var tombstones []rangeTombstone
+	for which := 0; which < 2; which++ {
+		for i := 0; i < c.NumInputFiles(which); i++ {
+			f := c.Input(which, i)
+			t, err := cfd.tableCache.RangeTombstones(f.number, f.fileSize)
+			if err != nil {
+				return err
+			}
+			tombstones = append(tombstones, t...)
+		}
+	}
+	compact.rangeDels = fragmentRangeTombstones(cfd.icmp.comparator, tombstones)
+	if compact.rangeDels == nil {
+		return nil
+	}
+	for _, fragment := range compact.rangeDels.fragments {
+		for _, seq := range fragment.seqs {
+			if seq <= compact.smallestSnapshot {
+				if !c.IsBaseLevelForRange(fragment.start, fragment.end) {
+					compact.outputRangeDels = append(compact.outputRangeDels,
+						rangeTombstone{start: fragment.start, end: fragment.end, seq: seq})
+				}
+				break
+			}
+			compact.outputRangeDels = append(compact.outputRangeDels,
+				rangeTombstone{start: fragment.start, end: fragment.end, seq: seq})
+		}
+	}
+	return nil
+}
+
+// addCompactionRangeTombstones adds to the current output the range
+// tombstones kept by the compaction, cut to [compact.rangeDelLower, upper),
+// and widens the range of the output to them.  A nil bound is unbounded.
+func (db *DB) addCompactionRangeTombstones(compact *compactionState, upper []byte) error {
+	icmp := compact.compaction.cfd.icmp
+	ucmp := icmp.comparator
+	lower := compact.rangeDelLower
+	out := compact.currentOutput()
+	for _, t := range compact.outputRangeDels {
+		if upper != nil && ucmp.Compare(t.start, upper) >= 0 {
+			break
+		}
+		if lower != nil && ucmp.Compare(t.end, lower) <= 0 {
+			continue
+		}
+		if lower != nil && ucmp.Compare(t.start, lower) < 0 {
+			t.start = lower
+		}
+		if upper != nil && ucmp.Compare(t.end, upper) > 0 {
+			t.end = upper
+		}
+		if err := compact.builder.AddRangeTombstone(t.internalKey(), t.end); err != nil {
+			return err
+		}
+		extendBoundsToRangeTombstone(icmp, &out.smallest, &out.largest, &t)
+	}
+	compact.rangeDelLower = upper
+	return nil
+}
+
+// hasPendingRangeTombstones returns true if range tombstones kept by the
+// compaction are not in an output yet.
+func (compact *compactionState) hasPendingRangeTombstones(ucmp Comparator) bool {
+	n := len(compact.outputRangeDels)
+	if n == 0 {
+		return false
+	}
+	// The fragments do not overlap, the last one ends last
+	return compact.rangeDelLower == nil || ucmp.Compare(compact.outputRangeDels[n-1].end, compact.rangeDelLower) > 0
+}
+
+// REQUIRES: db.mu is held
+// addCompactionOutput adds the entry key->value to the output of compact,
+// the output files are opened and finished as they fill.  The entries of a
+// user key are never split between outputs, so that the range tombstones
+// of an output hide all the older entries of the keys in its range.
+func (db *DB) addCompactionOutput(compact *compactionState, input Iterator, key, value []byte) error {
+	// Close output file before key if it is big enough
+	if compact.builder != nil &&
+		(compact.stopBefore || compact.builder.FileSize() >= compact.compaction.MaxOutputFileSize()) {
+		userKey := ExtractUserKey(key)
+		ucmp := compact.compaction.cfd.icmp.comparator
+		if ucmp.Compare(userKey, ExtractUserKey(compact.currentOutput().largest)) != 0 {
+			if err := db.finishCompactionOutputFile(compact, input, userKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Open output file if necessary
+	if compact.builder == nil {
+		if err := db.openCompactionOutputFile(compact); err != nil {
+			return err
+		}
+	}
+	if compact.builder.NumEntries() == 0 {
+		compact.currentOutput().smallest = append([]byte{}, key...)
+	}
+	compact.currentOutput().largest = append([]byte{}, key...)
+	return compact.builder.Add(key, value)
+}
+
+// mergeCompactionOperands consumes from input the merge operands of the
+// user key of ikey, its current entry, up to the value they apply to, and
+// returns the entries replacing them.  With the value, or when no older
+// entry of the key exists, the operands are fully merged into a value.
+// Else the adjacent operands are combined by PartialMerge.  The entries a
+// snapshot sees without the newer ones are left in input, unmerged.
+func (db *DB) mergeCompactionOperands(compact *compactionState, input Iterator,
+	ikey *ParsedInternalKey, now uint64) ([][]byte, [][]byte, error) {
+	mergeOperator := db.options.MergeOperator
+	if mergeOperator == nil {
+		return nil, nil, Error(Code_InvalidArgument, "merge operator not set")
+	}
+	ucmp := compact.compaction.cfd.icmp.comparator
+	userKey := append([]byte{}, ikey.UserKey...)
+	// Operands and their keys, newest first
+	keys := [][]byte{append([]byte{}, input.Key()...)}
+	operands := [][]byte{append([]byte{}, input.Value()...)}
+	var base []byte
+	hasBase := false
+	hasOlder := false // Older entries of the key are left in input
+	prevSeq := ikey.Sequence
+	for input.Next(); input.Valid(); input.Next() {
+		next, err := ParseInternalKey(input.Key())
+		if err != nil || ucmp.Compare(next.UserKey, userKey) != 0 {
+			break
+		}
+		if !compact.inSameSnapshotStripe(next.Sequence, prevSeq) {
+			hasOlder = true
+			break
+		}
+		prevSeq = next.Sequence
+		// Entries deleted by a range tombstone read as a deletion
+		covered := compact.rangeDels.covers(next.UserKey, next.Sequence, compact.smallestSnapshot)
+		if next.Type == ValueType_Merge && !covered {
+			keys = append(keys, append([]byte{}, input.Key()...))
+			operands = append(operands, append([]byte{}, input.Value()...))
+			continue
+		}
+		if value, live := liveValue(next.Type, input.Value(), now); live && !covered {
+			base = append([]byte{}, value...)
+		}
+		hasBase = true
+		input.Next()
+		break
+	}
+
+	// Oldest first
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	// With timestamps the older versions of the key, which are other user
+	// keys, may hold the value
+	if hasBase || (!hasOlder && timestampSize(ucmp) == 0 && compact.compaction.IsBaseLevelForKey(userKey)) {
+		value, err := mergeOperator.FullMerge(stripTimestamp(ucmp, userKey), base, operands)
+		if err != nil {
+			return nil, nil, err
+		}
+		newest := NewParsedInternalKey(userKey, ikey.Sequence, ValueType_Value)
+		return [][]byte{DumpInternalKey(newest)}, [][]byte{value}, nil
+	}
+
+	var mergedKeys, merged [][]byte
+	for i, operand := range operands {
+		if n := len(merged); n > 0 {
+			if combined, ok := mergeOperator.PartialMerge(stripTimestamp(ucmp, userKey), merged[n-1], operand); ok {
+				mergedKeys[n-1] = keys[i]
+				merged[n-1] = combined
+				continue
+			}
+		}
+		mergedKeys = append(mergedKeys, keys[i])
+		merged = append(merged, operand)
+	}
+	// Newest first, in the order of the internal keys
+	for i, j := 0, len(merged)-1; i < j; i, j = i+1, j-1 {
+		mergedKeys[i], mergedKeys[j] = mergedKeys[j], mergedKeys[i]
+		merged[i], merged[j] = merged[j], merged[i]
+	}
+	return mergedKeys, merged, nil
+}
+
+// nextValueOfKey returns the key of the current entry of input if it is a
+// value of userKey.
+func nextValueOfKey(ucmp Comparator, input Iterator, userKey []byte) (*ParsedInternalKey, bool) {
+	if !input.Valid() {
+		return nil, false
+	}
+	ikey, err := ParseInternalKey(input.Key())
+	if err != nil || ucmp.Compare(ikey.UserKey, userKey) != 0 {
+		return nil, false
+	}
+	return ikey, ikey.Type == ValueType_Value || ikey.Type == ValueType_ValueWithExpiry
+}
+
+func (db *DB) doCompactionWork(compact *compactionState) error {
+	startMicros := db.env.NowMicros()
+	var immMicros uint64 // Micros spent doing imm compactions
+
+	cfd := compact.compaction.cfd
+	if compact.compaction.inputVersion.NumFiles(compact.compaction.Level()) <= 0 {
+		panic("compaction level has no files")
+	}
+	if compact.builder != nil || compact.outfile != nil {
+		panic("compaction output is already open")
+	}
+	if db.snapshots.empty() {
+		compact.smallestSnapshot = db.versions.LastSequence()
+	} else {
+		compact.smallestSnapshot = db.snapshots.oldest().sequence
+	}
+	compact.snapshots = db.snapshots.sequences()
+	tsLow := cfd.fullHistoryTsLow
+	now := db.env.NowUnixMicros()
+	filter := db.options.CompactionFilter
+	ucmp := cfd.icmp.comparator
+	tsSize := timestampSize(ucmp)
+
+	input := db.versions.MakeInputIterator(compact.compaction)
+
+	// Release mutex while we're actually doing the compaction work
+	db.mu.Unlock()
+
+	input.SeekToFirst()
+	err := db.readCompactionRangeTombstones(compact)
+	var currentUserKey []byte
+	hasCurrentUserKey := false
+	lastSequenceForKey := KMaxSequenceNumber
+	// Whether a version of the current key older than tsLow is kept
+	keptBelowTsLow := false
+	for err == nil && input.Valid() && !db.shuttingDown.Load() {
+		// Prioritize immutable compaction work
+		if db.hasImm.Load() {
+			immStart := db.env.NowMicros()
+			db.mu.Lock()
+			if db.hasImm.Load() {
+				db.compactMemTable()
+				// Wake up makeRoomForWrite() if necessary.
+				db.bgCV.Broadcast()
+			}
+			db.mu.Unlock()
+			immMicros += db.env.NowMicros() - immStart
+		}
+
+		key := input.Key()
+		if compact.compaction.ShouldStopBefore(key) && compact.builder != nil {
+			compact.stopBefore = true
+		}
+
+		// Handle key/value, add to state, etc.
+		value := input.Value()
+		drop := false
+		if ikey, perr := ParseInternalKey(key); perr != nil {
+			// Do not hide error keys
+			currentUserKey = nil
+			hasCurrentUserKey = false
+			lastSequenceForKey = KMaxSequenceNumber
+		} else {
+			if !hasCurrentUserKey || ucmp.Compare(ikey.UserKey, currentUserKey) != 0 {
+				// First occurrence of this user key
+				if !hasCurrentUserKey || !sameUserKey(ucmp, ikey.UserKey, currentUserKey) {
+					keptBelowTsLow = false
+				}
+				currentUserKey = append(currentUserKey[:0], ikey.UserKey...)
+				hasCurrentUserKey = true
+				lastSequenceForKey = KMaxSequenceNumber
+			}
+
+			covered := compact.rangeDels.covers(ikey.UserKey, ikey.Sequence, compact.smallestSnapshot)
+			if ikey.Type == ValueType_Merge && !covered && lastSequenceForKey == KMaxSequenceNumber {
+				// The newest entry of the key is a merge operand, merge it
+				// with the older ones and the value they apply to
+				var keys, values [][]byte
+				keys, values, err = db.mergeCompactionOperands(compact, input, ikey, now)
+				for i := 0; err == nil && i < len(keys); i++ {
+					err = db.addCompactionOutput(compact, input, keys[i], values[i])
+				}
+				if err != nil {
+					break
+				}
+				// The entries of the key left are older than the value
+				lastSequenceForKey = ikey.Sequence
+				continue
+			}
+
+			if ikey.Type == ValueType_SingleDeletion && !covered &&
+				lastSequenceForKey > compact.smallestSnapshot && ikey.Sequence <= compact.smallestSnapshot {
+				lastSequenceForKey = ikey.Sequence
+				singleDeletion := append([]byte{}, key...)
+				input.Next()
+				if next, ok := nextValueOfKey(ucmp, input, currentUserKey); ok {
+					// The single deletion and the value it deletes cancel
+					// out, the older entries of the key are hidden as well
+					lastSequenceForKey = next.Sequence
+					input.Next()
+					continue
+				}
+				if tsSize > 0 || !compact.compaction.IsBaseLevelForKey(currentUserKey) {
+					// The value may be in lower levels
+					if err = db.addCompactionOutput(compact, input, singleDeletion, nil); err != nil {
+						break
+					}
+				}
+				continue
+			}
+
+			liveVal, live := liveValue(ikey.Type, value, now)
+			if live && !covered && filter != nil && lastSequenceForKey == KMaxSequenceNumber &&
+				!compact.visibleToSnapshot(ikey.Sequence) {
+				// The newest entry of the key, the one reads see, unless
+				// the reads as of a snapshot see it as well
+				decision, newValue := filter.Filter(compact.compaction.Level(),
+					stripTimestamp(ucmp, ikey.UserKey), liveVal)
+				switch decision {
+				case CompactionDecision_Remove:
+					live = false
+				case CompactionDecision_ChangeValue:
+					if ikey.Type == ValueType_ValueWithExpiry {
+						newValue = appendExpiry(newValue, util.DecodeUint64Fixed(value[len(liveVal):]))
+					}
+					value = newValue
+				}
+			}
+			// Expired values and the ones removed by the filter
+			obsolete := !live && (ikey.Type == ValueType_Value || ikey.Type == ValueType_ValueWithExpiry)
+			belowTsLow := tsSize > 0 && tsLow != nil &&
+				bytes.Compare(ikey.UserKey[len(ikey.UserKey)-tsSize:], tsLow) < 0
+			if lastSequenceForKey <= compact.smallestSnapshot {
+				// Hidden by an newer entry for same user key
+				drop = true
+			} else if covered {
+				// Deleted by a range tombstone of the inputs, which the
+				// outputs keep as long as lower levels may hold entries
+				// it deletes
+				drop = true
+			} else if belowTsLow && keptBelowTsLow {
+				// Hidden by a newer version older than tsLow, which is the
+				// one the reads as of tsLow or later see
+				drop = true
+			} else if tsSize == 0 && (ikey.Type == ValueType_Deletion || obsolete) &&
+				ikey.Sequence <= compact.smallestSnapshot &&
+				compact.compaction.IsBaseLevelForKey(ikey.UserKey) {
+				// For this user key:
+				// (1) there is no data in higher levels
+				// (2) data in lower levels will have larger sequence numbers
+				// (3) data in layers that are being compacted here and have
+				//     smaller sequence numbers will be dropped in the next
+				//     few iterations of this loop (by rule (A) above).
+				// Therefore this deletion marker, or obsolete value, can be
+				// dropped.
+				// With timestamps the marker also hides the older versions of
+				// the key, which are other user keys, so it is kept.
+				drop = true
+			} else if obsolete {
+				// The value is reclaimed, the marker still hides the older
+				// entries of the key in lower levels
+				ikey.Type = ValueType_Deletion
+				key = DumpInternalKey(ikey)
+				value = nil
+			}
+			if belowTsLow && !drop {
+				keptBelowTsLow = true
+			}
+
+			lastSequenceForKey = ikey.Sequence
+		}
+
+		if !drop {
+			if err = db.addCompactionOutput(compact, input, key, value); err != nil {
+				break
+			}
+		}
+
+		input.Next()
+	}
+
+	if err == nil && db.shuttingDown.Load() {
+		err = Error(Code_IOError, "deleting DB during compaction")
+	}
+	if err == nil && compact.builder == nil && compact.hasPendingRangeTombstones(ucmp) {
+		err = db.openCompactionOutputFile(compact)
+	}
+	if err == nil && compact.builder != nil {
+		err = db.finishCompactionOutputFile(compact, input, nil)
+	}
+	if err == nil {
+		err = input.Error()
+	}
+	input.Close()
+
+	var stats compactionStats
+	stats.micros = db.env.NowMicros() - startMicros - immMicros
+	for which := 0; which < 2; which++ {
+		for i := 0; i < compact.compaction.NumInputFiles(which); i++ {
+			stats.bytesRead += compact.compaction.Input(which, i).fileSize
+		}
+	}
+	for _, out := range compact.outputs {
+		stats.bytesWritten += out.fileSize
+	}
+
+	db.mu.Lock()
+	cfd.stats[compact.compaction.Level()+1].Add(&stats)
+
+	if err == nil {
+		err = db.installCompactionResults(compact)
+	}
+	return err
+}
+
+// Close waits for pending writes and background work, then releases the
+// files held by the DB.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// Wait for the queue to drain by queueing up as a writer
+	db.waitForWriteTurn(newWriter(&db.mu, nil, false))
+	db.leaveWriteQueue()
+	if db.logfile == nil {
+		return nil
+	}
+
+	// Wait for background work to finish.
+	db.shuttingDown.Store(true)
+	for db.bgCompactionScheduled {
+		db.bgCV.Wait()
+	}
+
+	err := db.logfile.Close()
+	db.logfile = nil
+	for _, cfd := range db.versions.columnFamilies {
+		cfd.tableCache.Close()
+	}
+	return err
+}
+
+// waitForWriteTurn queues w up as a writer and waits until it is at the
+// front of the queue, where it is the only one to change the DB.
+// REQUIRES: db.mu is held
+func (db *DB) waitForWriteTurn(w *writer) {
+	db.writers = append(db.writers, w)
+	for w != db.writers[0] {
+		w.cv.Wait()
+	}
+}
+
+// leaveWriteQueue removes the writer at the front of the queue and wakes up
+// the next one.
+// REQUIRES: db.mu is held
+func (db *DB) leaveWriteQueue() {
+	db.writers = db.writers[1:]
+	if len(db.writers) > 0 {
+		db.writers[0].cv.Signal()
+	}
+}
+
+// CreateColumnFamily creates a column family named name with options.  Its
+// entries are written along with the ones of the other families to the log
+// of the DB, its tables are apart.
+func (db *DB) CreateColumnFamily(options *ColumnFamilyOptions, name string) (*ColumnFamilyHandle, error) {
+	if db.options.Format == Format_LevelDB {
+		return nil, Error(Code_NotSupported, "Format_LevelDB does not support column families")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// No write may tag its entries with the id before the family is created
+	db.waitForWriteTurn(newWriter(&db.mu, nil, false))
+	defer db.leaveWriteQueue()
+	if db.logfile == nil {
+		return nil, Error(Code_InvalidArgument, "db is closed")
+	}
+	if db.versions.columnFamilyByName(name) != nil {
+		return nil, Error(Code_InvalidArgument, "column family "+name+" already exists")
+	}
+
+	id := db.versions.maxColumnFamily + 1
+	cfd := newColumnFamilyData(id, name, db.dbname, db.versions.options, options)
+	cfd.mem = cfd.newMemTable()
+	cfd.memLogNumber = db.logfileNumber
+	edit := NewVersionEdit()
+	edit.SetColumnFamily(id)
+	edit.AddColumnFamily(name)
+	edit.SetComparatorName(cfd.icmp.comparator.Name())
+	edit.SetMaxColumnFamily(id)
+	edit.SetLogNumber(db.logfileNumber)
+	if err := db.versions.logAndApply(cfd, edit, &db.mu); err != nil {
+		cfd.tableCache.Close()
+		return nil, err
+	}
+	return &ColumnFamilyHandle{cfd: cfd}, nil
+}
+
+// DropColumnFamily drops the column family cf, its entries are deleted.
+// The default column family cannot be dropped.
+func (db *DB) DropColumnFamily(cf *ColumnFamilyHandle) error {
+	cfd := cf.cfd
+	if cfd.id == kDefaultColumnFamily {
+		return Error(Code_InvalidArgument, "cannot drop the default column family")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.waitForWriteTurn(newWriter(&db.mu, nil, false))
+	defer db.leaveWriteQueue()
+	if db.logfile == nil {
+		return Error(Code_InvalidArgument, "db is closed")
+	}
+	if cfd.dropped {
+		return Error(Code_InvalidArgument, "column family "+cfd.name+" already dropped")
+	}
+
+	edit := NewVersionEdit()
+	edit.SetColumnFamily(cfd.id)
+	edit.DropColumnFamily()
+	if err := db.versions.LogAndApply(edit, &db.mu); err != nil {
+		return err
+	}
+	db.updateHasImm()
+	db.deleteObsoleteFiles()
+	return nil
+}
+
+// Put sets the database entry for "key" to "value".
+// Note: consider setting options.Sync = true.
+func (db *DB) Put(options *WriteOptions, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return db.Write(options, batch)
+}
+
+// PutWithTTL sets the database entry for "key" to "value" for ttl, after
+// which reads no longer see the entry and compactions drop it.  ttl must be
+// positive, it is rounded up to the micro-second, and an expiry past the
+// largest one representable is clamped to it.
+func (db *DB) PutWithTTL(options *WriteOptions, key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return Error(Code_InvalidArgument, "ttl must be positive")
+	}
+	batch := NewWriteBatch()
+	batch.PutWithExpiry(key, value, expiryAfter(db.env.NowUnixMicros(), ttl))
+	return db.Write(options, batch)
+}
+
+// expiryAfter returns the expiry of an entry written at now with the
+// positive ttl, saturated at the largest expiry.
+func expiryAfter(now uint64, ttl time.Duration) uint64 {
+	micros := uint64(ttl / time.Microsecond)
+	if ttl%time.Microsecond != 0 {
+		micros++
+	}
+	if now > math.MaxUint64-micros {
+		return math.MaxUint64
+	}
+	return now + micros
+}
+
+// Merge merges "operand" into the database entry for "key" with the
+// Options.MergeOperator.
+func (db *DB) Merge(options *WriteOptions, key, operand []byte) error {
+	batch := NewWriteBatch()
+	batch.Merge(key, operand)
+	return db.Write(options, batch)
+}
+
+// DeleteRange removes the database entries (if any) for the keys in
+// ["begin", "end").  It is not an error if no key of the range existed.
+// The range is deleted by a single tombstone, compactions drop the entries
+// it covers.
+func (db *DB) DeleteRange(options *WriteOptions, begin, end []byte) error {
+	ucmp := db.defaultColumnFamily.cfd.icmp.comparator
+	if timestampSize(ucmp) > 0 {
+		return Error(Code_NotSupported, "DeleteRange does not support timestamps")
+	}
+	if ucmp.Compare(begin, end) > 0 {
+		return Error(Code_InvalidArgument, "begin key comes after end key")
+	}
+	batch := NewWriteBatch()
+	batch.DeleteRange(begin, end)
+	return db.Write(options, batch)
+}
+
+// SingleDelete removes the database entry (if any) for "key", which must
+// have been written by a single Put since it was last deleted, if ever.
+// Unlike the tombstone of Delete, which compactions only drop once it
+// reaches the last level holding the key, the tombstone of SingleDelete
+// is dropped along with the value it deletes by the first compaction
+// meeting both.
+//
+// The behavior is undefined if "key" was written several times, merged or
+// deleted by Delete or DeleteRange since: the single deletion only cancels
+// out with the newest value, so reads may see an older one again.
+func (db *DB) SingleDelete(options *WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.SingleDelete(key)
+	return db.Write(options, batch)
+}
+
+// Delete removes the database entry (if any) for "key".
+// It is not an error if "key" did not exist in the database.
+// Note: consider setting options.Sync = true.
+func (db *DB) Delete(options *WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.Write(options, batch)
+}
+
+// PutCF sets the entry for "key" to "value" in the column family cf.
+func (db *DB) PutCF(options *WriteOptions, cf *ColumnFamilyHandle, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.PutCF(cf, key, value)
+	return db.Write(options, batch)
+}
+
+// DeleteCF removes the entry (if any) for "key" from the column family cf.
+func (db *DB) DeleteCF(options *WriteOptions, cf *ColumnFamilyHandle, key []byte) error {
+	batch := NewWriteBatch()
+	batch.DeleteCF(cf, key)
+	return db.Write(options, batch)
+}
+
+// Write applies the specified updates to the database, atomically across
+// the column families they write to.
+//
+// Concurrent writers are queued, the writer at the front of the queue
+// becomes the leader: it merges the batches of the writers behind it into
+// one group, appends the group as a single log record, applies it to the
+// memtables and then wakes the followers with the result.
+func (db *DB) Write(options *WriteOptions, updates *WriteBatch) error {
+	return db.write(options, updates, nil)
+}
+
+// write is Write with a writer callback, see writer.callback.
+func (db *DB) write(options *WriteOptions, updates *WriteBatch, callback func() error) error {
+	if updates != nil {
+		if db.options.Format == Format_LevelDB && !hasOnlyLevelDBRecords(updates) {
+			return Error(Code_InvalidArgument, "Format_LevelDB only supports Put and Delete")
+		}
+		if db.options.MergeOperator == nil && hasMerge(updates) {
+			return Error(Code_InvalidArgument, "merge operator not set")
+		}
+		db.mu.Lock()
+		tsSize, err := db.batchTimestampSize(updates)
+		db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if tsSize > 0 && hasRangeDeletion(updates) {
+			return Error(Code_NotSupported, "DeleteRange does not support timestamps")
+		}
+		if updates, err = stampBatch(tsSize, options, updates); err != nil {
+			return err
+		}
+	}
+	w := newWriter(&db.mu, updates, options.Sync)
+	w.callback = callback
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.writers = append(db.writers, w)
+	for !w.done && w != db.writers[0] {
+		w.cv.Wait()
+	}
+	if w.done {
+		return w.err
+	}
+	if db.logfile == nil {
+		db.writers = db.writers[1:]
+		if len(db.writers) > 0 {
+			db.writers[0].cv.Signal()
+		}
+		return Error(Code_InvalidArgument, "db is closed")
+	}
+
+	var err error
+	if w.callback != nil {
+		// No write can happen meanwhile, w leads the queue
+		db.mu.Unlock()
+		err = w.callback()
+		db.mu.Lock()
+	}
+	if err == nil {
+		// May temporarily unlock and wait.
+		err = db.makeRoomForWrite(updates == nil)
+	}
+	lastSequence := db.versions.LastSequence()
+	group := []*writer{w}
+	if err == nil && updates != nil { // nil batch is for compactions
+		var writeBatch *WriteBatch
+		writeBatch, group = db.buildBatchGroup()
+		writeBatch.setSequence(lastSequence + 1)
+		lastSequence += SequenceNumber(writeBatch.Count())
+
+		// Add to log and apply to memtable.  We can release the lock
+		// during this phase since w is currently responsible for logging
+		// and protects against concurrent loggers and concurrent writes
+		// into the memtables.
+		mems := make(map[uint32]*MemTable)
+		for _, cfd := range db.versions.liveColumnFamilies() {
+			mems[cfd.id] = cfd.mem
+		}
+		db.mu.Unlock()
+		err = db.log.AddRecord(writeBatch.encodedContents(db.options.Format))
+		syncError := false
+		if err == nil && options.Sync {
+			err = db.logfile.Sync()
+			if err != nil {
+				syncError = true
+			}
+		}
+		if err == nil {
+			err = insertBatchGroup(mems, writeBatch, group)
+		}
+		db.mu.Lock()
+		if syncError {
+			// The state of the log file is indeterminate: the log record we
+			// just added may or may not show up when the DB is re-opened.
+			// So we force the DB into a mode where all future writes fail.
+			db.recordBackgroundError(err)
+		}
+		db.tmpBatch.Clear()
+
+		// Publish the sequence only after the whole group is in the
+		// memtable so readers never observe a partial batch.
+		db.versions.SetLastSequence(lastSequence)
+	}
+
+	for _, ready := range group {
+		if ready != w {
+			ready.err = err
+			ready.done = true
+			ready.cv.Signal()
+		}
+	}
+	db.writers = db.writers[len(group):]
+
+	// Notify new head of write queue
+	if len(db.writers) > 0 {
+		db.writers[0].cv.Signal()
+	}
+
+	return err
+}
+
+// insertBatchGroup applies the merged batch of a group to mems, the
+// memtables of the column families by id.  With concurrent memtables every
+// batch of the group is inserted by its own goroutine using the sequence
+// range it got up front, the batches of the writers are not modified.
+// REQUIRES: the caller is the leader of group and db.mu is not held
+func insertBatchGroup(mems map[uint32]*MemTable, writeBatch *WriteBatch, group []*writer) error {
+	if !mems[kDefaultColumnFamily].allowConcurrentWrite || len(group) == 1 {
+		return writeBatch.insertInto(mems)
+	}
+
+	seq := writeBatch.sequence()
+	errs := make([]error, len(group))
+	var wg sync.WaitGroup
+	for i, member := range group {
+		wg.Add(1)
+		go func(i int, batch *WriteBatch, seq SequenceNumber) {
+			defer wg.Done()
+			errs[i] = batch.insertIntoAt(seq, mems)
+		}(i, member.batch, seq)
+		seq += SequenceNumber(member.batch.Count())
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildBatchGroup merges the batches of the writers at the front of the
+// queue into db.tmpBatch and returns it with the writers it covers.  The
+// batches of the writers are left untouched, so that the callers may reuse
+// them.
+// REQUIRES: Writer list must be non-empty
+// REQUIRES: First writer must have a non-nil batch
+func (db *DB) buildBatchGroup() (*WriteBatch, []*writer) {
+	first := db.writers[0]
+	if first.batch == nil {
+		panic("first writer batch is nil")
+	}
+	result := db.tmpBatch
+	if result.Count() != 0 {
+		panic("tmp batch is not empty")
+	}
+	result.Append(first.batch)
+
+	size := first.batch.ApproximateSize()
+
+	// Allow the group to grow up to a maximum size, but if the
+	// original write is small, limit the growth so we do not slow
+	// down the small write too much.
+	maxSize := 1 << 20
+	if size <= (128 << 10) {
+		maxSize = size + (128 << 10)
+	}
+
+	n := 1
+	for _, w := range db.writers[1:] {
+		if w.sync && !first.sync {
+			// Do not include a sync write into a batch handled by a non-sync write.
+			break
+		}
+
+		if w.batch == nil || w.callback != nil {
+			// The callback has to run before the batch is written
+			break
+		}
+		size += w.batch.ApproximateSize()
+		if size > maxSize {
+			// Do not make batch too big
+			break
+		}
+
+		result.Append(w.batch)
+		n++
+	}
+	return result, db.writers[:n]
+}
+
+// makeRoomForWrite makes sure there is room in the memtable for the next
+// write, it throttles the writer while level-0 or the memtable flush falls
+// behind:
+//
+//   - past Level0SlowdownWritesTrigger level-0 files the write is delayed
+//     by 1ms, once per write
+//   - while the previous memtable is still being flushed the write blocks
+//   - past Level0StopWritesTrigger level-0 files the write blocks until a
+//     compaction catches up
+//
+// If force is true, the current memtable is switched even if it has room.
+// REQUIRES: db.mu is held
+// REQUIRES: this goroutine is currently at the front of the writer queue
+func (db *DB) makeRoomForWrite(force bool) error {
+	allowDelay := !force
+	for {
+		// The column families to switch to a new memtable, the triggers
+		// apply to the family with the most level-0 files
+		var full []*columnFamilyData
+		level0Files := 0
+		immPending := false
+		for _, cfd := range db.versions.liveColumnFamilies() {
+			if n := cfd.current.NumFiles(0); n > level0Files {
+				level0Files = n
+			}
+			if force || cfd.mem.ApproximateMemoryUsage() > cfd.options.WriteBufferSize {
+				full = append(full, cfd)
+				immPending = immPending || cfd.imm != nil
+			}
+		}
+
+		if db.bgError != nil {
+			// Yield previous error
+			return db.bgError
+		} else if allowDelay && level0Files >= db.options.Level0SlowdownWritesTrigger {
+			// We are getting close to hitting a hard limit on the number of
+			// L0 files.  Rather than delaying a single write by several
+			// seconds when we hit the hard limit, start delaying each
+			// individual write by 1ms to reduce latency variance.  Also,
+			// this delay hands over some CPU to the compaction goroutine in
+			// case it is sharing the same core as the writer.
+			db.mu.Unlock()
+			start := db.env.NowMicros()
+			db.env.SleepForMicroseconds(1000)
+			elapsed := db.env.NowMicros() - start
+			db.mu.Lock()
+			db.stallStats.l0SlowdownCount++
+			db.stallStats.l0SlowdownMicros += elapsed
+			allowDelay = false // Do not delay a single write more than once
+		} else if len(full) == 0 {
+			// There is room in current memtables
+			return nil
+		} else if immPending {
+			// We have filled up a current memtable, but the previous
+			// one is still being compacted, so we wait.
+			start := db.env.NowMicros()
+			db.bgCV.Wait()
+			db.stallStats.memtableStopCount++
+			db.stallStats.memtableStopMicros += db.env.NowMicros() - start
+		} else if level0Files >= db.options.Level0StopWritesTrigger {
+			// There are too many level-0 files.
+			start := db.env.NowMicros()
+			db.bgCV.Wait()
+			db.stallStats.l0StopCount++
+			db.stallStats.l0StopMicros += db.env.NowMicros() - start
+		} else {
+			// Attempt to switch to a new memtable and trigger compaction of old
+			newLogNumber := db.versions.NewFileNumber()
+			logfile, err := db.env.NewWritableFile(LogFileName(db.dbname, newLogNumber))
+			if err != nil {
+				// Avoid chewing through file number space in a tight loop.
+				db.versions.ReuseFileNumber(newLogNumber)
+				return err
+			}
+
+			if err := db.logfile.Close(); err != nil {
+				// We may have lost writes to the previous log file.
+				// Switch to the new log file anyway, but record as a background
+				// error so we do not attempt any more writes.
+				//
+				// We could perhaps attempt to save the memtable corresponding
+				// to log file and suppress the error if that works, but that
+				// would add more complexity in a critical code path.
+				db.recordBackgroundError(err)
+			}
+			db.logfile = logfile
+			db.logfileNumber = newLogNumber
+			db.log = NewLogWriter(logfile, db.options.Format)
+			for _, cfd := range full {
+				cfd.imm = cfd.mem
+				cfd.mem = cfd.newMemTable()
+				cfd.memLogNumber = newLogNumber
+			}
+			db.hasImm.Store(true)
+			force = false // Do not force another compaction if have room
+			db.maybeScheduleCompaction()
+		}
+	}
+}
+
+// batchTimestampSize returns the size of the timestamps of the comparators
+// of the column families updates writes to, which have to agree.
+// REQUIRES: db.mu is held
+func (db *DB) batchTimestampSize(updates *WriteBatch) (int, error) {
+	columnFamilies := batchColumnFamilies(updates)
+	if len(columnFamilies) == 0 {
+		columnFamilies = map[uint32]struct{}{kDefaultColumnFamily: {}}
+	}
+	size := -1
+	for id := range columnFamilies {
+		cfd := db.versions.columnFamilies[id]
+		if cfd == nil || cfd.dropped {
+			return 0, Error(Code_InvalidArgument, fmt.Sprintf("invalid column family %d", id))
+		}
+		cfSize := timestampSize(cfd.icmp.comparator)
+		if size >= 0 && cfSize != size {
+			return 0, Error(Code_InvalidArgument, "column families with different timestamp sizes")
+		}
+		size = cfSize
+	}
+	return size, nil
+}
+
+// stampBatch returns updates with the timestamp of options appended to
+// its keys when the comparators have timestamps of the given size.
+func stampBatch(size int, options *WriteOptions, updates *WriteBatch) (*WriteBatch, error) {
+	switch {
+	case size == 0 && options.Timestamp == nil:
+		return updates, nil
+	case size == 0:
+		return nil, Error(Code_InvalidArgument, "comparator does not support timestamps")
+	case len(options.Timestamp) != size:
+		return nil, Error(Code_InvalidArgument, "timestamp size mismatch")
+	}
+	return updates.withTimestamp(options.Timestamp)
+}
+
+// readTimestamp returns the timestamp reads with options in the column
+// family cfd are as of, the newest one if unset, or nil when the comparator
+// has no timestamps.
+// REQUIRES: db.mu is held
+func readTimestamp(cfd *columnFamilyData, options *ReadOptions) ([]byte, error) {
+	size := timestampSize(cfd.icmp.comparator)
+	switch {
+	case size == 0 && options.Timestamp == nil:
+		return nil, nil
+	case size == 0:
+		return nil, Error(Code_InvalidArgument, "comparator does not support timestamps")
+	case options.Timestamp == nil:
+		return maxTimestamp(size), nil
+	case len(options.Timestamp) != size:
+		return nil, Error(Code_InvalidArgument, "timestamp size mismatch")
+	case bytes.Compare(options.Timestamp, cfd.fullHistoryTsLow) < 0:
+		return nil, Error(Code_InvalidArgument, "read timestamp is below the full history low")
+	}
+	return options.Timestamp, nil
+}
+
+// IncreaseFullHistoryTsLow raises the full history low to ts.  Compactions
+// then keep, of the versions of a key older than ts, only the newest one
+// since reads as of ts or later cannot see the others, and reads as of a
+// timestamp older than ts are rejected.
+func (db *DB) IncreaseFullHistoryTsLow(ts []byte) error {
+	cfd := db.defaultColumnFamily.cfd
+	size := timestampSize(cfd.icmp.comparator)
+	if size == 0 {
+		return Error(Code_InvalidArgument, "comparator does not support timestamps")
+	}
+	if len(ts) != size {
+		return Error(Code_InvalidArgument, "timestamp size mismatch")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if bytes.Compare(ts, cfd.fullHistoryTsLow) < 0 {
+		return Error(Code_InvalidArgument, "cannot decrease the full history low")
+	}
+	cfd.fullHistoryTsLow = append([]byte{}, ts...)
+	return nil
+}
+
+// Get returns the value for "key" if the database contains an entry for
+// it, a Code_NotFound error otherwise.
+func (db *DB) Get(options *ReadOptions, key []byte) ([]byte, error) {
+	return db.GetCF(options, db.defaultColumnFamily, key)
+}
+
+// GetCF is Get in the column family cf.
+func (db *DB) GetCF(options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	ctx, err := db.lookup(options, cf.cfd, key)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.result()
+}
+
+// lookup looks "key" up in the column family cfd, as of options.Snapshot if
+// set, and returns the context of the lookup.
+func (db *DB) lookup(options *ReadOptions, cfd *columnFamilyData, key []byte) (*getContext, error) {
+	db.mu.Lock()
+	if cfd.dropped {
+		db.mu.Unlock()
+		return nil, Error(Code_InvalidArgument, "column family "+cfd.name+" dropped")
+	}
+	ts, err := readTimestamp(cfd, options)
+	if err != nil {
+		db.mu.Unlock()
+		return nil, err
+	}
+	if ts != nil {
+		key = append(append([]byte{}, key...), ts...)
+	}
+	snapshot := db.versions.LastSequence()
+	if options.Snapshot != nil {
+		snapshot = options.Snapshot.sequence
+	}
+	mem := cfd.mem
+	imm := cfd.imm
+	current := cfd.current
+	current.Ref()
+
+	// Unlock while reading from files and memtables
+	db.mu.Unlock()
+	// First look in the memtable, then in the immutable memtable (if any).
+	lkey := NewLookupKey(key, snapshot)
+	ctx := newGetContext(cfd.icmp.comparator, db.options.MergeOperator,
+		lkey.UserKey(), snapshot, db.env.NowUnixMicros())
+	done := mem.Get(lkey, ctx)
+	if !done && imm != nil {
+		done = imm.Get(lkey, ctx)
+	}
+	if !done {
+		err = current.Get(options, lkey, ctx)
+	}
+	db.mu.Lock()
+
+	current.Unref()
+	db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// latestSequence returns the sequence number of the newest entry of "key"
+// in the column family cfd, or of the newest range tombstone deleting it,
+// 0 if there is none.
+func (db *DB) latestSequence(cfd *columnFamilyData, key []byte) (SequenceNumber, error) {
+	ctx, err := db.lookup(&ReadOptions{}, cfd, key)
+	if err != nil {
+		return 0, err
+	}
+	if ctx.state == saverState_Corrupt {
+		_, err = ctx.result()
+		return 0, err
+	}
+	return ctx.newestSeq, nil
+}
+
+// GetSnapshot returns a snapshot of the current state of the DB, see
+// ReadOptions.Snapshot.  The caller must call ReleaseSnapshot once the
+// snapshot is no longer needed.
+func (db *DB) GetSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.snapshots.add(db.versions.LastSequence())
+}
+
+// ReleaseSnapshot releases a snapshot acquired by GetSnapshot.
+func (db *DB) ReleaseSnapshot(snapshot *Snapshot) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.snapshots.remove(snapshot)
+}
+
+// newInternalIterator returns an iterator over the internal keys of the
+// memtables and of the current version of the column family cfd, the
+// latter is pinned until the iterator is closed.  The sequence number to
+// read at, the one of options.Snapshot or else the latest, is returned
+// along, with the range tombstones of the memtables and of the version
+// which the iterator does not yield.
+func (db *DB) newInternalIterator(cfd *columnFamilyData, options *ReadOptions) (Iterator, SequenceNumber, *fragmentedRangeTombstones) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	latestSnapshot := db.versions.LastSequence()
+	if options.Snapshot != nil {
+		latestSnapshot = options.Snapshot.sequence
+	}
+	if cfd.dropped {
+		return NewErrorIterator(Error(Code_InvalidArgument, "column family "+cfd.name+" dropped")), latestSnapshot, nil
+	}
+
+	// Collect together all needed child iterators
+	list := []Iterator{cfd.mem.NewIterator()}
+	tombstones, err := cfd.mem.rangeTombstones()
+	if cfd.imm != nil {
+		list = append(list, cfd.imm.NewIterator())
+		if err == nil {
+			var immTombstones []rangeTombstone
+			immTombstones, err = cfd.imm.rangeTombstones()
+			tombstones = append(tombstones, immTombstones...)
+		}
+	}
+	current := cfd.current
+	if err == nil {
+		var tableTombstones []rangeTombstone
+		tableTombstones, err = current.RangeTombstones()
+		tombstones = append(tombstones, tableTombstones...)
+	}
+	if err != nil {
+		for _, iter := range list {
+			iter.Close()
+		}
+		return NewErrorIterator(err), latestSnapshot, nil
+	}
+	current.AddIterators(options, &list)
+	internalIter := NewMergingIterator(cfd.icmp, list)
+	current.Ref()
+	internalIter.RegisterCleanup(func() {
+		db.mu.Lock()
+		current.Unref()
+		db.mu.Unlock()
+	})
+	return internalIter, latestSnapshot, fragmentRangeTombstones(cfd.icmp.comparator, tombstones)
+}
+
+// NewIterator returns an iterator over the contents of the database.
+// The result of NewIterator() is initially invalid (caller must
+// call one of the Seek methods on the iterator before using it).
+//
+// Caller should Close the iterator when it is no longer needed, the
+// iterator keeps the files it reads from alive until then.
+func (db *DB) NewIterator(options *ReadOptions) Iterator {
+	return db.NewIteratorCF(options, db.defaultColumnFamily)
+}
+
+// NewIteratorCF is NewIterator over the column family cf.
+func (db *DB) NewIteratorCF(options *ReadOptions, cf *ColumnFamilyHandle) Iterator {
+	cfd := cf.cfd
+	db.mu.Lock()
+	ts, err := readTimestamp(cfd, options)
+	db.mu.Unlock()
+	if err != nil {
+		return NewErrorIterator(err)
+	}
+	if ts != nil {
+		// The bounds are before all the versions of their keys
+		stamped := *options
+		stamped.Timestamp = ts
+		maxTs := maxTimestamp(len(ts))
+		if options.LowerBound != nil {
+			stamped.LowerBound = append(append([]byte{}, options.LowerBound...), maxTs...)
+		}
+		if options.UpperBound != nil {
+			stamped.UpperBound = append(append([]byte{}, options.UpperBound...), maxTs...)
+		}
+		options = &stamped
+	}
+	iter, latestSnapshot, rangeDels := db.newInternalIterator(cfd, options)
+	return newDBIterator(cfd.icmp.comparator, iter, latestSnapshot, rangeDels,
+		db.env.NowUnixMicros(), options, db.options.PrefixExtractor, db.options.MergeOperator)
+}
+
+// GetProperty returns the value of a DB property, the ones about files
+// describe the default column family.  Valid property names include:
+//
+//	"leveldb.num-files-at-level<N>" - return the number of files at level <N>,
+//	   where <N> is an ASCII representation of a level number (e.g. "0").
+//	"leveldb.stats" - returns a multi-line string that describes statistics
+//	   about the internal operation of the DB, including write stalls.
+//	"leveldb.write-stall-stats" - returns the number of writes delayed or
+//	   blocked by the level-0 and memtable triggers and the time they waited.
+//	"leveldb.sstables" - returns a multi-line string that describes all
+//	   of the sstables that make up the db contents.
+//	"leveldb.approximate-memory-usage" - returns the approximate number of
+//	   bytes of memory in use by the DB.
+//
+// The second return value is false if "property" is not a valid property.
+func (db *DB) GetProperty(property string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !strings.HasPrefix(property, "leveldb.") {
+		return "", false
+	}
+	in := property[len("leveldb."):]
+
+	if strings.HasPrefix(in, "num-files-at-level") {
+		level, err := strconv.Atoi(in[len("num-files-at-level"):])
+		if err != nil || level < 0 || level >= kNumLevels {
+			return "", false
+		}
+		return strconv.Itoa(db.versions.NumLevelFiles(level)), true
+	}
+
+	stats := &db.defaultColumnFamily.cfd.stats
+	switch in {
+	case "stats":
+		var r strings.Builder
+		r.WriteString("                               Compactions\n" +
+			"Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n" +
+			"--------------------------------------------------\n")
+		for level := 0; level < kNumLevels; level++ {
+			files := db.versions.NumLevelFiles(level)
+			if stats[level].micros > 0 || files > 0 {
+				fmt.Fprintf(&r, "%3d %8d %8.0f %9.0f %8.0f %9.0f\n", level, files,
+					float64(db.versions.NumLevelBytes(level))/1048576.0,
+					float64(stats[level].micros)/1e6,
+					float64(stats[level].bytesRead)/1048576.0,
+					float64(stats[level].bytesWritten)/1048576.0)
+			}
+		}
+		r.WriteString("\n                               Write stalls\n" +
+			"Cause              Count   Time(sec)\n" +
+			"------------------------------------\n")
+		s := &db.stallStats
+		fmt.Fprintf(&r, "level0-slowdown %8d %11.3f\n", s.l0SlowdownCount, float64(s.l0SlowdownMicros)/1e6)
+		fmt.Fprintf(&r, "memtable-stop   %8d %11.3f\n", s.memtableStopCount, float64(s.memtableStopMicros)/1e6)
+		fmt.Fprintf(&r, "level0-stop     %8d %11.3f\n", s.l0StopCount, float64(s.l0StopMicros)/1e6)
+		return r.String(), true
+	case "write-stall-stats":
+		s := &db.stallStats
+		return fmt.Sprintf("level0-slowdown-count=%d level0-slowdown-micros=%d "+
+			"memtable-stop-count=%d memtable-stop-micros=%d "+
+			"level0-stop-count=%d level0-stop-micros=%d",
+			s.l0SlowdownCount, s.l0SlowdownMicros,
+			s.memtableStopCount, s.memtableStopMicros,
+			s.l0StopCount, s.l0StopMicros), true
+	case "sstables":
+		return db.versions.Current().DebugString(), true
+	case "approximate-memory-usage":
+		var totalUsage uint64
+		for _, cfd := range db.versions.liveColumnFamilies() {
+			totalUsage += cfd.mem.ApproximateMemoryUsage()
+			if cfd.imm != nil {
+				totalUsage += cfd.imm.ApproximateMemoryUsage()
+			}
+		}
+		return strconv.FormatUint(totalUsage, 10), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,496 @@
+package leveldb
+
+import "bytes"
+
+// dbIter merges the internal iterator over memtables and tables into the
+// user view of the DB: for each user key only the newest entry visible at
+// the sequence number is yielded, merged with the older ones if it is a
+// merge operand, and deleted or expired keys are skipped, as well as the
+// entries deleted by a range tombstone.
+//
+// The internal iterator is positioned at the entry yielding Key() and
+// Value() when direction is direction_Forward, or just after all entries
+// whose user key == Key() if they were merged into savedKey/savedValue.
+// When direction is direction_Reverse it is positioned just before all
+// entries whose user key == Key(), and the current entry is saved in
+// savedKey/savedValue.
+type dbIter struct {
+	cleanupList
+	userComparator Comparator
+	iter           Iterator
+	sequence       SequenceNumber
+	rangeDels      *fragmentedRangeTombstones // nil if there are none
+	now            uint64                     // Entries expired by now are hidden
+	// With a TimestampedComparator, the timestamp entries are read as of,
+	// else nil.  The keys yielded are then stripped of their timestamps.
+	timestamp  []byte
+	lowerBound []byte // nil means no lower bound
+	upperBound []byte // nil means no upper bound
+	// Extracts the prefix of the Seek target in prefix seek mode, else nil
+	prefixExtractor SliceTransform
+	prefix          []byte // nil unless restricted to the prefix of the last Seek
+	mergeOperator   MergeOperator
+
+	err        error
+	savedKey   []byte // == current key when direction==direction_Reverse
+	savedValue []byte // == current raw value when direction==direction_Reverse
+	direction  direction
+	valid      bool
+	merged     bool // Forward at a merged entry saved in savedKey/savedValue
+}
+
+var _ Iterator = (*dbIter)(nil)
+
+// newDBIterator returns a new iterator that converts internal keys (yielded by
+// "internalIter") that were live at the specified "sequence" number and
+// time "now", and not deleted by rangeDels, into appropriate user keys,
+// restricted to the bounds of options.  In prefix seek mode prefixExtractor restricts Seek to the prefix
+// of its target.  mergeOperator merges the merge operands.
+func newDBIterator(userComparator Comparator, internalIter Iterator, sequence SequenceNumber,
+	rangeDels *fragmentedRangeTombstones, now uint64, options *ReadOptions, prefixExtractor SliceTransform, mergeOperator MergeOperator) *dbIter {
+	if !options.PrefixSameAsStart {
+		prefixExtractor = nil
+	}
+	return &dbIter{
+		userComparator:  userComparator,
+		iter:            internalIter,
+		sequence:        sequence,
+		rangeDels:       rangeDels,
+		now:             now,
+		timestamp:       options.Timestamp,
+		lowerBound:      options.LowerBound,
+		upperBound:      options.UpperBound,
+		prefixExtractor: prefixExtractor,
+		mergeOperator:   mergeOperator,
+		direction:       direction_Forward,
+	}
+}
+
+// visible returns true if the entry ikey was written at or before the
+// sequence number and timestamp of the iterator.
+func (it *dbIter) visible(ikey *ParsedInternalKey) bool {
+	if ikey.Sequence > it.sequence {
+		return false
+	}
+	return it.timestamp == nil ||
+		bytes.Compare(ikey.UserKey[len(ikey.UserKey)-len(it.timestamp):], it.timestamp) <= 0
+}
+
+// rangeDeleted returns true if the entry ikey is deleted by a range
+// tombstone visible at the sequence number of the iterator.
+func (it *dbIter) rangeDeleted(ikey *ParsedInternalKey) bool {
+	return it.rangeDels.covers(ikey.UserKey, ikey.Sequence, it.sequence)
+}
+
+// compareUserKey compares user keys ignoring their timestamps, if any.
+func (it *dbIter) compareUserKey(a, b []byte) int8 {
+	if it.timestamp != nil {
+		return it.userComparator.(TimestampedComparator).CompareWithoutTimestamp(a, b)
+	}
+	return it.userComparator.Compare(a, b)
+}
+
+// stripTimestamp returns userKey without its timestamp, if any.
+func (it *dbIter) stripTimestamp(userKey []byte) []byte {
+	return userKey[:len(userKey)-len(it.timestamp)]
+}
+
+func (it *dbIter) beforeLowerBound(userKey []byte) bool {
+	return it.lowerBound != nil && it.userComparator.Compare(userKey, it.lowerBound) < 0
+}
+
+func (it *dbIter) atOrAfterUpperBound(userKey []byte) bool {
+	return it.upperBound != nil && it.userComparator.Compare(userKey, it.upperBound) >= 0
+}
+
+// outOfPrefix returns true if userKey does not share the prefix the
+// iterator is restricted to.  Keys sharing a prefix are contiguous, so once
+// a scan meets such a key all the following ones are out of the prefix too.
+func (it *dbIter) outOfPrefix(userKey []byte) bool {
+	if it.prefix == nil {
+		return false
+	}
+	userKey = it.stripTimestamp(userKey)
+	return !it.prefixExtractor.InDomain(userKey) ||
+		!bytes.Equal(it.prefixExtractor.Transform(userKey), it.prefix)
+}
+
+func (it *dbIter) Valid() bool {
+	return it.valid
+}
+
+func (it *dbIter) Key() []byte {
+	if !it.valid {
+		panic("db iterator is invalid")
+	}
+	if it.direction == direction_Forward && !it.merged {
+		return it.stripTimestamp(ExtractUserKey(it.iter.Key()))
+	}
+	return it.stripTimestamp(it.savedKey)
+}
+
+func (it *dbIter) Value() []byte {
+	if !it.valid {
+		panic("db iterator is invalid")
+	}
+	if it.direction == direction_Forward && !it.merged {
+		_, t := UnpackSequenceAndType(ExtractTag(it.iter.Key()))
+		value, _ := liveValue(t, it.iter.Value(), it.now)
+		return value
+	}
+	return it.savedValue
+}
+
+func (it *dbIter) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Error()
+}
+
+// Close closes the internal iterator and runs the cleanups releasing the
+// memtables and the version pinned by the iterator.
+func (it *dbIter) Close() error {
+	err := it.iter.Close()
+	it.valid = false
+	it.runCleanups()
+	return err
+}
+
+func (it *dbIter) parseKey() (*ParsedInternalKey, bool) {
+	ikey, err := ParseInternalKey(it.iter.Key())
+	if err != nil {
+		it.err = Error(Code_Corruption, "corrupted internal key in DBIter")
+		return nil, false
+	}
+	return ikey, true
+}
+
+func (it *dbIter) Next() {
+	if !it.valid {
+		panic("db iterator is invalid")
+	}
+
+	if it.direction == direction_Reverse { // Switch directions?
+		it.direction = direction_Forward
+		// iter is pointing just before the entries for Key(),
+		// so advance into the range of entries for Key() and then
+		// use the normal skipping code below.
+		if !it.iter.Valid() {
+			it.iter.SeekToFirst()
+		} else {
+			it.iter.Next()
+		}
+		if !it.iter.Valid() {
+			it.valid = false
+			it.savedKey = it.savedKey[:0]
+			return
+		}
+		// savedKey already contains the key to skip past.
+	} else if it.merged {
+		// iter is already past the entries of savedKey, the current key
+		it.merged = false
+		if !it.iter.Valid() {
+			it.valid = false
+			it.savedKey = it.savedKey[:0]
+			return
+		}
+	} else {
+		// Store in savedKey the current key so we skip it below.
+		it.savedKey = append(it.savedKey[:0], ExtractUserKey(it.iter.Key())...)
+
+		// iter is pointing to current key. We can now safely move to the next to
+		// avoid checking current key.
+		it.iter.Next()
+		if !it.iter.Valid() {
+			it.valid = false
+			it.savedKey = it.savedKey[:0]
+			return
+		}
+	}
+
+	it.findNextUserEntry(true)
+}
+
+// findNextUserEntry moves the internal iterator to the next entry visible
+// to the user. If skipping is true, entries for user keys <= savedKey are
+// skipped.
+func (it *dbIter) findNextUserEntry(skipping bool) {
+	// Loop until we hit an acceptable entry to yield
+	if !it.iter.Valid() || it.direction != direction_Forward {
+		panic("db iterator is not positioned forward")
+	}
+	for {
+		if ikey, ok := it.parseKey(); ok && it.visible(ikey) {
+			if it.atOrAfterUpperBound(ikey.UserKey) || it.outOfPrefix(ikey.UserKey) {
+				// All the following entries are out of bound as well
+				break
+			}
+			if _, live := liveValue(ikey.Type, it.iter.Value(), it.now); (!live && ikey.Type != ValueType_Merge) ||
+				it.rangeDeleted(ikey) {
+				// Arrange to skip all upcoming entries for this key since
+				// they are hidden by this deletion or expired value, or
+				// deleted by a range tombstone as this entry is.
+				it.savedKey = append(it.savedKey[:0], ikey.UserKey...)
+				skipping = true
+			} else if skipping && it.compareUserKey(ikey.UserKey, it.savedKey) <= 0 {
+				// Entry hidden
+			} else if ikey.Type == ValueType_Merge {
+				it.mergeValuesNewToOld()
+				return
+			} else {
+				it.valid = true
+				it.savedKey = it.savedKey[:0]
+				return
+			}
+		}
+		it.iter.Next()
+		if !it.iter.Valid() {
+			break
+		}
+	}
+	it.savedKey = it.savedKey[:0]
+	it.valid = false
+}
+
+func (it *dbIter) Prev() {
+	if !it.valid {
+		panic("db iterator is invalid")
+	}
+
+	if it.direction == direction_Forward { // Switch directions?
+		// iter is pointing at the current entry.  Scan backwards until
+		// the key changes so we can use the normal reverse scanning code.
+		if it.merged {
+			// iter is past the entries of savedKey, the current key, and
+			// invalid if they are the last ones
+			it.merged = false
+			if !it.iter.Valid() {
+				it.iter.SeekToLast()
+			}
+		} else {
+			if !it.iter.Valid() {
+				panic("internal iterator is invalid")
+			}
+			it.savedKey = append(it.savedKey[:0], ExtractUserKey(it.iter.Key())...)
+		}
+		for {
+			it.iter.Prev()
+			if !it.iter.Valid() {
+				it.valid = false
+				it.savedKey = it.savedKey[:0]
+				it.savedValue = nil
+				return
+			}
+			if it.compareUserKey(ExtractUserKey(it.iter.Key()), it.savedKey) < 0 {
+				break
+			}
+		}
+		it.direction = direction_Reverse
+	}
+
+	it.findPrevUserEntry()
+}
+
+// findPrevUserEntry moves the internal iterator before the entries of the
+// previous user key visible to the user, saving that entry.
+func (it *dbIter) findPrevUserEntry() {
+	if it.direction != direction_Reverse {
+		panic("db iterator is not positioned in reverse")
+	}
+
+	live := false         // savedValue holds the value of savedKey
+	var operands [][]byte // Merge operands of savedKey newer than its value, oldest first
+	if it.iter.Valid() {
+		for {
+			if ikey, ok := it.parseKey(); ok && it.visible(ikey) {
+				if it.beforeLowerBound(ikey.UserKey) || it.outOfPrefix(ikey.UserKey) {
+					// All the previous entries are out of bound as well
+					break
+				}
+				if (live || len(operands) > 0) && it.compareUserKey(ikey.UserKey, it.savedKey) < 0 {
+					// We encountered a non-deleted value in entries for previous keys,
+					break
+				}
+				if ikey.Type == ValueType_Merge && !it.rangeDeleted(ikey) {
+					it.savedKey = append(it.savedKey[:0], ExtractUserKey(it.iter.Key())...)
+					operands = append(operands, append([]byte{}, it.iter.Value()...))
+				} else {
+					operands = nil
+					var value []byte
+					value, live = liveValue(ikey.Type, it.iter.Value(), it.now)
+					if live && it.rangeDeleted(ikey) {
+						live = false
+					}
+					if !live {
+						it.savedKey = it.savedKey[:0]
+						it.savedValue = nil
+					} else {
+						it.savedKey = append(it.savedKey[:0], ExtractUserKey(it.iter.Key())...)
+						it.savedValue = append(it.savedValue[:0], value...)
+					}
+				}
+			}
+			it.iter.Prev()
+			if !it.iter.Valid() {
+				break
+			}
+		}
+	}
+	if len(operands) > 0 {
+		var base []byte
+		if live {
+			base = it.savedValue
+		}
+		value, err := it.merge(it.savedKey, base, operands)
+		if err != nil {
+			it.err = err
+		}
+		it.savedValue = value
+		live = err == nil
+	}
+
+	if !live {
+		// End
+		it.valid = false
+		it.savedKey = it.savedKey[:0]
+		it.savedValue = nil
+		it.direction = direction_Forward
+	} else {
+		it.valid = true
+	}
+}
+
+// mergeValuesNewToOld merges the merge operand at the current entry with
+// the older entries of its key, up to the value they apply to.  The result
+// is saved in savedKey/savedValue, iter is left past the entries of the
+// key.
+func (it *dbIter) mergeValuesNewToOld() {
+	it.savedKey = append(it.savedKey[:0], ExtractUserKey(it.iter.Key())...)
+	operands := [][]byte{append([]byte{}, it.iter.Value()...)}
+	var base []byte
+	hasBase := false // The value or deletion the operands apply to was met
+	for it.iter.Next(); it.iter.Valid(); it.iter.Next() {
+		ikey, ok := it.parseKey()
+		if !ok || it.compareUserKey(ikey.UserKey, it.savedKey) != 0 {
+			break
+		}
+		if hasBase || !it.visible(ikey) {
+			continue
+		}
+		if it.rangeDeleted(ikey) {
+			// The operands apply to no value
+			hasBase = true
+			continue
+		}
+		if ikey.Type == ValueType_Merge {
+			operands = append(operands, append([]byte{}, it.iter.Value()...))
+			continue
+		}
+		if value, live := liveValue(ikey.Type, it.iter.Value(), it.now); live {
+			base = append([]byte{}, value...)
+		}
+		hasBase = true
+	}
+
+	// Oldest first
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	value, err := it.merge(it.savedKey, base, operands)
+	if err != nil {
+		it.err = err
+		it.valid = false
+		it.savedKey = it.savedKey[:0]
+		return
+	}
+	it.savedValue = value
+	it.merged = true
+	it.valid = true
+}
+
+// merge returns the value of userKey made of base, nil if it has none,
+// updated by operands, oldest first.
+func (it *dbIter) merge(userKey, base []byte, operands [][]byte) ([]byte, error) {
+	if it.mergeOperator == nil {
+		return nil, Error(Code_InvalidArgument, "merge operator not set")
+	}
+	return it.mergeOperator.FullMerge(it.stripTimestamp(userKey), base, operands)
+}
+
+func (it *dbIter) Seek(target []byte) {
+	if it.timestamp != nil {
+		// Position at the newest version of target as of the timestamp
+		target = append(append([]byte{}, target...), it.timestamp...)
+	}
+	it.seek(target)
+}
+
+// seek positions at the first entry at or after the user key target,
+// including its timestamp if any.
+func (it *dbIter) seek(target []byte) {
+	if it.beforeLowerBound(target) {
+		target = it.lowerBound
+	}
+	it.merged = false
+	it.prefix = nil
+	if it.prefixExtractor != nil {
+		if key := it.stripTimestamp(target); it.prefixExtractor.InDomain(key) {
+			it.prefix = append([]byte{}, it.prefixExtractor.Transform(key)...)
+		}
+	}
+	it.direction = direction_Forward
+	it.savedValue = nil
+	it.savedKey = append(it.savedKey[:0], DumpInternalKey(NewParsedInternalKey(target, it.sequence, ValueType_ForSeek))...)
+	it.iter.Seek(it.savedKey)
+	if it.iter.Valid() {
+		it.findNextUserEntry(false)
+	} else {
+		it.valid = false
+	}
+}
+
+func (it *dbIter) SeekToFirst() {
+	if it.lowerBound != nil {
+		it.seekLowerBound()
+		return
+	}
+	it.merged = false
+	it.prefix = nil
+	it.direction = direction_Forward
+	it.savedValue = nil
+	it.iter.SeekToFirst()
+	if it.iter.Valid() {
+		it.findNextUserEntry(false)
+	} else {
+		it.valid = false
+	}
+}
+
+// seekLowerBound positions at the first key at or after the lower bound,
+// unrestricted by the prefix of the bound.
+func (it *dbIter) seekLowerBound() {
+	extractor := it.prefixExtractor
+	it.prefixExtractor = nil
+	it.seek(it.lowerBound)
+	it.prefixExtractor = extractor
+}
+
+func (it *dbIter) SeekToLast() {
+	it.merged = false
+	it.prefix = nil
+	it.direction = direction_Reverse
+	it.savedValue = nil
+	if it.upperBound != nil {
+		// Position before all the entries of the upper bound
+		it.iter.Seek(DumpInternalKey(NewParsedInternalKey(it.upperBound, KMaxSequenceNumber, ValueType_ForSeek)))
+		if it.iter.Valid() {
+			it.iter.Prev()
+		} else {
+			it.iter.SeekToLast()
+		}
+	} else {
+		it.iter.SeekToLast()
+	}
+	it.findPrevUserEntry()
+}
@@ -0,0 +1,1810 @@
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+func openTestDB(t *testing.T, options *Options) *DB {
+	options.CreateIfMissing = true
+	db, err := Open(options, t.TempDir())
+	assert.NoError(t, err)
+	return db
+}
+
+func newTestOptions() *Options {
+	return &Options{
+		Comparator: NewBytewiseComparator(),
+		Env:        &Env{},
+	}
+}
+
+func TestDB_OpenMissing(t *testing.T) {
+	_, err := Open(newTestOptions(), t.TempDir()+"/missing")
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+}
+
+func TestDB_PutGetDelete(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("foo"), []byte("v1")))
+	value, err := db.Get(DefaultReadOptions, []byte("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(value))
+
+	assert.NoError(t, db.Put(&WriteOptions{Sync: true}, []byte("foo"), []byte("v2")))
+	value, err = db.Get(DefaultReadOptions, []byte("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(value))
+
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("foo")))
+	_, err = db.Get(DefaultReadOptions, []byte("foo"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+}
+
+func TestDB_BuildBatchGroup(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	batch := func(key string) *WriteBatch {
+		b := NewWriteBatch()
+		b.Put([]byte(key), []byte(key))
+		return b
+	}
+	db.writers = []*writer{
+		newWriter(&db.mu, batch("a"), false),
+		newWriter(&db.mu, batch("b"), false),
+		newWriter(&db.mu, batch("c"), true),
+		newWriter(&db.mu, batch("d"), false),
+	}
+
+	// a sync write is never merged into a non-sync group
+	result, group := db.buildBatchGroup()
+	assert.Equal(t, 2, len(group))
+	assert.Equal(t, 2, result.Count())
+	assert.Equal(t, 1, db.writers[0].batch.Count())
+	db.tmpBatch.Clear()
+
+	// a non-sync write can join a sync group
+	db.writers = db.writers[2:]
+	result, group = db.buildBatchGroup()
+	assert.Equal(t, 2, len(group))
+	assert.Equal(t, 2, result.Count())
+	db.tmpBatch.Clear()
+
+	// a single writer's batch is copied too
+	db.writers = db.writers[1:]
+	result, group = db.buildBatchGroup()
+	assert.Equal(t, 1, len(group))
+	assert.True(t, result == db.tmpBatch)
+	assert.Equal(t, db.writers[0].batch.contents()[kWriteBatchHeader:], result.contents()[kWriteBatchHeader:])
+	db.tmpBatch.Clear()
+	db.writers = nil
+}
+
+func TestDB_ReuseWriteBatch(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		options := newTestOptions()
+		options.AllowConcurrentMemtableWrite = concurrent
+		db := openTestDB(t, options)
+
+		batch := NewWriteBatch()
+		batch.Put([]byte("a"), []byte("v1"))
+		batch.Delete([]byte("b"))
+		contents := append([]byte{}, batch.contents()...)
+		assert.NoError(t, db.Write(DefaultWriteOptions, batch))
+		assert.Equal(t, contents, batch.contents())
+
+		// The batch written again gets new sequence numbers
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v2")))
+		assert.NoError(t, db.Write(DefaultWriteOptions, batch))
+		assert.Equal(t, "v1", dbGet(t, db, "a"))
+		assert.Equal(t, SequenceNumber(5), db.versions.LastSequence())
+
+		// And so does a batch shared by concurrent writers
+		writers, writesPerWriter := 8, 20
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < writesPerWriter; i++ {
+					assert.NoError(t, db.Write(DefaultWriteOptions, batch))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, contents, batch.contents())
+		assert.Equal(t, SequenceNumber(5+2*writers*writesPerWriter), db.versions.LastSequence())
+		assert.NoError(t, db.Close())
+	}
+}
+
+func testConcurrentWrites(t *testing.T, options *Options) {
+	db := openTestDB(t, options)
+
+	writers := 16
+	writesPerWriter := 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				key := []byte(fmt.Sprintf("%02d-%04d", w, i))
+				assert.NoError(t, db.Put(&WriteOptions{Sync: i%10 == 0}, key, key))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writesPerWriter; i++ {
+			key := []byte(fmt.Sprintf("%02d-%04d", w, i))
+			value, err := db.Get(DefaultReadOptions, key)
+			assert.NoError(t, err)
+			assert.Equal(t, key, value)
+		}
+	}
+	assert.Equal(t, SequenceNumber(writers*writesPerWriter), db.versions.LastSequence())
+	logName := LogFileName(db.dbname, db.logfileNumber)
+	assert.NoError(t, db.Close())
+
+	// every write is in the log exactly once, possibly grouped with others
+	contents, err := os.ReadFile(logName)
+	assert.NoError(t, err)
+	reader := NewLogReader(bytes.NewReader(contents), true, Format_Native)
+	var records, count int
+	for {
+		record, ok := reader.ReadRecord()
+		if !ok {
+			break
+		}
+		batch := NewWriteBatch()
+		batch.setContents(record)
+		records++
+		count += batch.Count()
+	}
+	assert.NoError(t, reader.Error())
+	assert.Equal(t, writers*writesPerWriter, count)
+	assert.LessOrEqual(t, records, count)
+}
+
+func TestDB_ConcurrentWrites(t *testing.T) {
+	testConcurrentWrites(t, newTestOptions())
+}
+
+func TestDB_ConcurrentMemtableWrites(t *testing.T) {
+	options := newTestOptions()
+	options.AllowConcurrentMemtableWrite = true
+	testConcurrentWrites(t, options)
+}
+
+func TestDB_WriteAfterClose(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	assert.NoError(t, db.Close())
+	assert.Error(t, db.Put(DefaultWriteOptions, []byte("foo"), []byte("bar")))
+}
+
+func TestDB_Recover(t *testing.T) {
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("foo"), []byte("v1")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("baz"), []byte("v5")))
+	assert.NoError(t, db.Close())
+
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	value, err := db.Get(DefaultReadOptions, []byte("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(value))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("bar"), []byte("v2")))
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("baz")))
+	assert.NoError(t, db.Close())
+
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+	for key, expected := range map[string]string{"foo": "v1", "bar": "v2"} {
+		value, err := db.Get(DefaultReadOptions, []byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+	_, err = db.Get(DefaultReadOptions, []byte("baz"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+}
+
+func TestDB_ErrorIfExists(t *testing.T) {
+	dbname := t.TempDir()
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	options.ErrorIfExsits = true
+	_, err = Open(options, dbname)
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+}
+
+func TestDB_SanitizeOptions(t *testing.T) {
+	icmp := NewInternalKeyComparator(NewBytewiseComparator())
+	options := sanitizeOptions("db", icmp, nil, &Options{})
+	assert.Equal(t, uint64(4<<20), options.WriteBufferSize)
+	assert.Equal(t, 16, options.BlockRestartInternal)
+	assert.Equal(t, kL0_SlowdownWritesTrigger, options.Level0SlowdownWritesTrigger)
+	assert.Equal(t, kL0_StopWritesTrigger, options.Level0StopWritesTrigger)
+
+	options = sanitizeOptions("db", icmp, nil, &Options{
+		WriteBufferSize:             1,
+		Level0SlowdownWritesTrigger: 20,
+		Level0StopWritesTrigger:     10,
+	})
+	assert.Equal(t, uint64(64<<10), options.WriteBufferSize)
+	assert.Equal(t, 20, options.Level0StopWritesTrigger)
+}
+
+// waitForCompaction waits for the background compactions of db to finish.
+func waitForCompaction(db *DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for db.bgCompactionScheduled {
+		db.bgCV.Wait()
+	}
+}
+
+func numTableFiles(db *DB) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for level := 0; level < kNumLevels; level++ {
+		n += db.versions.NumLevelFiles(level)
+	}
+	return n
+}
+
+func TestDB_FlushAndCompaction(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	const n = 2000
+	value := bytes.Repeat([]byte("v"), 1000)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			assert.NoError(t, db.Put(DefaultWriteOptions, key, append(value, byte('0'+round))))
+		}
+	}
+	assert.Greater(t, numTableFiles(db), 0)
+
+	check := func(db *DB) {
+		for i := 0; i < n; i++ {
+			got, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("key%06d", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, append(value, '2'), got)
+		}
+	}
+	check(db)
+	assert.NoError(t, db.Close())
+
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_WriteStallOnLevel0Slowdown(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	options.Level0SlowdownWritesTrigger = 1
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	// Overwriting the same keys makes every flush overlap the previous
+	// ones, so the tables pile up in level-0.
+	value := bytes.Repeat([]byte("v"), 10000)
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i%10))
+		assert.NoError(t, db.Put(DefaultWriteOptions, key, value))
+	}
+
+	db.mu.Lock()
+	stalls := db.stallStats
+	db.mu.Unlock()
+	assert.Greater(t, stalls.l0SlowdownCount, uint64(0))
+	assert.GreaterOrEqual(t, stalls.l0SlowdownMicros, stalls.l0SlowdownCount*1000)
+
+	prop, ok := db.GetProperty("leveldb.write-stall-stats")
+	assert.True(t, ok)
+	assert.Contains(t, prop, fmt.Sprintf("level0-slowdown-count=%d", stalls.l0SlowdownCount))
+	prop, ok = db.GetProperty("leveldb.stats")
+	assert.True(t, ok)
+	assert.Contains(t, prop, "level0-slowdown")
+}
+
+// pauseCompactions keeps the background compactions from running until
+// resumeCompactions, by posing as a scheduled one.
+func pauseCompactions(db *DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for db.bgCompactionScheduled {
+		db.bgCV.Wait()
+	}
+	db.bgCompactionScheduled = true
+}
+
+func resumeCompactions(db *DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.bgCompactionScheduled = false
+	db.maybeScheduleCompaction()
+	db.bgCV.Broadcast()
+}
+
+// assertBlocked asserts that nothing is received from done for a while.
+func assertBlocked(t *testing.T, done <-chan error) {
+	select {
+	case err := <-done:
+		assert.Fail(t, "write did not block", "err: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDB_WriteStopOnLevel0Files(t *testing.T) {
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	options.Level0SlowdownWritesTrigger = kL0_CompactionTrigger
+	options.Level0StopWritesTrigger = kL0_CompactionTrigger
+	dbname := t.TempDir()
+
+	// The log recovered on Open is flushed to level-0
+	for i := 0; i < kL0_CompactionTrigger-1; i++ {
+		db, err := Open(options, dbname)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte(fmt.Sprint(i))))
+		assert.NoError(t, db.Close())
+	}
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, kL0_CompactionTrigger-1, db.versions.NumLevelFiles(0))
+
+	// Flush one more level-0 table while the compactions are held back
+	pauseCompactions(db)
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v")))
+	assert.NoError(t, db.Write(DefaultWriteOptions, nil))
+	db.mu.Lock()
+	db.compactMemTable()
+	assert.Equal(t, kL0_CompactionTrigger, db.versions.NumLevelFiles(0))
+	db.mu.Unlock()
+
+	// A write that needs a new memtable blocks until level-0 is compacted
+	done := make(chan error)
+	go func() {
+		done <- db.Write(DefaultWriteOptions, nil)
+	}()
+	assertBlocked(t, done)
+	resumeCompactions(db)
+	assert.NoError(t, <-done)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Less(t, db.versions.NumLevelFiles(0), kL0_CompactionTrigger)
+	assert.Greater(t, db.stallStats.l0StopCount, uint64(0))
+}
+
+func TestDB_WriteStopOnImmutableMemTable(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	// The memtable switched out cannot be flushed
+	pauseCompactions(db)
+	assert.NoError(t, db.Write(DefaultWriteOptions, nil))
+
+	// Filling the new memtable blocks the writer instead of growing it
+	value := bytes.Repeat([]byte("v"), 10000)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("key%03d", i)), value); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	assertBlocked(t, done)
+	db.mu.Lock()
+	usage := db.versions.defaultColumnFamily.mem.ApproximateMemoryUsage()
+	db.mu.Unlock()
+	assert.LessOrEqual(t, usage, options.WriteBufferSize+2*uint64(len(value)))
+
+	resumeCompactions(db)
+	assert.NoError(t, <-done)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Greater(t, db.stallStats.memtableStopCount, uint64(0))
+}
+
+func TestDB_GetProperty(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("foo"), []byte("v1")))
+	prop, ok := db.GetProperty("leveldb.num-files-at-level0")
+	assert.True(t, ok)
+	assert.Equal(t, "0", prop)
+	prop, ok = db.GetProperty("leveldb.approximate-memory-usage")
+	assert.True(t, ok)
+	assert.NotEqual(t, "0", prop)
+	_, ok = db.GetProperty("leveldb.num-files-at-level7")
+	assert.False(t, ok)
+	_, ok = db.GetProperty("rocksdb.stats")
+	assert.False(t, ok)
+}
+
+func collectIterator(iter Iterator, reverse bool) []string {
+	var kvs []string
+	if reverse {
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			kvs = append(kvs, string(iter.Key())+"->"+string(iter.Value()))
+		}
+	} else {
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			kvs = append(kvs, string(iter.Key())+"->"+string(iter.Value()))
+		}
+	}
+	return kvs
+}
+
+func TestDB_Iterator(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	iter := db.NewIterator(DefaultReadOptions)
+	iter.SeekToFirst()
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Close())
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte("v"+key)))
+	}
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("b"), []byte("vb2")))
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("c")))
+
+	iter = db.NewIterator(DefaultReadOptions)
+	defer iter.Close()
+	assert.Equal(t, []string{"a->va", "b->vb2", "d->vd"}, collectIterator(iter, false))
+	assert.Equal(t, []string{"d->vd", "b->vb2", "a->va"}, collectIterator(iter, true))
+
+	iter.Seek([]byte("b"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "b", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "d", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "b", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "a", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "b", string(iter.Key()))
+	iter.Seek([]byte("c"))
+	assert.Equal(t, "d", string(iter.Key()))
+	iter.Seek([]byte("e"))
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Error())
+
+	// the iterator does not observe later writes
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("e"), []byte("ve")))
+	assert.Equal(t, []string{"a->va", "b->vb2", "d->vd"}, collectIterator(iter, false))
+}
+
+func TestDB_IteratorPinsVersion(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	put := func(round byte) {
+		for i := 0; i < 500; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			assert.NoError(t, db.Put(DefaultWriteOptions, key, append(value, round)))
+		}
+	}
+	put('0')
+	assert.Greater(t, numTableFiles(db), 0)
+
+	iter := db.NewIterator(DefaultReadOptions)
+	db.mu.Lock()
+	pinned := db.versions.Current()
+	db.mu.Unlock()
+
+	// overwrite everything so the tables of the pinned version are
+	// compacted away
+	for round := byte('1'); round <= '5'; round++ {
+		put(round)
+	}
+
+	count := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		assert.Equal(t, byte('0'), iter.Value()[len(value)])
+		count++
+	}
+	assert.NoError(t, iter.Error())
+	assert.Equal(t, 500, count)
+
+	db.mu.Lock()
+	_, live := db.versions.defaultColumnFamily.versions[pinned]
+	db.mu.Unlock()
+	assert.True(t, live)
+	assert.NoError(t, iter.Close())
+	db.mu.Lock()
+	_, live = db.versions.defaultColumnFamily.versions[pinned]
+	db.mu.Unlock()
+	assert.True(t, !live || pinned == db.versions.Current())
+}
+
+func TestDB_IteratorBounds(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte("v"+key)))
+	}
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("c")))
+
+	iter := db.NewIterator(&ReadOptions{LowerBound: []byte("b"), UpperBound: []byte("e")})
+	defer iter.Close()
+	assert.Equal(t, []string{"b->vb", "d->vd"}, collectIterator(iter, false))
+	assert.Equal(t, []string{"d->vd", "b->vb"}, collectIterator(iter, true))
+
+	iter.Seek([]byte("a"))
+	assert.Equal(t, "b", string(iter.Key()))
+	iter.Prev()
+	assert.False(t, iter.Valid())
+	iter.Seek([]byte("d"))
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Seek([]byte("e"))
+	assert.False(t, iter.Valid())
+
+	iter = db.NewIterator(&ReadOptions{UpperBound: []byte("bb")})
+	defer iter.Close()
+	assert.Equal(t, []string{"a->va", "b->vb"}, collectIterator(iter, false))
+	assert.Equal(t, []string{"b->vb", "a->va"}, collectIterator(iter, true))
+}
+
+func TestDB_PrefixSeek(t *testing.T) {
+	options := newTestOptions()
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	options.PrefixExtractor = NewFixedPrefixTransform(3)
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	for _, key := range []string{"aaa1", "aaa2", "bbb1", "bbb2", "bbb3", "ddd1"} {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte("v"+key)))
+	}
+	db.Close()
+
+	// Recovery writes the log to a table, later writes stay in the memtable
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 1, numTableFiles(db))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("bbb4"), []byte("vbbb4")))
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("bbb2")))
+
+	iter := db.NewIterator(&ReadOptions{PrefixSameAsStart: true})
+	defer iter.Close()
+	var keys []string
+	for iter.Seek([]byte("bbb")); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"bbb1", "bbb3", "bbb4"}, keys)
+
+	iter.Seek([]byte("bbb3"))
+	keys = keys[:0]
+	for ; iter.Valid(); iter.Prev() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"bbb3", "bbb1"}, keys)
+
+	iter.Seek([]byte("ccc"))
+	assert.False(t, iter.Valid())
+	iter.Seek([]byte("a")) // out of domain, not restricted
+	assert.Equal(t, "aaa1", string(iter.Key()))
+	assert.Equal(t, 7-1, len(collectIterator(iter, false)))
+}
+
+func TestDB_ChangeFilterType(t *testing.T) {
+	options := newTestOptions()
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+
+	// Each reopen writes the log of the previous run to a table with the
+	// new filter type, the tables of the previous types stay readable
+	for i, filterType := range []FilterType{FilterType_Block, FilterType_Full, FilterType_Partitioned, FilterType_Block} {
+		options.FilterType = filterType
+		db, err := Open(options, dbname)
+		assert.NoError(t, err)
+		for j := 0; j < i; j++ {
+			got, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("key%d", j)))
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value%d", j), string(got))
+		}
+		_, err = db.Get(DefaultReadOptions, []byte("missing"))
+		assert.True(t, err.(*LevelError).IsNotFound())
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		db.Close()
+	}
+}
+
+func TestDB_DataBlockHashIndex(t *testing.T) {
+	options := newTestOptions()
+	options.DataBlockIndexType = DataBlockIndexType_BinarySearchAndHash
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 500; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if i%5 == round {
+				assert.NoError(t, db.Delete(DefaultWriteOptions, key))
+			} else {
+				assert.NoError(t, db.Put(DefaultWriteOptions, key, []byte(fmt.Sprintf("value%d.%d", i, round))))
+			}
+		}
+	}
+	db.Close()
+
+	// Reopen to write the entries to a table
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Greater(t, numTableFiles(db), 0)
+	for i := 0; i < 500; i++ {
+		got, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("key%03d", i)))
+		if i%5 == 2 {
+			assert.True(t, err.(*LevelError).IsNotFound())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d.2", i), string(got))
+	}
+	_, err = db.Get(DefaultReadOptions, []byte("key"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+}
+
+func TestDB_ComparatorMismatch(t *testing.T) {
+	dbname := t.TempDir()
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("foo"), []byte("bar")))
+	assert.NoError(t, db.Close())
+
+	options.Comparator = NewReverseBytewiseComparator()
+	_, err = Open(options, dbname)
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	options.Comparator = NewBytewiseComparator()
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_Comparators(t *testing.T) {
+	for _, comparator := range []Comparator{
+		NewReverseBytewiseComparator(),
+		NewUint64Comparator(),
+		NewLengthThenBytesComparator(),
+	} {
+		options := newTestOptions()
+		options.Comparator = comparator
+		options.BlockSize = 256
+		options.CreateIfMissing = true
+		dbname := t.TempDir()
+		db, err := Open(options, dbname)
+		assert.NoError(t, err)
+		var keys [][]byte
+		for i := 0; i < 1000; i++ {
+			var key []byte
+			if comparator.Name() == "leveldb.Uint64Comparator" {
+				key = util.EncodeUint64Fixed(uint64(i * 7919))
+			} else {
+				key = []byte(fmt.Sprint(i * 7919))
+			}
+			keys = append(keys, key)
+			assert.NoError(t, db.Put(DefaultWriteOptions, key, key))
+		}
+		db.Close()
+
+		// Reopen to write the entries to a table
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+		sort.Slice(keys, func(i, j int) bool { return comparator.Compare(keys[i], keys[j]) < 0 })
+		iter := db.NewIterator(DefaultReadOptions)
+		i := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			assert.Equal(t, keys[i], iter.Key())
+			i++
+		}
+		assert.Equal(t, len(keys), i)
+		for _, key := range keys[:100] {
+			iter.Seek(key)
+			assert.True(t, iter.Valid())
+			assert.Equal(t, key, iter.Key())
+			got, err := db.Get(DefaultReadOptions, key)
+			assert.NoError(t, err)
+			assert.Equal(t, key, got)
+		}
+		assert.NoError(t, iter.Close())
+		db.Close()
+	}
+}
+
+func TestDB_Timestamps(t *testing.T) {
+	ts := func(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+	options := newTestOptions()
+	options.Comparator = NewTimestampComparator(NewBytewiseComparator(), 8)
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	options.DataBlockIndexType = DataBlockIndexType_BinarySearchAndHash
+	options.BlockSize = 256
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	err = db.Put(DefaultWriteOptions, []byte("key"), []byte("value"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	_, err = db.Get(&ReadOptions{Timestamp: ts(1)[:4]}, []byte("key"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	// Round r writes v<r> at timestamp 10*r, round 3 deletes the keys
+	// multiple of 10 instead, every round is flushed to a table.
+	write := func(round int) {
+		writeOptions := &WriteOptions{Timestamp: ts(uint64(10 * round))}
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if round == 3 && i%10 == 0 {
+				assert.NoError(t, db.Delete(writeOptions, key))
+			} else {
+				assert.NoError(t, db.Put(writeOptions, key, []byte(fmt.Sprint("v", round))))
+			}
+		}
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+	}
+	get := func(readTs uint64, key string) string {
+		value, err := db.Get(&ReadOptions{Timestamp: ts(readTs)}, []byte(key))
+		if err != nil {
+			assert.True(t, err.(*LevelError).IsNotFound())
+			return "NOT_FOUND"
+		}
+		return string(value)
+	}
+	for round := 1; round <= 3; round++ {
+		write(round)
+	}
+	assert.Equal(t, "NOT_FOUND", get(5, "key005"))
+	assert.Equal(t, "v1", get(15, "key005"))
+	assert.Equal(t, "v2", get(20, "key005"))
+	assert.Equal(t, "v3", get(35, "key005"))
+	assert.Equal(t, "v2", get(25, "key010"))
+	assert.Equal(t, "NOT_FOUND", get(30, "key010"))
+
+	iter := db.NewIterator(&ReadOptions{Timestamp: ts(25)})
+	n := 0
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		assert.Equal(t, fmt.Sprintf("key%03d", 99-n), string(iter.Key()))
+		assert.Equal(t, "v2", string(iter.Value()))
+		n++
+	}
+	assert.Equal(t, 100, n)
+	assert.NoError(t, iter.Close())
+
+	iter = db.NewIterator(&ReadOptions{
+		Timestamp:  ts(30),
+		LowerBound: []byte("key010"),
+		UpperBound: []byte("key030"),
+	})
+	var keys []string
+	for iter.Seek([]byte("key015")); iter.Valid(); iter.Next() {
+		assert.Equal(t, "v3", string(iter.Value()))
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"key015", "key016", "key017", "key018", "key019",
+		"key021", "key022", "key023", "key024", "key025", "key026", "key027", "key028", "key029"}, keys)
+	iter.SeekToFirst()
+	assert.Equal(t, "key011", string(iter.Key()))
+	assert.NoError(t, iter.Close())
+
+	// The fourth table triggers a level-0 compaction, which keeps only the
+	// newest version older than the full history low.
+	assert.NoError(t, db.IncreaseFullHistoryTsLow(ts(25)))
+	assert.True(t, db.IncreaseFullHistoryTsLow(ts(20)).(*LevelError).IsInvalidArgument())
+	options.FullHistoryTsLow = ts(25)
+	write(4)
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	_, err = db.Get(&ReadOptions{Timestamp: ts(15)}, []byte("key005"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	assert.Equal(t, "v2", get(25, "key005"))
+	assert.Equal(t, "v4", get(45, "key010"))
+
+	versions := func(key string) int {
+		internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+		defer internalIter.Close()
+		n := 0
+		for internalIter.Seek(NewLookupKey(append([]byte(key), maxTimestamp(8)...), KMaxSequenceNumber).InternalKey()); internalIter.Valid(); internalIter.Next() {
+			if !bytes.HasPrefix(internalIter.Key(), []byte(key)) {
+				break
+			}
+			n++
+		}
+		return n
+	}
+	assert.Equal(t, 3, versions("key005"))
+	assert.Equal(t, 3, versions("key010"))
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_TTL(t *testing.T) {
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	const ttl = 50 * time.Millisecond
+	// Every round is flushed to a level-0 table, the fourth one triggers a
+	// compaction.
+	round := func(write func()) {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("a")))
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+		write()
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+	}
+	check := func(want map[string]string) {
+		got := map[string]string{}
+		iter := db.NewIterator(DefaultReadOptions)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		n := 0
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			assert.Equal(t, got[string(iter.Key())], string(iter.Value()))
+			n++
+		}
+		assert.NoError(t, iter.Close())
+		assert.Equal(t, want, got)
+		assert.Equal(t, len(want), n)
+		for _, key := range []string{"k1", "k2", "k3", "k4"} {
+			value, err := db.Get(DefaultReadOptions, []byte(key))
+			if _, ok := want[key]; ok {
+				assert.NoError(t, err)
+				assert.Equal(t, want[key], string(value))
+			} else {
+				assert.True(t, err.(*LevelError).IsNotFound())
+			}
+		}
+	}
+
+	round(func() {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("k4"), []byte("old")))
+	})
+	assert.NoError(t, db.PutWithTTL(DefaultWriteOptions, []byte("k1"), []byte("v1"), time.Hour))
+	assert.NoError(t, db.PutWithTTL(DefaultWriteOptions, []byte("k2"), []byte("v2"), ttl))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("k3"), []byte("v3")))
+	assert.NoError(t, db.PutWithTTL(DefaultWriteOptions, []byte("k4"), []byte("v4"), ttl))
+	check(map[string]string{"a": "a", "z": "z", "k1": "v1", "k2": "v2", "k3": "v3", "k4": "v4"})
+
+	time.Sleep(2 * ttl)
+	// The expired value of k4 hides the older one
+	want := map[string]string{"a": "a", "z": "z", "k1": "v1", "k3": "v3"}
+	check(want)
+	round(func() {})
+	check(want)
+	round(func() {})
+	round(func() {})
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	check(want)
+
+	// The expired values are gone, the older value of k4 with them
+	internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+	for internalIter.SeekToFirst(); internalIter.Valid(); internalIter.Next() {
+		ikey, err := ParseInternalKey(internalIter.Key())
+		assert.NoError(t, err)
+		if key := string(ikey.UserKey); key == "k2" || key == "k4" {
+			assert.Equal(t, ValueType_Deletion, ikey.Type)
+		}
+	}
+	assert.NoError(t, internalIter.Close())
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_PutWithTTLBounds(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	for _, ttl := range []time.Duration{0, -time.Second, math.MinInt64} {
+		err := db.PutWithTTL(DefaultWriteOptions, []byte("k"), []byte("v"), ttl)
+		assert.True(t, err.(*LevelError).IsInvalidArgument())
+	}
+	_, err := db.Get(DefaultReadOptions, []byte("k"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+
+	// The longest ttl does not wrap around to an expiry in the past
+	assert.NoError(t, db.PutWithTTL(DefaultWriteOptions, []byte("k"), []byte("v"), math.MaxInt64))
+	value, err := db.Get(DefaultReadOptions, []byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v", string(value))
+
+	assert.Equal(t, uint64(1001), expiryAfter(1000, time.Nanosecond))
+	assert.Equal(t, uint64(3000), expiryAfter(1000, 2*time.Millisecond))
+	assert.Equal(t, uint64(math.MaxUint64), expiryAfter(math.MaxUint64-10, time.Hour))
+	assert.Equal(t, uint64(math.MaxUint64), expiryAfter(math.MaxUint64-3600_000_000, time.Hour))
+}
+
+type testCompactionFilter struct {
+	levels map[int]bool
+}
+
+func (f *testCompactionFilter) Name() string {
+	return "test"
+}
+
+// Filter removes the keys starting with "tmp" and upper-cases the values
+// of the keys starting with "up".
+func (f *testCompactionFilter) Filter(level int, key, existingValue []byte) (CompactionDecision, []byte) {
+	f.levels[level] = true
+	switch {
+	case bytes.HasPrefix(key, []byte("tmp")):
+		return CompactionDecision_Remove, nil
+	case bytes.HasPrefix(key, []byte("up")):
+		return CompactionDecision_ChangeValue, bytes.ToUpper(existingValue)
+	}
+	return CompactionDecision_Keep, nil
+}
+
+func TestDB_CompactionFilter(t *testing.T) {
+	filter := &testCompactionFilter{levels: map[int]bool{}}
+	options := newTestOptions()
+	options.CompactionFilter = filter
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	// Every round is flushed to a level-0 table, the fourth one triggers a
+	// compaction.
+	for round := 0; round < 4; round++ {
+		for _, key := range []string{"a", "keep", "tmp1", "tmp2", "up1", "z"} {
+			value := fmt.Sprint(key, round)
+			assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte(value)))
+		}
+		if round == 0 {
+			assert.NoError(t, db.PutWithTTL(DefaultWriteOptions, []byte("up2"), []byte("ttl"), time.Hour))
+		}
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+	}
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	assert.Equal(t, map[int]bool{0: true}, filter.levels)
+
+	got := map[string]string{}
+	iter := db.NewIterator(DefaultReadOptions)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		got[string(iter.Key())] = string(iter.Value())
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, map[string]string{"a": "a3", "keep": "keep3", "up1": "UP13", "up2": "TTL", "z": "z3"}, got)
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_CompactionFilterSnapshot(t *testing.T) {
+	filter := &testCompactionFilter{levels: map[int]bool{}}
+	options := newTestOptions()
+	options.CompactionFilter = filter
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	// Reopened to flush a level-0 table over the whole key range
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("a")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+	assert.NoError(t, db.Close())
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Every flush makes a level-0 table, the fourth one is compacted
+	compact := func(flushes int) {
+		for i := 0; i < flushes; i++ {
+			assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+			assert.NoError(t, db.Write(DefaultWriteOptions, nil))
+			waitForCompaction(db)
+		}
+		assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	}
+	get := func(snapshot *Snapshot, key string) string {
+		value, err := db.Get(&ReadOptions{Snapshot: snapshot}, []byte(key))
+		if err != nil {
+			assert.True(t, err.(*LevelError).IsNotFound())
+			return "NOT_FOUND"
+		}
+		return string(value)
+	}
+
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("tmp1"), []byte("v1")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("tmp2"), []byte("v1")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("up1"), []byte("v1")))
+	snapshot := db.GetSnapshot()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("tmp2"), []byte("v2")))
+
+	// The entries the snapshot sees are left as they are, the newer ones
+	// are filtered
+	compact(kL0_CompactionTrigger - 1)
+	assert.Equal(t, "v1", get(nil, "tmp1"))
+	assert.Equal(t, "v1", get(nil, "up1"))
+	assert.Equal(t, "NOT_FOUND", get(nil, "tmp2"))
+	assert.Equal(t, "v1", get(snapshot, "tmp1"))
+	assert.Equal(t, "v1", get(snapshot, "tmp2"))
+	assert.Equal(t, "v1", get(snapshot, "up1"))
+
+	db.ReleaseSnapshot(snapshot)
+	compact(kL0_CompactionTrigger)
+	assert.Equal(t, "NOT_FOUND", get(nil, "tmp1"))
+	assert.Equal(t, "NOT_FOUND", get(nil, "tmp2"))
+	assert.Equal(t, "V1", get(nil, "up1"))
+}
+
+func TestDB_Snapshots(t *testing.T) {
+	filter := &testCompactionFilter{levels: map[int]bool{}}
+	options := newTestOptions()
+	options.CompactionFilter = filter
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	// Reopened to flush a level-0 table over the whole key range
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v0")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+	assert.NoError(t, db.Close())
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A nil batch switches to a new memtable, every flush makes a table
+	// overlapping the others at level-0, the fourth one is compacted
+	flush := func() {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+		assert.NoError(t, db.Write(DefaultWriteOptions, nil))
+		waitForCompaction(db)
+	}
+	get := func(snapshot *Snapshot, key string) string {
+		value, err := db.Get(&ReadOptions{Snapshot: snapshot}, []byte(key))
+		if err != nil {
+			assert.True(t, err.(*LevelError).IsNotFound())
+			return "NOT_FOUND"
+		}
+		return string(value)
+	}
+
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v1")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("d"), []byte("v1")))
+	assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("m"), []byte("x")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("tmp"), []byte("t1")))
+	snapshot := db.GetSnapshot()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v2")))
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("d")))
+	assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("m"), []byte("y")))
+
+	check := func() {
+		assert.Equal(t, "v1", get(snapshot, "a"))
+		assert.Equal(t, "v2", get(nil, "a"))
+		assert.Equal(t, "v1", get(snapshot, "d"))
+		assert.Equal(t, "NOT_FOUND", get(nil, "d"))
+		assert.Equal(t, "x", get(snapshot, "m"))
+		assert.Equal(t, "x,y", get(nil, "m"))
+		iter := db.NewIterator(&ReadOptions{Snapshot: snapshot, UpperBound: []byte("z")})
+		assert.Equal(t, []string{"a->v1", "d->v1", "m->x", "tmp->t1"}, collectIterator(iter, false))
+		assert.NoError(t, iter.Close())
+	}
+	check()
+	for i := 1; i < kL0_CompactionTrigger; i++ {
+		flush()
+	}
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	assert.Greater(t, db.versions.NumLevelFiles(1), 0)
+	check()
+	// The filter skips the entries the snapshot sees
+	assert.Equal(t, "t1", get(nil, "tmp"))
+
+	db.ReleaseSnapshot(snapshot)
+	for i := 0; i < kL0_CompactionTrigger; i++ {
+		flush()
+	}
+	assert.Equal(t, "NOT_FOUND", get(nil, "tmp"))
+	assert.Equal(t, "x,y", get(nil, "m"))
+	internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+	var entries []string
+	for internalIter.SeekToFirst(); internalIter.Valid(); internalIter.Next() {
+		ikey, err := ParseInternalKey(internalIter.Key())
+		assert.NoError(t, err)
+		if key := string(ikey.UserKey); key != "z" {
+			entries = append(entries, key)
+		}
+	}
+	assert.NoError(t, internalIter.Close())
+	assert.Equal(t, []string{"a", "m"}, entries)
+}
+
+func TestDB_Merge(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	get := func(key string) string {
+		value, err := db.Get(DefaultReadOptions, []byte(key))
+		if err != nil {
+			assert.True(t, err.(*LevelError).IsNotFound())
+			return "NOT_FOUND"
+		}
+		return string(value)
+	}
+	check := func(want map[string]string) {
+		got := map[string]string{}
+		iter := db.NewIterator(DefaultReadOptions)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		assert.Equal(t, want, got)
+		n := 0
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			assert.Equal(t, want[string(iter.Key())], string(iter.Value()))
+			n++
+		}
+		assert.Equal(t, len(want), n)
+		// Switch directions at a merged key
+		iter.Seek([]byte("b"))
+		assert.Equal(t, "b", string(iter.Key()))
+		iter.Prev()
+		assert.Equal(t, "a", string(iter.Key()))
+		assert.Equal(t, want["a"], string(iter.Value()))
+		iter.Next()
+		assert.Equal(t, "b", string(iter.Key()))
+		assert.NoError(t, iter.Close())
+		for key, value := range want {
+			assert.Equal(t, value, get(key))
+		}
+	}
+
+	// Every round is flushed to a level-0 table, the fourth one triggers a
+	// compaction.
+	for round := 0; round < 4; round++ {
+		r := fmt.Sprint(round)
+		switch round {
+		case 0:
+			assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("a0")))
+			assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+		case 2:
+			assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("c")))
+		}
+		assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("a"), []byte("a"+r)))
+		assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("b"), []byte("b"+r)))
+		assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("c"), []byte("c"+r)))
+		assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("c"), []byte("x"+r)))
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+	}
+	want := map[string]string{
+		"a": "a0,a0,a1,a2,a3",
+		"b": "b0,b1,b2,b3",
+		"c": "c2,x2,c3,x3",
+		"z": "z",
+	}
+	check(want)
+
+	// The operands left in the memtable are merged on top of the tables
+	assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("b"), []byte("b4")))
+	want["b"] += ",b4"
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	check(want)
+
+	// Compactions fully merged the operands
+	internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+	for internalIter.SeekToFirst(); internalIter.Valid(); internalIter.Next() {
+		ikey, err := ParseInternalKey(internalIter.Key())
+		assert.NoError(t, err)
+		if string(ikey.UserKey) != "b" {
+			assert.Equal(t, ValueType_Value, ikey.Type)
+		}
+	}
+	assert.NoError(t, internalIter.Close())
+	assert.NoError(t, db.Close())
+
+	// Merges need a merge operator
+	options.MergeOperator = nil
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	err = db.Merge(DefaultWriteOptions, []byte("a"), []byte("a"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	_, err = db.Get(DefaultReadOptions, []byte("b"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_MergeAtLastKey(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	db := openTestDB(t, options)
+	defer db.Close()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("a0")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z0")))
+	assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("z"), []byte("z1")))
+
+	// The entries of the merged last key leave the internal iterator at
+	// the end of the keyspace
+	iter := db.NewIterator(DefaultReadOptions)
+	iter.Seek([]byte("z"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "z", string(iter.Key()))
+	assert.Equal(t, "z0,z1", string(iter.Value()))
+	iter.Prev()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "a", string(iter.Key()))
+	assert.Equal(t, "a0", string(iter.Value()))
+	iter.Next()
+	assert.Equal(t, "z", string(iter.Key()))
+	assert.Equal(t, "z0,z1", string(iter.Value()))
+	iter.Next()
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Error())
+
+	assert.NoError(t, iter.Close())
+
+	// Also when it is the only key
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("a")))
+	iter = db.NewIterator(DefaultReadOptions)
+	iter.Seek([]byte("a"))
+	assert.Equal(t, "z", string(iter.Key()))
+	iter.Prev()
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Error())
+	assert.NoError(t, iter.Close())
+}
+
+func TestDB_Uint64AddOperator(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewUint64AddOperator()
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	for i := 1; i <= 10; i++ {
+		assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("counter"), util.EncodeUint64Fixed(uint64(i))))
+	}
+	value, err := db.Get(DefaultReadOptions, []byte("counter"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(55), util.DecodeUint64Fixed(value))
+
+	assert.NoError(t, db.Merge(DefaultWriteOptions, []byte("counter"), []byte("bad")))
+	_, err = db.Get(DefaultReadOptions, []byte("counter"))
+	assert.True(t, err.(*LevelError).IsCorruption())
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%02d", i)) }
+	want := map[string]string{}
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, key(i), []byte(fmt.Sprint("v", i))))
+		if i < 10 || i >= 25 {
+			want[string(key(i))] = fmt.Sprint("v", i)
+		}
+	}
+	assert.NoError(t, db.DeleteRange(DefaultWriteOptions, key(10), key(20)))
+	// The entries written after the tombstone are not deleted
+	assert.NoError(t, db.Put(DefaultWriteOptions, key(15), []byte("new")))
+	assert.NoError(t, db.Merge(DefaultWriteOptions, key(16), []byte("m")))
+	want[string(key(15))] = "new"
+	want[string(key(16))] = "m"
+	// Overlapping tombstones
+	assert.NoError(t, db.DeleteRange(DefaultWriteOptions, key(18), key(25)))
+
+	err = db.DeleteRange(DefaultWriteOptions, key(2), key(1))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	check := func() {
+		for i := 0; i < 30; i++ {
+			value, err := db.Get(DefaultReadOptions, key(i))
+			if v, ok := want[string(key(i))]; ok {
+				assert.NoError(t, err)
+				assert.Equal(t, v, string(value))
+			} else {
+				assert.True(t, err.(*LevelError).IsNotFound(), string(key(i)))
+			}
+		}
+		got := map[string]string{}
+		iter := db.NewIterator(DefaultReadOptions)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		assert.Equal(t, want, got)
+		n := 0
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			assert.Equal(t, want[string(iter.Key())], string(iter.Value()))
+			n++
+		}
+		assert.Equal(t, len(want), n)
+		iter.Seek(key(10))
+		assert.Equal(t, string(key(15)), string(iter.Key()))
+		iter.Prev()
+		assert.Equal(t, string(key(9)), string(iter.Key()))
+		assert.NoError(t, iter.Close())
+	}
+	check()
+
+	// Every round is flushed to a table, the range tombstones of the first
+	// one delete the entries of the others
+	for round := 0; round < 4; round++ {
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+		check()
+		assert.NoError(t, db.Put(DefaultWriteOptions, key(29), []byte("v29")))
+	}
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	check()
+
+	// The compaction to the base level dropped the deleted entries and the
+	// tombstones
+	db.mu.Lock()
+	tombstones, err := db.versions.Current().RangeTombstones()
+	db.mu.Unlock()
+	assert.NoError(t, err)
+	assert.Empty(t, tombstones)
+	internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+	for internalIter.Seek(DumpInternalKey(NewParsedInternalKey(key(10), KMaxSequenceNumber, ValueType_ForSeek))); internalIter.Valid(); internalIter.Next() {
+		userKey := string(ExtractUserKey(internalIter.Key()))
+		if userKey >= string(key(25)) {
+			break
+		}
+		assert.Contains(t, []string{string(key(15)), string(key(16))}, userKey)
+	}
+	assert.NoError(t, internalIter.Close())
+	assert.NoError(t, db.Close())
+
+	// Tombstones are not supported with timestamps
+	db = openTestDB(t, &Options{Comparator: NewTimestampComparator(NewBytewiseComparator(), 8)})
+	err = db.DeleteRange(&WriteOptions{Timestamp: make([]byte, 8)}, []byte("a"), []byte("b"))
+	assert.True(t, err.(*LevelError).IsNotSupported())
+	assert.NoError(t, db.Close())
+}
+
+// TestDB_DeleteRangeSplitOutputs checks that the compactions keeping a
+// range tombstone split it between their outputs.
+func TestDB_DeleteRangeSplitOutputs(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	options.MaxFileSize = 1 << 20
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	// Flushed to level-2, below the tombstone
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("m%05d", i)), value))
+	}
+	waitForCompaction(db)
+	assert.Greater(t, db.versions.NumLevelFiles(2), 0)
+
+	assert.NoError(t, db.DeleteRange(DefaultWriteOptions, []byte("a"), []byte("z")))
+	const n = 5000
+	for i := 0; i < n; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("k%05d", i)), value))
+	}
+	waitForCompaction(db)
+	assert.Greater(t, db.versions.NumLevelFiles(1), 1)
+
+	// Every output of the level-1 compactions holds its part of the
+	// tombstone
+	db.mu.Lock()
+	for _, f := range db.versions.Current().files[1] {
+		tombstones, err := db.defaultColumnFamily.cfd.tableCache.RangeTombstones(f.number, f.fileSize)
+		assert.NoError(t, err)
+		assert.Len(t, tombstones, 1)
+	}
+	db.mu.Unlock()
+
+	for i := 0; i < 100; i += 7 {
+		_, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("m%05d", i)))
+		assert.True(t, err.(*LevelError).IsNotFound())
+	}
+	for i := 0; i < n; i += 7 {
+		got, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("k%05d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, value, got)
+	}
+	iter := db.NewIterator(DefaultReadOptions)
+	count := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, n, count)
+	assert.NoError(t, iter.Close())
+}
+
+func TestDB_SingleDelete(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	// Flushed to level-2, so that the compactions to level-1 are not at
+	// the base level of the keys
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("k%05d", i)), value))
+	}
+	waitForCompaction(db)
+	assert.Greater(t, db.versions.NumLevelFiles(2), 0)
+
+	// The even keys are single deleted, the odd ones deleted
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%05dx", i)) }
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, key(i), []byte("v")))
+	}
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			assert.NoError(t, db.SingleDelete(DefaultWriteOptions, key(i)))
+		} else {
+			assert.NoError(t, db.Delete(DefaultWriteOptions, key(i)))
+		}
+	}
+	check := func() {
+		for i := 0; i < 20; i++ {
+			_, err := db.Get(DefaultReadOptions, key(i))
+			assert.True(t, err.(*LevelError).IsNotFound())
+		}
+		iter := db.NewIterator(&ReadOptions{LowerBound: []byte("k"), UpperBound: []byte("l")})
+		n := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			assert.Equal(t, value, iter.Value())
+			n++
+		}
+		assert.Equal(t, 100, n)
+		assert.NoError(t, iter.Close())
+	}
+	check()
+
+	for round := 0; round < 4; round++ {
+		assert.NoError(t, db.Close())
+		db, err = Open(options, dbname)
+		assert.NoError(t, err)
+		check()
+		// Overlaps with all the level-0 files, which are compacted together
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("a")))
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte("z"), []byte("z")))
+	}
+	waitForCompaction(db)
+	assert.Equal(t, 0, db.versions.NumLevelFiles(0))
+	check()
+
+	// The single deletions cancelled out with the values, the deletions
+	// are kept for the lower levels
+	internalIter, _, _ := db.newInternalIterator(db.defaultColumnFamily.cfd, DefaultReadOptions)
+	deleted := map[string]ValueType{}
+	for internalIter.SeekToFirst(); internalIter.Valid(); internalIter.Next() {
+		ikey, err := ParseInternalKey(internalIter.Key())
+		assert.NoError(t, err)
+		if bytes.HasSuffix(ikey.UserKey, []byte("x")) {
+			deleted[string(ikey.UserKey)] = ikey.Type
+		}
+	}
+	assert.NoError(t, internalIter.Close())
+	assert.Len(t, deleted, 10)
+	for i := 1; i < 20; i += 2 {
+		assert.Equal(t, ValueType_Deletion, deleted[string(key(i))])
+	}
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_ColumnFamilies(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	options.CreateIfMissing = true
+	dbname := t.TempDir()
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+
+	reverseOptions := &ColumnFamilyOptions{Comparator: NewReverseBytewiseComparator()}
+	cf, err := db.CreateColumnFamily(reverseOptions, "reverse")
+	assert.NoError(t, err)
+	assert.Equal(t, "reverse", cf.Name())
+	assert.Equal(t, uint32(1), cf.ID())
+	_, err = db.CreateColumnFamily(reverseOptions, "reverse")
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	// A batch writes to both families atomically
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.PutCF(cf, []byte("a"), []byte("2"))
+	batch.PutCF(cf, []byte("b"), []byte("3"))
+	assert.NoError(t, db.Write(DefaultWriteOptions, batch))
+
+	check := func(db *DB, cf *ColumnFamilyHandle) {
+		value, err := db.Get(DefaultReadOptions, []byte("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(value))
+		_, err = db.Get(DefaultReadOptions, []byte("b"))
+		assert.True(t, err.(*LevelError).IsNotFound())
+		value, err = db.GetCF(DefaultReadOptions, cf, []byte("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, "2", string(value))
+		iter := db.NewIteratorCF(DefaultReadOptions, cf)
+		assert.Equal(t, []string{"b->3", "a->2"}, collectIterator(iter, false))
+		assert.NoError(t, iter.Close())
+	}
+	check(db, cf)
+
+	// The default family is flushed, the log it switched from still holds
+	// the entries of the other family
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(fmt.Sprintf("k%05d", i)), value))
+	}
+	waitForCompaction(db)
+	assert.Greater(t, numTableFiles(db), 0)
+	assert.NoError(t, db.Close())
+
+	// All the families have to be opened
+	_, err = Open(options, dbname)
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	_, _, err = OpenColumnFamilies(options, dbname, []ColumnFamilyDescriptor{
+		{Name: "reverse", Options: reverseOptions},
+		{Name: "missing", Options: &ColumnFamilyOptions{}},
+	})
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	_, _, err = OpenColumnFamilies(options, dbname, []ColumnFamilyDescriptor{
+		{Name: "reverse", Options: &ColumnFamilyOptions{}},
+	})
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	db, handles, err := OpenColumnFamilies(options, dbname, []ColumnFamilyDescriptor{
+		{Name: "reverse", Options: reverseOptions},
+	})
+	assert.NoError(t, err)
+	cf = handles[0]
+	check(db, cf)
+	db.mu.Lock()
+	assert.Greater(t, cf.cfd.current.NumFiles(0), 0)
+	db.mu.Unlock()
+
+	// Ids are not reused
+	other, err := db.CreateColumnFamily(&ColumnFamilyOptions{}, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), other.ID())
+	assert.NoError(t, db.PutCF(DefaultWriteOptions, other, []byte("c"), []byte("4")))
+	assert.NoError(t, db.DeleteCF(DefaultWriteOptions, cf, []byte("b")))
+
+	assert.True(t, db.DropColumnFamily(db.DefaultColumnFamily()).(*LevelError).IsInvalidArgument())
+	assert.NoError(t, db.DropColumnFamily(cf))
+	assert.True(t, db.DropColumnFamily(cf).(*LevelError).IsInvalidArgument())
+	_, err = db.GetCF(DefaultReadOptions, cf, []byte("a"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	assert.True(t, db.PutCF(DefaultWriteOptions, cf, []byte("a"), nil).(*LevelError).IsInvalidArgument())
+	assert.NoError(t, db.Close())
+
+	// The dropped family is gone, its tables are deleted
+	_, _, err = OpenColumnFamilies(options, dbname, []ColumnFamilyDescriptor{
+		{Name: "reverse", Options: reverseOptions},
+		{Name: "other", Options: &ColumnFamilyOptions{}},
+	})
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	db, handles, err = OpenColumnFamilies(options, dbname, []ColumnFamilyDescriptor{
+		{Name: "other", Options: &ColumnFamilyOptions{}},
+	})
+	assert.NoError(t, err)
+	defer db.Close()
+	got, err := db.GetCF(DefaultReadOptions, handles[0], []byte("c"))
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(got))
+	value, err = db.Get(DefaultReadOptions, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	live := make(map[uint64]struct{})
+	db.mu.Lock()
+	db.versions.AddLiveFiles(live)
+	db.mu.Unlock()
+	filenames, err := db.env.GetChildren(dbname)
+	assert.NoError(t, err)
+	for _, filename := range filenames {
+		if number, ft, ok := ParseFileName(filename); ok && ft == FileType_TableFile {
+			assert.Contains(t, live, number)
+		}
+	}
+}
+
+func TestDB_ColumnFamiliesLevelDBFormat(t *testing.T) {
+	options := newTestOptions()
+	options.Format = Format_LevelDB
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	_, err := db.CreateColumnFamily(&ColumnFamilyOptions{}, "cf")
+	assert.True(t, err.(*LevelError).IsNotSupported())
+}
+
+// copyGoldenDB copies the database of testdata/leveldb/dir to a new
+// directory.  The databases of testdata/leveldb hold key000..key199,
+// compacted to a table with bloom filters, then the log deletes the keys
+// multiple of 10 and sets the keys ending in 5 to new%03d.
+func copyGoldenDB(t *testing.T, dir string) string {
+	dbname := t.TempDir()
+	entries, err := os.ReadDir("testdata/leveldb/" + dir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		data, err := os.ReadFile("testdata/leveldb/" + dir + "/" + entry.Name())
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(dbname+"/"+entry.Name(), data, 0644))
+	}
+	return dbname
+}
+
+func checkGoldenDB(t *testing.T, db *DB) {
+	for i := 0; i < 200; i++ {
+		got, err := db.Get(DefaultReadOptions, []byte(fmt.Sprintf("key%03d", i)))
+		switch i % 10 {
+		case 0:
+			assert.True(t, err.(*LevelError).IsNotFound())
+		case 5:
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("new%03d", i), string(got))
+		default:
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value%03d", i), string(got))
+		}
+	}
+}
+
+func TestDB_LevelDBFormat(t *testing.T) {
+	// testdata/leveldb/db was written by goleveldb, its table is snappy
+	// compressed
+	dbname := copyGoldenDB(t, "db")
+	options := newTestOptions()
+	options.FilterPolicy = newGoLevelDBBloomFilterPolicy()
+
+	// The MANIFEST does not read in the native format
+	_, err := Open(options, dbname)
+	assert.Error(t, err)
+
+	options.Format = Format_LevelDB
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	checkGoldenDB(t, db)
+	iter := db.NewIterator(DefaultReadOptions)
+	n := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		n++
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, 180, n)
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("key200"), []byte("value200")))
+	assert.NoError(t, db.Close())
+
+	// The log and the tables written in the format read back
+	db, err = Open(options, dbname)
+	assert.NoError(t, err)
+	checkGoldenDB(t, db)
+	got, err := db.Get(DefaultReadOptions, []byte("key200"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value200", string(got))
+	assert.NoError(t, db.Close())
+}
+
+// checkGoldenDBFormat opens the golden database of testdata/leveldb/dir in
+// Format_LevelDB with the bloom filter policy of the C++ library.
+func checkGoldenDBFormat(t *testing.T, dir string) {
+	dbname := copyGoldenDB(t, dir)
+	options := newTestOptions()
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	options.Format = Format_LevelDB
+	db, err := Open(options, dbname)
+	assert.NoError(t, err)
+	checkGoldenDB(t, db)
+	iter := db.NewIterator(DefaultReadOptions)
+	n := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		n++
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, 180, n)
+	assert.NoError(t, db.Close())
+}
+
+func TestDB_CppLevelDBFormat(t *testing.T) {
+	skipWithoutGolden(t, "testdata/leveldb/cpp/db")
+	checkGoldenDBFormat(t, "cpp/db")
+}
+
+// TestDB_LevelDBFormatOutput reads testdata/leveldb/go/db, written by this
+// package, which compat.sh checks that the C++ library reads.
+func TestDB_LevelDBFormatOutput(t *testing.T) {
+	checkGoldenDBFormat(t, "go/db")
+}
+
+func TestDB_IteratorBoundsSkipTables(t *testing.T) {
+	options := newTestOptions()
+	options.WriteBufferSize = 64 << 10
+	db := openTestDB(t, options)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		assert.NoError(t, db.Put(DefaultWriteOptions, key, value))
+	}
+	assert.Greater(t, numTableFiles(db), 1)
+
+	iter := db.NewIterator(&ReadOptions{LowerBound: []byte("key0500"), UpperBound: []byte("key0600")})
+	defer iter.Close()
+	var keys []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.NoError(t, iter.Error())
+	assert.Equal(t, 100, len(keys))
+	assert.Equal(t, "key0500", keys[0])
+	assert.Equal(t, "key0599", keys[99])
+
+	iter.SeekToLast()
+	assert.Equal(t, "key0599", string(iter.Key()))
+}
@@ -2,6 +2,29 @@ package leveldb
 
 import "github.com/xufeisofly/leveldb-go/util"
 
+// Grouping of constants.  We may want to make some of these
+// parameters set via options.
+const (
+	kNumLevels = 7
+
+	// Level-0 compaction is started when we hit this many files.
+	kL0_CompactionTrigger = 4
+
+	// Soft limit on number of level-0 files.  We slow down writes at this point.
+	kL0_SlowdownWritesTrigger = 8
+
+	// Maximum number of level-0 files.  We stop writes at this point.
+	kL0_StopWritesTrigger = 12
+
+	// Maximum level to which a new compacted memtable is pushed if it
+	// does not create overlap.  We try to push to level 2 to avoid the
+	// relatively expensive level 0=>1 compactions and to avoid some
+	// expensive manifest file operations.  We do not push all the way to
+	// the largest level since that can generate a lot of wasted disk
+	// space if the same key space is being repeatedly overwritten.
+	kMaxMemCompactLevel = 2
+)
+
 type LookupKey struct {
 	data             []byte
 	internalKeyStart int // the size of internal key length
@@ -85,7 +108,7 @@ func ParseInternalKey(ikey []byte) (*ParsedInternalKey, error) {
 
 	tagInt := util.DecodeUint64Fixed(ikey[n-TagSize:])
 	seq, t := UnpackSequenceAndType(tagInt)
-	if t > ValueType_Value {
+	if t > ValueType_ForSeek {
 		return nil, Error(Code_NotFound, "value type not valid")
 	}
 	return &ParsedInternalKey{
@@ -103,3 +126,112 @@ func ExtractUserKey(ikey []byte) []byte {
 	}
 	return ikey[:n-TagSize]
 }
+
+// ExtractTag extracts the packed sequence and type from internal key
+func ExtractTag(ikey []byte) uint64 {
+	n := len(ikey)
+	if n < TagSize {
+		panic("internal key size < 8")
+	}
+	return util.DecodeUint64Fixed(ikey[n-TagSize:])
+}
+
+// appendSwappedTag appends to dst the internal key ikey with the byte order
+// of its tag reversed.  This converts between the big-endian tags used in
+// memory and the little-endian tags stored by Format_LevelDB.
+func appendSwappedTag(dst, ikey []byte) []byte {
+	n := len(ikey) - TagSize
+	if n < 0 {
+		return append(dst, ikey...)
+	}
+	dst = append(dst, ikey[:n]...)
+	for i := len(ikey) - 1; i >= n; i-- {
+		dst = append(dst, ikey[i])
+	}
+	return dst
+}
+
+// appendLevelDBKey appends to dst the internal key ikey as Format_LevelDB
+// stores it.  The C++ library seeks with kTypeValue, so the keys of type
+// ValueType_ForSeek, the index separators, are stored with ValueType_Value
+// as it would.
+func appendLevelDBKey(dst, ikey []byte) []byte {
+	dst = appendSwappedTag(dst, ikey)
+	if n := len(dst) - TagSize; n >= 0 && ValueType(dst[n]) == ValueType_ForSeek {
+		dst[n] = byte(ValueType_Value)
+	}
+	return dst
+}
+
+// liveValue returns the value of an entry of type t stored as value, false
+// if the entry is a deletion or has expired by now.
+func liveValue(t ValueType, value []byte, now uint64) ([]byte, bool) {
+	switch t {
+	case ValueType_Value:
+		return value, true
+	case ValueType_ValueWithExpiry:
+		n := len(value) - Uint64Size
+		if n < 0 || util.DecodeUint64Fixed(value[n:]) <= now {
+			return nil, false
+		}
+		return value[:n], true
+	}
+	return nil, false
+}
+
+// userKeyComparator returns the user comparator of cmp and whether the keys
+// ordered by cmp are internal keys.  The tables of a DB are ordered by an
+// internalKeyComparator, standalone tables by a user comparator.
+func userKeyComparator(cmp Comparator) (Comparator, bool) {
+	if icmp, ok := cmp.(*internalKeyComparator); ok {
+		return icmp.comparator, true
+	}
+	return cmp, false
+}
+
+// internalFilterPolicy is a filter policy wrapper that converts from internal keys
+// to user keys
+type internalFilterPolicy struct {
+	userPolicy FilterPolicy
+	// Size of the timestamps ending the user keys, left out of the filters
+	// so that reads as of any timestamp match
+	timestampSize int
+}
+
+var _ FilterPolicy = (*internalFilterPolicy)(nil)
+
+func newInternalFilterPolicy(p FilterPolicy, timestampSize int) *internalFilterPolicy {
+	return &internalFilterPolicy{userPolicy: p, timestampSize: timestampSize}
+}
+
+func (p *internalFilterPolicy) forFormat(format Format) FilterPolicy {
+	if _, ok := p.userPolicy.(formatFilterPolicy); !ok {
+		return p
+	}
+	return newInternalFilterPolicy(filterPolicyForFormat(p.userPolicy, format), p.timestampSize)
+}
+
+// filterKey returns the key of the filters for the internal key.
+func (p *internalFilterPolicy) filterKey(key []byte) []byte {
+	userKey := ExtractUserKey(key)
+	if len(userKey) >= p.timestampSize {
+		userKey = userKey[:len(userKey)-p.timestampSize]
+	}
+	return userKey
+}
+
+func (p *internalFilterPolicy) Name() string {
+	return p.userPolicy.Name()
+}
+
+func (p *internalFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
+	userKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		userKeys[i] = p.filterKey(key)
+	}
+	p.userPolicy.CreateFilter(userKeys, dst)
+}
+
+func (p *internalFilterPolicy) KeyMayMatch(key []byte, filter []byte) bool {
+	return p.userPolicy.KeyMayMatch(p.filterKey(key), filter)
+}
@@ -1 +1,180 @@
 package leveldb
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// A file abstraction for reading sequentially through a file
+type SequentialFile interface {
+	io.Reader
+	io.Closer
+}
+
+// A file abstraction for randomly reading the contents of a file.
+// Safe for concurrent use.
+type RandomAccessFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// A file abstraction for sequential writing.  The implementation
+// must provide buffering since callers may append small fragments
+// at a time to the file.
+type WritableFile interface {
+	Append(data []byte) error
+	Close() error
+	Flush() error
+	Sync() error
+}
+
+// NewSequentialFile creates an object that sequentially reads the file with the specified name.
+func (e *Env) NewSequentialFile(fname string) (SequentialFile, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return f, nil
+}
+
+// NewRandomAccessFile creates an object supporting random-access reads from the file with the
+// specified name.
+func (e *Env) NewRandomAccessFile(fname string) (RandomAccessFile, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return f, nil
+}
+
+// NewWritableFile creates an object that writes to a new file with the specified
+// name.  Deletes any existing file with the same name and creates a
+// new file.
+func (e *Env) NewWritableFile(fname string) (WritableFile, error) {
+	f, err := os.OpenFile(fname, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	return &posixWritableFile{
+		file: f,
+		buf:  bufio.NewWriterSize(f, kWritableFileBufferSize),
+	}, nil
+}
+
+// FileExists returns true iff the named file exists.
+func (e *Env) FileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
+}
+
+// GetChildren returns the names of the children of the specified directory.
+// The names are relative to "dir".
+func (e *Env) GetChildren(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, ioError(err)
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.Name())
+	}
+	return result, nil
+}
+
+// RemoveFile deletes the named file.
+func (e *Env) RemoveFile(fname string) error {
+	if err := os.Remove(fname); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+// RenameFile renames file src to target.
+func (e *Env) RenameFile(src, target string) error {
+	if err := os.Rename(src, target); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+// GetFileSize returns the size of fname.
+func (e *Env) GetFileSize(fname string) (uint64, error) {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return 0, ioError(err)
+	}
+	return uint64(info.Size()), nil
+}
+
+// CreateDir creates the specified directory, it is not an error if it
+// already exists.
+func (e *Env) CreateDir(dirname string) error {
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+// NowMicros returns the number of micro-seconds since some fixed point in time. Only
+// useful for computing deltas of time.
+func (e *Env) NowMicros() uint64 {
+	return uint64(time.Now().UnixNano() / 1000)
+}
+
+// NowUnixMicros returns the number of micro-seconds since the Unix epoch,
+// the clock the expiry of the entries written with a TTL is measured by.
+func (e *Env) NowUnixMicros() uint64 {
+	return uint64(time.Now().UnixMicro())
+}
+
+// SleepForMicroseconds sleeps/delays the goroutine for the prescribed number of micro-seconds.
+func (e *Env) SleepForMicroseconds(micros int) {
+	time.Sleep(time.Duration(micros) * time.Microsecond)
+}
+
+const kWritableFileBufferSize = 65536
+
+type posixWritableFile struct {
+	file *os.File
+	buf  *bufio.Writer
+}
+
+var _ WritableFile = (*posixWritableFile)(nil)
+
+func (f *posixWritableFile) Append(data []byte) error {
+	if _, err := f.buf.Write(data); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+func (f *posixWritableFile) Close() error {
+	flushErr := f.Flush()
+	if err := f.file.Close(); err != nil {
+		return ioError(err)
+	}
+	return flushErr
+}
+
+func (f *posixWritableFile) Flush() error {
+	if err := f.buf.Flush(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+func (f *posixWritableFile) Sync() error {
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return ioError(err)
+	}
+	return nil
+}
+
+func ioError(err error) error {
+	return Error(Code_IOError, err.Error())
+}
@@ -19,6 +19,8 @@ const (
 	Code_NotSupported
 	Code_InvalidArgument
 	Code_IOError
+	Code_Busy
+	Code_TimedOut
 )
 
 func Error(code Code, msg string) error {
@@ -44,3 +46,15 @@ func (e *LevelError) IsInvalidArgument() bool {
 func (e *LevelError) IsIOError() bool {
 	return e.Code == Code_IOError
 }
+
+// IsBusy returns true if the operation conflicted with a concurrent one and
+// may succeed if retried, e.g. the commit of a transaction.
+func (e *LevelError) IsBusy() bool {
+	return e.Code == Code_Busy
+}
+
+// IsTimedOut returns true if the operation gave up waiting, e.g. for the
+// lock of a key held by another transaction.
+func (e *LevelError) IsTimedOut() bool {
+	return e.Code == Code_TimedOut
+}
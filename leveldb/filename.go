@@ -0,0 +1,121 @@
+package leveldb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type FileType int
+
+const (
+	FileType_LogFile FileType = iota
+	FileType_DBLockFile
+	FileType_TableFile
+	FileType_DescriptorFile
+	FileType_CurrentFile
+	FileType_TempFile
+	FileType_InfoLogFile // Either the current one, or an old one
+)
+
+func makeFileName(dbname string, number uint64, suffix string) string {
+	return fmt.Sprintf("%s/%06d.%s", dbname, number, suffix)
+}
+
+// LogFileName returns the name of the log file with the specified number
+// in the db named by "dbname".  The result will be prefixed with
+// "dbname".
+func LogFileName(dbname string, number uint64) string {
+	if number <= 0 {
+		panic("log file number <= 0")
+	}
+	return makeFileName(dbname, number, "log")
+}
+
+// TableFileName returns the name of the sstable with the specified number
+// in the db named by "dbname".  The result will be prefixed with
+// "dbname".
+func TableFileName(dbname string, number uint64) string {
+	if number <= 0 {
+		panic("table file number <= 0")
+	}
+	return makeFileName(dbname, number, "ldb")
+}
+
+// DescriptorFileName returns the name of the descriptor file for the db named by
+// "dbname" and the specified incarnation number.  The result will be
+// prefixed with "dbname".
+func DescriptorFileName(dbname string, number uint64) string {
+	if number <= 0 {
+		panic("descriptor file number <= 0")
+	}
+	return fmt.Sprintf("%s/MANIFEST-%06d", dbname, number)
+}
+
+// CurrentFileName returns the name of the current file.  This file contains the name
+// of the current manifest file.  The result will be prefixed with
+// "dbname".
+func CurrentFileName(dbname string) string {
+	return dbname + "/CURRENT"
+}
+
+// LockFileName returns the name of the lock file for the db named by
+// "dbname".  The result will be prefixed with "dbname".
+func LockFileName(dbname string) string {
+	return dbname + "/LOCK"
+}
+
+// TempFileName returns the name of a temporary file owned by the db named "dbname".
+// The result will be prefixed with "dbname".
+func TempFileName(dbname string, number uint64) string {
+	if number <= 0 {
+		panic("temp file number <= 0")
+	}
+	return makeFileName(dbname, number, "dbtmp")
+}
+
+// ParseFileName parses the file name of a db file without the directory
+// prefix. Owned filenames have the form:
+//
+//	dbname/CURRENT
+//	dbname/LOCK
+//	dbname/LOG
+//	dbname/LOG.old
+//	dbname/MANIFEST-[0-9]+
+//	dbname/[0-9]+.(log|sst|ldb|dbtmp)
+func ParseFileName(filename string) (uint64, FileType, bool) {
+	switch filename {
+	case "CURRENT":
+		return 0, FileType_CurrentFile, true
+	case "LOCK":
+		return 0, FileType_DBLockFile, true
+	case "LOG", "LOG.old":
+		return 0, FileType_InfoLogFile, true
+	}
+
+	if strings.HasPrefix(filename, "MANIFEST-") {
+		num, err := strconv.ParseUint(strings.TrimPrefix(filename, "MANIFEST-"), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return num, FileType_DescriptorFile, true
+	}
+
+	numStr, suffix, ok := strings.Cut(filename, ".")
+	if !ok {
+		return 0, 0, false
+	}
+	num, err := strconv.ParseUint(numStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch suffix {
+	case "log":
+		return num, FileType_LogFile, true
+	case "sst", "ldb":
+		return num, FileType_TableFile, true
+	case "dbtmp":
+		return num, FileType_TempFile, true
+	}
+	return 0, 0, false
+}
@@ -1,32 +1,99 @@
 package leveldb
 
-import (
-	"github.com/xufeisofly/leveldb-go/util"
-)
+import "bytes"
+
+// filterBuilder builds the filters of a table, its implementations match
+// the FilterType values.
+type filterBuilder interface {
+	setPrefixExtractor(p SliceTransform, internalKeys bool)
+	StartBlock(blockOffset uint64) error
+	AddKey(key []byte)
+}
+
+// filterKeys collects the keys of a filter, along with their prefixes if
+// a prefix extractor is set.
+type filterKeys struct {
+	// If non-nil, the prefixes of the keys are added to the filters
+	// along with the keys
+	prefixExtractor SliceTransform
+	internalKeys    bool     // keys are internal keys, so are the added prefixes
+	lastPrefix      []byte   // last prefix added to the current filter
+	keys            [][]byte // flattened key contents
+}
+
+// setPrefixExtractor makes the builder add the prefixes extracted by p to
+// the filters.  internalKeys tells whether the added keys are internal keys.
+func (fk *filterKeys) setPrefixExtractor(p SliceTransform, internalKeys bool) {
+	fk.prefixExtractor = p
+	fk.internalKeys = internalKeys
+}
+
+func (fk *filterKeys) add(key []byte) {
+	// the caller may reuse key once AddKey returns
+	fk.keys = append(fk.keys, append([]byte{}, key...))
+
+	if fk.prefixExtractor != nil {
+		pkey, prefix, ok := prefixFilterKey(fk.prefixExtractor, fk.internalKeys, key)
+		if ok && (fk.lastPrefix == nil || !bytes.Equal(prefix, fk.lastPrefix)) {
+			fk.keys = append(fk.keys, pkey)
+			fk.lastPrefix = prefix
+		}
+	}
+}
+
+// reset starts a new filter.
+func (fk *filterKeys) reset() {
+	fk.keys = [][]byte{}
+	fk.lastPrefix = nil
+}
 
 // A FilterBlockBuilder is used to construct all of the filters for a
 // particular Table.  It generates a single string which is stored as
 // a special block in the Table.
 //
 // The sequence of calls to FilterBlockBuilder must match the regexp:
-//      (StartBlock AddKey*)* Finish
+//
+//	(StartBlock AddKey*)* Finish
 type filterBlockBuilder struct {
+	filterKeys
 	policy FilterPolicy
-	keys   [][]byte // flattened key contents
 	// start         []uint64 // starting index in keys of each key
 	result []byte // filter data computed so far
 	// tmpKeys       [][]byte // policy.CreateFilter() argument
 	filterOffsets []uint64
+	format        Format // width and byte order of the offsets
 }
 
+var _ filterBuilder = (*filterBlockBuilder)(nil)
+
 const kFilterBaseLg = 11
 
 var kFilterBase int = 1 << kFilterBaseLg
 
-func NewFilterBlockBuilder(policy FilterPolicy) *filterBlockBuilder {
+func NewFilterBlockBuilder(policy FilterPolicy, format Format) *filterBlockBuilder {
 	return &filterBlockBuilder{
 		policy: policy,
+		format: format,
+	}
+}
+
+// prefixFilterKey returns the key added to the filters for the prefix of key,
+// in the same format as key, and the prefix itself.  Returns false if key is
+// out of the domain of p.
+func prefixFilterKey(p SliceTransform, internalKeys bool, key []byte) ([]byte, []byte, bool) {
+	userKey := key
+	if internalKeys {
+		userKey = ExtractUserKey(key)
+	}
+	if !p.InDomain(userKey) {
+		return nil, nil, false
 	}
+	prefix := p.Transform(userKey)
+	pkey := append([]byte{}, prefix...)
+	if internalKeys {
+		pkey = append(pkey, key[len(key)-TagSize:]...)
+	}
+	return pkey, pkey[:len(prefix)], true
 }
 
 func (fb *filterBlockBuilder) StartBlock(blockOffset uint64) error {
@@ -42,7 +109,7 @@ func (fb *filterBlockBuilder) StartBlock(blockOffset uint64) error {
 
 func (fb *filterBlockBuilder) AddKey(key []byte) {
 	// fb.start = append(fb.start, uint64(len(fb.keys)))
-	fb.keys = append(fb.keys, key)
+	fb.add(key)
 }
 
 func (fb *filterBlockBuilder) Finish() []byte {
@@ -53,10 +120,10 @@ func (fb *filterBlockBuilder) Finish() []byte {
 	// Append array of per-filter offsets
 	arrayOffset := len(fb.result)
 	for i := 0; i < len(fb.filterOffsets); i++ {
-		util.PutUint64Fixed(&fb.result, fb.filterOffsets[i])
+		fb.format.putOffset(&fb.result, fb.filterOffsets[i])
 	}
 
-	util.PutUint64Fixed(&fb.result, uint64(arrayOffset))
+	fb.format.putOffset(&fb.result, uint64(arrayOffset))
 	fb.result = append(fb.result, kFilterBaseLg)
 	return fb.result
 }
@@ -71,8 +138,15 @@ func (fb *filterBlockBuilder) generateFilter() {
 
 	fb.filterOffsets = append(fb.filterOffsets, uint64(len(fb.result)))
 	fb.policy.CreateFilter(fb.keys, &fb.result)
+	fb.reset()
+}
 
-	fb.keys = [][]byte{}
+// filterReader answers the filter lookups of a table, its implementations
+// match the FilterType values.
+type filterReader interface {
+	// KeyMayMatch returns false if key is not in the data block starting
+	// at blockOffset.
+	KeyMayMatch(blockOffset uint64, key []byte) bool
 }
 
 type filterBlockReader struct {
@@ -81,22 +155,24 @@ type filterBlockReader struct {
 	offset []byte // offset array at block end
 	num    int    // number of entries in offset array
 	baseLg int    // encoding parameter
+	format Format // width and byte order of the offsets
 }
 
-func NewFilterBlockReader(policy FilterPolicy, contents []byte) *filterBlockReader {
+func NewFilterBlockReader(policy FilterPolicy, contents []byte, format Format) *filterBlockReader {
 	n := len(contents)
-	if n < 9 {
-		// 1 byte for baseLg and 8 for start of offset array
+	w := format.offsetSize()
+	if n < 1+w {
+		// 1 byte for baseLg and w for start of offset array
 		return nil
 	}
 	baseLg := contents[n-1]
-	lastWord := util.DecodeUint64Fixed(contents[n-9:]) // length of filter data
-	if lastWord > uint64(n-9) {
+	lastWord := format.decodeOffset(contents[n-1-w:]) // length of filter data
+	if lastWord > uint64(n-1-w) {
 		return nil
 	}
 	data := contents[:lastWord]
 	offset := data[lastWord : n-1]
-	num := (n - 9 - int(lastWord)) / 8 // num of filters
+	num := (n - 1 - w - int(lastWord)) / w // num of filters
 
 	return &filterBlockReader{
 		policy: policy,
@@ -104,14 +180,16 @@ func NewFilterBlockReader(policy FilterPolicy, contents []byte) *filterBlockRead
 		offset: offset,
 		num:    num,
 		baseLg: int(baseLg),
+		format: format,
 	}
 }
 
 func (fr *filterBlockReader) KeyMayMatch(blockOffset uint64, key []byte) bool {
 	index := blockOffset >> uint64(fr.baseLg)
 	if index < uint64(fr.num) {
-		start := util.DecodeUint64Fixed(fr.offset[8*index:])
-		limit := util.DecodeUint64Fixed(fr.offset[8*index+8:])
+		w := uint64(fr.format.offsetSize())
+		start := fr.format.decodeOffset(fr.offset[w*index:])
+		limit := fr.format.decodeOffset(fr.offset[w*index+w:])
 		if start <= limit && limit <= uint64(len(fr.data)) {
 			filter := fr.data[start:limit]
 			return fr.policy.KeyMayMatch(key, filter)
@@ -37,19 +37,19 @@ func (f *testHashFilterPolicy) KeyMayMatch(key []byte, filter []byte) bool {
 
 func TestFilterBlock_EmptyBuilder(t *testing.T) {
 	policy := &testHashFilterPolicy{}
-	builder := leveldb.NewFilterBlockBuilder(policy)
+	builder := leveldb.NewFilterBlockBuilder(policy, leveldb.Format_Native)
 	block := builder.Finish()
 
 	assert.Equal(t, "\\x00\\x00\\x00\\x00\\x00\\x00\\x00\\x00\\x0b", util.EscapeString(block))
 
-	reader := leveldb.NewFilterBlockReader(policy, block)
+	reader := leveldb.NewFilterBlockReader(policy, block, leveldb.Format_Native)
 	assert.True(t, reader.KeyMayMatch(0, []byte("foo")))
 	assert.True(t, reader.KeyMayMatch(100000, []byte("foo")))
 }
 
 func TestFilterBlock_SingleChunk(t *testing.T) {
 	policy := &testHashFilterPolicy{}
-	builder := leveldb.NewFilterBlockBuilder(policy)
+	builder := leveldb.NewFilterBlockBuilder(policy, leveldb.Format_Native)
 	builder.StartBlock(100)
 	builder.AddKey([]byte("foo"))
 	builder.AddKey([]byte("bar"))
@@ -59,7 +59,7 @@ func TestFilterBlock_SingleChunk(t *testing.T) {
 	builder.StartBlock(300)
 	builder.AddKey([]byte("hello"))
 	block := builder.Finish()
-	reader := leveldb.NewFilterBlockReader(policy, block)
+	reader := leveldb.NewFilterBlockReader(policy, block, leveldb.Format_Native)
 	assert.True(t, reader.KeyMayMatch(100, []byte("foo")))
 	assert.True(t, reader.KeyMayMatch(100, []byte("bar")))
 	assert.True(t, reader.KeyMayMatch(100, []byte("box")))
@@ -71,7 +71,7 @@ func TestFilterBlock_SingleChunk(t *testing.T) {
 
 func TestFilterBlock_MultiChunk(t *testing.T) {
 	policy := &testHashFilterPolicy{}
-	builder := leveldb.NewFilterBlockBuilder(policy)
+	builder := leveldb.NewFilterBlockBuilder(policy, leveldb.Format_Native)
 
 	// first filter
 	builder.StartBlock(0)
@@ -91,7 +91,7 @@ func TestFilterBlock_MultiChunk(t *testing.T) {
 	builder.AddKey([]byte("hello"))
 
 	block := builder.Finish()
-	reader := leveldb.NewFilterBlockReader(policy, block)
+	reader := leveldb.NewFilterBlockReader(policy, block, leveldb.Format_Native)
 
 	// check first filter
 	assert.True(t, reader.KeyMayMatch(0, []byte("foo")))
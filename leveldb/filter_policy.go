@@ -33,3 +33,18 @@ type FilterPolicy interface {
 	// list, but it should aim to return false with a high probability.
 	KeyMayMatch(key []byte, filter []byte) bool
 }
+
+// formatFilterPolicy is implemented by the policies whose filters depend on
+// the encoding of the table.
+type formatFilterPolicy interface {
+	forFormat(format Format) FilterPolicy
+}
+
+// filterPolicyForFormat returns the policy building and reading the filters
+// of the tables encoded in format.
+func filterPolicyForFormat(policy FilterPolicy, format Format) FilterPolicy {
+	if p, ok := policy.(formatFilterPolicy); ok {
+		return p.forFormat(format)
+	}
+	return policy
+}
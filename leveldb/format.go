@@ -1,7 +1,229 @@
 package leveldb
 
+import (
+	"encoding/binary"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// byteOrder returns the byte order of the fixed-width integers of f.
+func (f Format) byteOrder() byteOrder {
+	if f == Format_LevelDB {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// offsetSize returns the width of the block restart offsets and of the
+// filter offsets of f.
+func (f Format) offsetSize() int {
+	if f == Format_LevelDB {
+		return Uint32Size
+	}
+	return Uint64Size
+}
+
+func (f Format) putOffset(dst *[]byte, v uint64) {
+	if f.offsetSize() == Uint32Size {
+		*dst = f.byteOrder().AppendUint32(*dst, uint32(v))
+	} else {
+		*dst = f.byteOrder().AppendUint64(*dst, v)
+	}
+}
+
+func (f Format) decodeOffset(input []byte) uint64 {
+	if f.offsetSize() == Uint32Size {
+		return uint64(f.byteOrder().Uint32(input))
+	}
+	return f.byteOrder().Uint64(input)
+}
+
+func (f Format) putFixed32(dst *[]byte, v uint32) {
+	*dst = f.byteOrder().AppendUint32(*dst, v)
+}
+
+func (f Format) decodeFixed32(input []byte) uint32 {
+	return f.byteOrder().Uint32(input)
+}
+
+// reverseBytes converts the fixed-width integer b between byte orders.
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// BlockHandle is a pointer to the extent of a file that stores a data
+// block or a meta block.
+type BlockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+// Maximum encoding length of a BlockHandle
+const kMaxEncodedLength = 10 + 10
+
+func NewBlockHandle() *BlockHandle {
+	return &BlockHandle{
+		offset: ^uint64(0),
+		size:   ^uint64(0),
+	}
+}
+
+// Offset returns the offset of the block in the file.
+func (h *BlockHandle) Offset() uint64 {
+	return h.offset
+}
+
+func (h *BlockHandle) SetOffset(offset uint64) {
+	h.offset = offset
+}
+
+// Size returns the size of the stored block
+func (h *BlockHandle) Size() uint64 {
+	return h.size
+}
+
+func (h *BlockHandle) SetSize(size uint64) {
+	h.size = size
+}
+
+func (h *BlockHandle) EncodeTo(dst *[]byte) {
+	// Sanity check that all fields have been set
+	if h.offset == ^uint64(0) || h.size == ^uint64(0) {
+		panic("block handle is not set")
+	}
+	util.PutUvarint(dst, h.offset)
+	util.PutUvarint(dst, h.size)
+}
+
+// DecodeFrom decodes the handle from input and returns the number of
+// bytes consumed
+func (h *BlockHandle) DecodeFrom(input []byte) (int, error) {
+	offset, n1 := util.DecodeUvarint(input)
+	if n1 <= 0 {
+		return 0, Error(Code_Corruption, "bad block handle")
+	}
+	size, n2 := util.DecodeUvarint(input[n1:])
+	if n2 <= 0 {
+		return 0, Error(Code_Corruption, "bad block handle")
+	}
+	h.offset = offset
+	h.size = size
+	return n1 + n2, nil
+}
+
+// Footer encapsulates the fixed information stored at the tail
+// end of every table file.
+type Footer struct {
+	metaindexHandle BlockHandle
+	indexHandle     BlockHandle
+	// Encoding of the table, told by the byte order of the magic number
+	format Format
+}
+
+// Encoded length of a Footer.  Note that the serialization of a
+// Footer will always occupy exactly this many bytes.  It consists
+// of two block handles and a magic number.
+const kEncodedFooterLength = 2*kMaxEncodedLength + 8
+
+// kTableMagicNumber was picked by running
+//
+//	echo http://code.google.com/p/leveldb/ | sha1sum
+//
+// and taking the leading 64 bits.
+const kTableMagicNumber uint64 = 0xdb4775248b80fb57
+
+// 1-byte type + 32-bit crc
+const kBlockTrailerSize = 5
+
+func (f *Footer) EncodeTo(dst *[]byte) {
+	originalSize := len(*dst)
+	f.metaindexHandle.EncodeTo(dst)
+	f.indexHandle.EncodeTo(dst)
+	// Padding
+	*dst = append(*dst, make([]byte, 2*kMaxEncodedLength-(len(*dst)-originalSize))...)
+	*dst = f.format.byteOrder().AppendUint64(*dst, kTableMagicNumber)
+}
+
+func (f *Footer) DecodeFrom(input []byte) error {
+	if len(input) < kEncodedFooterLength {
+		return Error(Code_Corruption, "not an sstable (footer too short)")
+	}
+
+	magic := input[kEncodedFooterLength-8:]
+	switch kTableMagicNumber {
+	case Format_Native.byteOrder().Uint64(magic):
+		f.format = Format_Native
+	case Format_LevelDB.byteOrder().Uint64(magic):
+		f.format = Format_LevelDB
+	default:
+		return Error(Code_Corruption, "not an sstable (bad magic number)")
+	}
+
+	n, err := f.metaindexHandle.DecodeFrom(input)
+	if err != nil {
+		return err
+	}
+	_, err = f.indexHandle.DecodeFrom(input[n:])
+	return err
+}
+
 type blockContents struct {
 	data          []byte // actual contents of data
 	cachable      bool   // true if data can be cached
 	heapAllocated bool   // true if caller should delete data
+	format        Format // encoding of the table holding the block
+}
+
+// ReadBlock reads the block identified by "handle" from "file", a table
+// encoded in format.
+func ReadBlock(file RandomAccessFile, options *ReadOptions, handle *BlockHandle, format Format) (*blockContents, error) {
+	// Read the block contents as well as the type/crc footer.
+	// See table_builder.go for the code that built this structure.
+	n := int(handle.Size())
+	buf := make([]byte, n+kBlockTrailerSize)
+	if m, err := file.ReadAt(buf, int64(handle.Offset())); m != len(buf) {
+		if err != nil {
+			return nil, ioError(err)
+		}
+		return nil, Error(Code_Corruption, "truncated block read")
+	}
+
+	// Check the crc of the type and the block contents
+	if options.VerifyChecksums {
+		crc := util.CRC32CUnmask(format.decodeFixed32(buf[n+1:]))
+		actual := util.CRC32CValue(buf[:n+1])
+		if actual != crc {
+			return nil, Error(Code_Corruption, "block checksum mismatch")
+		}
+	}
+
+	switch CompressionType(buf[n]) {
+	case CompressionType_NoCompression:
+		return &blockContents{
+			data:          buf[:n],
+			cachable:      true,
+			heapAllocated: true,
+			format:        format,
+		}, nil
+	case CompressionType_Snappy:
+		data, err := util.SnappyDecode(buf[:n])
+		if err != nil {
+			return nil, Error(Code_Corruption, "corrupted compressed block contents")
+		}
+		return &blockContents{
+			data:          data,
+			cachable:      true,
+			heapAllocated: true,
+			format:        format,
+		}, nil
+	default:
+		return nil, Error(Code_NotSupported, "unsupported block compression type")
+	}
 }
@@ -0,0 +1,202 @@
+package leveldb
+
+import "sync"
+
+// fullFilterBlockBuilder builds a single filter for all the keys of a table
+// (FilterType_Full).  The filter block is the filter as generated by the
+// policy.
+//
+// The sequence of calls must match the regexp:
+//
+//	(StartBlock AddKey*)* Finish
+type fullFilterBlockBuilder struct {
+	filterKeys
+	policy FilterPolicy
+}
+
+var _ filterBuilder = (*fullFilterBlockBuilder)(nil)
+
+func newFullFilterBlockBuilder(policy FilterPolicy) *fullFilterBlockBuilder {
+	return &fullFilterBlockBuilder{
+		policy: policy,
+	}
+}
+
+func (fb *fullFilterBlockBuilder) StartBlock(blockOffset uint64) error {
+	return nil
+}
+
+func (fb *fullFilterBlockBuilder) AddKey(key []byte) {
+	fb.add(key)
+}
+
+func (fb *fullFilterBlockBuilder) Finish() []byte {
+	var result []byte
+	fb.policy.CreateFilter(fb.keys, &result)
+	fb.reset()
+	return result
+}
+
+type fullFilterBlockReader struct {
+	policy FilterPolicy
+	filter []byte
+}
+
+var _ filterReader = (*fullFilterBlockReader)(nil)
+
+func newFullFilterBlockReader(policy FilterPolicy, contents []byte) *fullFilterBlockReader {
+	return &fullFilterBlockReader{
+		policy: policy,
+		filter: contents,
+	}
+}
+
+func (fr *fullFilterBlockReader) KeyMayMatch(blockOffset uint64, key []byte) bool {
+	return fr.policy.KeyMayMatch(key, fr.filter)
+}
+
+// blockPartition is a partition of the index or of the filter of a table
+// along with the index key of the last data block it covers.
+type blockPartition struct {
+	lastKey  []byte
+	contents []byte
+}
+
+// partitionedFilterBlockBuilder builds the filter partitions of a table
+// (FilterType_Partitioned).  The table builder cuts a filter partition
+// along with each partition of its index, after the index entry of the
+// last data block they cover, so that both partitions cover the same data
+// blocks.  It writes each partition as a block and a top-level index block
+// mapping the last index key of each partition to its handle.
+//
+// The sequence of calls must match the regexp:
+//
+//	((StartBlock AddKey*)* cutPartition)* Finish
+type partitionedFilterBlockBuilder struct {
+	filterKeys
+	policy     FilterPolicy
+	partitions []blockPartition
+}
+
+var _ filterBuilder = (*partitionedFilterBlockBuilder)(nil)
+
+func newPartitionedFilterBlockBuilder(policy FilterPolicy) *partitionedFilterBlockBuilder {
+	return &partitionedFilterBlockBuilder{
+		policy: policy,
+	}
+}
+
+func (fb *partitionedFilterBlockBuilder) StartBlock(blockOffset uint64) error {
+	return nil
+}
+
+func (fb *partitionedFilterBlockBuilder) AddKey(key []byte) {
+	fb.add(key)
+}
+
+// cutPartition ends the current partition, lastKey being the index key of
+// its last data block.
+func (fb *partitionedFilterBlockBuilder) cutPartition(lastKey []byte) {
+	p := blockPartition{lastKey: append([]byte{}, lastKey...)}
+	fb.policy.CreateFilter(fb.keys, &p.contents)
+	fb.partitions = append(fb.partitions, p)
+	fb.reset()
+}
+
+// Finish returns the filter partitions of the table in key order.
+func (fb *partitionedFilterBlockBuilder) Finish() []blockPartition {
+	return fb.partitions
+}
+
+// The number of partitions of its index and of its filter a table keeps
+const kPartitionCacheSize = 8
+
+// partitionCache keeps the partitions of a table read last, so that the
+// lookups of nearby keys do not read the same partition again.
+type partitionCache struct {
+	mu sync.Mutex
+	// The most recently used first
+	cached []cachedPartition
+}
+
+type cachedPartition struct {
+	offset   uint64
+	contents *blockContents
+}
+
+// read returns the contents of the partition of table at handle, from the
+// cache if it was read lately.
+func (c *partitionCache) read(table *Table, handle *BlockHandle) (*blockContents, error) {
+	c.mu.Lock()
+	for i, p := range c.cached {
+		if p.offset == handle.Offset() {
+			copy(c.cached[1:i+1], c.cached[:i])
+			c.cached[0] = p
+			c.mu.Unlock()
+			return p.contents, nil
+		}
+	}
+	c.mu.Unlock()
+
+	opt := &ReadOptions{}
+	if table.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	contents, err := ReadBlock(table.file, opt, handle, table.format)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cached) < kPartitionCacheSize {
+		c.cached = append(c.cached, cachedPartition{})
+	}
+	copy(c.cached[1:], c.cached)
+	c.cached[0] = cachedPartition{offset: handle.Offset(), contents: contents}
+	return contents, nil
+}
+
+// partitionedFilterBlockReader keeps the top-level index of the filter
+// partitions of a table and reads the partition of a key on lookups.  The
+// last partitions read are kept in memory.
+type partitionedFilterBlockReader struct {
+	policy FilterPolicy
+	table  *Table
+	index  *block
+	cache  partitionCache
+}
+
+var _ filterReader = (*partitionedFilterBlockReader)(nil)
+
+func newPartitionedFilterBlockReader(policy FilterPolicy, table *Table, contents *blockContents) *partitionedFilterBlockReader {
+	return &partitionedFilterBlockReader{
+		policy: policy,
+		table:  table,
+		index:  NewBlock(contents),
+	}
+}
+
+// KeyMayMatch looks up key in the partition covering it.  The index keys
+// are the index keys of the last data blocks of the partitions, so the
+// first one >= key selects the partition of the data block of key.
+func (fr *partitionedFilterBlockReader) KeyMayMatch(blockOffset uint64, key []byte) bool {
+	iter := fr.index.NewIterator(fr.table.options.Comparator)
+	defer iter.Close()
+	iter.Seek(key)
+	if !iter.Valid() {
+		// key is after the last key of the table
+		return iter.Error() != nil
+	}
+
+	var handle BlockHandle
+	if _, err := handle.DecodeFrom(iter.Value()); err != nil {
+		return true
+	}
+	contents, err := fr.cache.read(fr.table, &handle)
+	if err != nil {
+		// Errors are treated as potential matches
+		return true
+	}
+	return fr.policy.KeyMayMatch(key, contents.data)
+}
@@ -0,0 +1,138 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+type saverState int
+
+const (
+	saverState_NotFound saverState = iota
+	saverState_Found
+	saverState_Deleted
+	saverState_Corrupt
+	saverState_Merge // Merge operands found, the value they apply to is not
+)
+
+// getContext is the state of a point lookup as it walks the entries of the
+// key from the newest: the memtables, then the tables level by level.  It
+// collects the merge operands until it meets a value or a deletion.
+type getContext struct {
+	ucmp          Comparator
+	mergeOperator MergeOperator
+	userKey       []byte
+	now           uint64 // Values expired by now read as deleted
+	snapshot      SequenceNumber
+	// The entries older than it are deleted by a range tombstone
+	rangeDelSeq SequenceNumber
+	state       saverState
+	value       []byte
+	operands    [][]byte // Newest first
+	// The sequence number of the newest entry of the key met, or of the
+	// newest range tombstone deleting it
+	newestSeq SequenceNumber
+}
+
+func newGetContext(ucmp Comparator, mergeOperator MergeOperator, userKey []byte,
+	snapshot SequenceNumber, now uint64) *getContext {
+	return &getContext{
+		ucmp:          ucmp,
+		mergeOperator: mergeOperator,
+		userKey:       userKey,
+		now:           now,
+		snapshot:      snapshot,
+		state:         saverState_NotFound,
+	}
+}
+
+// addRangeTombstones records the range tombstones, sorted by start, of the
+// memtable or table the lookup is about to read from.  They are newer than
+// the entries of the key in the memtables and tables read after it.
+func (g *getContext) addRangeTombstones(tombstones []rangeTombstone) {
+	g.addRangeDelSeq(maxCoveringSeq(g.ucmp, tombstones, g.userKey, g.snapshot))
+}
+
+// addFragmentedRangeTombstones is addRangeTombstones with the tombstones
+// fragmented, only the fragment holding the key is read.
+func (g *getContext) addFragmentedRangeTombstones(f *fragmentedRangeTombstones) {
+	g.addRangeDelSeq(f.maxCoveringSeq(g.userKey, g.snapshot))
+}
+
+// addRangeDelSeq records seq, the one of the newest range tombstone of a
+// memtable or table deleting the key.
+func (g *getContext) addRangeDelSeq(seq SequenceNumber) {
+	if seq > g.rangeDelSeq {
+		g.rangeDelSeq = seq
+	}
+	if g.rangeDelSeq > g.newestSeq {
+		g.newestSeq = g.rangeDelSeq
+	}
+}
+
+// saveValue records the entry ikey->v met by the lookup.  It returns true
+// if the lookup needs the next entry as well, the value the merge operands
+// met so far apply to.
+func (g *getContext) saveValue(ikey, v []byte) bool {
+	parsedKey, err := ParseInternalKey(ikey)
+	if err != nil {
+		g.state = saverState_Corrupt
+		return false
+	}
+	if !sameUserKey(g.ucmp, parsedKey.UserKey, g.userKey) {
+		return false
+	}
+	if parsedKey.Sequence > g.newestSeq {
+		g.newestSeq = parsedKey.Sequence
+	}
+	if parsedKey.Sequence < g.rangeDelSeq {
+		g.state = saverState_Deleted
+		return false
+	}
+	if parsedKey.Type == ValueType_Merge {
+		g.state = saverState_Merge
+		g.operands = append(g.operands, append([]byte{}, v...))
+		return true
+	}
+	if live, ok := liveValue(parsedKey.Type, v, g.now); ok {
+		g.state = saverState_Found
+		g.value = append([]byte{}, live...)
+	} else {
+		g.state = saverState_Deleted
+	}
+	return false
+}
+
+// done returns true once the lookup met the entries deciding the value.
+func (g *getContext) done() bool {
+	return g.state != saverState_NotFound && g.state != saverState_Merge
+}
+
+// result returns the value of the key, merged if need be, or a
+// Code_NotFound error.
+func (g *getContext) result() ([]byte, error) {
+	switch g.state {
+	case saverState_Found:
+		if len(g.operands) == 0 {
+			return g.value, nil
+		}
+		return g.merge(g.value)
+	case saverState_Deleted, saverState_Merge:
+		if len(g.operands) == 0 {
+			return nil, Error(Code_NotFound, "")
+		}
+		return g.merge(nil)
+	case saverState_Corrupt:
+		return nil, Error(Code_Corruption, "corrupted key for "+util.EscapeString(g.userKey))
+	}
+	return nil, Error(Code_NotFound, "")
+}
+
+// merge applies the operands to base.
+func (g *getContext) merge(base []byte) ([]byte, error) {
+	if g.mergeOperator == nil {
+		return nil, Error(Code_InvalidArgument, "merge operator not set")
+	}
+	operands := make([][]byte, len(g.operands))
+	for i, operand := range g.operands {
+		operands[len(operands)-1-i] = operand
+	}
+	return g.mergeOperator.FullMerge(stripTimestamp(g.ucmp, g.userKey), base, operands)
+}
@@ -17,9 +17,36 @@ type Iterator interface {
 	Key() []byte
 	// Return the value for the current entry
 	Value() []byte
+	// Return the error hit by the iterator, if any. An iterator which
+	// hit an error becomes invalid, it does not mean the end of the data.
+	Error() error
+	// Release the resources held by the iterator and run the registered
+	// cleanup functions. The iterator must not be used after Close.
+	Close() error
+	// Register fn to be run when the iterator is closed
+	RegisterCleanup(fn func())
 }
 
-type emptyIterator struct{}
+// cleanupList holds the functions registered with RegisterCleanup, it is
+// embedded by the Iterator implementations
+type cleanupList struct {
+	fns []func()
+}
+
+func (c *cleanupList) RegisterCleanup(fn func()) {
+	c.fns = append(c.fns, fn)
+}
+
+func (c *cleanupList) runCleanups() {
+	for _, fn := range c.fns {
+		fn()
+	}
+	c.fns = nil
+}
+
+type emptyIterator struct {
+	cleanupList
+}
 
 func NewEmptyIterator() *emptyIterator {
 	return &emptyIterator{}
@@ -36,8 +63,32 @@ func (i *emptyIterator) Seek(target []byte) {}
 func (i *emptyIterator) Next()              {}
 func (i *emptyIterator) Prev()              {}
 func (i *emptyIterator) Key() []byte {
-	panic("invalid")
+	return nil
 }
 func (i *emptyIterator) Value() []byte {
-	panic("invalid")
+	return nil
+}
+func (i *emptyIterator) Error() error {
+	return nil
+}
+func (i *emptyIterator) Close() error {
+	i.runCleanups()
+	return nil
+}
+
+// errorIterator is an empty iterator carrying the error which prevented
+// the real iterator from being created
+type errorIterator struct {
+	emptyIterator
+	err error
+}
+
+func NewErrorIterator(err error) *errorIterator {
+	return &errorIterator{err: err}
+}
+
+var _ Iterator = (*errorIterator)(nil)
+
+func (i *errorIterator) Error() error {
+	return i.err
 }
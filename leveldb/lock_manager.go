@@ -0,0 +1,167 @@
+package leveldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+// lockManager grants the exclusive locks on the keys of a TransactionDB to
+// transactions, identified by their ids.  The keys are spread over stripes
+// with a mutex each, so that locking the keys of different stripes does not
+// contend.
+//
+// A transaction waiting for a lock adds an edge to the wait-for graph, from
+// itself to the holder of the lock.  The edge that would close a cycle is
+// refused: the transaction gets a Code_Busy error instead of deadlocking.
+// The edges are removed as soon as the lock is released, before the waiter
+// wakes up, so that they never outlive the wait.
+type lockManager struct {
+	stripes []lockStripe
+
+	waitMu sync.Mutex
+	// The edges of the waiting transactions, a transaction waits for at
+	// most one lock at a time
+	waitFor map[uint64]waitEdge
+
+	// If non-nil, called by lock before it waits for a lock, once the
+	// stripe of the key is unlocked, for tests
+	beforeWait func()
+}
+
+// waitEdge is the edge of the wait-for graph of a transaction waiting for
+// the lock of key held by holder.
+type waitEdge struct {
+	holder uint64
+	key    string
+}
+
+type lockStripe struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	txnID    uint64
+	released chan struct{} // Closed once the lock is released
+}
+
+func newLockManager(numStripes int) *lockManager {
+	lm := &lockManager{
+		stripes: make([]lockStripe, numStripes),
+		waitFor: make(map[uint64]waitEdge),
+	}
+	for i := range lm.stripes {
+		lm.stripes[i].locks = make(map[string]*keyLock)
+	}
+	return lm
+}
+
+func (lm *lockManager) stripe(key string) *lockStripe {
+	return &lm.stripes[util.Hash([]byte(key), 0x2c6b9e1f)%uint32(len(lm.stripes))]
+}
+
+// lock acquires the lock of key for the transaction txnID, waiting for up
+// to timeout for its holder to release it, or forever if timeout is
+// negative.  It returns a Code_TimedOut error if the lock is still held by
+// then, and a Code_Busy error if waiting would deadlock.  Locking a key
+// twice is a no-op.
+func (lm *lockManager) lock(txnID uint64, key string, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	s := lm.stripe(key)
+	for {
+		s.mu.Lock()
+		l := s.locks[key]
+		if l == nil {
+			s.locks[key] = &keyLock{txnID: txnID, released: make(chan struct{})}
+			s.mu.Unlock()
+			return nil
+		}
+		if l.txnID == txnID {
+			s.mu.Unlock()
+			return nil
+		}
+		if timeout == 0 {
+			s.mu.Unlock()
+			return Error(Code_TimedOut, "lock held by another transaction")
+		}
+		// The edge is added before the stripe is unlocked, so that the lock
+		// cannot be released in between, leaving the edge behind
+		err := lm.addWait(txnID, l.txnID, key)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if lm.beforeWait != nil {
+			lm.beforeWait()
+		}
+		select {
+		case <-l.released:
+			lm.removeWait(txnID)
+		case <-expired:
+			lm.removeWait(txnID)
+			return Error(Code_TimedOut, "timed out waiting for a lock held by another transaction")
+		}
+	}
+}
+
+// unlock releases the lock of key if the transaction txnID holds it, and
+// wakes up the transactions waiting for it.
+func (lm *lockManager) unlock(txnID uint64, key string) {
+	s := lm.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.locks[key]; l != nil && l.txnID == txnID {
+		delete(s.locks, key)
+		lm.removeWaitsFor(txnID, key)
+		close(l.released)
+	}
+}
+
+// addWait adds the edge from waiter to holder, for the lock of key, to the
+// wait-for graph, unless it closes a cycle, i.e. holder waits for waiter,
+// directly or not.
+func (lm *lockManager) addWait(waiter, holder uint64, key string) error {
+	lm.waitMu.Lock()
+	defer lm.waitMu.Unlock()
+	// The graph has no cycle, so the path from holder is at most as long as
+	// the number of edges
+	id := holder
+	for i := 0; i <= len(lm.waitFor); i++ {
+		if id == waiter {
+			return Error(Code_Busy, "deadlock detected")
+		}
+		next, ok := lm.waitFor[id]
+		if !ok {
+			break
+		}
+		id = next.holder
+	}
+	lm.waitFor[waiter] = waitEdge{holder: holder, key: key}
+	return nil
+}
+
+// removeWait removes the edge of waiter, once it woke up or gave up.
+func (lm *lockManager) removeWait(waiter uint64) {
+	lm.waitMu.Lock()
+	defer lm.waitMu.Unlock()
+	delete(lm.waitFor, waiter)
+}
+
+// removeWaitsFor removes the edges of the transactions waiting for the lock
+// of key held by holder, which is being released.
+func (lm *lockManager) removeWaitsFor(holder uint64, key string) {
+	lm.waitMu.Lock()
+	defer lm.waitMu.Unlock()
+	for waiter, edge := range lm.waitFor {
+		if edge.holder == holder && edge.key == key {
+			delete(lm.waitFor, waiter)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package leveldb
+
+// Log format information shared by reader and writer.
+//
+// The log file contents are a sequence of 32KB blocks.  The only
+// exception is that the tail of the file may contain a partial block.
+//
+// Each block consists of a sequence of records:
+//
+//	block := record* trailer?
+//	record :=
+//	  checksum: uint32     // crc32c of type and data[] ; masked
+//	  length: uint16
+//	  type: uint8          // One of FULL, FIRST, MIDDLE, LAST
+//	  data: uint8[length]
+//
+// A record never starts within the last six bytes of a block (since it
+// won't fit).  Any leftover bytes here form the trailer, which must
+// consist entirely of zero bytes and must be skipped by readers.
+
+type logRecordType byte
+
+const (
+	// Zero is reserved for preallocated files
+	logRecordType_Zero logRecordType = 0
+
+	logRecordType_Full logRecordType = 1
+
+	// For fragments
+	logRecordType_First  logRecordType = 2
+	logRecordType_Middle logRecordType = 3
+	logRecordType_Last   logRecordType = 4
+)
+
+const kMaxRecordType = logRecordType_Last
+
+const kLogBlockSize = 32768
+
+// Header is checksum (4 bytes), length (2 bytes), type (1 byte).
+const kLogHeaderSize = 4 + 2 + 1
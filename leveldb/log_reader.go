@@ -0,0 +1,178 @@
+package leveldb
+
+import (
+	"io"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+// logReader reads records written by logWriter
+type logReader struct {
+	src           io.Reader
+	checksum      bool
+	format        Format // encoding of the record checksums
+	backingStore  []byte
+	buffer        []byte // unread part of backingStore
+	eof           bool   // last read returned fewer than kLogBlockSize bytes
+	droppedBytes  int    // bytes skipped because of corruption
+	lastRecordErr error  // first corruption reported
+}
+
+// NewLogReader creates a reader that will return log records from "src",
+// written in format.  If "checksum" is true, verify checksums if available.
+func NewLogReader(src io.Reader, checksum bool, format Format) *logReader {
+	return &logReader{
+		src:          src,
+		checksum:     checksum,
+		format:       format,
+		backingStore: make([]byte, kLogBlockSize),
+	}
+}
+
+// ReadRecord reads the next record. Returns (record, true) on success,
+// (nil, false) when the end of the input is hit. Corrupted fragments are
+// skipped and reported through Error.
+// The returned record is only valid until the next call of ReadRecord.
+func (r *logReader) ReadRecord() ([]byte, bool) {
+	var scratch []byte
+	inFragmentedRecord := false
+
+	for {
+		fragment, t := r.readPhysicalRecord()
+		switch t {
+		case logRecordType_Full:
+			if inFragmentedRecord && len(scratch) > 0 {
+				r.reportCorruption(len(scratch), "partial record without end(1)")
+			}
+			return fragment, true
+		case logRecordType_First:
+			if inFragmentedRecord && len(scratch) > 0 {
+				r.reportCorruption(len(scratch), "partial record without end(2)")
+			}
+			scratch = append(scratch[:0], fragment...)
+			inFragmentedRecord = true
+		case logRecordType_Middle:
+			if !inFragmentedRecord {
+				r.reportCorruption(len(fragment), "missing start of fragmented record(1)")
+			} else {
+				scratch = append(scratch, fragment...)
+			}
+		case logRecordType_Last:
+			if !inFragmentedRecord {
+				r.reportCorruption(len(fragment), "missing start of fragmented record(2)")
+			} else {
+				return append(scratch, fragment...), true
+			}
+		case logRecordType_Eof:
+			// This can be caused by the writer dying immediately after
+			// writing a physical record but before completing the next; don't
+			// treat it as a corruption, just ignore the entire logical record.
+			return nil, false
+		case logRecordType_Bad:
+			if inFragmentedRecord {
+				r.reportCorruption(len(scratch), "error in middle of record")
+				inFragmentedRecord = false
+				scratch = scratch[:0]
+			}
+		default:
+			r.reportCorruption(len(fragment), "unknown record type")
+			inFragmentedRecord = false
+			scratch = scratch[:0]
+		}
+	}
+}
+
+// Error returns the first corruption found while reading, if any
+func (r *logReader) Error() error {
+	return r.lastRecordErr
+}
+
+// extend record types with the following special values
+const (
+	logRecordType_Eof logRecordType = kMaxRecordType + 1
+	// Returned whenever we find an invalid physical record.
+	// Currently there are two situations in which this happens:
+	// * The record has an invalid CRC
+	// * The record is a 0-length record
+	logRecordType_Bad logRecordType = kMaxRecordType + 2
+)
+
+func (r *logReader) readPhysicalRecord() ([]byte, logRecordType) {
+	for {
+		if len(r.buffer) < kLogHeaderSize {
+			if !r.eof {
+				// Last read was a full read, so this is a trailer to skip
+				n, err := io.ReadFull(r.src, r.backingStore)
+				r.buffer = r.backingStore[:n]
+				if err != nil {
+					if err != io.EOF && err != io.ErrUnexpectedEOF {
+						r.reportDrop(kLogBlockSize, ioError(err))
+					}
+					r.eof = true
+				}
+				continue
+			}
+			// Note that if buffer is non-empty, we have a truncated header at the
+			// end of the file, which can be caused by the writer crashing in the
+			// middle of writing the header. Instead of considering this an error,
+			// just report EOF.
+			r.buffer = nil
+			return nil, logRecordType_Eof
+		}
+
+		// Parse the header
+		header := r.buffer
+		length := int(header[4]) | int(header[5])<<8
+		t := logRecordType(header[6])
+		if kLogHeaderSize+length > len(r.buffer) {
+			dropSize := len(r.buffer)
+			r.buffer = nil
+			if !r.eof {
+				r.reportCorruption(dropSize, "bad record length")
+				return nil, logRecordType_Bad
+			}
+			// If the end of the file has been reached without reading |length| bytes
+			// of payload, assume the writer died in the middle of writing the record.
+			// Don't report a corruption.
+			return nil, logRecordType_Eof
+		}
+
+		if t == logRecordType_Zero && length == 0 {
+			// Skip zero length record without reporting any drops since
+			// such records are produced by writers that preallocate file regions.
+			r.buffer = nil
+			return nil, logRecordType_Bad
+		}
+
+		// Check crc
+		if r.checksum {
+			expectedCrc := util.CRC32CUnmask(r.format.decodeFixed32(header))
+			actualCrc := util.CRC32CValue(header[6 : kLogHeaderSize+length])
+			if actualCrc != expectedCrc {
+				// Drop the rest of the buffer since "length" itself may have
+				// been corrupted and if we trust it, we could find some
+				// fragment of a real log record that just happens to look
+				// like a valid log record.
+				dropSize := len(r.buffer)
+				r.buffer = nil
+				r.reportCorruption(dropSize, "checksum mismatch")
+				return nil, logRecordType_Bad
+			}
+		}
+
+		result := header[kLogHeaderSize : kLogHeaderSize+length]
+		r.buffer = r.buffer[kLogHeaderSize+length:]
+		return result, t
+	}
+}
+
+func (r *logReader) reportCorruption(bytes int, reason string) {
+	r.reportDrop(bytes, Error(Code_Corruption, reason))
+}
+
+func (r *logReader) reportDrop(bytes int, err error) {
+	r.droppedBytes += bytes
+	if r.lastRecordErr == nil {
+		r.lastRecordErr = err
+	}
+}
@@ -0,0 +1,134 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stringDest is an in-memory WritableFile
+type stringDest struct {
+	contents []byte
+}
+
+var _ WritableFile = (*stringDest)(nil)
+
+func (d *stringDest) Append(data []byte) error {
+	d.contents = append(d.contents, data...)
+	return nil
+}
+func (d *stringDest) Close() error { return nil }
+func (d *stringDest) Flush() error { return nil }
+func (d *stringDest) Sync() error  { return nil }
+
+// bigString constructs a string of the specified length made out of the supplied
+// partial string.
+func bigString(partial string, n int) string {
+	var result string
+	for len(result) < n {
+		result += partial
+	}
+	return result[:n]
+}
+
+func readAll(contents []byte) []string {
+	reader := NewLogReader(bytes.NewReader(contents), true, Format_Native)
+	var records []string
+	for {
+		record, ok := reader.ReadRecord()
+		if !ok {
+			break
+		}
+		records = append(records, string(record))
+	}
+	return records
+}
+
+func TestLog_Empty(t *testing.T) {
+	dest := &stringDest{}
+	assert.Empty(t, readAll(dest.contents))
+}
+
+func TestLog_ReadWrite(t *testing.T) {
+	dest := &stringDest{}
+	w := NewLogWriter(dest, Format_Native)
+	for _, record := range []string{"foo", "bar", "", "xxxx"} {
+		assert.NoError(t, w.AddRecord([]byte(record)))
+	}
+	assert.Equal(t, []string{"foo", "bar", "", "xxxx"}, readAll(dest.contents))
+}
+
+func TestLog_Fragmentation(t *testing.T) {
+	dest := &stringDest{}
+	w := NewLogWriter(dest, Format_Native)
+	records := []string{"small", bigString("medium", 50000), bigString("large", 100000)}
+	for _, record := range records {
+		assert.NoError(t, w.AddRecord([]byte(record)))
+	}
+	assert.Equal(t, records, readAll(dest.contents))
+}
+
+func TestLog_MarginalTrailer(t *testing.T) {
+	// Make a trailer that is exactly the same length as an empty record.
+	dest := &stringDest{}
+	w := NewLogWriter(dest, Format_Native)
+	n := kLogBlockSize - 2*kLogHeaderSize
+	assert.NoError(t, w.AddRecord([]byte(bigString("foo", n))))
+	assert.Equal(t, kLogBlockSize-kLogHeaderSize, len(dest.contents))
+	assert.NoError(t, w.AddRecord([]byte("")))
+	assert.NoError(t, w.AddRecord([]byte("bar")))
+	assert.Equal(t, []string{bigString("foo", n), "", "bar"}, readAll(dest.contents))
+}
+
+func TestLog_ChecksumMismatch(t *testing.T) {
+	dest := &stringDest{}
+	w := NewLogWriter(dest, Format_Native)
+	assert.NoError(t, w.AddRecord([]byte("foo")))
+	dest.contents[kLogHeaderSize] ^= 0xff
+
+	reader := NewLogReader(bytes.NewReader(dest.contents), true, Format_Native)
+	_, ok := reader.ReadRecord()
+	assert.False(t, ok)
+	assert.True(t, strings.Contains(fmt.Sprint(reader.Error()), "checksum mismatch"))
+}
+
+func TestLog_LevelDBFormat(t *testing.T) {
+	// testdata/leveldb/log holds records of these sizes, spanning blocks
+	golden, err := os.ReadFile("testdata/leveldb/log")
+	assert.NoError(t, err)
+	var records [][]byte
+	for _, n := range []int{0, 10, 33000, 100} {
+		record := make([]byte, n)
+		for i := range record {
+			record[i] = byte(i*7 + n)
+		}
+		records = append(records, record)
+	}
+
+	dest := &stringDest{}
+	w := NewLogWriter(dest, Format_LevelDB)
+	for _, record := range records {
+		assert.NoError(t, w.AddRecord(record))
+	}
+	assert.Equal(t, golden, dest.contents)
+
+	reader := NewLogReader(bytes.NewReader(golden), true, Format_LevelDB)
+	for _, record := range records {
+		actual, ok := reader.ReadRecord()
+		assert.True(t, ok)
+		assert.Equal(t, record, actual)
+	}
+	_, ok := reader.ReadRecord()
+	assert.False(t, ok)
+	assert.NoError(t, reader.Error())
+
+	// The checksums do not match in the other format
+	reader = NewLogReader(bytes.NewReader(golden), true, Format_Native)
+	_, ok = reader.ReadRecord()
+	assert.False(t, ok)
+	assert.Error(t, reader.Error())
+}
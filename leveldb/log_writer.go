@@ -0,0 +1,120 @@
+package leveldb
+
+import (
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+// logWriter appends records to a log file, it is used for both the
+// write ahead log and the MANIFEST.
+type logWriter struct {
+	dest        WritableFile
+	blockOffset int    // Current offset in block
+	format      Format // encoding of the record checksums
+
+	// crc32c values for all supported record types.  These are
+	// pre-computed to reduce the overhead of computing the crc of the
+	// record type stored in the header.
+	typeCrc [kMaxRecordType + 1]uint32
+}
+
+// NewLogWriter creates a writer that will append data to "dest" in
+// format.  "dest" must be initially empty.
+func NewLogWriter(dest WritableFile, format Format) *logWriter {
+	return NewLogWriterWithLength(dest, 0, format)
+}
+
+// NewLogWriterWithLength creates a writer that will append data to "dest"
+// in format.  "dest" must have initial length "destLength".
+func NewLogWriterWithLength(dest WritableFile, destLength uint64, format Format) *logWriter {
+	w := &logWriter{
+		dest:        dest,
+		blockOffset: int(destLength % kLogBlockSize),
+		format:      format,
+	}
+	for i := range w.typeCrc {
+		w.typeCrc[i] = util.CRC32CValue([]byte{byte(i)})
+	}
+	return w
+}
+
+// AddRecord appends slice as one logical record, fragmenting it across
+// blocks when needed.
+func (w *logWriter) AddRecord(slice []byte) error {
+	ptr := slice
+	left := len(slice)
+
+	// Fragment the record if necessary and emit it.  Note that if slice
+	// is empty, we still want to iterate once to emit a single
+	// zero-length record
+	begin := true
+	for {
+		leftover := kLogBlockSize - w.blockOffset
+		if leftover < 0 {
+			panic("log block leftover < 0")
+		}
+		if leftover < kLogHeaderSize {
+			// Switch to a new block
+			if leftover > 0 {
+				// Fill the trailer
+				if err := w.dest.Append(make([]byte, leftover)); err != nil {
+					return err
+				}
+			}
+			w.blockOffset = 0
+		}
+
+		avail := kLogBlockSize - w.blockOffset - kLogHeaderSize
+		fragmentLength := util.Min(left, avail)
+
+		var t logRecordType
+		end := left == fragmentLength
+		if begin && end {
+			t = logRecordType_Full
+		} else if begin {
+			t = logRecordType_First
+		} else if end {
+			t = logRecordType_Last
+		} else {
+			t = logRecordType_Middle
+		}
+
+		if err := w.emitPhysicalRecord(t, ptr[:fragmentLength]); err != nil {
+			return err
+		}
+		ptr = ptr[fragmentLength:]
+		left -= fragmentLength
+		begin = false
+		if left <= 0 {
+			return nil
+		}
+	}
+}
+
+func (w *logWriter) emitPhysicalRecord(t logRecordType, data []byte) error {
+	length := len(data)
+	if length > 0xffff {
+		panic("log record length > 0xffff")
+	}
+	if w.blockOffset+kLogHeaderSize+length > kLogBlockSize {
+		panic("log record exceeds block size")
+	}
+
+	// Format the header
+	buf := make([]byte, 0, kLogHeaderSize)
+	// Compute the crc of the record type and the payload.
+	crc := util.CRC32CExtend(w.typeCrc[t], data)
+	crc = util.CRC32CMask(crc) // Adjust for storage
+	w.format.putFixed32(&buf, crc)
+	buf = append(buf, byte(length&0xff), byte(length>>8), byte(t))
+
+	// Write the header and the payload
+	err := w.dest.Append(buf)
+	if err == nil {
+		err = w.dest.Append(data)
+		if err == nil {
+			err = w.dest.Flush()
+		}
+	}
+	w.blockOffset += kLogHeaderSize + length
+	return err
+}
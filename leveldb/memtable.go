@@ -1,24 +1,94 @@
 package leveldb
 
-import "github.com/xufeisofly/leveldb-go/util"
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
 
 type MemTable struct {
-	table      skiplist
-	comparator Comparator
+	table *skiplist
+	// The range tombstones, apart from the point entries of table, in the
+	// same entry format
+	rangeDelTable *skiplist
+	arena         *Arena
+	comparator    Comparator // user comparator
+
+	// The range tombstones of rangeDelTable fragmented, for Get to look up
+	// the ones covering a key.  Add rebuilds them under rangeDelMu with
+	// every range tombstone.
+	rangeDelMu sync.Mutex
+	rangeDels  atomic.Pointer[fragmentedRangeTombstones]
+
+	// if true, Add may be called concurrently
+	allowConcurrentWrite bool
 }
 
+// NewMemTable creates a MemTable ordered by the user comparator.
+// Add requires external synchronization.
 func NewMemTable(comparator Comparator) *MemTable {
+	arena := NewArena()
 	return &MemTable{
-		table:      *NewSkiplist(comparator),
-		comparator: comparator,
+		table:         NewSkiplist(newMemTableKeyComparator(comparator), arena),
+		rangeDelTable: NewSkiplist(newMemTableKeyComparator(comparator), arena),
+		arena:         arena,
+		comparator:    comparator,
+	}
+}
+
+// NewConcurrentMemTable creates a MemTable whose Add may be called from
+// several goroutines at once, the entries are spliced into the skiplist
+// with compare-and-swap instead of a writer lock.
+//
+// Callers must assign the sequence numbers of a batch up front and only
+// publish the last sequence of the batch to readers after every entry of
+// it is added, so readers never observe a partially applied batch.
+func NewConcurrentMemTable(comparator Comparator) *MemTable {
+	m := NewMemTable(comparator)
+	m.allowConcurrentWrite = true
+	return m
+}
+
+// ApproximateMemoryUsage returns an estimate of the number of bytes of data in use by this
+// data structure. It is safe to call when MemTable is being modified.
+func (m *MemTable) ApproximateMemoryUsage() uint64 {
+	return m.arena.MemoryUsage()
+}
+
+// Empty returns true if no entry nor range tombstone was added to the
+// memtable.
+func (m *MemTable) Empty() bool {
+	for _, table := range []*skiplist{m.table, m.rangeDelTable} {
+		iter := NewSkiplistIterator(table)
+		iter.SeekToFirst()
+		if iter.Valid() {
+			return false
+		}
 	}
+	return true
 }
 
 func (m *MemTable) NewIterator() Iterator {
-	return NewMemTableIterator(&m.table)
+	return NewMemTableIterator(m.table)
 }
 
-// Add puts key value into memtable
+// NewRangeTombstoneIterator returns an iterator over the range tombstones
+// of the memtable, their start internal keys mapped to their ends.
+func (m *MemTable) NewRangeTombstoneIterator() Iterator {
+	return NewMemTableIterator(m.rangeDelTable)
+}
+
+// rangeTombstones returns a copy of the range tombstones of the memtable,
+// sorted by start.
+func (m *MemTable) rangeTombstones() ([]rangeTombstone, error) {
+	iter := m.NewRangeTombstoneIterator()
+	defer iter.Close()
+	return readRangeTombstones(iter)
+}
+
+// Add puts key value into memtable, range tombstones go apart from the
+// other entries.
 // format of an entry
 // key_size: varint of internal_key.size()
 // key bytes: user key
@@ -32,23 +102,58 @@ func (m *MemTable) Add(seq SequenceNumber, t ValueType, key, value []byte) error
 	val_size_bytes := util.EncodeUvarint(uint64(val_size))
 	tag_bytes := util.EncodeUint64Fixed(PackSequenceAndType(seq, t))
 
-	buf := make([]byte, len(internal_key_size_bytes)+internal_key_size+len(val_size_bytes)+val_size)
+	encodedLen := len(internal_key_size_bytes) + internal_key_size + len(val_size_bytes) + val_size
+	var buf []byte
+	if m.allowConcurrentWrite {
+		buf = m.arena.AllocateConcurrently(encodedLen)
+	} else {
+		buf = m.arena.Allocate(encodedLen)
+	}
 
 	var i int
 	for _, bs := range [][]byte{internal_key_size_bytes, key, tag_bytes, val_size_bytes, value} {
 		i += copy(buf[i:], bs)
 	}
 
-	m.table.Insert(buf)
+	table := m.table
+	if t == ValueType_RangeDeletion {
+		table = m.rangeDelTable
+	}
+	if m.allowConcurrentWrite {
+		table.InsertConcurrently(buf)
+	} else {
+		table.Insert(buf)
+	}
+	if t == ValueType_RangeDeletion {
+		return m.fragmentRangeTombstones()
+	}
+	return nil
+}
+
+// fragmentRangeTombstones rebuilds the fragments of the range tombstones
+// from rangeDelTable, once a tombstone is added.  The rebuilds are
+// serialized, so that the last one sees every tombstone added before.
+func (m *MemTable) fragmentRangeTombstones() error {
+	m.rangeDelMu.Lock()
+	defer m.rangeDelMu.Unlock()
+	tombstones, err := m.rangeTombstones()
+	if err != nil {
+		return err
+	}
+	m.rangeDels.Store(fragmentRangeTombstones(m.comparator, tombstones))
 	return nil
 }
 
-// Get gets value by LookupKey
-func (m *MemTable) Get(key *LookupKey) ([]byte, error) {
+// Get continues the lookup of key in the memtable, it returns true once ctx
+// is done: the memtable contains a value, a deletion or an expired value
+// for key, possibly after merge operands, or the entry of key is deleted by
+// a range tombstone.
+func (m *MemTable) Get(key *LookupKey, ctx *getContext) bool {
+	ctx.addFragmentedRangeTombstones(m.rangeDels.Load())
+
 	memkey := key.MemTableKey()
-	tableIter := NewSkiplistIterator(&m.table)
-	tableIter.Seek(memkey)
-	if tableIter.Valid() {
+	tableIter := NewSkiplistIterator(m.table)
+	for tableIter.Seek(memkey); tableIter.Valid(); tableIter.Next() {
 		// entry format is:
 		// internal key length: varint
 		// userkey: []byte
@@ -57,26 +162,49 @@ func (m *MemTable) Get(key *LookupKey) ([]byte, error) {
 		// value: []byte
 		entry := tableIter.Key()
 		ikey, ikeyLen, ikeyLenSize := util.GetVarLengthPrefixedBytes(entry)
-		ukey := ikey[:len(ikey)-TagSize]
-
-		if m.comparator.Compare(ukey, key.UserKey()) == 0 {
-			tag := util.DecodeUint64Fixed(ikey[len(ikey)-TagSize:])
-			_, t := UnpackSequenceAndType(tag)
-			switch t {
-			case ValueType_Value:
-				val, _, _ := util.GetVarLengthPrefixedBytes(entry[ikeyLenSize+int(ikeyLen):])
-				return val, nil
-			case ValueType_Deletion:
-				return nil, Error(Code_NotFound, "")
-			}
+		val, _, _ := util.GetVarLengthPrefixedBytes(entry[ikeyLenSize+int(ikeyLen):])
+		if !ctx.saveValue(ikey, val) {
+			break
 		}
 	}
+	return ctx.done()
+}
+
+// memTableKeyComparator orders skiplist entries by their length prefixed
+// internal keys
+type memTableKeyComparator struct {
+	comparator *internalKeyComparator
+}
+
+var _ Comparator = (*memTableKeyComparator)(nil)
+
+func newMemTableKeyComparator(c Comparator) *memTableKeyComparator {
+	return &memTableKeyComparator{NewInternalKeyComparator(c)}
+}
+
+func (mc *memTableKeyComparator) Compare(a, b []byte) int8 {
+	// Internal keys are encoded as length-prefixed strings.
+	ka, _, _ := util.GetVarLengthPrefixedBytes(a)
+	kb, _, _ := util.GetVarLengthPrefixedBytes(b)
+	return mc.comparator.Compare(ka, kb)
+}
+
+func (mc *memTableKeyComparator) Name() string {
+	return "leveldb.MemTableKeyComparator"
+}
+
+// memtable keys are never shortened
+func (mc *memTableKeyComparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	return nil
+}
 
-	return nil, Error(Code_NotFound, "")
+func (mc *memTableKeyComparator) FindShortSuccessor(key *[]byte) error {
+	return nil
 }
 
 // memTableIterator
 type memTableIterator struct {
+	cleanupList
 	tableIter *skiplistIterator
 	tmp       []byte
 }
@@ -105,24 +233,36 @@ func (mi *memTableIterator) SeekToLast() {
 
 func (mi *memTableIterator) Seek(target []byte) {
 	// encode target size in front of target data before seeking it in the skiplist
-	mi.Seek(append(util.EncodeUvarint(uint64(len(target))), target...))
+	mi.tmp = append(util.EncodeUvarint(uint64(len(target))), target...)
+	mi.tableIter.Seek(mi.tmp)
 }
 
 func (mi *memTableIterator) Next() {
-	mi.Next()
+	mi.tableIter.Next()
 }
 
 func (mi *memTableIterator) Prev() {
-	mi.Prev()
+	mi.tableIter.Prev()
 }
 
+// Key returns the internal key of the current entry
 func (mi *memTableIterator) Key() []byte {
-	key, _, _ := util.GetVarLengthPrefixedBytes(mi.Key())
+	key, _, _ := util.GetVarLengthPrefixedBytes(mi.tableIter.Key())
 	return key
 }
 
+func (mi *memTableIterator) Error() error {
+	return nil
+}
+
+func (mi *memTableIterator) Close() error {
+	mi.runCleanups()
+	return nil
+}
+
 func (mi *memTableIterator) Value() []byte {
-	key, l, lSize := util.GetVarLengthPrefixedBytes(mi.Key())
-	value, _, _ := util.GetVarLengthPrefixedBytes(key[int(l)+lSize:])
+	entry := mi.tableIter.Key()
+	_, l, lSize := util.GetVarLengthPrefixedBytes(entry)
+	value, _, _ := util.GetVarLengthPrefixedBytes(entry[int(l)+lSize:])
 	return value
 }
@@ -0,0 +1,19 @@
+package leveldb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/leveldb"
+)
+
+func TestMemTable_ApproximateMemoryUsage(t *testing.T) {
+	mem := leveldb.NewMemTable(leveldb.NewBytewiseComparator())
+	usage := mem.ApproximateMemoryUsage()
+
+	value := make([]byte, 100)
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, mem.Add(leveldb.SequenceNumber(i+1), leveldb.ValueType_Value, key(i), value))
+	}
+	assert.Greater(t, mem.ApproximateMemoryUsage(), usage+1000*100)
+}
@@ -0,0 +1,115 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+// MergeOperator combines the operands written by DB.Merge into the value of
+// their key, so that read-modify-write updates such as counters cost a
+// single write.  Operands are kept as ValueType_Merge entries and merged
+// lazily, by reads and by compactions.
+//
+// The operator must be the same for the whole life of a DB, its Name is
+// not checked.
+type MergeOperator interface {
+	// Name returns the name of the operator.
+	Name() string
+
+	// FullMerge returns the value of key made of existingValue, nil if the
+	// key has no value, updated by operands in the order they were written.
+	// An error fails the read or the compaction, it is reported as is.
+	FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge combines leftOperand and the newer rightOperand into a
+	// single operand, false if they cannot be combined without the value
+	// they apply to.  Compactions use it to shrink runs of operands.
+	PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool)
+}
+
+type uint64AddOperator struct{}
+
+var _ MergeOperator = (*uint64AddOperator)(nil)
+
+// NewUint64AddOperator returns a MergeOperator for counters: values and
+// operands are fixed64 integers, the operands are added to the value.  A
+// missing value counts as 0.
+func NewUint64AddOperator() MergeOperator {
+	return &uint64AddOperator{}
+}
+
+func (o *uint64AddOperator) Name() string {
+	return "leveldb.Uint64AddOperator"
+}
+
+func decodeUint64Operand(operand []byte) (uint64, error) {
+	if len(operand) != Uint64Size {
+		return 0, Error(Code_Corruption, "uint64 operand size is not 8 bytes")
+	}
+	return util.DecodeUint64Fixed(operand), nil
+}
+
+func (o *uint64AddOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+	if existingValue != nil {
+		v, err := decodeUint64Operand(existingValue)
+		if err != nil {
+			return nil, err
+		}
+		sum = v
+	}
+	for _, operand := range operands {
+		v, err := decodeUint64Operand(operand)
+		if err != nil {
+			return nil, err
+		}
+		sum += v
+	}
+	return util.EncodeUint64Fixed(sum), nil
+}
+
+func (o *uint64AddOperator) PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool) {
+	left, err := decodeUint64Operand(leftOperand)
+	if err != nil {
+		return nil, false
+	}
+	right, err := decodeUint64Operand(rightOperand)
+	if err != nil {
+		return nil, false
+	}
+	return util.EncodeUint64Fixed(left + right), true
+}
+
+type stringAppendOperator struct {
+	delimiter []byte
+}
+
+var _ MergeOperator = (*stringAppendOperator)(nil)
+
+// NewStringAppendOperator returns a MergeOperator appending the operands to
+// the value, separated by delimiter.
+func NewStringAppendOperator(delimiter []byte) MergeOperator {
+	return &stringAppendOperator{delimiter: append([]byte{}, delimiter...)}
+}
+
+func (o *stringAppendOperator) Name() string {
+	return "leveldb.StringAppendOperator"
+}
+
+func (o *stringAppendOperator) FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, error) {
+	var result []byte
+	if existingValue != nil {
+		result = append(result, existingValue...)
+	}
+	for i, operand := range operands {
+		if existingValue != nil || i > 0 {
+			result = append(result, o.delimiter...)
+		}
+		result = append(result, operand...)
+	}
+	return result, nil
+}
+
+func (o *stringAppendOperator) PartialMerge(key, leftOperand, rightOperand []byte) ([]byte, bool) {
+	result := make([]byte, 0, len(leftOperand)+len(o.delimiter)+len(rightOperand))
+	result = append(result, leftOperand...)
+	result = append(result, o.delimiter...)
+	return append(result, rightOperand...), true
+}
@@ -0,0 +1,192 @@
+package leveldb
+
+type direction int
+
+const (
+	direction_Forward direction = iota
+	direction_Reverse
+)
+
+// mergingIterator yields the union of the data in its children,
+// ordered by comparator
+type mergingIterator struct {
+	cleanupList
+	// We might want to use a heap in case there are lots of children.
+	// For now we use a simple array since we expect a very small number
+	// of children in leveldb.
+	comparator Comparator
+	children   []Iterator
+	current    Iterator
+	direction  direction
+}
+
+var _ Iterator = (*mergingIterator)(nil)
+
+// NewMergingIterator returns an iterator that provided the union of the data in
+// children[0,n-1].  Takes ownership of the child iterators and
+// will delete them when the result iterator is deleted.
+//
+// The result does no duplicate suppression.  I.e., if a particular
+// key is present in K child iterators, it will be yielded K times.
+func NewMergingIterator(comparator Comparator, children []Iterator) Iterator {
+	switch len(children) {
+	case 0:
+		return NewEmptyIterator()
+	case 1:
+		return children[0]
+	default:
+		return &mergingIterator{
+			comparator: comparator,
+			children:   children,
+			direction:  direction_Forward,
+		}
+	}
+}
+
+func (mi *mergingIterator) Valid() bool {
+	return mi.current != nil
+}
+
+func (mi *mergingIterator) SeekToFirst() {
+	for _, child := range mi.children {
+		child.SeekToFirst()
+	}
+	mi.findSmallest()
+	mi.direction = direction_Forward
+}
+
+func (mi *mergingIterator) SeekToLast() {
+	for _, child := range mi.children {
+		child.SeekToLast()
+	}
+	mi.findLargest()
+	mi.direction = direction_Reverse
+}
+
+func (mi *mergingIterator) Seek(target []byte) {
+	for _, child := range mi.children {
+		child.Seek(target)
+	}
+	mi.findSmallest()
+	mi.direction = direction_Forward
+}
+
+func (mi *mergingIterator) Next() {
+	if !mi.Valid() {
+		panic("merging iterator is invalid")
+	}
+
+	// Ensure that all children are positioned after key().
+	// If we are moving in the forward direction, it is already
+	// true for all of the non-current children since current is
+	// the smallest child and key() == current.Key().  Otherwise,
+	// we explicitly position the non-current children.
+	if mi.direction != direction_Forward {
+		key := append([]byte{}, mi.Key()...)
+		for _, child := range mi.children {
+			if child != mi.current {
+				child.Seek(key)
+				if child.Valid() && mi.comparator.Compare(key, child.Key()) == 0 {
+					child.Next()
+				}
+			}
+		}
+		mi.direction = direction_Forward
+	}
+
+	mi.current.Next()
+	mi.findSmallest()
+}
+
+func (mi *mergingIterator) Prev() {
+	if !mi.Valid() {
+		panic("merging iterator is invalid")
+	}
+
+	// Ensure that all children are positioned before key().
+	// If we are moving in the reverse direction, it is already
+	// true for all of the non-current children since current is
+	// the largest child and key() == current.Key().  Otherwise,
+	// we explicitly position the non-current children.
+	if mi.direction != direction_Reverse {
+		key := append([]byte{}, mi.Key()...)
+		for _, child := range mi.children {
+			if child != mi.current {
+				child.Seek(key)
+				if child.Valid() {
+					// Child is at first entry >= key().  Step back one to be < key()
+					child.Prev()
+				} else {
+					// Child has no entries >= key().  Position at last entry.
+					child.SeekToLast()
+				}
+			}
+		}
+		mi.direction = direction_Reverse
+	}
+
+	mi.current.Prev()
+	mi.findLargest()
+}
+
+func (mi *mergingIterator) Key() []byte {
+	if !mi.Valid() {
+		panic("merging iterator is invalid")
+	}
+	return mi.current.Key()
+}
+
+func (mi *mergingIterator) Value() []byte {
+	if !mi.Valid() {
+		panic("merging iterator is invalid")
+	}
+	return mi.current.Value()
+}
+
+// Error returns the first error among the children
+func (mi *mergingIterator) Error() error {
+	for _, child := range mi.children {
+		if err := child.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all the children
+func (mi *mergingIterator) Close() error {
+	var err error
+	for _, child := range mi.children {
+		if cerr := child.Close(); err == nil {
+			err = cerr
+		}
+	}
+	mi.current = nil
+	mi.runCleanups()
+	return err
+}
+
+func (mi *mergingIterator) findSmallest() {
+	var smallest Iterator
+	for _, child := range mi.children {
+		if child.Valid() {
+			if smallest == nil || mi.comparator.Compare(child.Key(), smallest.Key()) < 0 {
+				smallest = child
+			}
+		}
+	}
+	mi.current = smallest
+}
+
+func (mi *mergingIterator) findLargest() {
+	var largest Iterator
+	for i := len(mi.children) - 1; i >= 0; i-- {
+		child := mi.children[i]
+		if child.Valid() {
+			if largest == nil || mi.comparator.Compare(child.Key(), largest.Key()) > 0 {
+				largest = child
+			}
+		}
+	}
+	mi.current = largest
+}
@@ -0,0 +1,56 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMemTableIterator(keys ...string) Iterator {
+	mem := NewMemTable(NewBytewiseComparator())
+	for i, key := range keys {
+		mem.Add(SequenceNumber(i+1), ValueType_Value, []byte(key), []byte(key))
+	}
+	return mem.NewIterator()
+}
+
+func TestMergingIterator_Merge(t *testing.T) {
+	icmp := NewInternalKeyComparator(NewBytewiseComparator())
+	iter := NewMergingIterator(icmp, []Iterator{
+		newTestMemTableIterator("a", "c", "e"),
+		newTestMemTableIterator("b", "d"),
+	})
+
+	var keys []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(ExtractUserKey(iter.Key())))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+
+	keys = nil
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		keys = append(keys, string(ExtractUserKey(iter.Key())))
+	}
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, keys)
+	assert.NoError(t, iter.Error())
+	assert.NoError(t, iter.Close())
+}
+
+func TestMergingIterator_ErrorAndClose(t *testing.T) {
+	icmp := NewInternalKeyComparator(NewBytewiseComparator())
+	corruption := Error(Code_Corruption, "bad child")
+	var closed []string
+	good := newTestMemTableIterator("a", "b")
+	good.RegisterCleanup(func() { closed = append(closed, "good") })
+	bad := NewErrorIterator(corruption)
+	bad.RegisterCleanup(func() { closed = append(closed, "bad") })
+
+	iter := NewMergingIterator(icmp, []Iterator{good, bad})
+	iter.RegisterCleanup(func() { closed = append(closed, "merger") })
+	iter.SeekToFirst()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, corruption, iter.Error())
+
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, []string{"good", "bad", "merger"}, closed)
+}
@@ -0,0 +1,173 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+// Txn is an optimistic transaction: it reads the DB as of the snapshot
+// taken when it began and buffers its writes in a WriteBatch, without
+// locking.  The keys it writes or reads with GetForUpdate are tracked, and
+// Commit fails with a Code_Busy error if another write to one of them was
+// applied since the snapshot.  The transaction may then be retried.
+//
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db       *DB
+	snapshot *Snapshot
+	batch    *WriteBatch
+	// The tracked keys by column family
+	tracked map[*columnFamilyData]map[string]struct{}
+	// The values of the keys written by the transaction by column family,
+	// nil for the deleted ones, so that it reads its own writes
+	writes map[*columnFamilyData]map[string][]byte
+	done   bool
+}
+
+// BeginOptimisticTransaction begins a transaction reading the current state
+// of the DB, see Txn.  It must end with Commit or Rollback.
+func (db *DB) BeginOptimisticTransaction() *Txn {
+	return &Txn{
+		db:       db,
+		snapshot: db.GetSnapshot(),
+		batch:    NewWriteBatch(),
+		tracked:  make(map[*columnFamilyData]map[string]struct{}),
+		writes:   make(map[*columnFamilyData]map[string][]byte),
+	}
+}
+
+// Get returns the value for "key" written by the transaction, or else the
+// one of the DB as of the snapshot of the transaction.  The key is not
+// tracked.
+func (txn *Txn) Get(options *ReadOptions, key []byte) ([]byte, error) {
+	return txn.GetCF(options, txn.db.defaultColumnFamily, key)
+}
+
+// GetCF is Get in the column family cf.
+func (txn *Txn) GetCF(options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	if txn.done {
+		return nil, errTxnDone
+	}
+	if value, ok := txn.writes[cf.cfd][string(key)]; ok {
+		if value == nil {
+			return nil, Error(Code_NotFound, "")
+		}
+		return append([]byte{}, value...), nil
+	}
+	snapshotOptions := *options
+	snapshotOptions.Snapshot = txn.snapshot
+	return txn.db.GetCF(&snapshotOptions, cf, key)
+}
+
+// GetForUpdate is Get, and tracks "key" so that the commit fails if another
+// write to it is applied meanwhile.
+func (txn *Txn) GetForUpdate(options *ReadOptions, key []byte) ([]byte, error) {
+	return txn.GetForUpdateCF(options, txn.db.defaultColumnFamily, key)
+}
+
+// GetForUpdateCF is GetForUpdate in the column family cf.
+func (txn *Txn) GetForUpdateCF(options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	if txn.done {
+		return nil, errTxnDone
+	}
+	txn.track(cf.cfd, key)
+	return txn.GetCF(options, cf, key)
+}
+
+// Put sets the entry for "key" to "value" once the transaction commits.
+func (txn *Txn) Put(key, value []byte) error {
+	return txn.PutCF(txn.db.defaultColumnFamily, key, value)
+}
+
+// PutCF is Put in the column family cf.
+func (txn *Txn) PutCF(cf *ColumnFamilyHandle, key, value []byte) error {
+	if txn.done {
+		return errTxnDone
+	}
+	txn.track(cf.cfd, key)
+	txn.batch.PutCF(cf, key, value)
+	txn.setWrite(cf.cfd, key, append([]byte{}, value...))
+	return nil
+}
+
+// Delete removes the entry for "key" once the transaction commits.
+func (txn *Txn) Delete(key []byte) error {
+	return txn.DeleteCF(txn.db.defaultColumnFamily, key)
+}
+
+// DeleteCF is Delete in the column family cf.
+func (txn *Txn) DeleteCF(cf *ColumnFamilyHandle, key []byte) error {
+	if txn.done {
+		return errTxnDone
+	}
+	txn.track(cf.cfd, key)
+	txn.batch.DeleteCF(cf, key)
+	txn.setWrite(cf.cfd, key, nil)
+	return nil
+}
+
+// Commit applies the writes of the transaction atomically, unless one of
+// its tracked keys was written since the snapshot of the transaction, in
+// which case nothing is written and a Code_Busy error is returned.  The
+// transaction is over either way.
+func (txn *Txn) Commit(options *WriteOptions) error {
+	if txn.done {
+		return errTxnDone
+	}
+	defer txn.end()
+	// The check runs at the front of the write queue, so no write can slip
+	// in between the check and the batch
+	return txn.db.write(options, txn.batch, txn.checkConflicts)
+}
+
+// Rollback discards the writes of the transaction, which is over.
+func (txn *Txn) Rollback() error {
+	if txn.done {
+		return errTxnDone
+	}
+	txn.end()
+	return nil
+}
+
+var errTxnDone = Error(Code_InvalidArgument, "transaction already committed or rolled back")
+
+func (txn *Txn) track(cfd *columnFamilyData, key []byte) {
+	keys := txn.tracked[cfd]
+	if keys == nil {
+		keys = make(map[string]struct{})
+		txn.tracked[cfd] = keys
+	}
+	keys[string(key)] = struct{}{}
+}
+
+func (txn *Txn) setWrite(cfd *columnFamilyData, key, value []byte) {
+	values := txn.writes[cfd]
+	if values == nil {
+		values = make(map[string][]byte)
+		txn.writes[cfd] = values
+	}
+	values[string(key)] = value
+}
+
+// checkConflicts returns a Code_Busy error if an entry of a tracked key is
+// newer than the snapshot of the transaction.
+// REQUIRES: the writes to the DB are blocked
+func (txn *Txn) checkConflicts() error {
+	for cfd, keys := range txn.tracked {
+		for key := range keys {
+			seq, err := txn.db.latestSequence(cfd, []byte(key))
+			if err != nil {
+				return err
+			}
+			if seq > txn.snapshot.sequence {
+				return Error(Code_Busy, "write conflict on key "+util.EscapeString([]byte(key)))
+			}
+		}
+	}
+	return nil
+}
+
+func (txn *Txn) end() {
+	txn.done = true
+	txn.db.ReleaseSnapshot(txn.snapshot)
+	txn.batch.Clear()
+	txn.tracked = nil
+	txn.writes = nil
+}
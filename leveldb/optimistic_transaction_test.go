@@ -0,0 +1,175 @@
+package leveldb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func txnGet(t *testing.T, txn *Txn, key string) string {
+	value, err := txn.Get(DefaultReadOptions, []byte(key))
+	if err != nil {
+		assert.True(t, err.(*LevelError).IsNotFound())
+		return "NOT_FOUND"
+	}
+	return string(value)
+}
+
+func dbGet(t *testing.T, db *DB, key string) string {
+	value, err := db.Get(DefaultReadOptions, []byte(key))
+	if err != nil {
+		assert.True(t, err.(*LevelError).IsNotFound())
+		return "NOT_FOUND"
+	}
+	return string(value)
+}
+
+func TestTxn_Commit(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v1")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("b"), []byte("v1")))
+
+	txn := db.BeginOptimisticTransaction()
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v2")))
+	assert.NoError(t, txn.Delete([]byte("b")))
+	assert.NoError(t, txn.Put([]byte("c"), []byte("v2")))
+
+	// The transaction reads its own writes, the DB does not see them yet
+	assert.Equal(t, "v2", txnGet(t, txn, "a"))
+	assert.Equal(t, "NOT_FOUND", txnGet(t, txn, "b"))
+	assert.Equal(t, "v2", txnGet(t, txn, "c"))
+	assert.Equal(t, "v1", dbGet(t, db, "a"))
+	assert.Equal(t, "v1", dbGet(t, db, "b"))
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "c"))
+
+	assert.NoError(t, txn.Commit(DefaultWriteOptions))
+	assert.Equal(t, "v2", dbGet(t, db, "a"))
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "b"))
+	assert.Equal(t, "v2", dbGet(t, db, "c"))
+	assert.True(t, db.snapshots.empty())
+}
+
+func TestTxn_SnapshotIsolation(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v1")))
+
+	txn := db.BeginOptimisticTransaction()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v2")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("b"), []byte("v2")))
+	assert.Equal(t, "v1", txnGet(t, txn, "a"))
+	assert.Equal(t, "NOT_FOUND", txnGet(t, txn, "b"))
+
+	// Keys read with Get are not tracked
+	assert.NoError(t, txn.Put([]byte("c"), []byte("v3")))
+	assert.NoError(t, txn.Commit(DefaultWriteOptions))
+	assert.Equal(t, "v2", dbGet(t, db, "a"))
+	assert.Equal(t, "v3", dbGet(t, db, "c"))
+}
+
+func TestTxn_Conflicts(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v1")))
+
+	// A key read for update and written meanwhile
+	txn := db.BeginOptimisticTransaction()
+	value, err := txn.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(value))
+	assert.NoError(t, txn.Put([]byte("b"), []byte("txn")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v2")))
+	err = txn.Commit(DefaultWriteOptions)
+	assert.True(t, err.(*LevelError).IsBusy())
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "b"))
+
+	// A key written by two transactions, the first commit wins
+	txn1 := db.BeginOptimisticTransaction()
+	txn2 := db.BeginOptimisticTransaction()
+	assert.NoError(t, txn1.Put([]byte("a"), []byte("txn1")))
+	assert.NoError(t, txn2.Put([]byte("a"), []byte("txn2")))
+	assert.NoError(t, txn1.Commit(DefaultWriteOptions))
+	err = txn2.Commit(DefaultWriteOptions)
+	assert.True(t, err.(*LevelError).IsBusy())
+	assert.Equal(t, "txn1", dbGet(t, db, "a"))
+
+	// A key deleted meanwhile
+	txn = db.BeginOptimisticTransaction()
+	_, err = txn.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Delete(DefaultWriteOptions, []byte("a")))
+	err = txn.Commit(DefaultWriteOptions)
+	assert.True(t, err.(*LevelError).IsBusy())
+
+	// A key written meanwhile, then flushed and compacted to a table
+	txn = db.BeginOptimisticTransaction()
+	_, err = txn.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("a"), []byte("v3")))
+	assert.NoError(t, db.Write(DefaultWriteOptions, nil))
+	waitForCompaction(db)
+	assert.True(t, db.versions.defaultColumnFamily.mem.Empty())
+	err = txn.Commit(DefaultWriteOptions)
+	assert.True(t, err.(*LevelError).IsBusy())
+	assert.Equal(t, "v3", dbGet(t, db, "a"))
+
+	// Writes of other keys do not conflict
+	txn = db.BeginOptimisticTransaction()
+	_, err = txn.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v4")))
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("b"), []byte("v4")))
+	assert.NoError(t, txn.Commit(DefaultWriteOptions))
+	assert.Equal(t, "v4", dbGet(t, db, "a"))
+	assert.True(t, db.snapshots.empty())
+}
+
+func TestTxn_Rollback(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+
+	txn := db.BeginOptimisticTransaction()
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v1")))
+	assert.NoError(t, txn.Rollback())
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "a"))
+	assert.True(t, db.snapshots.empty())
+
+	// The transaction is over
+	assert.True(t, txn.Put([]byte("a"), []byte("v2")).(*LevelError).IsInvalidArgument())
+	_, err := txn.Get(DefaultReadOptions, []byte("a"))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+	assert.True(t, txn.Commit(DefaultWriteOptions).(*LevelError).IsInvalidArgument())
+	assert.True(t, txn.Rollback().(*LevelError).IsInvalidArgument())
+}
+
+func TestTxn_ConcurrentIncrements(t *testing.T) {
+	db := openTestDB(t, newTestOptions())
+	defer db.Close()
+	assert.NoError(t, db.Put(DefaultWriteOptions, []byte("counter"), []byte("0")))
+
+	const numThreads, numIncrements = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numIncrements; {
+				txn := db.BeginOptimisticTransaction()
+				value, err := txn.GetForUpdate(DefaultReadOptions, []byte("counter"))
+				assert.NoError(t, err)
+				n, _ := strconv.Atoi(string(value))
+				assert.NoError(t, txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1))))
+				if err := txn.Commit(DefaultWriteOptions); err != nil {
+					assert.True(t, err.(*LevelError).IsBusy())
+					continue
+				}
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, strconv.Itoa(numThreads*numIncrements), dbGet(t, db, "counter"))
+}
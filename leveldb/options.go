@@ -1,5 +1,7 @@
 package leveldb
 
+import "time"
+
 type Env struct{}
 type Logger struct{}
 type BlockCache struct{}
@@ -91,6 +93,75 @@ type Options struct {
 	// Many applications will benefit from passing the result of
 	// NewBloomFilterPolicy() here.
 	FilterPolicy FilterPolicy
+	// Encoding of the files written by the DB: the logs, the MANIFEST and
+	// the tables.  Format_LevelDB reads and writes the databases of the C++
+	// LevelDB library, whose default snappy compressed tables are readable
+	// as well.  Tables are read in the encoding they were written in.
+	//
+	// Default: Format_Native
+	Format Format
+	// If non-nil, the prefixes it extracts from the user keys are added to
+	// the filters along with the keys, so that iterators in prefix seek mode
+	// (see ReadOptions.PrefixSameAsStart) can skip the tables holding no key
+	// with the sought prefix.  Has no effect without a FilterPolicy.
+	PrefixExtractor SliceTransform
+	// Layout of the filters of the tables, see FilterType.  Tables written
+	// with another layout remain readable.
+	//
+	// Default: FilterType_Block
+	FilterType FilterType
+	// Partition size of FilterType_Partitioned: an index partition and its
+	// filter partition are cut at the first data block boundary once the
+	// estimated size of the index partition reaches MetadataBlockSize
+	// bytes.
+	//
+	// Default: 4K
+	MetadataBlockSize int
+	// With DataBlockIndexType_BinarySearchAndHash the data blocks carry a
+	// hash index of their user keys, which point lookups use to skip the
+	// binary search of the restart array.  Blocks of more than 253 restart
+	// intervals are built without it.  Blocks with the index are not
+	// readable by older versions nor by the C++ library.
+	//
+	// Default: DataBlockIndexType_BinarySearch
+	DataBlockIndexType DataBlockIndexType
+	// Number of keys per bucket of the data block hash index, lower ratios
+	// mean fewer collisions for more space.
+	//
+	// Default: 0.75
+	DataBlockHashTableUtilRatio float64
+	// Combines the operands of DB.Merge into the values of their keys,
+	// required to write merges.
+	//
+	// Default: nil
+	MergeOperator MergeOperator
+	// If non-nil, called by compactions to drop or rewrite entries, see
+	// CompactionFilter.
+	//
+	// Default: nil
+	CompactionFilter CompactionFilter
+	// With a TimestampedComparator, the initial full history low, see
+	// DB.IncreaseFullHistoryTsLow.
+	//
+	// Default: nil, the whole history is kept
+	FullHistoryTsLow []byte
+	// If true, writers insert their WriteBatch into the memtable in parallel
+	// instead of serializing on the writer lock.  Each batch has its sequence
+	// numbers assigned up front and readers only observe it after all of its
+	// entries are inserted.
+	//
+	// Default: false
+	AllowConcurrentMemtableWrite bool
+	// Soft limit on the number of level-0 files.  Once it is reached each
+	// write is delayed by 1ms to let the compaction catch up.
+	//
+	// Default: 8
+	Level0SlowdownWritesTrigger int
+	// Hard limit on the number of level-0 files.  Writes are blocked until
+	// a compaction brings the number of level-0 files below it.
+	//
+	// Default: 12
+	Level0StopWritesTrigger int
 }
 
 var DefaultOptions = &Options{
@@ -100,3 +171,103 @@ var DefaultOptions = &Options{
 	ParanoidChecks:  false,
 	Env:             &Env{},
 }
+
+// Options that control read operations
+type ReadOptions struct {
+	// If true, all data read from underlying storage will be
+	// verified against corresponding checksums.
+	VerifyChecksums bool
+	// Should the data read for this iteration be cached in memory?
+	// Callers may wish to set this field to false for bulk scans.
+	FillCache bool
+	// If non-nil, iterators do not yield keys before LowerBound (inclusive),
+	// Seek and SeekToFirst are clamped to it.  Blocks and tables whose keys
+	// are all before it are not read.
+	LowerBound []byte
+	// If non-nil, iterators do not yield keys at or after UpperBound
+	// (exclusive), SeekToLast positions at the last key before it.  Blocks
+	// and tables whose keys are all at or after it are not read.
+	UpperBound []byte
+	// If true and Options.PrefixExtractor is set, an iterator positioned by
+	// Seek only yields the keys sharing the prefix of the Seek target and
+	// skips the tables whose filters rule that prefix out.  Next and Prev
+	// stop once the keys leave the prefix.  SeekToFirst and SeekToLast are
+	// not restricted.
+	PrefixSameAsStart bool
+	// With a TimestampedComparator, reads see the versions of the keys as of
+	// Timestamp: the newest one with a timestamp <= Timestamp.  Keys are
+	// given and yielded without timestamp, iterator bounds as well.  Reads
+	// as of timestamps below the full history low are rejected, see
+	// DB.IncreaseFullHistoryTsLow.
+	//
+	// Default: nil, the latest versions
+	Timestamp []byte
+	// If non-nil, reads see the DB as of Snapshot, which must not have been
+	// released, see DB.GetSnapshot.
+	//
+	// Default: nil, the latest state of the DB
+	Snapshot *Snapshot
+}
+
+var DefaultReadOptions = &ReadOptions{
+	VerifyChecksums: false,
+	FillCache:       true,
+}
+
+// Options that control write operations
+type WriteOptions struct {
+	// If true, the write will be flushed from the operating system
+	// buffer cache (by calling WritableFile::Sync()) before the write
+	// is considered complete.  If this flag is true, writes will be
+	// slower.
+	//
+	// If this flag is false, and the machine crashes, some recent
+	// writes may be lost.  Note that if it is just the process that
+	// crashes (i.e., the machine does not reboot), no writes will be
+	// lost even if sync==false.
+	//
+	// In other words, a DB write with sync==false has similar
+	// crash semantics as the "write()" system call.  A DB write
+	// with sync==true has similar crash semantics to a "write()"
+	// system call followed by "fsync()".
+	Sync bool
+	// With a TimestampedComparator, the timestamp appended to the keys
+	// written, required.  The timestamps of the writes of a key must not
+	// decrease.
+	Timestamp []byte
+}
+
+var DefaultWriteOptions = &WriteOptions{
+	Sync: false,
+}
+
+// Options of a TransactionDB
+type TransactionDBOptions struct {
+	// Number of stripes the locks of the keys are spread over, more stripes
+	// contend less when many transactions lock keys at once.
+	//
+	// Default: 16
+	NumStripes int
+	// How long a transaction waits for the lock of a key held by another
+	// one before failing with a Code_TimedOut error, unless its
+	// TransactionOptions.LockTimeout is set.  Zero fails without waiting,
+	// a negative timeout waits forever.
+	LockTimeout time.Duration
+}
+
+var DefaultTransactionDBOptions = &TransactionDBOptions{
+	NumStripes:  16,
+	LockTimeout: time.Second,
+}
+
+// Options of a transaction of a TransactionDB
+type TransactionOptions struct {
+	// How long the transaction waits for the lock of a key held by another
+	// one, see TransactionDBOptions.LockTimeout.  Zero fails without
+	// waiting, a negative timeout waits forever.
+	//
+	// Default: nil, the TransactionDBOptions.LockTimeout
+	LockTimeout *time.Duration
+}
+
+var DefaultTransactionOptions = &TransactionOptions{}
@@ -0,0 +1,169 @@
+package leveldb
+
+import "sort"
+
+// rangeTombstone deletes the user keys in [start, end) written before seq.
+// It is stored as the internal key (start, seq, ValueType_RangeDeletion)
+// mapped to end, in the range deletion skiplist of the memtables and in the
+// range deletion block of the tables.
+type rangeTombstone struct {
+	start []byte
+	end   []byte
+	seq   SequenceNumber
+}
+
+func (t *rangeTombstone) internalKey() []byte {
+	return DumpInternalKey(NewParsedInternalKey(t.start, t.seq, ValueType_RangeDeletion))
+}
+
+// extendBoundsToRangeTombstone widens the [smallest, largest] internal key
+// range of a table, nil when empty, to the range tombstone t it holds.  The
+// end of t is exclusive so the range ends before all the entries of the end
+// key.
+func extendBoundsToRangeTombstone(icmp Comparator, smallest, largest *[]byte, t *rangeTombstone) {
+	start := t.internalKey()
+	if *smallest == nil || icmp.Compare(start, *smallest) < 0 {
+		*smallest = start
+	}
+	end := DumpInternalKey(NewParsedInternalKey(t.end, KMaxSequenceNumber, ValueType_RangeDeletion))
+	if *largest == nil || icmp.Compare(end, *largest) > 0 {
+		*largest = end
+	}
+}
+
+// readRangeTombstones returns a copy of the range tombstones yielded by
+// iter, in the order of their internal keys.
+func readRangeTombstones(iter Iterator) ([]rangeTombstone, error) {
+	var tombstones []rangeTombstone
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, err := ParseInternalKey(iter.Key())
+		if err != nil || ikey.Type != ValueType_RangeDeletion {
+			return nil, Error(Code_Corruption, "bad range tombstone")
+		}
+		tombstones = append(tombstones, rangeTombstone{
+			start: append([]byte{}, ikey.UserKey...),
+			end:   append([]byte{}, iter.Value()...),
+			seq:   ikey.Sequence,
+		})
+	}
+	return tombstones, iter.Error()
+}
+
+// maxCoveringSeq returns the sequence number of the newest of tombstones,
+// sorted by start, that deletes userKey as of snapshot, or 0 if none does.
+func maxCoveringSeq(ucmp Comparator, tombstones []rangeTombstone, userKey []byte, snapshot SequenceNumber) SequenceNumber {
+	var seq SequenceNumber
+	for i := range tombstones {
+		t := &tombstones[i]
+		if ucmp.Compare(t.start, userKey) > 0 {
+			break
+		}
+		if t.seq <= snapshot && t.seq > seq && ucmp.Compare(userKey, t.end) < 0 {
+			seq = t.seq
+		}
+	}
+	return seq
+}
+
+// rangeTombstoneFragment is a range of user keys deleted by the same set of
+// range tombstones.
+type rangeTombstoneFragment struct {
+	start []byte
+	end   []byte
+	seqs  []SequenceNumber // Decreasing
+}
+
+// fragmentedRangeTombstones is a set of range tombstones cut at the bounds
+// of each other into non overlapping fragments, sorted by start.  A nil
+// set holds no tombstone.
+type fragmentedRangeTombstones struct {
+	ucmp      Comparator
+	fragments []rangeTombstoneFragment
+}
+
+// fragmentRangeTombstones returns the fragments of tombstones, nil if there
+// are none.  Empty tombstones, whose start is not before their end, are
+// ignored.
+func fragmentRangeTombstones(ucmp Comparator, tombstones []rangeTombstone) *fragmentedRangeTombstones {
+	var sorted []rangeTombstone
+	var bounds [][]byte
+	for _, t := range tombstones {
+		if ucmp.Compare(t.start, t.end) < 0 {
+			sorted = append(sorted, t)
+			bounds = append(bounds, t.start, t.end)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.Slice(sorted, func(i, j int) bool { return ucmp.Compare(sorted[i].start, sorted[j].start) < 0 })
+	sort.Slice(bounds, func(i, j int) bool { return ucmp.Compare(bounds[i], bounds[j]) < 0 })
+
+	f := &fragmentedRangeTombstones{ucmp: ucmp}
+	var active []rangeTombstone // The tombstones covering the current fragment
+	next := 0
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if ucmp.Compare(start, end) == 0 {
+			continue
+		}
+		for next < len(sorted) && ucmp.Compare(sorted[next].start, start) <= 0 {
+			active = append(active, sorted[next])
+			next++
+		}
+		n := 0
+		for _, t := range active {
+			// Every bound starts a fragment, so the tombstone covers
+			// either none or all of [start, end)
+			if ucmp.Compare(t.end, start) > 0 {
+				active[n] = t
+				n++
+			}
+		}
+		active = active[:n]
+		if n == 0 {
+			continue
+		}
+
+		seqs := make([]SequenceNumber, 0, n)
+		for _, t := range active {
+			seqs = append(seqs, t.seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] > seqs[j] })
+		m := 1
+		for _, seq := range seqs[1:] {
+			if seq != seqs[m-1] {
+				seqs[m] = seq
+				m++
+			}
+		}
+		f.fragments = append(f.fragments, rangeTombstoneFragment{start: start, end: end, seqs: seqs[:m]})
+	}
+	return f
+}
+
+// maxCoveringSeq returns the sequence number of the newest tombstone that
+// deletes userKey as of snapshot, or 0 if none does.
+func (f *fragmentedRangeTombstones) maxCoveringSeq(userKey []byte, snapshot SequenceNumber) SequenceNumber {
+	if f == nil {
+		return 0
+	}
+	i := sort.Search(len(f.fragments), func(i int) bool {
+		return f.ucmp.Compare(f.fragments[i].end, userKey) > 0
+	})
+	if i == len(f.fragments) || f.ucmp.Compare(f.fragments[i].start, userKey) > 0 {
+		return 0
+	}
+	for _, seq := range f.fragments[i].seqs {
+		if seq <= snapshot {
+			return seq
+		}
+	}
+	return 0
+}
+
+// covers returns true if the entry of userKey written at seq is deleted by
+// a tombstone as of snapshot.
+func (f *fragmentedRangeTombstones) covers(userKey []byte, seq, snapshot SequenceNumber) bool {
+	return seq < f.maxCoveringSeq(userKey, snapshot)
+}
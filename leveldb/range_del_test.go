@@ -0,0 +1,69 @@
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFragmentRangeTombstones(t *testing.T) {
+	tombstone := func(start, end string, seq SequenceNumber) rangeTombstone {
+		return rangeTombstone{start: []byte(start), end: []byte(end), seq: seq}
+	}
+	f := fragmentRangeTombstones(NewBytewiseComparator(), []rangeTombstone{
+		tombstone("c", "g", 7),
+		tombstone("a", "e", 5),
+		tombstone("c", "g", 7),
+		tombstone("x", "x", 9), // Empty
+	})
+
+	var got []string
+	for _, fragment := range f.fragments {
+		got = append(got, string(fragment.start)+"-"+string(fragment.end)+fmt.Sprint(fragment.seqs))
+	}
+	assert.Equal(t, []string{"a-c[5]", "c-e[7 5]", "e-g[7]"}, got)
+
+	assert.Equal(t, SequenceNumber(5), f.maxCoveringSeq([]byte("b"), KMaxSequenceNumber))
+	assert.Equal(t, SequenceNumber(7), f.maxCoveringSeq([]byte("c"), KMaxSequenceNumber))
+	assert.Equal(t, SequenceNumber(5), f.maxCoveringSeq([]byte("d"), 6))
+	assert.Equal(t, SequenceNumber(0), f.maxCoveringSeq([]byte("f"), 6))
+	assert.Equal(t, SequenceNumber(0), f.maxCoveringSeq([]byte("g"), KMaxSequenceNumber))
+	assert.Equal(t, SequenceNumber(0), f.maxCoveringSeq([]byte("x"), KMaxSequenceNumber))
+	assert.True(t, f.covers([]byte("d"), 6, KMaxSequenceNumber))
+	assert.False(t, f.covers([]byte("d"), 7, KMaxSequenceNumber))
+
+	assert.Nil(t, fragmentRangeTombstones(NewBytewiseComparator(), nil))
+	var none *fragmentedRangeTombstones
+	assert.False(t, none.covers([]byte("d"), 0, KMaxSequenceNumber))
+}
+
+func TestMemTable_RangeTombstones(t *testing.T) {
+	mem := NewMemTable(NewBytewiseComparator())
+	get := func(key string, snapshot SequenceNumber) saverState {
+		ctx := newGetContext(mem.comparator, nil, []byte(key), snapshot, 0)
+		mem.Get(NewLookupKey([]byte(key), snapshot), ctx)
+		return ctx.state
+	}
+	assert.NoError(t, mem.Add(1, ValueType_Value, []byte("b"), []byte("v")))
+	assert.NoError(t, mem.Add(2, ValueType_Value, []byte("d"), []byte("v")))
+	assert.Nil(t, mem.rangeDels.Load())
+	assert.Equal(t, saverState_Found, get("b", 10))
+
+	// The fragments are rebuilt with every tombstone
+	assert.NoError(t, mem.Add(3, ValueType_RangeDeletion, []byte("a"), []byte("c")))
+	assert.Len(t, mem.rangeDels.Load().fragments, 1)
+	assert.Equal(t, saverState_Deleted, get("b", 10))
+	assert.Equal(t, saverState_Found, get("b", 2))
+	assert.Equal(t, saverState_Found, get("d", 10))
+	assert.NoError(t, mem.Add(4, ValueType_RangeDeletion, []byte("c"), []byte("e")))
+	assert.Len(t, mem.rangeDels.Load().fragments, 2)
+	assert.Equal(t, saverState_Deleted, get("d", 10))
+	assert.Equal(t, saverState_Found, get("d", 3))
+
+	// Point entries leave them as they are
+	f := mem.rangeDels.Load()
+	assert.NoError(t, mem.Add(5, ValueType_Value, []byte("b"), []byte("v")))
+	assert.Same(t, f, mem.rangeDels.Load())
+	assert.Equal(t, saverState_Found, get("b", 10))
+}
@@ -0,0 +1,91 @@
+package leveldb_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/leveldb"
+)
+
+func TestRibbon_EmptyFilter(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewRibbonFilterPolicy(10))
+	assert.True(t, !b.Matches([]byte("hello")))
+	assert.True(t, !b.Matches([]byte("world")))
+}
+
+func TestRibbon_Small(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewRibbonFilterPolicy(10))
+	b.Add([]byte("hello"))
+	b.Add([]byte("world"))
+	assert.True(t, b.Matches([]byte("hello")))
+	assert.True(t, b.Matches([]byte("world")))
+	assert.True(t, !b.Matches([]byte("x")))
+	assert.True(t, !b.Matches([]byte("foo")))
+}
+
+func TestRibbon_UnknownVersion(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewRibbonFilterPolicy(10))
+	b.Add([]byte("hello"))
+	b.Build()
+	b.filter[len(b.filter)-1]++
+	assert.True(t, b.Matches([]byte("x")))
+}
+
+func TestRibbon_VaryingLengths(t *testing.T) {
+	b := newBloomTestWithPolicy(leveldb.NewRibbonFilterPolicy(10))
+	bloom := newBloomTestWithPolicy(leveldb.NewBloomFilterPolicy(10))
+
+	// Sum of the false positive rates of the large filters
+	var rates, bloomRates float64
+	for length := 1; length < 10000; length = nextLength(length) {
+		b.Reset()
+		bloom.Reset()
+		for i := 0; i < length; i++ {
+			b.Add(key(i))
+			bloom.Add(key(i))
+		}
+		b.Build()
+		bloom.Build()
+
+		// All added keys must match
+		for i := 0; i < length; i++ {
+			assert.True(t, b.Matches(key(i)), fmt.Sprintf("Length %d; key %d", length, i))
+		}
+
+		// Check false positive rate
+		rate := b.FalsePositiveRate()
+		bloomRate := bloom.FalsePositiveRate()
+		if kVerbose >= 1 {
+			fmt.Fprintf(os.Stderr, "False positives: %5.2f%% (bloom %5.2f%%) @ length = %6d ; bytes = %6d (bloom %6d)\n",
+				rate*100.0, bloomRate*100.0, length, b.FilterSize(), bloom.FilterSize())
+		}
+		assert.Less(t, rate, 0.02) // Must not be over 2%
+		if length >= 4000 {
+			// At most 70% of the space of the bloom filter, see
+			// NewRibbonFilterPolicy
+			assert.LessOrEqual(t, b.FilterSize()*100, bloom.FilterSize()*70, length)
+			rates += rate
+			bloomRates += bloomRate
+		}
+	}
+	// For at most the false positive rate of the bloom filter
+	assert.LessOrEqual(t, rates, bloomRates)
+}
+
+func BenchmarkRibbon_KeyMayMatch(b *testing.B) {
+	benchmarkFilterKeyMayMatch(b, leveldb.NewRibbonFilterPolicy(10))
+}
+
+func BenchmarkRibbon_CreateFilter(b *testing.B) {
+	policy := leveldb.NewRibbonFilterPolicy(10)
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	for i := 0; i < b.N; i++ {
+		var filter []byte
+		policy.CreateFilter(keys, &filter)
+	}
+}
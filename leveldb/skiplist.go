@@ -3,86 +3,136 @@ package leveldb
 import (
 	"math/rand"
 	"sync"
-	"time"
+	"sync/atomic"
+	"unsafe"
 )
 
+// Thread safety
+// -------------
+//
+// Writes require external synchronization, most likely a mutex.
+// The exception is InsertConcurrently, which may be called from many
+// goroutines at once as long as Insert is not called at the same time.
+// Reads require a guarantee that the skiplist will not be destroyed
+// while the read is in progress.  Apart from that, reads progress
+// without any internal locking or synchronization.
+//
+// Invariants:
+//
+// (1) Allocated nodes are never deleted until the skiplist is
+// destroyed.  This is trivially guaranteed by the code since we
+// never delete any skip list nodes.
+//
+// (2) The contents of a node except for the next/prev pointers are
+// immutable after the node has been linked into the skiplist.
+// Only Insert() modifies the list, and it is careful to initialize
+// a node and use release-stores to publish the nodes in one or
+// more lists.
+
 // node is the node of skiplist
 type node struct {
-	key   []byte  // key bytes in a node
-	nexts []*node // nexts nodes linked to this node, index represents the level
-
-	mu *sync.RWMutex
-}
-
-func newNode(key []byte, height uint32) *node {
-	return &node{
-		key:   key,
-		nexts: make([]*node, height),
-		mu:    &sync.RWMutex{},
-	}
+	key []byte // key bytes in a node
+	// nexts nodes linked to this node, index represents the level
+	// nexts[0] is the lowest level link
+	nexts []atomic.Pointer[node]
 }
 
+// Next returns the next node of level n.
+// Use an 'acquire load' so that we observe a fully initialized
+// version of the returned node.
 func (node *node) Next(n uint32) *node {
-	if n < 0 {
-		panic("n is < 0")
-	}
-	node.mu.RLock()
-	defer node.mu.RUnlock()
-
-	return node.nexts[n]
+	return node.nexts[n].Load()
 }
 
+// SetNext links x to level n.
+// Use a 'release store' so that anybody who reads through this
+// pointer observes a fully initialized version of the inserted node.
 func (node *node) SetNext(n uint32, x *node) {
-	if n < 0 {
-		panic("n is < 0")
-	}
-	node.mu.Lock()
-	defer node.mu.Unlock()
-
-	node.nexts[n] = x
+	node.nexts[n].Store(x)
 }
 
+// NoBarrier_Next is used where the caller already holds the write
+// lock. Go atomics have no relaxed ordering, so it is the same as Next.
 func (node *node) NoBarrier_Next(n uint32) *node {
-	if n < 0 {
-		panic("n is < 0")
-	}
-
-	return node.nexts[n]
+	return node.nexts[n].Load()
 }
 
+// NoBarrier_SetNext is used for nodes which are not published yet.
 func (node *node) NoBarrier_SetNext(n uint32, x *node) {
-	if n < 0 {
-		panic("n is < 0")
-	}
-
-	node.nexts[n] = x
+	node.nexts[n].Store(x)
 }
 
 const kMaxHeight = 12
 
+// number of nodes and next links carved out of one slab
+const kNodeSlabSize = 256
+
+var (
+	kNodeSize = uint64(unsafe.Sizeof(node{}))
+	kLinkSize = uint64(unsafe.Sizeof(atomic.Pointer[node]{}))
+)
+
 // skiplist is the core structure of memtable
 type skiplist struct {
 	comparator Comparator
+	arena      *Arena // arena used for accounting of nodes
 	head       *node  // head node of the skiplist
-	max_height uint32 // height of the entire list
+
+	// Height of the entire list.  Modified only by Insert().  Read
+	// racily by readers, but stale values are ok.
+	max_height atomic.Uint32
+
+	// Read/written only by Insert(), or under allocMu by InsertConcurrently().
+	rnd *rand.Rand
+
+	// guards node allocation and rnd in InsertConcurrently()
+	allocMu sync.Mutex
+
+	// nodes hold pointers so they can not live in the arena's byte blocks,
+	// instead they are carved out of slabs whose size is charged to the arena
+	nodeSlab []node
+	linkSlab []atomic.Pointer[node]
 }
 
-func NewSkiplist(comparator Comparator) *skiplist {
-	head := newNode(nil, kMaxHeight)
+// NewSkiplist creates a new skiplist object that will use "comparator" for
+// comparing keys, and will account the memory of its nodes in "arena".
+// Keys inserted into the list are expected to be allocated from "arena" too.
+func NewSkiplist(comparator Comparator, arena *Arena) *skiplist {
+	sl := &skiplist{
+		comparator: comparator,
+		arena:      arena,
+		rnd:        rand.New(rand.NewSource(0xdeadbeef)),
+	}
+	sl.max_height.Store(1)
+	sl.head = sl.newNode(nil, kMaxHeight)
 	for i := 0; i < kMaxHeight; i++ {
-		head.SetNext(uint32(i), nil)
+		sl.head.SetNext(uint32(i), nil)
 	}
-	return &skiplist{
-		comparator: comparator,
-		head:       newNode(nil, kMaxHeight),
-		max_height: 1,
+	return sl
+}
+
+func (sl *skiplist) newNode(key []byte, height uint32) *node {
+	if len(sl.nodeSlab) == 0 {
+		sl.nodeSlab = make([]node, kNodeSlabSize)
+		sl.arena.charge(kNodeSlabSize * kNodeSize)
+	}
+	if uint32(len(sl.linkSlab)) < height {
+		sl.linkSlab = make([]atomic.Pointer[node], kNodeSlabSize)
+		sl.arena.charge(kNodeSlabSize * kLinkSize)
 	}
+	x := &sl.nodeSlab[0]
+	sl.nodeSlab = sl.nodeSlab[1:]
+	x.key = key
+	x.nexts = sl.linkSlab[:height:height]
+	sl.linkSlab = sl.linkSlab[height:]
+	return x
 }
 
 // Insert key into the list
 // REQUIRES: nothing that compares equal to key is currently in the list
+// REQUIRES: external synchronization between writers
 func (sl *skiplist) Insert(key []byte) {
-	prev := make([]*node, kMaxHeight)
+	var prev [kMaxHeight]*node
 	x := sl.findGreatorOrEqual(key, &prev)
 
 	if x != nil && sl.Equal(key, x.key) {
@@ -96,15 +146,81 @@ func (sl *skiplist) Insert(key []byte) {
 		for i := sl.getMaxHeight(); i < height; i++ {
 			prev[i] = sl.head
 		}
+		// It is ok to mutate max_height without any synchronization
+		// with concurrent readers.  A concurrent reader that observes
+		// the new value of max_height will see either the old value of
+		// new level pointers from head (nil), or a new value set in
+		// the loop below.  In the former case the reader will
+		// immediately drop to the next level since nil sorts after all
+		// keys.  In the latter case the reader will use the new node.
+		sl.max_height.Store(height)
 	}
 
-	x = newNode(key, height)
+	x = sl.newNode(key, height)
 	for i := uint32(0); i < height; i++ {
+		// NoBarrier_SetNext() suffices since we will add a barrier when
+		// we publish a pointer to "x" in prev[i].
 		x.NoBarrier_SetNext(i, prev[i].NoBarrier_Next(i))
 		prev[i].SetNext(i, x)
 	}
 }
 
+// InsertConcurrently is like Insert but is safe to be called concurrently
+// with other InsertConcurrently calls. Each level is spliced in with a
+// compare-and-swap, and the splice is recomputed when another writer wins
+// the race at that level.
+// REQUIRES: nothing that compares equal to key is currently in the list
+func (sl *skiplist) InsertConcurrently(key []byte) {
+	sl.allocMu.Lock()
+	height := sl.randomHeight()
+	x := sl.newNode(key, height)
+	sl.allocMu.Unlock()
+
+	// raise max_height if needed, readers handle a higher max_height
+	// with nil links the same way as in Insert()
+	for {
+		maxHeight := sl.getMaxHeight()
+		if height <= maxHeight || sl.max_height.CompareAndSwap(maxHeight, height) {
+			break
+		}
+	}
+
+	var prev, next [kMaxHeight]*node
+	before := sl.head
+	for level := int(kMaxHeight) - 1; level >= 0; level-- {
+		prev[level], next[level] = sl.findSpliceForLevel(key, before, uint32(level))
+		before = prev[level]
+	}
+
+	for i := uint32(0); i < height; i++ {
+		for {
+			if i == 0 && next[0] != nil && sl.Equal(key, next[0].key) {
+				panic("do not allow duplicate insertion")
+			}
+			x.NoBarrier_SetNext(i, next[i])
+			if prev[i].nexts[i].CompareAndSwap(next[i], x) {
+				break
+			}
+			// another writer changed prev[i] at this level, nodes are never
+			// removed so the new splice can be searched from prev[i]
+			prev[i], next[i] = sl.findSpliceForLevel(key, prev[i], i)
+		}
+	}
+}
+
+// findSpliceForLevel returns the pair of nodes at level which key should
+// be linked between, starting the search from before.
+// REQUIRES: before is head or before.key < key
+func (sl *skiplist) findSpliceForLevel(key []byte, before *node, level uint32) (*node, *node) {
+	for {
+		next := before.Next(level)
+		if !sl.keyIsAfterNode(key, next) {
+			return before, next
+		}
+		before = next
+	}
+}
+
 // Contains returns true if an entry that compares equal to key is in the list
 func (sl *skiplist) Contains(key []byte) bool {
 	x := sl.findGreatorOrEqual(key, nil)
@@ -114,9 +230,9 @@ func (sl *skiplist) Contains(key []byte) bool {
 const kBranching uint32 = 4
 
 func (sl *skiplist) randomHeight() uint32 {
-	rand.Seed(time.Now().UnixNano())
+	// Increase height with probability 1 in kBranching
 	height := uint32(1)
-	for height < kMaxHeight && rand.Uint32()%kBranching == 0 {
+	for height < kMaxHeight && sl.rnd.Uint32()%kBranching == 0 {
 		height += 1
 	}
 	if height <= 0 {
@@ -129,7 +245,7 @@ func (sl *skiplist) randomHeight() uint32 {
 }
 
 func (sl *skiplist) getMaxHeight() uint32 {
-	return sl.max_height
+	return sl.max_height.Load()
 }
 
 func (sl *skiplist) Equal(aKey, bKey []byte) bool {
@@ -143,7 +259,7 @@ func (sl *skiplist) keyIsAfterNode(key []byte, n *node) bool {
 
 // findGreatorOrEqual returns the earliest node that comes at or after the key.
 // returns nil if there is no such node.
-func (sl *skiplist) findGreatorOrEqual(key []byte, prev *[]*node) *node {
+func (sl *skiplist) findGreatorOrEqual(key []byte, prev *[kMaxHeight]*node) *node {
 	curNode := sl.head
 	level := sl.getMaxHeight() - 1
 	for {
@@ -173,7 +289,7 @@ func (sl *skiplist) findLessThan(key []byte) *node {
 
 	for {
 		next := curNode.Next(level)
-		if next == nil || sl.comparator.Compare(next.key, key) < 0 {
+		if next != nil && sl.comparator.Compare(next.key, key) < 0 {
 			curNode = next
 		} else {
 			if level == 0 {
@@ -1,8 +1,11 @@
 package leveldb_test
 
 import (
+	"bytes"
 	"math/rand"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,7 +16,7 @@ import (
 
 func TestSkiplist_Empty(t *testing.T) {
 	testCmp := leveldb.NewBytewiseComparator()
-	list := leveldb.NewSkiplist(testCmp)
+	list := leveldb.NewSkiplist(testCmp, leveldb.NewArena())
 	assert.True(t, !list.Contains([]byte("10")))
 
 	iter := leveldb.NewSkiplistIterator(list)
@@ -33,7 +36,7 @@ func TestSkiplist_InsertAndLookup(t *testing.T) {
 	keySet := make(map[int]struct{}, 0)
 	keyArr := make([]int, 0)
 	cmp := leveldb.NewBytewiseComparator()
-	list := leveldb.NewSkiplist(cmp)
+	list := leveldb.NewSkiplist(cmp, leveldb.NewArena())
 
 	begin := 0
 
@@ -97,3 +100,215 @@ func TestSkiplist_InsertAndLookup(t *testing.T) {
 		iter.Prev()
 	}
 }
+
+// TestSkiplist_Concurrent runs one writer and several readers at the same
+// time, run with -race to verify the memory model of the skiplist.
+// Readers must see a sorted list which contains every key whose insertion
+// completed before the read started.
+func TestSkiplist_Concurrent(t *testing.T) {
+	N := 5000
+	readers := 4
+
+	keys := benchmarkKeys(N)
+	list := leveldb.NewSkiplist(leveldb.NewBytewiseComparator(), leveldb.NewArena())
+	var inserted atomic.Int64
+	var wg sync.WaitGroup
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				done := int(inserted.Load())
+				for i := 0; i < done; i++ {
+					if !list.Contains(keys[i]) {
+						t.Errorf("key %d is inserted but not found", i)
+						return
+					}
+				}
+
+				var count int
+				var prev []byte
+				iter := leveldb.NewSkiplistIterator(list)
+				for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+					if prev != nil && bytes.Compare(prev, iter.Key()) >= 0 {
+						t.Errorf("keys are out of order")
+						return
+					}
+					prev = iter.Key()
+					count++
+				}
+				if count < done {
+					t.Errorf("iterate %d keys, want at least %d", count, done)
+					return
+				}
+				if done == N {
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < N; i++ {
+		list.Insert(keys[i])
+		inserted.Store(int64(i + 1))
+	}
+	wg.Wait()
+}
+
+func benchmarkKeys(n int) [][]byte {
+	rnd := rand.New(rand.NewSource(301))
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = util.EncodeUint64Fixed(rnd.Uint64())
+	}
+	return keys
+}
+
+// mutexSkiplist is the skiplist before it became lock-free, kept as the
+// baseline of the benchmarks: every next link is guarded by a per-node
+// RWMutex, the global PRNG is reseeded for every height and the height of
+// the list is never raised above 1.
+type mutexSkiplist struct {
+	comparator leveldb.Comparator
+	head       *mutexNode
+	max_height uint32
+}
+
+type mutexNode struct {
+	key   []byte
+	nexts []*mutexNode
+
+	mu sync.RWMutex
+}
+
+func (node *mutexNode) Next(n uint32) *mutexNode {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.nexts[n]
+}
+
+func (node *mutexNode) SetNext(n uint32, x *mutexNode) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.nexts[n] = x
+}
+
+const kMutexSkiplistMaxHeight = 12
+
+func newMutexSkiplist(comparator leveldb.Comparator) *mutexSkiplist {
+	return &mutexSkiplist{
+		comparator: comparator,
+		head:       &mutexNode{nexts: make([]*mutexNode, kMutexSkiplistMaxHeight)},
+		max_height: 1,
+	}
+}
+
+func (sl *mutexSkiplist) Insert(key []byte) {
+	prev := make([]*mutexNode, kMutexSkiplistMaxHeight)
+	sl.findGreatorOrEqual(key, prev)
+
+	height := sl.randomHeight()
+	for i := sl.max_height; i < height; i++ {
+		prev[i] = sl.head
+	}
+	x := &mutexNode{key: key, nexts: make([]*mutexNode, height)}
+	for i := uint32(0); i < height; i++ {
+		x.nexts[i] = prev[i].nexts[i]
+		prev[i].SetNext(i, x)
+	}
+}
+
+func (sl *mutexSkiplist) Contains(key []byte) bool {
+	x := sl.findGreatorOrEqual(key, nil)
+	return x != nil && sl.comparator.Compare(key, x.key) == 0
+}
+
+func (sl *mutexSkiplist) randomHeight() uint32 {
+	rand.Seed(time.Now().UnixNano())
+	height := uint32(1)
+	for height < kMutexSkiplistMaxHeight && rand.Uint32()%4 == 0 {
+		height++
+	}
+	return height
+}
+
+func (sl *mutexSkiplist) findGreatorOrEqual(key []byte, prev []*mutexNode) *mutexNode {
+	x := sl.head
+	level := sl.max_height - 1
+	for {
+		next := x.Next(level)
+		if next != nil && sl.comparator.Compare(key, next.key) > 0 {
+			x = next
+		} else {
+			if prev != nil {
+				prev[level] = x
+			}
+			if level == 0 {
+				return next
+			}
+			level--
+		}
+	}
+}
+
+// benchmarkList is implemented by the skiplist and its mutexSkiplist
+// baseline.
+type benchmarkList interface {
+	Insert(key []byte)
+	Contains(key []byte) bool
+}
+
+// benchmarkLists runs bench against the skiplist and the baseline.
+func benchmarkLists(b *testing.B, bench func(b *testing.B, newList func() benchmarkList)) {
+	cmp := leveldb.NewBytewiseComparator()
+	b.Run("LockFree", func(b *testing.B) {
+		bench(b, func() benchmarkList { return leveldb.NewSkiplist(cmp, leveldb.NewArena()) })
+	})
+	b.Run("Mutex", func(b *testing.B) {
+		bench(b, func() benchmarkList { return newMutexSkiplist(cmp) })
+	})
+}
+
+func BenchmarkSkiplist_Insert(b *testing.B) {
+	benchmarkLists(b, func(b *testing.B, newList func() benchmarkList) {
+		keys := benchmarkKeys(b.N)
+		list := newList()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			list.Insert(keys[i])
+		}
+	})
+}
+
+func BenchmarkSkiplist_Contains(b *testing.B) {
+	benchmarkLists(b, func(b *testing.B, newList func() benchmarkList) {
+		keys := benchmarkKeys(10000)
+		list := newList()
+		for _, k := range keys {
+			list.Insert(k)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			list.Contains(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkSkiplist_ParallelContains(b *testing.B) {
+	benchmarkLists(b, func(b *testing.B, newList func() benchmarkList) {
+		keys := benchmarkKeys(10000)
+		list := newList()
+		for _, k := range keys {
+			list.Insert(k)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				list.Contains(keys[i%len(keys)])
+				i++
+			}
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package leveldb
+
+import "fmt"
+
+// SliceTransform extracts a prefix from a key.  It is used to build
+// prefix filters and to restrict prefix seeks to the keys sharing the
+// prefix of the seek target.
+//
+// The keys sharing a prefix must be contiguous in the comparator order,
+// i.e. the prefix of a key must order the same way as the key.
+type SliceTransform interface {
+	// Return the name of this transformation.  The name is recorded in
+	// the tables, prefix filters built by a transform with a different
+	// name are ignored.
+	Name() string
+
+	// Transform returns the prefix of key.
+	// REQUIRES: InDomain(key)
+	Transform(key []byte) []byte
+
+	// InDomain returns true if key has a prefix, keys out of the domain
+	// are not added to the prefix filters.
+	InDomain(key []byte) bool
+}
+
+type fixedPrefixTransform struct {
+	prefixLen int
+	name      string
+}
+
+var _ SliceTransform = (*fixedPrefixTransform)(nil)
+
+// NewFixedPrefixTransform returns a SliceTransform whose prefix is the first
+// prefixLen bytes of the key, keys shorter than prefixLen have no prefix.
+func NewFixedPrefixTransform(prefixLen int) SliceTransform {
+	return &fixedPrefixTransform{
+		prefixLen: prefixLen,
+		name:      fmt.Sprintf("leveldb.FixedPrefix.%d", prefixLen),
+	}
+}
+
+func (t *fixedPrefixTransform) Name() string {
+	return t.name
+}
+
+func (t *fixedPrefixTransform) Transform(key []byte) []byte {
+	return key[:t.prefixLen]
+}
+
+func (t *fixedPrefixTransform) InDomain(key []byte) bool {
+	return len(key) >= t.prefixLen
+}
+
+type cappedPrefixTransform struct {
+	capLen int
+	name   string
+}
+
+var _ SliceTransform = (*cappedPrefixTransform)(nil)
+
+// NewCappedPrefixTransform returns a SliceTransform whose prefix is the first
+// capLen bytes of the key, or the whole key if it is shorter.
+func NewCappedPrefixTransform(capLen int) SliceTransform {
+	return &cappedPrefixTransform{
+		capLen: capLen,
+		name:   fmt.Sprintf("leveldb.CappedPrefix.%d", capLen),
+	}
+}
+
+func (t *cappedPrefixTransform) Name() string {
+	return t.name
+}
+
+func (t *cappedPrefixTransform) Transform(key []byte) []byte {
+	if len(key) < t.capLen {
+		return key
+	}
+	return key[:t.capLen]
+}
+
+func (t *cappedPrefixTransform) InDomain(key []byte) bool {
+	return true
+}
@@ -0,0 +1,62 @@
+package leveldb
+
+import "container/list"
+
+// Snapshot is a consistent view of the DB: the reads with
+// ReadOptions.Snapshot set to it see the entries written before it was
+// taken, and compactions keep them until it is released.  See
+// DB.GetSnapshot.
+type Snapshot struct {
+	sequence SequenceNumber
+	element  *list.Element // In the snapshotList of the DB
+}
+
+// Sequence returns the sequence number of the last entry the snapshot
+// sees.
+func (s *Snapshot) Sequence() SequenceNumber {
+	return s.sequence
+}
+
+// snapshotList holds the live snapshots of a DB, oldest first.  The
+// sequence numbers of the snapshots never decrease along the list.
+type snapshotList struct {
+	list list.List
+}
+
+func (l *snapshotList) empty() bool {
+	return l.list.Len() == 0
+}
+
+func (l *snapshotList) oldest() *Snapshot {
+	return l.list.Front().Value.(*Snapshot)
+}
+
+func (l *snapshotList) newest() *Snapshot {
+	return l.list.Back().Value.(*Snapshot)
+}
+
+// sequences returns the sequence numbers of the snapshots, oldest first.
+func (l *snapshotList) sequences() []SequenceNumber {
+	var seqs []SequenceNumber
+	for e := l.list.Front(); e != nil; e = e.Next() {
+		seqs = append(seqs, e.Value.(*Snapshot).sequence)
+	}
+	return seqs
+}
+
+func (l *snapshotList) add(seq SequenceNumber) *Snapshot {
+	if !l.empty() && l.newest().sequence > seq {
+		panic("snapshot sequence is older than the newest snapshot")
+	}
+	s := &Snapshot{sequence: seq}
+	s.element = l.list.PushBack(s)
+	return s
+}
+
+func (l *snapshotList) remove(s *Snapshot) {
+	if s.element == nil {
+		panic("snapshot already released")
+	}
+	l.list.Remove(s.element)
+	s.element = nil
+}
@@ -0,0 +1,385 @@
+package leveldb
+
+import "bytes"
+
+// Table is a sorted map from strings to strings.  Tables are
+// immutable and persistent.  A Table may be safely accessed from
+// multiple goroutines without external synchronization.
+type Table struct {
+	options         *Options
+	file            RandomAccessFile
+	filter          filterReader
+	metaindexHandle BlockHandle // Handle to metaindex_block: saved from footer
+	indexBlock      *block
+	// If non-nil, the index is partitioned: indexBlock is the top-level
+	// index of the index partitions, the last ones read are kept here
+	indexPartitions *partitionCache
+	format          Format // encoding of the table, told by the footer
+	// The filters also hold the prefixes extracted by options.PrefixExtractor
+	prefixFiltered bool
+	// Sorted by start, read when the table is opened
+	rangeTombstones []rangeTombstone
+}
+
+// OpenTable attempts to open the table that is stored in bytes [0..fileSize)
+// of "file", and read the metadata entries necessary to allow
+// retrieving data from the table.
+//
+// "file" must remain live while this Table is in use.
+//
+// A table that records the name of another user comparator than
+// options.Comparator fails to open with a Code_InvalidArgument error.
+// Format_LevelDB tables are opened with any comparator.
+func OpenTable(options *Options, file RandomAccessFile, size uint64) (*Table, error) {
+	if size < kEncodedFooterLength {
+		return nil, Error(Code_Corruption, "file is too short to be an sstable")
+	}
+
+	footerInput := make([]byte, kEncodedFooterLength)
+	if n, err := file.ReadAt(footerInput, int64(size-kEncodedFooterLength)); n != kEncodedFooterLength {
+		return nil, ioError(err)
+	}
+
+	var footer Footer
+	if err := footer.DecodeFrom(footerInput); err != nil {
+		return nil, err
+	}
+
+	// Read the index block
+	opt := &ReadOptions{}
+	if options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	indexBlockContents, err := ReadBlock(file, opt, &footer.indexHandle, footer.format)
+	if err != nil {
+		return nil, err
+	}
+
+	// We've successfully read the footer and the index block: we're
+	// ready to serve requests.
+	t := &Table{
+		options:         options,
+		file:            file,
+		metaindexHandle: footer.metaindexHandle,
+		indexBlock:      NewBlock(indexBlockContents),
+		format:          footer.format,
+	}
+	if err := t.readMeta(&footer); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readMeta reads the range tombstones and the filter of the table, and
+// whether its index is partitioned.  Returns an error if the table was
+// built with another comparator.  The tables of Format_LevelDB, like the
+// ones of the C++ library, do not record their comparator and are left
+// unchecked.
+func (t *Table) readMeta(footer *Footer) error {
+	opt := &ReadOptions{}
+	if t.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	contents, err := ReadBlock(t.file, opt, &footer.metaindexHandle, t.format)
+	if err != nil {
+		// The index of the table could be partitioned, it cannot be
+		// read without the meta info
+		return err
+	}
+	meta := NewBlock(contents)
+
+	iter := meta.NewIterator(NewBytewiseComparator())
+	defer iter.Close()
+	// Tables written before the comparator was recorded are not checked
+	iter.Seek([]byte(kComparatorMetaKey))
+	if iter.Valid() && string(iter.Key()) == kComparatorMetaKey {
+		ucmp, _ := userKeyComparator(t.options.Comparator)
+		if name := string(iter.Value()); name != ucmp.Name() {
+			return Error(Code_InvalidArgument,
+				name+" does not match existing comparator "+ucmp.Name())
+		}
+	}
+
+	iter.Seek([]byte(kPartitionedIndexMetaKey))
+	if iter.Valid() && string(iter.Key()) == kPartitionedIndexMetaKey {
+		t.indexPartitions = &partitionCache{}
+	}
+
+	iter.Seek([]byte(kRangeDelMetaKey))
+	if iter.Valid() && string(iter.Key()) == kRangeDelMetaKey {
+		if err := t.readRangeTombstones(iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	if t.options.FilterPolicy == nil {
+		return nil // Do not need any other metadata
+	}
+	// The filter type of the table is told by the prefix of its metaindex key
+	for _, filterType := range []FilterType{FilterType_Block, FilterType_Full, FilterType_Partitioned} {
+		key := []byte(filterMetaKeyPrefix[filterType] + t.options.FilterPolicy.Name())
+		iter.Seek(key)
+		if iter.Valid() && string(iter.Key()) == string(key) {
+			t.readFilter(filterType, iter.Value())
+			break
+		}
+	}
+
+	if t.filter != nil && t.options.PrefixExtractor != nil {
+		key := []byte("prefix." + t.options.PrefixExtractor.Name())
+		iter.Seek(key)
+		t.prefixFiltered = iter.Valid() && string(iter.Key()) == string(key)
+	}
+	return nil
+}
+
+// kComparatorMetaKey is the metaindex key of the name of the user
+// comparator of the table.
+const kComparatorMetaKey = "comparator"
+
+// kPartitionedIndexMetaKey is the metaindex key telling that the index of
+// the table is partitioned, see FilterType_Partitioned.
+const kPartitionedIndexMetaKey = "partitionedindex"
+
+// kRangeDelMetaKey is the metaindex key of the range deletion block of the
+// table.
+const kRangeDelMetaKey = "rangedel"
+
+// filterMetaKeyPrefix is the prefix of the metaindex key of the filter
+// block per filter type, the name of the filter policy follows.
+var filterMetaKeyPrefix = map[FilterType]string{
+	FilterType_Block:       "filter.",
+	FilterType_Full:        "fullfilter.",
+	FilterType_Partitioned: "partitionedfilter.",
+}
+
+// readRangeTombstones reads the range deletion block.  Unlike the filter
+// the range tombstones are needed to read the table, failing to read them
+// is an error.
+func (t *Table) readRangeTombstones(handleValue []byte) error {
+	var handle BlockHandle
+	if _, err := handle.DecodeFrom(handleValue); err != nil {
+		return err
+	}
+	opt := &ReadOptions{}
+	if t.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	contents, err := ReadBlock(t.file, opt, &handle, t.format)
+	if err != nil {
+		return err
+	}
+	iter := NewBlock(contents).NewIterator(t.options.Comparator)
+	defer iter.Close()
+	t.rangeTombstones, err = readRangeTombstones(iter)
+	return err
+}
+
+func (t *Table) readFilter(filterType FilterType, filterHandleValue []byte) {
+	var filterHandle BlockHandle
+	if _, err := filterHandle.DecodeFrom(filterHandleValue); err != nil {
+		return
+	}
+
+	// We might want to unify with ReadBlock() if we start
+	// requiring checksum verification in Table::Open.
+	opt := &ReadOptions{}
+	if t.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	contents, err := ReadBlock(t.file, opt, &filterHandle, t.format)
+	if err != nil {
+		return
+	}
+	policy := filterPolicyForFormat(t.options.FilterPolicy, t.format)
+	switch filterType {
+	case FilterType_Full:
+		t.filter = newFullFilterBlockReader(policy, contents.data)
+	case FilterType_Partitioned:
+		t.filter = newPartitionedFilterBlockReader(policy, t, contents)
+	default:
+		if reader := NewFilterBlockReader(policy, contents.data, t.format); reader != nil {
+			t.filter = reader
+		}
+	}
+}
+
+// blockReader converts an index iterator value (i.e., an encoded BlockHandle)
+// into an iterator over the contents of the corresponding block.
+func (t *Table) blockReader(options *ReadOptions, indexValue []byte) Iterator {
+	var handle BlockHandle
+	if _, err := handle.DecodeFrom(indexValue); err != nil {
+		return NewErrorIterator(err)
+	}
+	contents, err := ReadBlock(t.file, options, &handle, t.format)
+	if err != nil {
+		return NewErrorIterator(err)
+	}
+	return NewBlock(contents).NewIterator(t.options.Comparator)
+}
+
+// newIndexIterator returns an iterator over the index entries of the data
+// blocks, through the index partitions if the index is partitioned.
+func (t *Table) newIndexIterator() Iterator {
+	iter := t.indexBlock.NewIterator(t.options.Comparator)
+	if t.indexPartitions == nil {
+		return iter
+	}
+	return NewTwoLevelIterator(iter, func(indexValue []byte) Iterator {
+		var handle BlockHandle
+		if _, err := handle.DecodeFrom(indexValue); err != nil {
+			return NewErrorIterator(err)
+		}
+		contents, err := t.indexPartitions.read(t, &handle)
+		if err != nil {
+			return NewErrorIterator(err)
+		}
+		return NewBlock(contents).NewIterator(t.options.Comparator)
+	})
+}
+
+// NewIterator returns a new iterator over the table contents.
+// The result of NewIterator() is initially invalid (caller must
+// call one of the Seek methods on the iterator before using it).
+func (t *Table) NewIterator(options *ReadOptions) Iterator {
+	iter := NewTwoLevelIterator(
+		t.newIndexIterator(),
+		func(indexValue []byte) Iterator {
+			return t.blockReader(options, indexValue)
+		}).setBound(t.options.Comparator, options)
+	if options.PrefixSameAsStart && t.prefixFiltered {
+		return &prefixSeekIterator{Iterator: iter, table: t}
+	}
+	return iter
+}
+
+// prefixMayMatch returns false if the filters show that the table holds no
+// key sharing the prefix of target.
+func (t *Table) prefixMayMatch(target []byte) bool {
+	if !t.prefixFiltered {
+		return true
+	}
+	extractor := t.options.PrefixExtractor
+	_, internalKeys := userKeyComparator(t.options.Comparator)
+	pkey, prefix, ok := prefixFilterKey(extractor, internalKeys, target)
+	if !ok {
+		return true
+	}
+
+	iiter := t.newIndexIterator()
+	defer iiter.Close()
+	for iiter.Seek(target); iiter.Valid(); iiter.Next() {
+		var handle BlockHandle
+		if _, err := handle.DecodeFrom(iiter.Value()); err != nil {
+			return true
+		}
+		if t.filter.KeyMayMatch(handle.Offset(), pkey) {
+			return true
+		}
+		// An index key is >= all the keys of its block, so once it is past
+		// the keys with the prefix the following blocks are as well.
+		ukey := iiter.Key()
+		if internalKeys {
+			ukey = ExtractUserKey(ukey)
+		}
+		if !extractor.InDomain(ukey) || !bytes.Equal(extractor.Transform(ukey), prefix) {
+			return false
+		}
+	}
+	return false
+}
+
+// prefixSeekIterator is a table iterator in prefix seek mode: a Seek to a
+// target whose prefix the filters rule out leaves it invalid without reading
+// any data block.
+type prefixSeekIterator struct {
+	Iterator
+	table    *Table
+	filtered bool // the last Seek was ruled out by the filters
+}
+
+func (it *prefixSeekIterator) Valid() bool {
+	return !it.filtered && it.Iterator.Valid()
+}
+
+func (it *prefixSeekIterator) Seek(target []byte) {
+	it.filtered = !it.table.prefixMayMatch(target)
+	if !it.filtered {
+		it.Iterator.Seek(target)
+	}
+}
+
+func (it *prefixSeekIterator) SeekToFirst() {
+	it.filtered = false
+	it.Iterator.SeekToFirst()
+}
+
+func (it *prefixSeekIterator) SeekToLast() {
+	it.filtered = false
+	it.Iterator.SeekToLast()
+}
+
+// InternalGet calls handleResult with the entry found after a call to
+// Seek(key), then with the following ones as long as handleResult returns
+// true. May not make such a call if filter policy says that key is not
+// present.
+func (t *Table) InternalGet(options *ReadOptions, k []byte, handleResult func(k, v []byte) bool) error {
+	iiter := t.newIndexIterator()
+	defer iiter.Close()
+	iiter.Seek(k)
+	if !iiter.Valid() {
+		return iiter.Error()
+	}
+
+	handleValue := iiter.Value()
+	var handle BlockHandle
+	if _, err := handle.DecodeFrom(handleValue); err == nil && t.filter != nil &&
+		!t.filter.KeyMayMatch(handle.Offset(), k) {
+		// Not found
+		return nil
+	}
+
+	iter := t.blockReader(options, handleValue)
+	if biter, ok := iter.(*blockIter); ok {
+		biter.seekForGet(k)
+	} else {
+		iter.Seek(k)
+	}
+	for iter.Valid() && handleResult(iter.Key(), iter.Value()) {
+		iter.Next()
+		if !iter.Valid() && iter.Error() == nil {
+			// The entries wanted continue in the next block
+			iter.Close()
+			iiter.Next()
+			if !iiter.Valid() {
+				return iiter.Error()
+			}
+			iter = t.blockReader(options, iiter.Value())
+			iter.SeekToFirst()
+		}
+	}
+	err := iter.Error()
+	iter.Close()
+	return err
+}
+
+// ApproximateOffsetOf returns the approximate byte offset in the file where
+// the data for key begins (or would begin if the key were present in the
+// file).  The returned value is in terms of file bytes, and so includes
+// effects like compression of the underlying data.
+func (t *Table) ApproximateOffsetOf(key []byte) uint64 {
+	indexIter := t.newIndexIterator()
+	defer indexIter.Close()
+	indexIter.Seek(key)
+	if indexIter.Valid() {
+		var handle BlockHandle
+		if _, err := handle.DecodeFrom(indexIter.Value()); err == nil {
+			return handle.Offset()
+		}
+	}
+
+	// key is past the last key in the file.  Approximate the offset
+	// by returning the offset of the metaindex block (which is
+	// right near the end of the file).
+	return t.metaindexHandle.Offset()
+}
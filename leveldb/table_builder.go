@@ -0,0 +1,393 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+// TableBuilder provides the interface used to build a Table
+// (an immutable and sorted map from keys to values).
+//
+// Multiple goroutines can invoke const methods on a TableBuilder without
+// external synchronization, but if any of the goroutines may call a
+// non-const method, all goroutines accessing the same TableBuilder must use
+// external synchronization.
+//
+// A table file has the form:
+//
+//	<beginning_of_file>
+//	[data block 1]
+//	[data block 2]
+//	...
+//	[data block N]
+//	[meta block 1]
+//	...
+//	[meta block K]
+//	[metaindex block]
+//	[index block]
+//	[Footer]        (fixed size; starts at file_size - sizeof(Footer))
+//	<end_of_file>
+//
+// With FilterType_Partitioned the index block is the top-level index of the
+// index partitions, which are written before it.
+type TableBuilder struct {
+	options           *Options
+	indexBlockOptions *Options
+	file              WritableFile
+	offset            uint64
+	err               error
+	dataBlock         *blockBuilder
+	indexBlock        *blockBuilder
+	lastKey           []byte
+	numEntries        int
+	closed            bool // Either Finish() or Abandon() has been called.
+	filterBlock       filterBuilder
+	rangeDelBlock     *blockBuilder // nil until a range tombstone is added
+
+	// If non-nil, the index is cut into partitions along the filter
+	// partitions of partitionedFilter (FilterType_Partitioned), indexBlock
+	// being the current partition
+	partitionedFilter *partitionedFilterBlockBuilder
+	indexPartitions   []blockPartition
+	metadataBlockSize uint64
+
+	// We do not emit the index entry for a block until we have seen the
+	// first key for the next data block.  This allows us to use shorter
+	// keys in the index block.  For example, consider a block boundary
+	// between the keys "the quick brown fox" and "the who".  We can use
+	// "the r" as the key for the index block entry since it is >= all
+	// entries in the first block and < all entries in subsequent
+	// blocks.
+	//
+	// Invariant: pendingIndexEntry is true only if dataBlock is empty.
+	pendingIndexEntry bool
+	pendingHandle     BlockHandle // Handle to add to index block
+}
+
+// NewTableBuilder creates a builder that will store the contents of the table it is
+// building in file.  Does not close the file.  It is up to the
+// caller to close the file after calling Finish().
+func NewTableBuilder(options *Options, file WritableFile) *TableBuilder {
+	indexBlockOptions := *options
+	indexBlockOptions.BlockRestartInternal = 1
+
+	tb := &TableBuilder{
+		options:           options,
+		indexBlockOptions: &indexBlockOptions,
+		file:              file,
+		dataBlock:         newDataBlockBuilder(options),
+		indexBlock:        NewBlockBuilder(&indexBlockOptions),
+	}
+	if options.FilterPolicy != nil {
+		policy := filterPolicyForFormat(options.FilterPolicy, options.Format)
+		switch options.FilterType {
+		case FilterType_Full:
+			tb.filterBlock = newFullFilterBlockBuilder(policy)
+		case FilterType_Partitioned:
+			tb.partitionedFilter = newPartitionedFilterBlockBuilder(policy)
+			tb.filterBlock = tb.partitionedFilter
+			tb.metadataBlockSize = 4 << 10
+			if options.MetadataBlockSize > 0 {
+				tb.metadataBlockSize = uint64(options.MetadataBlockSize)
+			}
+		default:
+			tb.filterBlock = NewFilterBlockBuilder(policy, options.Format)
+		}
+		if options.PrefixExtractor != nil {
+			_, internalKeys := userKeyComparator(options.Comparator)
+			tb.filterBlock.setPrefixExtractor(options.PrefixExtractor, internalKeys)
+		}
+		tb.filterBlock.StartBlock(0)
+	}
+	return tb
+}
+
+// Add key,value to the table being constructed.
+// REQUIRES: key is after any previously added key according to comparator.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Add(key, value []byte) error {
+	if tb.closed {
+		panic("table builder is closed")
+	}
+	if tb.err != nil {
+		return tb.err
+	}
+	if tb.numEntries > 0 && tb.options.Comparator.Compare(key, tb.lastKey) <= 0 {
+		return Error(Code_InvalidArgument, "table keys are not added in order")
+	}
+
+	if tb.pendingIndexEntry {
+		if !tb.dataBlock.empty() {
+			panic("data block is not empty")
+		}
+		tb.options.Comparator.FindShortestSeparator(&tb.lastKey, key)
+		tb.addIndexEntry(false)
+	}
+
+	if tb.filterBlock != nil {
+		tb.filterBlock.AddKey(key)
+	}
+
+	tb.lastKey = append(tb.lastKey[:0], key...)
+	tb.numEntries++
+	if err := tb.dataBlock.Add(key, value); err != nil {
+		tb.err = err
+		return err
+	}
+
+	estimatedBlockSize := tb.dataBlock.CurrentSizeEstimate()
+	if estimatedBlockSize >= tb.options.BlockSize {
+		tb.Flush()
+	}
+	return tb.err
+}
+
+// AddRangeTombstone adds the range tombstone key->value to the range
+// deletion block of the table: key is the internal key of type
+// ValueType_RangeDeletion of the start of the range and value its end.
+// REQUIRES: key is after any previously added range tombstone key
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) AddRangeTombstone(key, value []byte) error {
+	if tb.closed {
+		panic("table builder is closed")
+	}
+	if tb.err != nil {
+		return tb.err
+	}
+	if tb.rangeDelBlock == nil {
+		tb.rangeDelBlock = NewBlockBuilder(tb.indexBlockOptions)
+	}
+	if err := tb.rangeDelBlock.Add(key, value); err != nil {
+		return Error(Code_InvalidArgument, "range tombstones are not added in order")
+	}
+	return nil
+}
+
+// Flush any buffered key/value pairs to file.
+// Can be used to ensure that two adjacent entries never live in
+// the same data block.  Most clients should not need to use this method.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Flush() {
+	if tb.closed {
+		panic("table builder is closed")
+	}
+	if tb.err != nil {
+		return
+	}
+	if tb.dataBlock.empty() {
+		return
+	}
+	if tb.pendingIndexEntry {
+		panic("pending index entry")
+	}
+	tb.writeBlock(tb.dataBlock, &tb.pendingHandle)
+	if tb.err == nil {
+		tb.pendingIndexEntry = true
+		tb.err = tb.file.Flush()
+	}
+	if tb.filterBlock != nil {
+		tb.filterBlock.StartBlock(tb.offset)
+	}
+}
+
+func (tb *TableBuilder) writeBlock(block *blockBuilder, handle *BlockHandle) {
+	// File format contains a sequence of blocks where each block has:
+	//    block_data: uint8[n]
+	//    type: uint8
+	//    crc: uint32
+	raw := block.Finish()
+
+	// TODO: compress the block once a compression library is available,
+	// all blocks are stored uncompressed for now.
+	tb.writeRawBlock(raw, CompressionType_NoCompression, handle)
+	block.Reset()
+}
+
+func (tb *TableBuilder) writeRawBlock(blockContents []byte, t CompressionType, handle *BlockHandle) {
+	handle.SetOffset(tb.offset)
+	handle.SetSize(uint64(len(blockContents)))
+	tb.err = tb.file.Append(blockContents)
+	if tb.err == nil {
+		trailer := []byte{byte(t)}
+		crc := util.CRC32CValue(blockContents)
+		crc = util.CRC32CExtend(crc, trailer) // Extend crc to cover block type
+		tb.options.Format.putFixed32(&trailer, util.CRC32CMask(crc))
+		tb.err = tb.file.Append(trailer)
+		if tb.err == nil {
+			tb.offset += uint64(len(blockContents) + kBlockTrailerSize)
+		}
+	}
+}
+
+// Error returns non-nil iff some error has been detected.
+func (tb *TableBuilder) Error() error {
+	return tb.err
+}
+
+// Finish building the table.  Stops using the file passed to the
+// constructor after this function returns.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Finish() error {
+	tb.Flush()
+	if tb.closed {
+		panic("table builder is closed")
+	}
+	tb.closed = true
+
+	if tb.err == nil && tb.pendingIndexEntry {
+		tb.options.Comparator.FindShortSuccessor(&tb.lastKey)
+		tb.addIndexEntry(true)
+	}
+
+	var filterBlockHandle, rangeDelBlockHandle, metaindexBlockHandle, indexBlockHandle BlockHandle
+
+	// Write filter block
+	var filterMetaKey string
+	if tb.err == nil && tb.filterBlock != nil {
+		switch fb := tb.filterBlock.(type) {
+		case *filterBlockBuilder:
+			filterMetaKey = filterMetaKeyPrefix[FilterType_Block]
+			tb.writeRawBlock(fb.Finish(), CompressionType_NoCompression, &filterBlockHandle)
+		case *fullFilterBlockBuilder:
+			filterMetaKey = filterMetaKeyPrefix[FilterType_Full]
+			tb.writeRawBlock(fb.Finish(), CompressionType_NoCompression, &filterBlockHandle)
+		case *partitionedFilterBlockBuilder:
+			filterMetaKey = filterMetaKeyPrefix[FilterType_Partitioned]
+			tb.writePartitions(fb.Finish(), &filterBlockHandle)
+		}
+	}
+
+	// Write range deletion block
+	if tb.err == nil && tb.rangeDelBlock != nil {
+		tb.writeBlock(tb.rangeDelBlock, &rangeDelBlockHandle)
+	}
+
+	// Write metaindex block
+	if tb.err == nil {
+		// metaindex keys are plain strings, not internal keys
+		metaIndexBlockOptions := *tb.indexBlockOptions
+		metaIndexBlockOptions.Comparator = NewBytewiseComparator()
+		metaIndexBlock := NewBlockBuilder(&metaIndexBlockOptions)
+		if tb.options.Format != Format_LevelDB {
+			// Add mapping from "comparator" to the name of the user
+			// comparator, which has to match when the table is opened.
+			// The tables of the C++ library do not record it.
+			ucmp, _ := userKeyComparator(tb.options.Comparator)
+			metaIndexBlock.Add([]byte(kComparatorMetaKey), []byte(ucmp.Name()))
+		}
+		if tb.filterBlock != nil {
+			// Add mapping from "filter.Name" to location of filter data,
+			// "fullfilter.Name" or "partitionedfilter.Name" for the other
+			// filter types
+			key := []byte(filterMetaKey + tb.options.FilterPolicy.Name())
+			var handleEncoding []byte
+			filterBlockHandle.EncodeTo(&handleEncoding)
+			metaIndexBlock.Add(key, handleEncoding)
+
+			if tb.partitionedFilter != nil {
+				// Record that the index is partitioned along the filter,
+				// "partitionedindex" sorts after "partitionedfilter.Name"
+				metaIndexBlock.Add([]byte(kPartitionedIndexMetaKey), nil)
+			}
+
+			if tb.options.PrefixExtractor != nil {
+				// Record that the filters also hold the prefixes extracted
+				// by "prefix.Name"
+				key := []byte("prefix." + tb.options.PrefixExtractor.Name())
+				metaIndexBlock.Add(key, nil)
+			}
+		}
+		if tb.rangeDelBlock != nil {
+			// Add mapping from "rangedel" to location of the range
+			// tombstones
+			var handleEncoding []byte
+			rangeDelBlockHandle.EncodeTo(&handleEncoding)
+			metaIndexBlock.Add([]byte(kRangeDelMetaKey), handleEncoding)
+		}
+
+		// TODO(postrelease): Add stats and other meta blocks
+		tb.writeBlock(metaIndexBlock, &metaindexBlockHandle)
+	}
+
+	// Write index block
+	if tb.err == nil {
+		if tb.partitionedFilter != nil {
+			tb.writePartitions(tb.indexPartitions, &indexBlockHandle)
+		} else {
+			tb.writeBlock(tb.indexBlock, &indexBlockHandle)
+		}
+	}
+
+	// Write footer
+	if tb.err == nil {
+		footer := Footer{
+			metaindexHandle: metaindexBlockHandle,
+			indexHandle:     indexBlockHandle,
+			format:          tb.options.Format,
+		}
+		var footerEncoding []byte
+		footer.EncodeTo(&footerEncoding)
+		tb.err = tb.file.Append(footerEncoding)
+		if tb.err == nil {
+			tb.offset += uint64(len(footerEncoding))
+		}
+	}
+	return tb.err
+}
+
+// addIndexEntry adds the index entry of the last data block, whose key
+// is lastKey.  With a partitioned index, the index partition and the filter
+// partition are cut after it once the index partition reaches the metadata
+// block size, or if the block is the last one of the table.
+func (tb *TableBuilder) addIndexEntry(last bool) {
+	var handleEncoding []byte
+	tb.pendingHandle.EncodeTo(&handleEncoding)
+	tb.indexBlock.Add(tb.lastKey, handleEncoding)
+	tb.pendingIndexEntry = false
+
+	if tb.partitionedFilter != nil && (last || tb.indexBlock.CurrentSizeEstimate() >= tb.metadataBlockSize) {
+		tb.indexPartitions = append(tb.indexPartitions, blockPartition{
+			lastKey:  append([]byte{}, tb.lastKey...),
+			contents: tb.indexBlock.Finish(),
+		})
+		tb.indexBlock.Reset()
+		tb.partitionedFilter.cutPartition(tb.lastKey)
+	}
+}
+
+// writePartitions writes each partition as a raw block, then the top-level
+// index of the partitions, whose handle is stored in handle.
+func (tb *TableBuilder) writePartitions(partitions []blockPartition, handle *BlockHandle) {
+	indexBlock := NewBlockBuilder(tb.indexBlockOptions)
+	for _, p := range partitions {
+		var partitionHandle BlockHandle
+		tb.writeRawBlock(p.contents, CompressionType_NoCompression, &partitionHandle)
+		if tb.err != nil {
+			return
+		}
+		var handleEncoding []byte
+		partitionHandle.EncodeTo(&handleEncoding)
+		indexBlock.Add(p.lastKey, handleEncoding)
+	}
+	tb.writeBlock(indexBlock, handle)
+}
+
+// Abandon indicates that the contents of this builder should be abandoned.  Stops
+// using the file passed to the constructor after this function returns.
+// If the caller is not going to call Finish(), it must call Abandon()
+// before destroying this builder.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Abandon() {
+	if tb.closed {
+		panic("table builder is closed")
+	}
+	tb.closed = true
+}
+
+// NumEntries returns the number of calls to Add() so far.
+func (tb *TableBuilder) NumEntries() int {
+	return tb.numEntries
+}
+
+// FileSize returns the size of the file generated so far.  If invoked after a successful
+// Finish() call, returns the size of the final generated file.
+func (tb *TableBuilder) FileSize() uint64 {
+	return tb.offset
+}
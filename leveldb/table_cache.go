@@ -0,0 +1,103 @@
+package leveldb
+
+import "sync"
+
+type tableAndFile struct {
+	file  RandomAccessFile
+	table *Table
+}
+
+// tableCache keeps the tables of the DB open, keyed by file number.
+//
+// TODO: bound the number of open tables by Options.MaxOpenFiles with an
+// LRU cache.
+type tableCache struct {
+	env     *Env
+	dbname  string
+	options *Options
+
+	mu     sync.Mutex
+	tables map[uint64]*tableAndFile
+}
+
+func newTableCache(dbname string, options *Options) *tableCache {
+	return &tableCache{
+		env:     options.Env,
+		dbname:  dbname,
+		options: options,
+		tables:  make(map[uint64]*tableAndFile),
+	}
+}
+
+func (tc *tableCache) findTable(fileNumber, fileSize uint64) (*Table, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tf, ok := tc.tables[fileNumber]; ok {
+		return tf.table, nil
+	}
+
+	fname := TableFileName(tc.dbname, fileNumber)
+	file, err := tc.env.NewRandomAccessFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	table, err := OpenTable(tc.options, file, fileSize)
+	if err != nil {
+		file.Close()
+		// We do not cache error results so that if the error is transient,
+		// or somebody repairs the file, we recover automatically.
+		return nil, err
+	}
+	tc.tables[fileNumber] = &tableAndFile{file: file, table: table}
+	return table, nil
+}
+
+// NewIterator returns an iterator for the specified file number (the corresponding
+// file length must be exactly "fileSize" bytes).
+func (tc *tableCache) NewIterator(options *ReadOptions, fileNumber, fileSize uint64) Iterator {
+	table, err := tc.findTable(fileNumber, fileSize)
+	if err != nil {
+		return NewErrorIterator(err)
+	}
+	return table.NewIterator(options)
+}
+
+// RangeTombstones returns the range tombstones of the specified file number,
+// sorted by start.
+func (tc *tableCache) RangeTombstones(fileNumber, fileSize uint64) ([]rangeTombstone, error) {
+	table, err := tc.findTable(fileNumber, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	return table.rangeTombstones, nil
+}
+
+// Get calls handleResult with the found entry if a seek to internal key "k" in
+// specified file finds an entry.
+func (tc *tableCache) Get(options *ReadOptions, fileNumber, fileSize uint64, k []byte, handleResult func(k, v []byte) bool) error {
+	table, err := tc.findTable(fileNumber, fileSize)
+	if err != nil {
+		return err
+	}
+	return table.InternalGet(options, k, handleResult)
+}
+
+// Evict any entry for the specified file number. The file is not closed
+// here since iterators created before may still read from it, it is
+// closed once it becomes unreachable.
+func (tc *tableCache) Evict(fileNumber uint64) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.tables, fileNumber)
+}
+
+// Close releases all open tables
+func (tc *tableCache) Close() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for number, tf := range tc.tables {
+		tf.file.Close()
+		delete(tc.tables, number)
+	}
+}
@@ -0,0 +1,645 @@
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+func buildTestTable(t *testing.T, options *Options, n int) (*Table, func()) {
+	env := &Env{}
+	fname := filepath.Join(t.TempDir(), "000001.ldb")
+	file, err := env.NewWritableFile(fname)
+	assert.NoError(t, err)
+	builder := NewTableBuilder(options, file)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, builder.Add([]byte(fmt.Sprintf("k%05d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	assert.NoError(t, builder.Finish())
+	assert.Equal(t, n, builder.NumEntries())
+	assert.NoError(t, file.Close())
+
+	info, err := os.Stat(fname)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(info.Size()), builder.FileSize())
+
+	rfile, err := env.NewRandomAccessFile(fname)
+	assert.NoError(t, err)
+	table, err := OpenTable(options, rfile, builder.FileSize())
+	assert.NoError(t, err)
+	return table, func() { rfile.Close() }
+}
+
+func newTableTestOptions() *Options {
+	return &Options{
+		Comparator:           NewBytewiseComparator(),
+		BlockSize:            256,
+		BlockRestartInternal: 4,
+	}
+}
+
+func TestTable_Iterate(t *testing.T) {
+	const n = 1000
+	table, closeFn := buildTestTable(t, newTableTestOptions(), n)
+	defer closeFn()
+
+	iter := table.NewIterator(DefaultReadOptions)
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("k%05d", i), string(iter.Key()))
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(iter.Value()))
+		i++
+	}
+	assert.Equal(t, n, i)
+
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i--
+		assert.Equal(t, fmt.Sprintf("k%05d", i), string(iter.Key()))
+	}
+	assert.Equal(t, 0, i)
+
+	iter.Seek([]byte("k00500a"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "k00501", string(iter.Key()))
+}
+
+func TestTable_InternalGetWithFilter(t *testing.T) {
+	options := newTableTestOptions()
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	table, closeFn := buildTestTable(t, options, 100)
+	defer closeFn()
+
+	var found []byte
+	handle := func(k, v []byte) bool { found = append([]byte{}, v...); return false }
+	assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte("k00042"), handle))
+	assert.Equal(t, "v42", string(found))
+
+	found = nil
+	assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte("missing"), handle))
+	assert.Nil(t, found)
+}
+
+func TestTable_DataBlockHashIndex(t *testing.T) {
+	for _, format := range []Format{Format_Native, Format_LevelDB} {
+		options := newTableTestOptions()
+		options.DataBlockIndexType = DataBlockIndexType_BinarySearchAndHash
+		options.Format = format
+		table, closeFn := buildTestTable(t, options, 1000)
+
+		var found []byte
+		handle := func(k, v []byte) bool { found = append([]byte{}, v...); return false }
+		skipped := 0
+		for i := 0; i < 1000; i++ {
+			found = nil
+			assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d", i)), handle))
+			assert.Equal(t, fmt.Sprintf("v%d", i), string(found))
+
+			// Missing keys yield a following key, unless the hash index
+			// tells they are not in the block
+			found = nil
+			assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d.5", i)), handle))
+			if found == nil {
+				skipped++
+			}
+		}
+		assert.Greater(t, skipped, 250)
+
+		iter := table.NewIterator(DefaultReadOptions)
+		n := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			n++
+		}
+		assert.NoError(t, iter.Close())
+		assert.Equal(t, 1000, n)
+		closeFn()
+	}
+}
+
+func TestTable_ComparatorMismatch(t *testing.T) {
+	options := newTableTestOptions()
+	table, closeFn := buildTestTable(t, options, 100)
+	defer closeFn()
+	info, err := table.file.(*os.File).Stat()
+	assert.NoError(t, err)
+
+	_, err = OpenTable(options, table.file, uint64(info.Size()))
+	assert.NoError(t, err)
+
+	options.Comparator = NewReverseBytewiseComparator()
+	_, err = OpenTable(options, table.file, uint64(info.Size()))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	// The user comparator of internal keys is checked
+	options.Comparator = NewInternalKeyComparator(NewBytewiseComparator())
+	_, err = OpenTable(options, table.file, uint64(info.Size()))
+	assert.NoError(t, err)
+}
+
+// renamedComparator is a bytewise comparator under another name
+type renamedComparator struct {
+	Comparator
+	name string
+}
+
+func (c *renamedComparator) Name() string {
+	return c.name
+}
+
+func TestTable_ComparatorMetaEntry(t *testing.T) {
+	options := newTableTestOptions()
+	options.Comparator = &renamedComparator{NewBytewiseComparator(), "test.OtherComparator"}
+	table, closeFn := buildTestTable(t, options, 100)
+	defer closeFn()
+	info, err := table.file.(*os.File).Stat()
+	assert.NoError(t, err)
+
+	// The metaindex records the name of the comparator
+	contents, err := ReadBlock(table.file, &ReadOptions{}, &table.metaindexHandle, table.format)
+	assert.NoError(t, err)
+	iter := NewBlock(contents).NewIterator(NewBytewiseComparator())
+	iter.Seek([]byte(kComparatorMetaKey))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kComparatorMetaKey, string(iter.Key()))
+	assert.Equal(t, "test.OtherComparator", string(iter.Value()))
+	assert.NoError(t, iter.Close())
+
+	// which has to match although the keys are ordered the same
+	options.Comparator = NewBytewiseComparator()
+	_, err = OpenTable(options, table.file, uint64(info.Size()))
+	assert.True(t, err.(*LevelError).IsInvalidArgument())
+
+	// The tables of Format_LevelDB are not checked
+	options.Comparator = &renamedComparator{NewBytewiseComparator(), "test.OtherComparator"}
+	options.Format = Format_LevelDB
+	table, closeFn = buildTestTable(t, options, 100)
+	defer closeFn()
+	info, err = table.file.(*os.File).Stat()
+	assert.NoError(t, err)
+	options.Comparator = NewBytewiseComparator()
+	_, err = OpenTable(options, table.file, uint64(info.Size()))
+	assert.NoError(t, err)
+}
+
+func TestTable_FilterTypes(t *testing.T) {
+	for _, filterType := range []FilterType{FilterType_Block, FilterType_Full, FilterType_Partitioned} {
+		options := newTableTestOptions()
+		options.FilterPolicy = NewBloomFilterPolicy(10)
+		options.FilterType = filterType
+		options.MetadataBlockSize = 256
+		table, closeFn := buildTestTable(t, options, 1000)
+		file := &countingFile{RandomAccessFile: table.file}
+		table.file = file
+
+		var partitions int
+		switch filterType {
+		case FilterType_Block:
+			assert.IsType(t, &filterBlockReader{}, table.filter)
+		case FilterType_Full:
+			assert.IsType(t, &fullFilterBlockReader{}, table.filter)
+		case FilterType_Partitioned:
+			reader := table.filter.(*partitionedFilterBlockReader)
+			iter := reader.index.NewIterator(options.Comparator)
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				partitions++
+			}
+			iter.Close()
+			assert.Greater(t, partitions, 1)
+		}
+
+		var found []byte
+		handle := func(k, v []byte) bool { found = append([]byte{}, v...); return false }
+		for i := 0; i < 1000; i += 37 {
+			found = nil
+			assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d", i)), handle))
+			assert.Equal(t, fmt.Sprintf("v%d", i), string(found), filterType)
+		}
+
+		// Missing keys are ruled out without reading their data block
+		file.reads = 0
+		for i := 0; i < 1000; i++ {
+			assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d.", i)), handle))
+		}
+		// The partitions are read once for the lookups in key order
+		assert.Less(t, file.reads, partitions+50, filterType)
+		closeFn()
+	}
+}
+
+// keysFilterPolicy stores the keys of a filter, for testing which keys a
+// filter holds.
+type keysFilterPolicy struct{}
+
+func (f keysFilterPolicy) Name() string {
+	return "test.KeysFilter"
+}
+
+func (f keysFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
+	for _, key := range keys {
+		util.PutUvarint(dst, uint64(len(key)))
+		*dst = append(*dst, key...)
+	}
+}
+
+func (f keysFilterPolicy) KeyMayMatch(key, filter []byte) bool {
+	for _, k := range f.keys(filter) {
+		if string(k) == string(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f keysFilterPolicy) keys(filter []byte) []string {
+	var keys []string
+	for len(filter) > 0 {
+		n, m := util.DecodeUvarint(filter)
+		keys = append(keys, string(filter[m:m+int(n)]))
+		filter = filter[m+int(n):]
+	}
+	return keys
+}
+
+func TestTable_PartitionedIndex(t *testing.T) {
+	options := newTableTestOptions()
+	options.FilterPolicy = keysFilterPolicy{}
+	options.FilterType = FilterType_Partitioned
+	options.MetadataBlockSize = 256
+	table, closeFn := buildTestTable(t, options, 1000)
+	defer closeFn()
+	assert.NotNil(t, table.indexPartitions)
+	filter := table.filter.(*partitionedFilterBlockReader)
+
+	// Each filter partition holds the keys of the data blocks of the index
+	// partition of the same index key
+	var partitions int
+	index := table.indexBlock.NewIterator(options.Comparator)
+	filterIndex := filter.index.NewIterator(options.Comparator)
+	filterIndex.SeekToFirst()
+	for index.SeekToFirst(); index.Valid(); index.Next() {
+		assert.True(t, filterIndex.Valid())
+		assert.Equal(t, string(index.Key()), string(filterIndex.Key()))
+
+		var keys []string
+		var handle BlockHandle
+		_, err := handle.DecodeFrom(index.Value())
+		assert.NoError(t, err)
+		contents, err := table.indexPartitions.read(table, &handle)
+		assert.NoError(t, err)
+		blocks := NewTwoLevelIterator(NewBlock(contents).NewIterator(options.Comparator), func(indexValue []byte) Iterator {
+			return table.blockReader(DefaultReadOptions, indexValue)
+		})
+		for blocks.SeekToFirst(); blocks.Valid(); blocks.Next() {
+			keys = append(keys, string(blocks.Key()))
+		}
+		assert.NoError(t, blocks.Close())
+		assert.NotEmpty(t, keys)
+
+		_, err = handle.DecodeFrom(filterIndex.Value())
+		assert.NoError(t, err)
+		contents, err = filter.cache.read(table, &handle)
+		assert.NoError(t, err)
+		assert.Equal(t, keys, keysFilterPolicy{}.keys(contents.data))
+		partitions++
+		filterIndex.Next()
+	}
+	assert.False(t, filterIndex.Valid())
+	assert.NoError(t, index.Close())
+	assert.NoError(t, filterIndex.Close())
+	assert.Greater(t, partitions, 1)
+
+	// Lookups and iterations go through the index partitions
+	var found []byte
+	handle := func(k, v []byte) bool { found = append([]byte{}, v...); return false }
+	for i := 0; i < 1000; i += 7 {
+		found = nil
+		assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d", i)), handle))
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(found))
+	}
+	iter := table.NewIterator(DefaultReadOptions)
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("k%05d", i), string(iter.Key()))
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	for iter.Seek([]byte("k00500")); iter.Valid(); iter.Prev() {
+		i--
+	}
+	assert.Equal(t, 499, i)
+	assert.NoError(t, iter.Close())
+	assert.Greater(t, table.ApproximateOffsetOf([]byte("k00500")), uint64(0))
+}
+
+func TestTable_ApproximateOffsetOf(t *testing.T) {
+	table, closeFn := buildTestTable(t, newTableTestOptions(), 1000)
+	defer closeFn()
+
+	assert.Equal(t, uint64(0), table.ApproximateOffsetOf([]byte("a")))
+	mid := table.ApproximateOffsetOf([]byte("k00500"))
+	end := table.ApproximateOffsetOf([]byte("z"))
+	assert.Greater(t, mid, uint64(0))
+	assert.Greater(t, end, mid)
+}
+
+// countingFile counts the reads of the underlying file
+type countingFile struct {
+	RandomAccessFile
+	reads int
+}
+
+func (f *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	f.reads++
+	return f.RandomAccessFile.ReadAt(p, off)
+}
+
+func TestTable_IteratorBounds(t *testing.T) {
+	options := newTableTestOptions()
+	table, closeFn := buildTestTable(t, options, 1000)
+	defer closeFn()
+	file := &countingFile{RandomAccessFile: table.file}
+	table.file = file
+
+	// a full scan reads every data block
+	iter := table.NewIterator(DefaultReadOptions)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+	}
+	allBlocks := file.reads
+	assert.NoError(t, iter.Close())
+
+	file.reads = 0
+	iter = table.NewIterator(&ReadOptions{
+		LowerBound: []byte("k00100"),
+		UpperBound: []byte("k00110"),
+	})
+	n := 0
+	for iter.Seek([]byte("k00100")); iter.Valid() && string(iter.Key()) < "k00110"; iter.Next() {
+		n++
+	}
+	assert.Equal(t, 10, n)
+	// the iterator stops at the block holding the upper bound
+	for ; iter.Valid(); iter.Next() {
+	}
+	assert.Less(t, file.reads, 4)
+	assert.Less(t, file.reads, allBlocks)
+
+	file.reads = 0
+	iter.Seek([]byte("k00109"))
+	for ; iter.Valid(); iter.Prev() {
+	}
+	assert.Less(t, file.reads, 4)
+	assert.NoError(t, iter.Close())
+}
+
+func TestTable_PrefixSeek(t *testing.T) {
+	for _, filterType := range []FilterType{FilterType_Block, FilterType_Full, FilterType_Partitioned} {
+		testTablePrefixSeek(t, filterType)
+	}
+}
+
+func testTablePrefixSeek(t *testing.T, filterType FilterType) {
+	options := newTableTestOptions()
+	options.FilterType = filterType
+	options.MetadataBlockSize = 256
+	options.FilterPolicy = NewBloomFilterPolicy(10)
+	options.PrefixExtractor = NewFixedPrefixTransform(3)
+
+	env := &Env{}
+	fname := filepath.Join(t.TempDir(), "000001.ldb")
+	file, err := env.NewWritableFile(fname)
+	assert.NoError(t, err)
+	builder := NewTableBuilder(options, file)
+	for _, prefix := range []string{"aaa", "ccc", "eee"} {
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, builder.Add([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte("v")))
+		}
+	}
+	assert.NoError(t, builder.Finish())
+	assert.NoError(t, file.Close())
+
+	rfile, err := env.NewRandomAccessFile(fname)
+	assert.NoError(t, err)
+	defer rfile.Close()
+	table, err := OpenTable(options, rfile, builder.FileSize())
+	assert.NoError(t, err)
+	assert.True(t, table.prefixFiltered)
+
+	assert.True(t, table.prefixMayMatch([]byte("aaa000")))
+	assert.True(t, table.prefixMayMatch([]byte("ccc050")))
+	assert.True(t, table.prefixMayMatch([]byte("eee")))
+	assert.True(t, table.prefixMayMatch([]byte("z"))) // out of domain
+	assert.False(t, table.prefixMayMatch([]byte("bbb000")))
+	assert.False(t, table.prefixMayMatch([]byte("ddd")))
+	assert.False(t, table.prefixMayMatch([]byte("fff")))
+
+	iter := table.NewIterator(&ReadOptions{PrefixSameAsStart: true})
+	defer iter.Close()
+	iter.Seek([]byte("bbb"))
+	assert.False(t, iter.Valid())
+	iter.Seek([]byte("ccc"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "ccc000", string(iter.Key()))
+	iter.SeekToFirst()
+	assert.Equal(t, "aaa000", string(iter.Key()))
+
+	// A table read with another extractor does not use the prefix filters
+	options.PrefixExtractor = NewFixedPrefixTransform(2)
+	table, err = OpenTable(options, rfile, builder.FileSize())
+	assert.NoError(t, err)
+	assert.False(t, table.prefixFiltered)
+	assert.True(t, table.prefixMayMatch([]byte("bb")))
+}
+
+// renamedFilterPolicy is policy under another name, in every format.
+type renamedFilterPolicy struct {
+	FilterPolicy
+	name string
+}
+
+func (p *renamedFilterPolicy) Name() string {
+	return p.name
+}
+
+func (p *renamedFilterPolicy) forFormat(format Format) FilterPolicy {
+	return &renamedFilterPolicy{filterPolicyForFormat(p.FilterPolicy, format), p.name}
+}
+
+// newGoLevelDBBloomFilterPolicy returns the bloom filter policy of the
+// goleveldb package, which wrote the files of testdata/leveldb in the
+// format of the C++ library: the filters are the same, but the policy is
+// not suffixed with the version number 2.
+func newGoLevelDBBloomFilterPolicy() FilterPolicy {
+	return &renamedFilterPolicy{NewBloomFilterPolicy(10), "leveldb.BuiltinBloomFilter"}
+}
+
+func TestTable_LevelDBFormat(t *testing.T) {
+	// testdata/leveldb/table.ldb holds k00000..k00999, written with the
+	// default block options, no compression and a bloom filter
+	const golden = "testdata/leveldb/table.ldb"
+	options := &Options{
+		Comparator:           NewBytewiseComparator(),
+		FilterPolicy:         newGoLevelDBBloomFilterPolicy(),
+		BlockSize:            4 << 10,
+		BlockRestartInternal: 16,
+		Compression:          CompressionType_NoCompression,
+		Format:               Format_LevelDB,
+	}
+
+	// Reading the table of the C++ library
+	env := &Env{}
+	info, err := os.Stat(golden)
+	assert.NoError(t, err)
+	rfile, err := env.NewRandomAccessFile(golden)
+	assert.NoError(t, err)
+	defer rfile.Close()
+	table, err := OpenTable(options, rfile, uint64(info.Size()))
+	assert.NoError(t, err)
+	assert.Equal(t, Format_LevelDB, table.format)
+	iter := table.NewIterator(DefaultReadOptions)
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("k%05d", i), string(iter.Key()))
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(iter.Value()))
+		i++
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, 1000, i)
+	for i := 0; i < 100; i += 10 { // keys of the first data block
+		assert.True(t, table.filter.KeyMayMatch(0, []byte(fmt.Sprintf("k%05d", i))))
+	}
+
+	// Writing the same table
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	built, closeFile := buildTestTable(t, options, 1000)
+	defer closeFile()
+	actual := make([]byte, len(expected)+1)
+	n, _ := built.file.ReadAt(actual, 0)
+	assert.Equal(t, expected, actual[:n])
+}
+
+// TestTable_LevelDBFilterName checks the tables written with the bloom
+// filter policy under the name of the C++ library,
+// "leveldb.BuiltinBloomFilter2".  Both libraries write the same blocks as
+// goleveldb and only name the filter differently in the metaindex.
+func TestTable_LevelDBFilterName(t *testing.T) {
+	const golden = "testdata/leveldb/table.ldb"
+	options := &Options{
+		Comparator:           NewBytewiseComparator(),
+		FilterPolicy:         NewBloomFilterPolicy(10),
+		BlockSize:            4 << 10,
+		BlockRestartInternal: 16,
+		Compression:          CompressionType_NoCompression,
+		Format:               Format_LevelDB,
+	}
+	assert.Equal(t, "leveldb.BuiltinBloomFilter2", options.FilterPolicy.Name())
+	built, closeFile := buildTestTable(t, options, 1000)
+	defer closeFile()
+	assert.NotNil(t, built.filter)
+	for i := 0; i < 100; i += 10 {
+		assert.True(t, built.filter.KeyMayMatch(0, []byte(fmt.Sprintf("k%05d", i))))
+	}
+
+	// The data and filter blocks, up to the metaindex, are the golden ones
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	info, err := built.file.(*os.File).Stat()
+	assert.NoError(t, err)
+	actual := make([]byte, info.Size())
+	_, err = built.file.ReadAt(actual, 0)
+	assert.NoError(t, err)
+	metaindexOffset := built.metaindexHandle.Offset()
+	assert.Equal(t, expected[:metaindexOffset], actual[:metaindexOffset])
+
+	// whose filter is not found under the other name
+	options.FilterPolicy = newGoLevelDBBloomFilterPolicy()
+	table, err := OpenTable(options, built.file, uint64(info.Size()))
+	assert.NoError(t, err)
+	assert.Nil(t, table.filter)
+}
+
+// skipWithoutGolden skips the test if the golden file of the C++ library
+// at path has not been generated, see testdata/leveldb/README.md.
+func skipWithoutGolden(t *testing.T, path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		t.Skip(path + " is not generated, see testdata/leveldb/compat.sh")
+	}
+}
+
+func TestTable_CppLevelDBFormat(t *testing.T) {
+	// testdata/leveldb/cpp/table.ldb holds k00000..k00999, written by the
+	// C++ library with snappy compression and its bloom filter
+	const golden = "testdata/leveldb/cpp/table.ldb"
+	skipWithoutGolden(t, golden)
+	options := &Options{
+		Comparator:   NewBytewiseComparator(),
+		FilterPolicy: NewBloomFilterPolicy(10),
+		Format:       Format_LevelDB,
+	}
+	env := &Env{}
+	info, err := os.Stat(golden)
+	assert.NoError(t, err)
+	rfile, err := env.NewRandomAccessFile(golden)
+	assert.NoError(t, err)
+	defer rfile.Close()
+	table, err := OpenTable(options, rfile, uint64(info.Size()))
+	assert.NoError(t, err)
+	assert.Equal(t, Format_LevelDB, table.format)
+
+	// The data blocks are snappy compressed
+	index := table.newIndexIterator()
+	index.SeekToFirst()
+	assert.True(t, index.Valid())
+	var handle BlockHandle
+	_, err = handle.DecodeFrom(index.Value())
+	assert.NoError(t, err)
+	assert.NoError(t, index.Close())
+	compression := make([]byte, 1)
+	_, err = rfile.ReadAt(compression, int64(handle.Offset()+handle.Size()))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(CompressionType_Snappy), compression[0])
+
+	iter := table.NewIterator(DefaultReadOptions)
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("k%05d", i), string(iter.Key()))
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(iter.Value()))
+		i++
+	}
+	assert.NoError(t, iter.Close())
+	assert.Equal(t, 1000, i)
+
+	// The filter is found under "leveldb.BuiltinBloomFilter2"
+	assert.NotNil(t, table.filter)
+	var found []byte
+	get := func(k, v []byte) bool { found = append([]byte{}, v...); return false }
+	for i := 0; i < 1000; i += 37 {
+		found = nil
+		assert.NoError(t, table.InternalGet(DefaultReadOptions, []byte(fmt.Sprintf("k%05d", i)), get))
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(found))
+	}
+}
+
+// TestTable_LevelDBFormatOutput checks that the table of
+// testdata/leveldb/go, which compat.sh checks that the C++ library reads,
+// is still the table written in Format_LevelDB.
+func TestTable_LevelDBFormatOutput(t *testing.T) {
+	const golden = "testdata/leveldb/go/table.ldb"
+	options := &Options{
+		Comparator:           NewBytewiseComparator(),
+		FilterPolicy:         NewBloomFilterPolicy(10),
+		BlockSize:            4 << 10,
+		BlockRestartInternal: 16,
+		Format:               Format_LevelDB,
+	}
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	built, closeFile := buildTestTable(t, options, 1000)
+	defer closeFile()
+	actual := make([]byte, len(expected)+1)
+	n, _ := built.file.ReadAt(actual, 0)
+	assert.Equal(t, expected, actual[:n])
+}
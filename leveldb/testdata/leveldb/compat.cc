@@ -0,0 +1,157 @@
+// compat writes the files of testdata/leveldb/cpp with the C++ LevelDB
+// library, and checks that the library reads the files of
+// testdata/leveldb/go, written by the Go package in Format_LevelDB.  See
+// compat.sh.
+//
+//	compat generate DIR   writes DIR/db and DIR/table.ldb
+//	compat verify DIR     reads DIR/db and DIR/table.ldb, DIR/db is modified
+#include <cstdio>
+#include <cstdlib>
+#include <string>
+
+#include "leveldb/db.h"
+#include "leveldb/env.h"
+#include "leveldb/filter_policy.h"
+#include "leveldb/iterator.h"
+#include "leveldb/options.h"
+#include "leveldb/table.h"
+#include "leveldb/table_builder.h"
+
+namespace {
+
+void Check(const leveldb::Status& s, const std::string& what) {
+  if (!s.ok()) {
+    std::fprintf(stderr, "%s: %s\n", what.c_str(), s.ToString().c_str());
+    std::exit(1);
+  }
+}
+
+void Expect(bool cond, const std::string& what) {
+  if (!cond) {
+    std::fprintf(stderr, "unexpected %s\n", what.c_str());
+    std::exit(1);
+  }
+}
+
+std::string Format(const char* format, int i) {
+  char buf[32];
+  std::snprintf(buf, sizeof(buf), format, i);
+  return buf;
+}
+
+// The database holds key000..key199, compacted to snappy compressed tables
+// with bloom filters, then the log deletes the keys multiple of 10 and sets
+// the keys ending in 5 to new%03d.
+void GenerateDB(const std::string& dbname, const leveldb::FilterPolicy* policy) {
+  leveldb::Options options;
+  options.create_if_missing = true;
+  options.error_if_exists = true;
+  options.filter_policy = policy;
+  options.compression = leveldb::kSnappyCompression;
+  leveldb::DB* db;
+  Check(leveldb::DB::Open(options, dbname, &db), "open " + dbname);
+  for (int i = 0; i < 200; i++) {
+    Check(db->Put(leveldb::WriteOptions(), Format("key%03d", i), Format("value%03d", i)), "put");
+  }
+  db->CompactRange(nullptr, nullptr);
+  for (int i = 0; i < 200; i++) {
+    if (i % 10 == 0) {
+      Check(db->Delete(leveldb::WriteOptions(), Format("key%03d", i)), "delete");
+    } else if (i % 10 == 5) {
+      Check(db->Put(leveldb::WriteOptions(), Format("key%03d", i), Format("new%03d", i)), "put");
+    }
+  }
+  delete db;
+}
+
+void VerifyDB(const std::string& dbname, const leveldb::FilterPolicy* policy) {
+  leveldb::Options options;
+  options.filter_policy = policy;
+  leveldb::DB* db;
+  Check(leveldb::DB::Open(options, dbname, &db), "open " + dbname);
+  for (int i = 0; i < 200; i++) {
+    std::string key = Format("key%03d", i), value;
+    leveldb::Status s = db->Get(leveldb::ReadOptions(), key, &value);
+    if (i % 10 == 0) {
+      Expect(s.IsNotFound(), "value of " + key);
+    } else {
+      Check(s, "get " + key);
+      Expect(value == Format(i % 10 == 5 ? "new%03d" : "value%03d", i), "value of " + key);
+    }
+  }
+  leveldb::Iterator* iter = db->NewIterator(leveldb::ReadOptions());
+  int n = 0;
+  for (iter->SeekToFirst(); iter->Valid(); iter->Next()) {
+    n++;
+  }
+  Check(iter->status(), "iterate " + dbname);
+  Expect(n == 180, "number of keys in " + dbname);
+  delete iter;
+  delete db;
+}
+
+// The table holds k00000..k00999, written with the default block options,
+// snappy compression and a bloom filter.
+void GenerateTable(const std::string& fname, const leveldb::FilterPolicy* policy) {
+  leveldb::Options options;
+  options.filter_policy = policy;
+  options.compression = leveldb::kSnappyCompression;
+  leveldb::WritableFile* file;
+  Check(leveldb::Env::Default()->NewWritableFile(fname, &file), "create " + fname);
+  leveldb::TableBuilder builder(options, file);
+  for (int i = 0; i < 1000; i++) {
+    builder.Add(Format("k%05d", i), Format("v%d", i));
+  }
+  Check(builder.Finish(), "build " + fname);
+  Check(file->Close(), "close " + fname);
+  delete file;
+}
+
+void VerifyTable(const std::string& fname, const leveldb::FilterPolicy* policy) {
+  leveldb::Env* env = leveldb::Env::Default();
+  uint64_t size;
+  Check(env->GetFileSize(fname, &size), "size of " + fname);
+  leveldb::RandomAccessFile* file;
+  Check(env->NewRandomAccessFile(fname, &file), "open " + fname);
+  leveldb::Options options;
+  options.filter_policy = policy;
+  options.paranoid_checks = true;
+  leveldb::Table* table;
+  Check(leveldb::Table::Open(options, file, size, &table), "open table " + fname);
+  leveldb::ReadOptions read_options;
+  read_options.verify_checksums = true;
+  leveldb::Iterator* iter = table->NewIterator(read_options);
+  int i = 0;
+  for (iter->SeekToFirst(); iter->Valid(); iter->Next(), i++) {
+    Expect(iter->key().ToString() == Format("k%05d", i), "key " + iter->key().ToString());
+    Expect(iter->value().ToString() == Format("v%d", i), "value " + iter->value().ToString());
+  }
+  Check(iter->status(), "iterate " + fname);
+  Expect(i == 1000, "number of keys in " + fname);
+  delete iter;
+  delete table;
+  delete file;
+}
+
+}  // namespace
+
+int main(int argc, char** argv) {
+  if (argc != 3) {
+    std::fprintf(stderr, "usage: %s generate|verify DIR\n", argv[0]);
+    return 2;
+  }
+  const std::string command = argv[1], dir = argv[2];
+  const leveldb::FilterPolicy* policy = leveldb::NewBloomFilterPolicy(10);
+  if (command == "generate") {
+    GenerateDB(dir + "/db", policy);
+    GenerateTable(dir + "/table.ldb", policy);
+  } else if (command == "verify") {
+    VerifyTable(dir + "/table.ldb", policy);
+    VerifyDB(dir + "/db", policy);
+  } else {
+    std::fprintf(stderr, "unknown command %s\n", command.c_str());
+    return 2;
+  }
+  delete policy;
+  return 0;
+}
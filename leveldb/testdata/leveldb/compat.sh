@@ -0,0 +1,37 @@
+#!/bin/sh
+# Checks the on-disk compatibility of Format_LevelDB with the C++ LevelDB
+# library, see README.md:
+#
+#   - writes cpp/, a database and a table of the C++ library, which the Go
+#     tests read;
+#   - reads go/, the database and the table written by the Go package, with
+#     the C++ library.
+#
+# Usage: compat.sh LEVELDB_DIR
+#
+# LEVELDB_DIR is a checkout of https://github.com/google/leveldb built with
+# snappy (libsnappy-dev) in LEVELDB_DIR/build:
+#
+#   cmake -S LEVELDB_DIR -B LEVELDB_DIR/build -DCMAKE_BUILD_TYPE=Release \
+#       -DLEVELDB_BUILD_TESTS=OFF -DLEVELDB_BUILD_BENCHMARKS=OFF
+#   cmake --build LEVELDB_DIR/build
+set -e
+
+leveldb=$(cd "${1:?usage: compat.sh LEVELDB_DIR}" && pwd)
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+${CXX:-g++} -std=c++17 -O1 -I"$leveldb/include" -o "$tmp/compat" compat.cc \
+    "$leveldb/build/libleveldb.a" -lsnappy -lpthread
+
+rm -rf cpp
+mkdir cpp
+"$tmp/compat" generate cpp
+# Only the files of the database are checked in
+rm -f cpp/db/LOCK cpp/db/LOG cpp/db/LOG.old
+
+# Opening a database writes to it, the C++ library reads a copy of go/
+cp -R go "$tmp/go"
+"$tmp/compat" verify "$tmp/go"
+echo "compat: the C++ library read go/ and wrote cpp/"
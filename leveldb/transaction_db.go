@@ -0,0 +1,353 @@
+package leveldb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+// TransactionDB is a DB whose transactions lock the keys they write or read
+// with GetForUpdate until they end, instead of checking for conflicts when
+// they commit like the optimistic ones of DB.BeginOptimisticTransaction.
+// This spares the retries of the optimistic transactions on the keys many
+// of them update.
+//
+// A transaction waits for the lock of a key held by another one for up to
+// its lock timeout, and fails with a Code_Busy error at once if waiting
+// would deadlock.  The writes through the TransactionDB lock their keys as
+// well, the writes to the base DB do not.
+type TransactionDB struct {
+	db        *DB
+	options   TransactionDBOptions
+	locks     *lockManager
+	lastTxnID atomic.Uint64
+}
+
+// OpenTransactionDB opens the database with the specified "name" as Open,
+// for transactions.
+func OpenTransactionDB(options *Options, txnDBOptions *TransactionDBOptions, dbname string) (*TransactionDB, error) {
+	db, err := Open(options, dbname)
+	if err != nil {
+		return nil, err
+	}
+	tdb := &TransactionDB{db: db, options: *txnDBOptions}
+	if tdb.options.NumStripes <= 0 {
+		tdb.options.NumStripes = DefaultTransactionDBOptions.NumStripes
+	}
+	tdb.locks = newLockManager(tdb.options.NumStripes)
+	return tdb, nil
+}
+
+// BaseDB returns the DB of the TransactionDB, e.g. to manage its column
+// families.  Its writes do not lock keys.
+func (tdb *TransactionDB) BaseDB() *DB {
+	return tdb.db
+}
+
+// Close closes the DB, the transactions must have ended.
+func (tdb *TransactionDB) Close() error {
+	return tdb.db.Close()
+}
+
+// Get returns the value for "key" in the DB, without locking it.
+func (tdb *TransactionDB) Get(options *ReadOptions, key []byte) ([]byte, error) {
+	return tdb.db.Get(options, key)
+}
+
+// Put sets the entry for "key" to "value" once it is locked.
+func (tdb *TransactionDB) Put(options *WriteOptions, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return tdb.Write(options, batch)
+}
+
+// Delete removes the entry for "key" once it is locked.
+func (tdb *TransactionDB) Delete(options *WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return tdb.Write(options, batch)
+}
+
+// Write applies "updates" once the keys they write are locked, not the
+// ranges of their DeleteRange records.
+func (tdb *TransactionDB) Write(options *WriteOptions, updates *WriteBatch) error {
+	keys := batchKeyCollector{}
+	if err := updates.Iterate(&keys); err != nil {
+		return err
+	}
+	txn := tdb.BeginTransaction(DefaultTransactionOptions)
+	defer txn.end()
+	for _, key := range keys {
+		if err := txn.lock(key.columnFamily, key.key); err != nil {
+			return err
+		}
+	}
+	return tdb.db.Write(options, updates)
+}
+
+// Transaction is a transaction of a TransactionDB: it locks the keys it
+// writes or reads with GetForUpdate until it ends, and buffers its writes in
+// a WriteBatch applied atomically by Commit.  Reads see the latest state of
+// the DB, unless ReadOptions.Snapshot is set, and the writes of the
+// transaction.
+//
+// Savepoints mark states of the transaction to roll back to, the locks
+// taken since the savepoint are released then.
+//
+// A Transaction is not safe for concurrent use.
+type Transaction struct {
+	db          *TransactionDB
+	id          uint64
+	lockTimeout time.Duration
+	batch       *WriteBatch
+	// The values of the keys written by the transaction by column family,
+	// nil for the deleted ones, so that it reads its own writes
+	writes map[uint32]map[string][]byte
+	// The keys locked by the transaction, in locking order
+	locked    []string
+	lockedSet map[string]struct{}
+	// The number of keys locked at the savepoints
+	savePoints []int
+	done       bool
+}
+
+// BeginTransaction begins a transaction, see Transaction.  It must end with
+// Commit or Rollback.
+func (tdb *TransactionDB) BeginTransaction(options *TransactionOptions) *Transaction {
+	lockTimeout := tdb.options.LockTimeout
+	if options.LockTimeout != nil {
+		lockTimeout = *options.LockTimeout
+	}
+	return &Transaction{
+		db:          tdb,
+		id:          tdb.lastTxnID.Add(1),
+		lockTimeout: lockTimeout,
+		batch:       NewWriteBatch(),
+		writes:      make(map[uint32]map[string][]byte),
+		lockedSet:   make(map[string]struct{}),
+	}
+}
+
+// Get returns the value for "key" written by the transaction, or else the
+// one of the DB.  The key is not locked.
+func (txn *Transaction) Get(options *ReadOptions, key []byte) ([]byte, error) {
+	return txn.GetCF(options, txn.db.db.defaultColumnFamily, key)
+}
+
+// GetCF is Get in the column family cf.
+func (txn *Transaction) GetCF(options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	if txn.done {
+		return nil, errTxnDone
+	}
+	if value, ok := txn.writes[cf.ID()][string(key)]; ok {
+		if value == nil {
+			return nil, Error(Code_NotFound, "")
+		}
+		return append([]byte{}, value...), nil
+	}
+	return txn.db.db.GetCF(options, cf, key)
+}
+
+// GetForUpdate locks "key", then returns its value as Get.
+func (txn *Transaction) GetForUpdate(options *ReadOptions, key []byte) ([]byte, error) {
+	return txn.GetForUpdateCF(options, txn.db.db.defaultColumnFamily, key)
+}
+
+// GetForUpdateCF is GetForUpdate in the column family cf.
+func (txn *Transaction) GetForUpdateCF(options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	if txn.done {
+		return nil, errTxnDone
+	}
+	if err := txn.lock(cf.ID(), key); err != nil {
+		return nil, err
+	}
+	return txn.GetCF(options, cf, key)
+}
+
+// Put locks "key", then sets its entry to "value" once the transaction
+// commits.
+func (txn *Transaction) Put(key, value []byte) error {
+	return txn.PutCF(txn.db.db.defaultColumnFamily, key, value)
+}
+
+// PutCF is Put in the column family cf.
+func (txn *Transaction) PutCF(cf *ColumnFamilyHandle, key, value []byte) error {
+	if txn.done {
+		return errTxnDone
+	}
+	if err := txn.lock(cf.ID(), key); err != nil {
+		return err
+	}
+	txn.batch.PutCF(cf, key, value)
+	txn.setWrite(cf.ID(), key, append([]byte{}, value...))
+	return nil
+}
+
+// Delete locks "key", then removes its entry once the transaction commits.
+func (txn *Transaction) Delete(key []byte) error {
+	return txn.DeleteCF(txn.db.db.defaultColumnFamily, key)
+}
+
+// DeleteCF is Delete in the column family cf.
+func (txn *Transaction) DeleteCF(cf *ColumnFamilyHandle, key []byte) error {
+	if txn.done {
+		return errTxnDone
+	}
+	if err := txn.lock(cf.ID(), key); err != nil {
+		return err
+	}
+	txn.batch.DeleteCF(cf, key)
+	txn.setWrite(cf.ID(), key, nil)
+	return nil
+}
+
+// SetSavePoint records the state of the transaction, to which
+// RollbackToSavePoint returns.  Savepoints nest.
+func (txn *Transaction) SetSavePoint() error {
+	if txn.done {
+		return errTxnDone
+	}
+	txn.batch.SetSavePoint()
+	txn.savePoints = append(txn.savePoints, len(txn.locked))
+	return nil
+}
+
+// RollbackToSavePoint discards the writes since the last savepoint, releases
+// the locks taken since and removes the savepoint.  It returns a
+// Code_NotFound error if there is none.
+func (txn *Transaction) RollbackToSavePoint() error {
+	if txn.done {
+		return errTxnDone
+	}
+	if err := txn.batch.RollbackToSavePoint(); err != nil {
+		return err
+	}
+	numLocked := txn.savePoints[len(txn.savePoints)-1]
+	txn.savePoints = txn.savePoints[:len(txn.savePoints)-1]
+	for _, key := range txn.locked[numLocked:] {
+		txn.db.locks.unlock(txn.id, key)
+		delete(txn.lockedSet, key)
+	}
+	txn.locked = txn.locked[:numLocked]
+
+	// The writes left are the ones of the batch
+	txn.writes = make(map[uint32]map[string][]byte)
+	return txn.batch.Iterate(txnWriteRecorder{txn})
+}
+
+// Commit applies the writes of the transaction atomically and releases its
+// locks.  The transaction is over either way.
+func (txn *Transaction) Commit(options *WriteOptions) error {
+	if txn.done {
+		return errTxnDone
+	}
+	defer txn.end()
+	if txn.batch.Count() == 0 {
+		return nil
+	}
+	return txn.db.db.Write(options, txn.batch)
+}
+
+// Rollback discards the writes of the transaction and releases its locks,
+// the transaction is over.
+func (txn *Transaction) Rollback() error {
+	if txn.done {
+		return errTxnDone
+	}
+	txn.end()
+	return nil
+}
+
+// lock locks the key of the column family with id columnFamily for the
+// transaction, once.
+func (txn *Transaction) lock(columnFamily uint32, key []byte) error {
+	lockKey := string(util.EncodeUint32Fixed(columnFamily)) + string(key)
+	if _, ok := txn.lockedSet[lockKey]; ok {
+		return nil
+	}
+	if err := txn.db.locks.lock(txn.id, lockKey, txn.lockTimeout); err != nil {
+		return err
+	}
+	txn.locked = append(txn.locked, lockKey)
+	txn.lockedSet[lockKey] = struct{}{}
+	return nil
+}
+
+func (txn *Transaction) setWrite(columnFamily uint32, key, value []byte) {
+	values := txn.writes[columnFamily]
+	if values == nil {
+		values = make(map[string][]byte)
+		txn.writes[columnFamily] = values
+	}
+	values[string(key)] = value
+}
+
+func (txn *Transaction) end() {
+	txn.done = true
+	for _, key := range txn.locked {
+		txn.db.locks.unlock(txn.id, key)
+	}
+	txn.batch.Clear()
+	txn.writes = nil
+	txn.locked = nil
+	txn.lockedSet = nil
+	txn.savePoints = nil
+}
+
+// txnWriteRecorder records the writes of the batch of a transaction,
+// which only holds puts and deletions.
+type txnWriteRecorder struct {
+	txn *Transaction
+}
+
+var _ WriteBatchHandler = txnWriteRecorder{}
+
+func (r txnWriteRecorder) Put(columnFamily uint32, key, value []byte) {
+	r.txn.setWrite(columnFamily, key, append([]byte{}, value...))
+}
+
+func (r txnWriteRecorder) Delete(columnFamily uint32, key []byte) {
+	r.txn.setWrite(columnFamily, key, nil)
+}
+
+func (r txnWriteRecorder) PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64) {}
+
+func (r txnWriteRecorder) Merge(columnFamily uint32, key, operand []byte) {}
+
+func (r txnWriteRecorder) DeleteRange(columnFamily uint32, begin, end []byte) {}
+
+func (r txnWriteRecorder) SingleDelete(columnFamily uint32, key []byte) {}
+
+type batchKey struct {
+	columnFamily uint32
+	key          []byte
+}
+
+// batchKeyCollector collects the keys a WriteBatch writes, range deletions
+// aside.
+type batchKeyCollector []batchKey
+
+var _ WriteBatchHandler = (*batchKeyCollector)(nil)
+
+func (c *batchKeyCollector) Put(columnFamily uint32, key, value []byte) {
+	*c = append(*c, batchKey{columnFamily, key})
+}
+
+func (c *batchKeyCollector) Delete(columnFamily uint32, key []byte) {
+	*c = append(*c, batchKey{columnFamily, key})
+}
+
+func (c *batchKeyCollector) PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64) {
+	*c = append(*c, batchKey{columnFamily, key})
+}
+
+func (c *batchKeyCollector) Merge(columnFamily uint32, key, operand []byte) {
+	*c = append(*c, batchKey{columnFamily, key})
+}
+
+func (c *batchKeyCollector) DeleteRange(columnFamily uint32, begin, end []byte) {}
+
+func (c *batchKeyCollector) SingleDelete(columnFamily uint32, key []byte) {
+	*c = append(*c, batchKey{columnFamily, key})
+}
@@ -0,0 +1,272 @@
+package leveldb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestTransactionDB(t *testing.T, lockTimeout time.Duration) *TransactionDB {
+	options := newTestOptions()
+	options.CreateIfMissing = true
+	txnDBOptions := *DefaultTransactionDBOptions
+	txnDBOptions.LockTimeout = lockTimeout
+	tdb, err := OpenTransactionDB(options, &txnDBOptions, t.TempDir())
+	assert.NoError(t, err)
+	return tdb
+}
+
+func transactionGet(t *testing.T, txn *Transaction, key string) string {
+	value, err := txn.Get(DefaultReadOptions, []byte(key))
+	if err != nil {
+		assert.True(t, err.(*LevelError).IsNotFound())
+		return "NOT_FOUND"
+	}
+	return string(value)
+}
+
+func TestTransactionDB_Commit(t *testing.T) {
+	tdb := openTestTransactionDB(t, time.Second)
+	defer tdb.Close()
+	db := tdb.BaseDB()
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("a"), []byte("v1")))
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("b"), []byte("v1")))
+
+	txn := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v2")))
+	assert.NoError(t, txn.Delete([]byte("b")))
+	assert.Equal(t, "v2", transactionGet(t, txn, "a"))
+	assert.Equal(t, "NOT_FOUND", transactionGet(t, txn, "b"))
+	assert.Equal(t, "v1", dbGet(t, db, "a"))
+
+	// Reads see the latest state of the DB
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("c"), []byte("v1")))
+	assert.Equal(t, "v1", transactionGet(t, txn, "c"))
+
+	assert.NoError(t, txn.Commit(DefaultWriteOptions))
+	assert.Equal(t, "v2", dbGet(t, db, "a"))
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "b"))
+	assert.True(t, txn.Put([]byte("a"), []byte("v3")).(*LevelError).IsInvalidArgument())
+	assert.True(t, txn.Commit(DefaultWriteOptions).(*LevelError).IsInvalidArgument())
+
+	// The locks are released, by a rollback as well
+	txn = tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v3")))
+	assert.NoError(t, txn.Rollback())
+	assert.Equal(t, "v2", dbGet(t, db, "a"))
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("a"), []byte("v4")))
+	assert.NoError(t, tdb.Delete(DefaultWriteOptions, []byte("a")))
+	assert.Equal(t, "NOT_FOUND", dbGet(t, db, "a"))
+}
+
+func TestTransactionDB_LockTimeout(t *testing.T) {
+	tdb := openTestTransactionDB(t, 10*time.Millisecond)
+	defer tdb.Close()
+
+	txn1 := tdb.BeginTransaction(DefaultTransactionOptions)
+	_, err := txn1.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+
+	// Writes and reads for update of the key time out, plain reads do not
+	// lock it
+	txn2 := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.True(t, txn2.Put([]byte("a"), []byte("txn2")).(*LevelError).IsTimedOut())
+	_, err = txn2.GetForUpdate(DefaultReadOptions, []byte("a"))
+	assert.True(t, err.(*LevelError).IsTimedOut())
+	assert.Equal(t, "NOT_FOUND", transactionGet(t, txn2, "a"))
+	assert.True(t, tdb.Put(DefaultWriteOptions, []byte("a"), []byte("v")).(*LevelError).IsTimedOut())
+	assert.NoError(t, txn2.Put([]byte("b"), []byte("txn2")))
+
+	// A negative timeout waits until the lock is released
+	forever := time.Duration(-1)
+	txn3 := tdb.BeginTransaction(&TransactionOptions{LockTimeout: &forever})
+	locked := make(chan error)
+	go func() {
+		locked <- txn3.Put([]byte("a"), []byte("txn3"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, txn1.Commit(DefaultWriteOptions))
+	assert.NoError(t, <-locked)
+	assert.NoError(t, txn3.Commit(DefaultWriteOptions))
+	assert.NoError(t, txn2.Commit(DefaultWriteOptions))
+	assert.Equal(t, "txn3", dbGet(t, tdb.BaseDB(), "a"))
+	assert.Equal(t, "txn2", dbGet(t, tdb.BaseDB(), "b"))
+}
+
+func TestTransactionDB_Deadlock(t *testing.T) {
+	tdb := openTestTransactionDB(t, -1)
+	defer tdb.Close()
+
+	txn1 := tdb.BeginTransaction(DefaultTransactionOptions)
+	txn2 := tdb.BeginTransaction(DefaultTransactionOptions)
+	txn3 := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.NoError(t, txn1.Put([]byte("a"), []byte("txn1")))
+	assert.NoError(t, txn2.Put([]byte("b"), []byte("txn2")))
+	assert.NoError(t, txn3.Put([]byte("c"), []byte("txn3")))
+
+	// txn1 waits for txn2, which waits for txn3
+	locked1, locked2 := make(chan error), make(chan error)
+	go func() {
+		locked1 <- txn1.Put([]byte("b"), []byte("txn1"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		locked2 <- txn2.Put([]byte("c"), []byte("txn2"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Waiting for txn1 would close the cycle
+	assert.True(t, txn3.Put([]byte("a"), []byte("txn3")).(*LevelError).IsBusy())
+	assert.NoError(t, txn3.Rollback())
+	assert.NoError(t, <-locked2)
+	assert.NoError(t, txn2.Commit(DefaultWriteOptions))
+	assert.NoError(t, <-locked1)
+	assert.NoError(t, txn1.Commit(DefaultWriteOptions))
+	assert.Equal(t, "txn1", dbGet(t, tdb.BaseDB(), "b"))
+	assert.Equal(t, "txn2", dbGet(t, tdb.BaseDB(), "c"))
+	assert.Empty(t, tdb.locks.waitFor)
+}
+
+func TestTransactionDB_NoWait(t *testing.T) {
+	tdb := openTestTransactionDB(t, -1)
+	defer tdb.Close()
+
+	txn1 := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.NoError(t, txn1.Put([]byte("a"), []byte("txn1")))
+	// A zero timeout overrides the one of the DB
+	noWait := time.Duration(0)
+	txn2 := tdb.BeginTransaction(&TransactionOptions{LockTimeout: &noWait})
+	assert.True(t, txn2.Put([]byte("a"), []byte("txn2")).(*LevelError).IsTimedOut())
+	assert.NoError(t, txn2.Rollback())
+	assert.NoError(t, txn1.Commit(DefaultWriteOptions))
+}
+
+// TestTransactionDB_ReleasedLockWait checks that the wait for a lock ends in
+// the wait-for graph once the lock is released, before the waiter runs.
+func TestTransactionDB_ReleasedLockWait(t *testing.T) {
+	tdb := openTestTransactionDB(t, -1)
+	defer tdb.Close()
+
+	for i := 0; i < 20; i++ {
+		txn1 := tdb.BeginTransaction(DefaultTransactionOptions)
+		txn2 := tdb.BeginTransaction(DefaultTransactionOptions)
+		assert.NoError(t, txn2.Put([]byte("b"), []byte("txn2")))
+		assert.NoError(t, txn1.SetSavePoint())
+		assert.NoError(t, txn1.Put([]byte("a"), []byte("txn1")))
+
+		// txn2 waits for txn1
+		locked := make(chan error)
+		go func() {
+			err := txn2.Put([]byte("a"), []byte("txn2"))
+			if err == nil {
+				err = txn2.Commit(DefaultWriteOptions)
+			}
+			locked <- err
+		}()
+		for {
+			tdb.locks.waitMu.Lock()
+			_, waiting := tdb.locks.waitFor[txn2.id]
+			tdb.locks.waitMu.Unlock()
+			if waiting {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// Once txn1 released the lock, waiting for txn2 is no deadlock
+		assert.NoError(t, txn1.RollbackToSavePoint())
+		assert.NoError(t, txn1.Put([]byte("b"), []byte("txn1")))
+		assert.NoError(t, <-locked)
+		assert.NoError(t, txn1.Commit(DefaultWriteOptions))
+		assert.Equal(t, "txn2", dbGet(t, tdb.BaseDB(), "a"))
+		assert.Equal(t, "txn1", dbGet(t, tdb.BaseDB(), "b"))
+		assert.Empty(t, tdb.locks.waitFor)
+	}
+}
+
+// TestLockManager_UnlockDuringWait releases a lock while another
+// transaction starts waiting for it, the wait must not leave an edge behind
+// that makes the releasing transaction look deadlocked.
+func TestLockManager_UnlockDuringWait(t *testing.T) {
+	lm := newLockManager(1)
+	assert.NoError(t, lm.lock(1, "a", -1))
+	assert.NoError(t, lm.lock(2, "b", -1))
+	lm.beforeWait = func() {
+		lm.beforeWait = nil
+		lm.unlock(1, "a")
+		// 2 does not wait for 1 anymore, 1 may wait for 2
+		assert.NoError(t, lm.addWait(1, 2, "b"))
+		lm.removeWait(1)
+	}
+	assert.NoError(t, lm.lock(2, "a", -1))
+	assert.Nil(t, lm.beforeWait)
+	assert.Empty(t, lm.waitFor)
+}
+
+func TestTransactionDB_SavePoints(t *testing.T) {
+	tdb := openTestTransactionDB(t, 0)
+	defer tdb.Close()
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("a"), []byte("v0")))
+
+	txn := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.True(t, txn.RollbackToSavePoint().(*LevelError).IsNotFound())
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v1")))
+	assert.NoError(t, txn.SetSavePoint())
+	assert.NoError(t, txn.Put([]byte("a"), []byte("v2")))
+	assert.NoError(t, txn.Put([]byte("b"), []byte("v2")))
+	assert.NoError(t, txn.SetSavePoint())
+	assert.NoError(t, txn.Delete([]byte("a")))
+	_, err := txn.GetForUpdate(DefaultReadOptions, []byte("c"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+	assert.Equal(t, "NOT_FOUND", transactionGet(t, txn, "a"))
+
+	assert.NoError(t, txn.RollbackToSavePoint())
+	assert.Equal(t, "v2", transactionGet(t, txn, "a"))
+	assert.Equal(t, "v2", transactionGet(t, txn, "b"))
+	// The lock of "c" is released, the ones before the savepoint are held
+	other := tdb.BeginTransaction(DefaultTransactionOptions)
+	assert.NoError(t, other.Put([]byte("c"), []byte("other")))
+	assert.True(t, other.Put([]byte("a"), []byte("other")).(*LevelError).IsTimedOut())
+	assert.NoError(t, other.Commit(DefaultWriteOptions))
+
+	assert.NoError(t, txn.RollbackToSavePoint())
+	assert.Equal(t, "v1", transactionGet(t, txn, "a"))
+	assert.Equal(t, "NOT_FOUND", transactionGet(t, txn, "b"))
+	assert.True(t, tdb.Put(DefaultWriteOptions, []byte("a"), []byte("v")).(*LevelError).IsTimedOut())
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("b"), []byte("v")))
+	assert.True(t, txn.RollbackToSavePoint().(*LevelError).IsNotFound())
+
+	assert.NoError(t, txn.Commit(DefaultWriteOptions))
+	assert.Equal(t, "v1", dbGet(t, tdb.BaseDB(), "a"))
+	assert.Equal(t, "v", dbGet(t, tdb.BaseDB(), "b"))
+	assert.Equal(t, "other", dbGet(t, tdb.BaseDB(), "c"))
+}
+
+func TestTransactionDB_ConcurrentIncrements(t *testing.T) {
+	tdb := openTestTransactionDB(t, -1)
+	defer tdb.Close()
+	assert.NoError(t, tdb.Put(DefaultWriteOptions, []byte("counter"), []byte("0")))
+
+	// Every transaction succeeds at once, the lock orders them
+	const numThreads, numIncrements = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numIncrements; j++ {
+				txn := tdb.BeginTransaction(DefaultTransactionOptions)
+				value, err := txn.GetForUpdate(DefaultReadOptions, []byte("counter"))
+				assert.NoError(t, err)
+				n, _ := strconv.Atoi(string(value))
+				assert.NoError(t, txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1))))
+				assert.NoError(t, txn.Commit(DefaultWriteOptions))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, strconv.Itoa(numThreads*numIncrements), dbGet(t, tdb.BaseDB(), "counter"))
+}
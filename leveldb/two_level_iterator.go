@@ -0,0 +1,238 @@
+package leveldb
+
+// blockFunction returns an iterator over the block pointed to by an
+// index iterator value
+type blockFunction func(indexValue []byte) Iterator
+
+// twoLevelIterator yields the concatenation of all key/value pairs in a
+// sequence of blocks, the index iterator yields a sequence of values
+// which are converted to block iterators by blockFunction.
+type twoLevelIterator struct {
+	cleanupList
+	blockFunction blockFunction
+	indexIter     Iterator
+	dataIter      Iterator // May be nil
+	// If dataIter is non-nil, then "dataBlockHandle" holds the
+	// "indexValue" passed to blockFunction to create the dataIter.
+	dataBlockHandle []byte
+	err             error // first error of a closed data iterator
+
+	// If non-nil, blocks entirely outside of the bounds are not loaded.
+	// An index key is >= all the keys of its block and < all the keys of
+	// the following blocks.
+	bound *keyBound
+}
+
+var _ Iterator = (*twoLevelIterator)(nil)
+
+// NewTwoLevelIterator returns a new two level iterator.  A two-level iterator contains an
+// index iterator whose values point to a sequence of blocks where
+// each block is itself a sequence of key,value pairs.  The returned
+// two-level iterator yields the concatenation of all key/value pairs
+// in the sequence of blocks.  Takes ownership of "indexIter" and
+// will delete it when no longer needed.
+func NewTwoLevelIterator(indexIter Iterator, blockFunction blockFunction) *twoLevelIterator {
+	return &twoLevelIterator{
+		blockFunction: blockFunction,
+		indexIter:     indexIter,
+	}
+}
+
+// setBound makes the iterator stop at the blocks which are entirely
+// outside of the bounds of options instead of loading them.
+func (it *twoLevelIterator) setBound(comparator Comparator, options *ReadOptions) *twoLevelIterator {
+	it.bound = newKeyBound(comparator, options)
+	return it
+}
+
+func (it *twoLevelIterator) Valid() bool {
+	return it.dataIter != nil && it.dataIter.Valid()
+}
+
+func (it *twoLevelIterator) Key() []byte {
+	if !it.Valid() {
+		panic("two level iterator is invalid")
+	}
+	return it.dataIter.Key()
+}
+
+func (it *twoLevelIterator) Value() []byte {
+	if !it.Valid() {
+		panic("two level iterator is invalid")
+	}
+	return it.dataIter.Value()
+}
+
+// Error returns the error of the index iterator or of the data iterators,
+// including the ones already released.
+func (it *twoLevelIterator) Error() error {
+	if err := it.indexIter.Error(); err != nil {
+		return err
+	}
+	if it.dataIter != nil {
+		if err := it.dataIter.Error(); err != nil {
+			return err
+		}
+	}
+	return it.err
+}
+
+func (it *twoLevelIterator) Close() error {
+	it.setDataIterator(nil)
+	err := it.indexIter.Close()
+	it.runCleanups()
+	return err
+}
+
+func (it *twoLevelIterator) saveError(err error) {
+	if it.err == nil && err != nil {
+		it.err = err
+	}
+}
+
+func (it *twoLevelIterator) Seek(target []byte) {
+	it.indexIter.Seek(target)
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.Seek(target)
+	}
+	it.skipEmptyDataBlocksForward()
+}
+
+func (it *twoLevelIterator) SeekToFirst() {
+	it.indexIter.SeekToFirst()
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.SeekToFirst()
+	}
+	it.skipEmptyDataBlocksForward()
+}
+
+func (it *twoLevelIterator) SeekToLast() {
+	it.indexIter.SeekToLast()
+	it.initDataBlock()
+	if it.dataIter != nil {
+		it.dataIter.SeekToLast()
+	}
+	it.skipEmptyDataBlocksBackward()
+}
+
+func (it *twoLevelIterator) Next() {
+	if !it.Valid() {
+		panic("two level iterator is invalid")
+	}
+	it.dataIter.Next()
+	it.skipEmptyDataBlocksForward()
+}
+
+func (it *twoLevelIterator) Prev() {
+	if !it.Valid() {
+		panic("two level iterator is invalid")
+	}
+	it.dataIter.Prev()
+	it.skipEmptyDataBlocksBackward()
+}
+
+func (it *twoLevelIterator) skipEmptyDataBlocksForward() {
+	for it.dataIter == nil || !it.dataIter.Valid() {
+		// Move to next block
+		if !it.indexIter.Valid() {
+			it.setDataIterator(nil)
+			return
+		}
+		if it.bound != nil && it.bound.atOrAfterUpper(it.indexIter.Key()) {
+			// The following blocks are all at or after the upper bound
+			it.setDataIterator(nil)
+			return
+		}
+		it.indexIter.Next()
+		it.initDataBlock()
+		if it.dataIter != nil {
+			it.dataIter.SeekToFirst()
+		}
+	}
+}
+
+func (it *twoLevelIterator) skipEmptyDataBlocksBackward() {
+	for it.dataIter == nil || !it.dataIter.Valid() {
+		// Move to previous block
+		if !it.indexIter.Valid() {
+			it.setDataIterator(nil)
+			return
+		}
+		it.indexIter.Prev()
+		if it.bound != nil && it.indexIter.Valid() && it.bound.beforeLower(it.indexIter.Key()) {
+			// This block and the previous ones are all before the lower bound
+			it.setDataIterator(nil)
+			return
+		}
+		it.initDataBlock()
+		if it.dataIter != nil {
+			it.dataIter.SeekToLast()
+		}
+	}
+}
+
+func (it *twoLevelIterator) setDataIterator(dataIter Iterator) {
+	if it.dataIter != nil {
+		it.saveError(it.dataIter.Error())
+		it.dataIter.Close()
+	}
+	it.dataIter = dataIter
+}
+
+func (it *twoLevelIterator) initDataBlock() {
+	if !it.indexIter.Valid() {
+		it.setDataIterator(nil)
+		return
+	}
+	handle := it.indexIter.Value()
+	if it.dataIter != nil && string(handle) == string(it.dataBlockHandle) {
+		// dataIter is already constructed with this iterator, so
+		// no need to change anything
+		return
+	}
+	it.setDataIterator(it.blockFunction(handle))
+	it.dataBlockHandle = append(it.dataBlockHandle[:0], handle...)
+}
+
+// keyBound checks the index keys of a two-level iterator against the
+// user key bounds of ReadOptions.  The index keys are internal keys for
+// the tables of a DB and user keys for standalone tables.
+type keyBound struct {
+	userComparator Comparator
+	internal       bool
+	lower          []byte // nil means no lower bound
+	upper          []byte // nil means no upper bound
+}
+
+// newKeyBound returns nil if options has no bounds.
+func newKeyBound(comparator Comparator, options *ReadOptions) *keyBound {
+	if options == nil || (options.LowerBound == nil && options.UpperBound == nil) {
+		return nil
+	}
+	ucmp, internal := userKeyComparator(comparator)
+	return &keyBound{
+		userComparator: ucmp,
+		internal:       internal,
+		lower:          options.LowerBound,
+		upper:          options.UpperBound,
+	}
+}
+
+func (b *keyBound) userKey(key []byte) []byte {
+	if b.internal {
+		return ExtractUserKey(key)
+	}
+	return key
+}
+
+// beforeLower returns true if key is before the lower bound
+func (b *keyBound) beforeLower(key []byte) bool {
+	return b.lower != nil && b.userComparator.Compare(b.userKey(key), b.lower) < 0
+}
+
+// atOrAfterUpper returns true if key is at or after the upper bound
+func (b *keyBound) atOrAfterUpper(key []byte) bool {
+	return b.upper != nil && b.userComparator.Compare(b.userKey(key), b.upper) >= 0
+}
@@ -0,0 +1,439 @@
+package leveldb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+type FileMetaData struct {
+	refs         int
+	allowedSeeks int    // Seeks allowed until compaction
+	number       uint64 // file number
+	fileSize     uint64 // File size in bytes
+	smallest     []byte // Smallest internal key served by table
+	largest      []byte // Largest internal key served by table
+}
+
+func NewFileMetaData() *FileMetaData {
+	return &FileMetaData{
+		allowedSeeks: 1 << 30,
+	}
+}
+
+// Tag numbers for serialized VersionEdit.  These numbers are written to
+// disk and should not be changed.
+const (
+	kComparator     = 1
+	kLogNumber      = 2
+	kNextFileNumber = 3
+	kLastSequence   = 4
+	kCompactPointer = 5
+	kDeletedFile    = 6
+	kNewFile        = 7
+	// 8 was used for large value refs
+	kPrevLogNumber = 9
+
+	// The column families, the edits of the default one have no
+	// kColumnFamily tag
+	kColumnFamily     = 200
+	kColumnFamilyAdd  = 201
+	kColumnFamilyDrop = 202
+	kMaxColumnFamily  = 203
+)
+
+type levelFile struct {
+	level  int
+	number uint64
+}
+
+type levelKey struct {
+	level int
+	key   []byte
+}
+
+type levelFileMeta struct {
+	level int
+	meta  *FileMetaData
+}
+
+// VersionEdit records the changes applied on top of a Version, it is the
+// unit appended to the MANIFEST.  The comparator, the log number, the
+// compaction pointers and the files are the ones of the column family of
+// the edit, the other fields are shared by all the families.
+type VersionEdit struct {
+	comparator         string
+	logNumber          uint64
+	prevLogNumber      uint64
+	nextFileNumber     uint64
+	lastSequence       SequenceNumber
+	columnFamily       uint32
+	columnFamilyName   string // Of the added column family
+	maxColumnFamily    uint32
+	hasComparator      bool
+	hasLogNumber       bool
+	hasPrevLogNumber   bool
+	hasNextFileNumber  bool
+	hasLastSequence    bool
+	hasMaxColumnFamily bool
+	isColumnFamilyAdd  bool
+	isColumnFamilyDrop bool
+	compactPointers    []levelKey
+	deletedFiles       map[levelFile]struct{}
+	newFiles           []levelFileMeta
+}
+
+func NewVersionEdit() *VersionEdit {
+	e := &VersionEdit{}
+	e.Clear()
+	return e
+}
+
+func (e *VersionEdit) Clear() {
+	*e = VersionEdit{
+		deletedFiles: make(map[levelFile]struct{}),
+	}
+}
+
+func (e *VersionEdit) SetComparatorName(name string) {
+	e.hasComparator = true
+	e.comparator = name
+}
+
+func (e *VersionEdit) SetLogNumber(num uint64) {
+	e.hasLogNumber = true
+	e.logNumber = num
+}
+
+func (e *VersionEdit) SetPrevLogNumber(num uint64) {
+	e.hasPrevLogNumber = true
+	e.prevLogNumber = num
+}
+
+func (e *VersionEdit) SetNextFile(num uint64) {
+	e.hasNextFileNumber = true
+	e.nextFileNumber = num
+}
+
+func (e *VersionEdit) SetLastSequence(seq SequenceNumber) {
+	e.hasLastSequence = true
+	e.lastSequence = seq
+}
+
+// SetColumnFamily sets the column family the edit applies to, the default
+// one if unset.
+func (e *VersionEdit) SetColumnFamily(id uint32) {
+	e.columnFamily = id
+}
+
+// AddColumnFamily records the creation of the column family of the edit,
+// named name.
+func (e *VersionEdit) AddColumnFamily(name string) {
+	e.isColumnFamilyAdd = true
+	e.columnFamilyName = name
+}
+
+// DropColumnFamily records the drop of the column family of the edit.
+func (e *VersionEdit) DropColumnFamily() {
+	e.isColumnFamilyDrop = true
+}
+
+// SetMaxColumnFamily records the largest id given to a column family, ids
+// are never reused.
+func (e *VersionEdit) SetMaxColumnFamily(id uint32) {
+	e.hasMaxColumnFamily = true
+	e.maxColumnFamily = id
+}
+
+func (e *VersionEdit) SetCompactPointer(level int, key []byte) {
+	e.compactPointers = append(e.compactPointers, levelKey{level, append([]byte{}, key...)})
+}
+
+// AddFile adds the specified file at the specified number.
+// REQUIRES: This version has not been saved (see VersionSet::SaveTo)
+// REQUIRES: "smallest" and "largest" are smallest and largest keys in file
+func (e *VersionEdit) AddFile(level int, file, fileSize uint64, smallest, largest []byte) {
+	f := NewFileMetaData()
+	f.number = file
+	f.fileSize = fileSize
+	f.smallest = append([]byte{}, smallest...)
+	f.largest = append([]byte{}, largest...)
+	e.newFiles = append(e.newFiles, levelFileMeta{level, f})
+}
+
+// RemoveFile deletes the specified "file" from the specified "level".
+func (e *VersionEdit) RemoveFile(level int, file uint64) {
+	e.deletedFiles[levelFile{level, file}] = struct{}{}
+}
+
+func (e *VersionEdit) EncodeTo(dst *[]byte) {
+	e.encodeTo(dst, Format_Native)
+}
+
+// encodeTo appends the edit to dst with the internal keys in format.
+func (e *VersionEdit) encodeTo(dst *[]byte, format Format) {
+	putInternalKey := func(key []byte) {
+		if format == Format_LevelDB {
+			key = appendSwappedTag(nil, key)
+		}
+		util.PutVarLengthPrefixedBytes(dst, key)
+	}
+
+	if e.columnFamily != kDefaultColumnFamily {
+		util.PutUvarint(dst, kColumnFamily)
+		util.PutUvarint(dst, uint64(e.columnFamily))
+	}
+	if e.isColumnFamilyAdd {
+		util.PutUvarint(dst, kColumnFamilyAdd)
+		util.PutVarLengthPrefixedBytes(dst, []byte(e.columnFamilyName))
+	}
+	if e.isColumnFamilyDrop {
+		util.PutUvarint(dst, kColumnFamilyDrop)
+	}
+	if e.hasMaxColumnFamily {
+		util.PutUvarint(dst, kMaxColumnFamily)
+		util.PutUvarint(dst, uint64(e.maxColumnFamily))
+	}
+	if e.hasComparator {
+		util.PutUvarint(dst, kComparator)
+		util.PutVarLengthPrefixedBytes(dst, []byte(e.comparator))
+	}
+	if e.hasLogNumber {
+		util.PutUvarint(dst, kLogNumber)
+		util.PutUvarint(dst, e.logNumber)
+	}
+	if e.hasPrevLogNumber {
+		util.PutUvarint(dst, kPrevLogNumber)
+		util.PutUvarint(dst, e.prevLogNumber)
+	}
+	if e.hasNextFileNumber {
+		util.PutUvarint(dst, kNextFileNumber)
+		util.PutUvarint(dst, e.nextFileNumber)
+	}
+	if e.hasLastSequence {
+		util.PutUvarint(dst, kLastSequence)
+		util.PutUvarint(dst, uint64(e.lastSequence))
+	}
+
+	for _, p := range e.compactPointers {
+		util.PutUvarint(dst, kCompactPointer)
+		util.PutUvarint(dst, uint64(p.level))
+		putInternalKey(p.key)
+	}
+
+	// encode deleted files in a stable order
+	deleted := make([]levelFile, 0, len(e.deletedFiles))
+	for f := range e.deletedFiles {
+		deleted = append(deleted, f)
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].level != deleted[j].level {
+			return deleted[i].level < deleted[j].level
+		}
+		return deleted[i].number < deleted[j].number
+	})
+	for _, f := range deleted {
+		util.PutUvarint(dst, kDeletedFile)
+		util.PutUvarint(dst, uint64(f.level))
+		util.PutUvarint(dst, f.number)
+	}
+
+	for _, nf := range e.newFiles {
+		util.PutUvarint(dst, kNewFile)
+		util.PutUvarint(dst, uint64(nf.level))
+		util.PutUvarint(dst, nf.meta.number)
+		util.PutUvarint(dst, nf.meta.fileSize)
+		putInternalKey(nf.meta.smallest)
+		putInternalKey(nf.meta.largest)
+	}
+}
+
+func getUvarint(input *[]byte) (uint64, bool) {
+	v, n := util.DecodeUvarint(*input)
+	if n <= 0 {
+		return 0, false
+	}
+	*input = (*input)[n:]
+	return v, true
+}
+
+func getColumnFamily(input *[]byte) (uint32, bool) {
+	v, ok := getUvarint(input)
+	if !ok || v > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+func getLevel(input *[]byte) (int, bool) {
+	v, ok := getUvarint(input)
+	if !ok || v >= kNumLevels {
+		return 0, false
+	}
+	return int(v), true
+}
+
+func getInternalKey(input *[]byte, format Format) ([]byte, bool) {
+	key, ok := getLengthPrefixedSlice(input)
+	if !ok || len(key) < TagSize {
+		return nil, false
+	}
+	if format == Format_LevelDB {
+		return appendSwappedTag(nil, key), true
+	}
+	return append([]byte{}, key...), true
+}
+
+func (e *VersionEdit) DecodeFrom(src []byte) error {
+	return e.decodeFrom(src, Format_Native)
+}
+
+// decodeFrom sets the edit to src, whose internal keys are in format.
+func (e *VersionEdit) decodeFrom(src []byte, format Format) error {
+	e.Clear()
+	input := src
+	var msg string
+
+	for msg == "" && len(input) > 0 {
+		tag, ok := getUvarint(&input)
+		if !ok {
+			break
+		}
+		switch tag {
+		case kComparator:
+			if str, ok := getLengthPrefixedSlice(&input); ok {
+				e.SetComparatorName(string(str))
+			} else {
+				msg = "comparator name"
+			}
+		case kLogNumber:
+			if v, ok := getUvarint(&input); ok {
+				e.SetLogNumber(v)
+			} else {
+				msg = "log number"
+			}
+		case kPrevLogNumber:
+			if v, ok := getUvarint(&input); ok {
+				e.SetPrevLogNumber(v)
+			} else {
+				msg = "previous log number"
+			}
+		case kNextFileNumber:
+			if v, ok := getUvarint(&input); ok {
+				e.SetNextFile(v)
+			} else {
+				msg = "next file number"
+			}
+		case kLastSequence:
+			if v, ok := getUvarint(&input); ok {
+				e.SetLastSequence(SequenceNumber(v))
+			} else {
+				msg = "last sequence number"
+			}
+		case kColumnFamily:
+			if v, ok := getColumnFamily(&input); ok {
+				e.SetColumnFamily(v)
+			} else {
+				msg = "column family"
+			}
+		case kColumnFamilyAdd:
+			if str, ok := getLengthPrefixedSlice(&input); ok {
+				e.AddColumnFamily(string(str))
+			} else {
+				msg = "column family name"
+			}
+		case kColumnFamilyDrop:
+			e.DropColumnFamily()
+		case kMaxColumnFamily:
+			if v, ok := getColumnFamily(&input); ok {
+				e.SetMaxColumnFamily(v)
+			} else {
+				msg = "max column family"
+			}
+		case kCompactPointer:
+			level, ok1 := getLevel(&input)
+			key, ok2 := getInternalKey(&input, format)
+			if ok1 && ok2 {
+				e.compactPointers = append(e.compactPointers, levelKey{level, key})
+			} else {
+				msg = "compaction pointer"
+			}
+		case kDeletedFile:
+			level, ok1 := getLevel(&input)
+			number, ok2 := getUvarint(&input)
+			if ok1 && ok2 {
+				e.RemoveFile(level, number)
+			} else {
+				msg = "deleted file"
+			}
+		case kNewFile:
+			level, ok1 := getLevel(&input)
+			f := NewFileMetaData()
+			var ok2, ok3, ok4, ok5 bool
+			f.number, ok2 = getUvarint(&input)
+			f.fileSize, ok3 = getUvarint(&input)
+			f.smallest, ok4 = getInternalKey(&input, format)
+			f.largest, ok5 = getInternalKey(&input, format)
+			if ok1 && ok2 && ok3 && ok4 && ok5 {
+				e.newFiles = append(e.newFiles, levelFileMeta{level, f})
+			} else {
+				msg = "new-file entry"
+			}
+		default:
+			msg = "unknown tag"
+		}
+	}
+
+	if msg == "" && len(input) != 0 {
+		msg = "invalid tag"
+	}
+	if msg != "" {
+		return Error(Code_Corruption, "VersionEdit: "+msg)
+	}
+	return nil
+}
+
+func (e *VersionEdit) DebugString() string {
+	r := "VersionEdit {"
+	if e.columnFamily != kDefaultColumnFamily {
+		r += fmt.Sprintf("\n  ColumnFamily: %d", e.columnFamily)
+	}
+	if e.isColumnFamilyAdd {
+		r += "\n  ColumnFamilyAdd: " + e.columnFamilyName
+	}
+	if e.isColumnFamilyDrop {
+		r += "\n  ColumnFamilyDrop"
+	}
+	if e.hasMaxColumnFamily {
+		r += fmt.Sprintf("\n  MaxColumnFamily: %d", e.maxColumnFamily)
+	}
+	if e.hasComparator {
+		r += "\n  Comparator: " + e.comparator
+	}
+	if e.hasLogNumber {
+		r += fmt.Sprintf("\n  LogNumber: %d", e.logNumber)
+	}
+	if e.hasPrevLogNumber {
+		r += fmt.Sprintf("\n  PrevLogNumber: %d", e.prevLogNumber)
+	}
+	if e.hasNextFileNumber {
+		r += fmt.Sprintf("\n  NextFile: %d", e.nextFileNumber)
+	}
+	if e.hasLastSequence {
+		r += fmt.Sprintf("\n  LastSeq: %d", e.lastSequence)
+	}
+	for _, p := range e.compactPointers {
+		r += fmt.Sprintf("\n  CompactPointer: %d %s", p.level, util.EscapeString(p.key))
+	}
+	for f := range e.deletedFiles {
+		r += fmt.Sprintf("\n  RemoveFile: %d %d", f.level, f.number)
+	}
+	for _, nf := range e.newFiles {
+		r += fmt.Sprintf("\n  AddFile: %d %d %d %s .. %s", nf.level, nf.meta.number, nf.meta.fileSize,
+			util.EscapeString(nf.meta.smallest), util.EscapeString(nf.meta.largest))
+	}
+	r += "\n}\n"
+	return r
+}
@@ -0,0 +1,67 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncodeDecode(t *testing.T, edit *VersionEdit) {
+	var encoded, encoded2 []byte
+	edit.EncodeTo(&encoded)
+	parsed := NewVersionEdit()
+	assert.NoError(t, parsed.DecodeFrom(encoded))
+	parsed.EncodeTo(&encoded2)
+	assert.Equal(t, encoded, encoded2)
+}
+
+func TestVersionEdit_EncodeDecode(t *testing.T) {
+	const kBig uint64 = 1 << 50
+
+	edit := NewVersionEdit()
+	for i := uint64(0); i < 4; i++ {
+		testEncodeDecode(t, edit)
+		edit.AddFile(3, kBig+300+i, kBig+400+i,
+			DumpInternalKey(NewParsedInternalKey([]byte("foo"), SequenceNumber(kBig+500+i), ValueType_Value)),
+			DumpInternalKey(NewParsedInternalKey([]byte("zoo"), SequenceNumber(kBig+600+i), ValueType_Deletion)))
+		edit.RemoveFile(4, kBig+700+i)
+		edit.SetCompactPointer(int(i), DumpInternalKey(NewParsedInternalKey([]byte("x"), SequenceNumber(kBig+900+i), ValueType_Value)))
+	}
+
+	edit.SetComparatorName("foo")
+	edit.SetLogNumber(kBig + 100)
+	edit.SetNextFile(kBig + 200)
+	edit.SetLastSequence(SequenceNumber(kBig + 1000))
+	testEncodeDecode(t, edit)
+
+	edit.SetColumnFamily(7)
+	edit.AddColumnFamily("users")
+	edit.SetMaxColumnFamily(7)
+	testEncodeDecode(t, edit)
+
+	var encoded []byte
+	edit.EncodeTo(&encoded)
+	parsed := NewVersionEdit()
+	assert.NoError(t, parsed.DecodeFrom(encoded))
+	assert.Equal(t, uint32(7), parsed.columnFamily)
+	assert.Equal(t, "users", parsed.columnFamilyName)
+	assert.True(t, parsed.isColumnFamilyAdd)
+	assert.False(t, parsed.isColumnFamilyDrop)
+
+	drop := NewVersionEdit()
+	drop.SetColumnFamily(7)
+	drop.DropColumnFamily()
+	testEncodeDecode(t, drop)
+}
+
+func TestVersionEdit_DecodeCorruption(t *testing.T) {
+	edit := NewVersionEdit()
+	edit.SetComparatorName("foo")
+	var encoded []byte
+	edit.EncodeTo(&encoded)
+
+	err := NewVersionEdit().DecodeFrom(encoded[:len(encoded)-1])
+	assert.True(t, err.(*LevelError).IsCorruption())
+	err = NewVersionEdit().DecodeFrom([]byte{99})
+	assert.True(t, err.(*LevelError).IsCorruption())
+}
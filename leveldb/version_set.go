@@ -0,0 +1,1505 @@
+package leveldb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+func targetFileSize(options *Options) uint64 {
+	return options.MaxFileSize
+}
+
+// maxGrandParentOverlapBytes is the maximum bytes of overlaps in grandparent (i.e., level+2) before we
+// stop building a single file in a level->level+1 compaction.
+func maxGrandParentOverlapBytes(options *Options) uint64 {
+	return 10 * targetFileSize(options)
+}
+
+// expandedCompactionByteSizeLimit is the maximum number of bytes in all compacted files.  We avoid expanding
+// the lower level file set of a compaction if it would make the
+// total compaction cover more than this many bytes.
+func expandedCompactionByteSizeLimit(options *Options) uint64 {
+	return 25 * targetFileSize(options)
+}
+
+func maxBytesForLevel(level int) float64 {
+	// Note: the result for level zero is not really used since we set
+	// the level-0 compaction threshold based on number of files.
+
+	// Result for both level-0 and level-1
+	result := 10. * 1048576.0
+	for level > 1 {
+		result *= 10
+		level--
+	}
+	return result
+}
+
+func maxFileSizeForLevel(options *Options, level int) uint64 {
+	// We could vary per level to reduce number of files?
+	return targetFileSize(options)
+}
+
+func totalFileSize(files []*FileMetaData) uint64 {
+	var sum uint64
+	for _, f := range files {
+		sum += f.fileSize
+	}
+	return sum
+}
+
+// findFile returns the smallest index i such that files[i].largest >= key.
+// Returns len(files) if there is no such file.
+// REQUIRES: "files" contains a sorted list of non-overlapping files.
+func findFile(icmp *internalKeyComparator, files []*FileMetaData, key []byte) int {
+	return sort.Search(len(files), func(i int) bool {
+		return icmp.Compare(files[i].largest, key) >= 0
+	})
+}
+
+func afterFile(ucmp Comparator, userKey []byte, f *FileMetaData) bool {
+	// nil userKey occurs before all keys and is therefore never after f
+	return userKey != nil && ucmp.Compare(userKey, ExtractUserKey(f.largest)) > 0
+}
+
+func beforeFile(ucmp Comparator, userKey []byte, f *FileMetaData) bool {
+	// nil userKey occurs after all keys and is therefore never before f
+	return userKey != nil && ucmp.Compare(userKey, ExtractUserKey(f.smallest)) < 0
+}
+
+// someFileOverlapsRange returns true iff some file in "files" overlaps the user key range
+// [smallestUserKey,largestUserKey].
+// smallestUserKey==nil represents a key smaller than all keys in the DB.
+// largestUserKey==nil represents a key largest than all keys in the DB.
+// REQUIRES: If disjointSortedFiles, files[] contains disjoint ranges
+// in sorted order.
+func someFileOverlapsRange(icmp *internalKeyComparator, disjointSortedFiles bool, files []*FileMetaData,
+	smallestUserKey, largestUserKey []byte) bool {
+	ucmp := icmp.comparator
+	if !disjointSortedFiles {
+		// Need to check against all files
+		for _, f := range files {
+			if afterFile(ucmp, smallestUserKey, f) || beforeFile(ucmp, largestUserKey, f) {
+				// No overlap
+			} else {
+				return true // Overlap
+			}
+		}
+		return false
+	}
+
+	// Binary search over file list
+	index := 0
+	if smallestUserKey != nil {
+		// Find the earliest possible internal key for smallestUserKey
+		smallKey := DumpInternalKey(NewParsedInternalKey(smallestUserKey, KMaxSequenceNumber, ValueType_ForSeek))
+		index = findFile(icmp, files, smallKey)
+	}
+
+	if index >= len(files) {
+		// beginning of range is after all files, so no overlap.
+		return false
+	}
+
+	return !beforeFile(ucmp, largestUserKey, files[index])
+}
+
+// Version is a set of table files per level, immutable once installed.
+type Version struct {
+	cfd  *columnFamilyData // Column family to which this Version belongs
+	refs int               // Number of live refs to this version
+
+	// List of files per level
+	files [kNumLevels][]*FileMetaData
+
+	// Level that should be compacted next and its compaction score.
+	// Score < 1 means compaction is not strictly needed.  These fields
+	// are initialized by Finalize().
+	compactionScore float64
+	compactionLevel int
+}
+
+func newVersion(cfd *columnFamilyData) *Version {
+	return &Version{
+		cfd:             cfd,
+		compactionScore: -1,
+		compactionLevel: -1,
+	}
+}
+
+// Ref and Unref keep track of the live versions.
+// REQUIRES: the DB mutex is held
+func (v *Version) Ref() {
+	v.refs++
+}
+
+func (v *Version) Unref() {
+	if v.refs < 1 {
+		panic("version refs < 1")
+	}
+	v.refs--
+	if v.refs == 0 {
+		delete(v.cfd.versions, v)
+	}
+}
+
+// NumFiles returns the number of files at the specified level.
+func (v *Version) NumFiles(level int) int {
+	return len(v.files[level])
+}
+
+// newConcatenatingIterator returns an iterator over the files of a sorted,
+// non-overlapping level
+func (v *Version) newConcatenatingIterator(options *ReadOptions, level int) Iterator {
+	return NewTwoLevelIterator(
+		newLevelFileNumIterator(v.cfd.icmp, v.files[level]),
+		func(fileValue []byte) Iterator {
+			if len(fileValue) != 16 {
+				return NewErrorIterator(Error(Code_Corruption, "FileReader invoked with unexpected value"))
+			}
+			return v.cfd.tableCache.NewIterator(options,
+				util.DecodeUint64Fixed(fileValue), util.DecodeUint64Fixed(fileValue[8:]))
+		}).setBound(v.cfd.icmp, options)
+}
+
+// AddIterators appends to iters a sequence of iterators that will
+// yield the contents of this Version when merged together.
+// REQUIRES: This version has been saved (see VersionSet::SaveTo)
+func (v *Version) AddIterators(options *ReadOptions, iters *[]Iterator) {
+	// Merge all level zero files together since they may overlap, the
+	// files entirely outside of the bounds are skipped.
+	bound := newKeyBound(v.cfd.icmp, options)
+	for _, f := range v.files[0] {
+		if bound != nil && (bound.beforeLower(f.largest) || bound.atOrAfterUpper(f.smallest)) {
+			continue
+		}
+		*iters = append(*iters, v.cfd.tableCache.NewIterator(options, f.number, f.fileSize))
+	}
+
+	// For levels > 0, we can use a concatenating iterator that sequentially
+	// walks through the non-overlapping files in the level, opening them
+	// lazily.
+	for level := 1; level < kNumLevels; level++ {
+		if len(v.files[level]) != 0 {
+			*iters = append(*iters, v.newConcatenatingIterator(options, level))
+		}
+	}
+}
+
+// Get continues the lookup of key in the tables of the version, newest
+// first, until ctx is done.
+// REQUIRES: lock is not held
+func (v *Version) Get(options *ReadOptions, k *LookupKey, ctx *getContext) error {
+	ikey := k.InternalKey()
+	userKey := k.UserKey()
+	ucmp := v.cfd.icmp.comparator
+	// The versions of the key visible as of its timestamp are the user keys
+	// in [userKey, userKeyEnd]
+	userKeyEnd := userKey
+	if n := timestampSize(ucmp); n > 0 {
+		userKeyEnd = append(append([]byte{}, stripTimestamp(ucmp, userKey)...), make([]byte, n)...)
+	}
+
+	// Search level-0 in order from newest to oldest.
+	var tmp []*FileMetaData
+	for _, f := range v.files[0] {
+		if ucmp.Compare(userKeyEnd, ExtractUserKey(f.smallest)) >= 0 &&
+			ucmp.Compare(userKey, ExtractUserKey(f.largest)) <= 0 {
+			tmp = append(tmp, f)
+		}
+	}
+	sort.Slice(tmp, func(i, j int) bool { return tmp[i].number > tmp[j].number })
+
+	// Returns true once the lookup is done, in other files it keeps
+	// searching for the key or for the value its merge operands apply to
+	search := func(f *FileMetaData) (bool, error) {
+		tombstones, err := v.cfd.tableCache.RangeTombstones(f.number, f.fileSize)
+		if err != nil {
+			return true, err
+		}
+		ctx.addRangeTombstones(tombstones)
+		err = v.cfd.tableCache.Get(options, f.number, f.fileSize, ikey, ctx.saveValue)
+		if err != nil {
+			return true, err
+		}
+		return ctx.done(), nil
+	}
+
+	for _, f := range tmp {
+		if done, err := search(f); done {
+			return err
+		}
+	}
+
+	// Search other levels.
+	for level := 1; level < kNumLevels; level++ {
+		files := v.files[level]
+		if len(files) == 0 {
+			continue
+		}
+
+		// Binary search to find earliest index whose largest key >= internal_key.
+		index := findFile(v.cfd.icmp, files, ikey)
+		if index < len(files) {
+			f := files[index]
+			if ucmp.Compare(userKeyEnd, ExtractUserKey(f.smallest)) >= 0 {
+				if done, err := search(f); done {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// RangeTombstones returns the range tombstones of the tables of the
+// version.
+func (v *Version) RangeTombstones() ([]rangeTombstone, error) {
+	var tombstones []rangeTombstone
+	for level := 0; level < kNumLevels; level++ {
+		for _, f := range v.files[level] {
+			t, err := v.cfd.tableCache.RangeTombstones(f.number, f.fileSize)
+			if err != nil {
+				return nil, err
+			}
+			tombstones = append(tombstones, t...)
+		}
+	}
+	return tombstones, nil
+}
+
+// OverlapInLevel returns true iff some file in the specified level overlaps
+// some part of [smallestUserKey,largestUserKey].
+// smallestUserKey==nil represents a key smaller than all the DB's keys.
+// largestUserKey==nil represents a key largest than all the DB's keys.
+func (v *Version) OverlapInLevel(level int, smallestUserKey, largestUserKey []byte) bool {
+	return someFileOverlapsRange(v.cfd.icmp, level > 0, v.files[level], smallestUserKey, largestUserKey)
+}
+
+// PickLevelForMemTableOutput returns the level at which we should place a new memtable compaction
+// result that covers the range [smallestUserKey,largestUserKey].
+func (v *Version) PickLevelForMemTableOutput(smallestUserKey, largestUserKey []byte) int {
+	level := 0
+	if !v.OverlapInLevel(0, smallestUserKey, largestUserKey) {
+		// Push to next level if there is no overlap in next level,
+		// and the #bytes overlapping in the level after that are limited.
+		start := DumpInternalKey(NewParsedInternalKey(smallestUserKey, KMaxSequenceNumber, ValueType_ForSeek))
+		limit := DumpInternalKey(NewParsedInternalKey(largestUserKey, 0, ValueType(0)))
+		for level < kMaxMemCompactLevel {
+			if v.OverlapInLevel(level+1, smallestUserKey, largestUserKey) {
+				break
+			}
+			if level+2 < kNumLevels {
+				// Check that file does not overlap too many grandparent bytes.
+				overlaps := v.GetOverlappingInputs(level+2, start, limit)
+				if totalFileSize(overlaps) > maxGrandParentOverlapBytes(v.cfd.options) {
+					break
+				}
+			}
+			level++
+		}
+	}
+	return level
+}
+
+// GetOverlappingInputs returns all files in "level" that overlap [begin,end]
+// nil begin means before all keys, nil end means after all keys
+func (v *Version) GetOverlappingInputs(level int, begin, end []byte) []*FileMetaData {
+	var inputs []*FileMetaData
+	var userBegin, userEnd []byte
+	if begin != nil {
+		userBegin = ExtractUserKey(begin)
+	}
+	if end != nil {
+		userEnd = ExtractUserKey(end)
+	}
+	userCmp := v.cfd.icmp.comparator
+	for i := 0; i < len(v.files[level]); {
+		f := v.files[level][i]
+		i++
+		fileStart := ExtractUserKey(f.smallest)
+		fileLimit := ExtractUserKey(f.largest)
+		if begin != nil && userCmp.Compare(fileLimit, userBegin) < 0 {
+			// "f" is completely before specified range; skip it
+		} else if end != nil && userCmp.Compare(fileStart, userEnd) > 0 {
+			// "f" is completely after specified range; skip it
+		} else {
+			inputs = append(inputs, f)
+			if level == 0 {
+				// Level-0 files may overlap each other.  So check if the newly
+				// added file has expanded the range.  If so, restart search.
+				if begin != nil && userCmp.Compare(fileStart, userBegin) < 0 {
+					userBegin = fileStart
+					inputs = nil
+					i = 0
+				} else if end != nil && userCmp.Compare(fileLimit, userEnd) > 0 {
+					userEnd = fileLimit
+					inputs = nil
+					i = 0
+				}
+			}
+		}
+	}
+	return inputs
+}
+
+func (v *Version) DebugString() string {
+	var r strings.Builder
+	for level := 0; level < kNumLevels; level++ {
+		// E.g.,
+		//   --- level 1 ---
+		//   17:123['a' .. 'd']
+		//   20:43['e' .. 'g']
+		fmt.Fprintf(&r, "--- level %d ---\n", level)
+		for _, f := range v.files[level] {
+			fmt.Fprintf(&r, " %d:%d[%s .. %s]\n", f.number, f.fileSize,
+				util.EscapeString(f.smallest), util.EscapeString(f.largest))
+		}
+	}
+	return r.String()
+}
+
+// levelFileNumIterator is an internal iterator.  For a given
+// version/level pair, yields information about the files in the level.
+// For a given entry, Key() is the largest key that occurs in the file,
+// and Value() is a 16-byte value containing the file number and file size,
+// both encoded using EncodeUint64Fixed.
+type levelFileNumIterator struct {
+	cleanupList
+	icmp     *internalKeyComparator
+	flist    []*FileMetaData
+	index    int
+	valueBuf []byte
+}
+
+var _ Iterator = (*levelFileNumIterator)(nil)
+
+func newLevelFileNumIterator(icmp *internalKeyComparator, flist []*FileMetaData) *levelFileNumIterator {
+	return &levelFileNumIterator{
+		icmp:  icmp,
+		flist: flist,
+		index: len(flist), // Marks as invalid
+	}
+}
+
+func (it *levelFileNumIterator) Valid() bool {
+	return it.index < len(it.flist)
+}
+
+func (it *levelFileNumIterator) Seek(target []byte) {
+	it.index = findFile(it.icmp, it.flist, target)
+}
+
+func (it *levelFileNumIterator) SeekToFirst() {
+	it.index = 0
+}
+
+func (it *levelFileNumIterator) SeekToLast() {
+	if len(it.flist) == 0 {
+		it.index = 0
+	} else {
+		it.index = len(it.flist) - 1
+	}
+}
+
+func (it *levelFileNumIterator) Next() {
+	if !it.Valid() {
+		panic("level file num iterator is invalid")
+	}
+	it.index++
+}
+
+func (it *levelFileNumIterator) Prev() {
+	if !it.Valid() {
+		panic("level file num iterator is invalid")
+	}
+	if it.index == 0 {
+		it.index = len(it.flist) // Marks as invalid
+	} else {
+		it.index--
+	}
+}
+
+func (it *levelFileNumIterator) Key() []byte {
+	if !it.Valid() {
+		panic("level file num iterator is invalid")
+	}
+	return it.flist[it.index].largest
+}
+
+func (it *levelFileNumIterator) Error() error {
+	return nil
+}
+
+func (it *levelFileNumIterator) Close() error {
+	it.runCleanups()
+	return nil
+}
+
+func (it *levelFileNumIterator) Value() []byte {
+	if !it.Valid() {
+		panic("level file num iterator is invalid")
+	}
+	it.valueBuf = it.valueBuf[:0]
+	util.PutUint64Fixed(&it.valueBuf, it.flist[it.index].number)
+	util.PutUint64Fixed(&it.valueBuf, it.flist[it.index].fileSize)
+	return it.valueBuf
+}
+
+// VersionSet holds the column families of the DB, each with its sequence of
+// Versions, the newest one is current.  It persists the changes between
+// Versions in the MANIFEST.
+type VersionSet struct {
+	env                *Env
+	dbname             string
+	options            *Options
+	nextFileNumber     uint64
+	manifestFileNumber uint64
+	lastSequence       SequenceNumber
+	prevLogNumber      uint64 // 0 or backing store for memtable being compacted
+
+	// Opened lazily
+	descriptorFile WritableFile
+	descriptorLog  *logWriter
+	// Set while LogAndApply writes to the MANIFEST without the DB mutex,
+	// edits are written one at a time
+	writingManifest bool
+	manifestWritten *sync.Cond
+
+	// The column families by id, the dropped ones included
+	columnFamilies      map[uint32]*columnFamilyData
+	defaultColumnFamily *columnFamilyData
+	maxColumnFamily     uint32 // Largest id ever given to a column family
+}
+
+func NewVersionSet(dbname string, options *Options, defaultColumnFamily *columnFamilyData) *VersionSet {
+	return &VersionSet{
+		env:                options.Env,
+		dbname:             dbname,
+		options:            options,
+		nextFileNumber:     2,
+		manifestFileNumber: 0, // Filled by Recover()
+		columnFamilies: map[uint32]*columnFamilyData{
+			kDefaultColumnFamily: defaultColumnFamily,
+		},
+		defaultColumnFamily: defaultColumnFamily,
+	}
+}
+
+// Current returns the current version of the default column family.
+func (vs *VersionSet) Current() *Version {
+	return vs.defaultColumnFamily.current
+}
+
+// ManifestFileNumber returns the current manifest file number
+func (vs *VersionSet) ManifestFileNumber() uint64 {
+	return vs.manifestFileNumber
+}
+
+// NewFileNumber allocates and returns a new file number
+func (vs *VersionSet) NewFileNumber() uint64 {
+	n := vs.nextFileNumber
+	vs.nextFileNumber++
+	return n
+}
+
+// ReuseFileNumber arranges to reuse "fileNumber" unless a newer file number has
+// already been allocated.
+// REQUIRES: "fileNumber" was returned by a call to NewFileNumber().
+func (vs *VersionSet) ReuseFileNumber(fileNumber uint64) {
+	if vs.nextFileNumber == fileNumber+1 {
+		vs.nextFileNumber = fileNumber
+	}
+}
+
+// NumLevelFiles returns the number of Table files at the specified level of
+// the default column family.
+func (vs *VersionSet) NumLevelFiles(level int) int {
+	return vs.Current().NumFiles(level)
+}
+
+// NumLevelBytes returns the combined file size of all files at the specified
+// level of the default column family.
+func (vs *VersionSet) NumLevelBytes(level int) uint64 {
+	return totalFileSize(vs.Current().files[level])
+}
+
+// LastSequence returns the last sequence number.
+func (vs *VersionSet) LastSequence() SequenceNumber {
+	return vs.lastSequence
+}
+
+// SetLastSequence sets the last sequence number to s.
+func (vs *VersionSet) SetLastSequence(s SequenceNumber) {
+	if s < vs.lastSequence {
+		panic("last sequence goes backward")
+	}
+	vs.lastSequence = s
+}
+
+// MarkFileNumberUsed marks the specified file number as used.
+func (vs *VersionSet) MarkFileNumberUsed(number uint64) {
+	if vs.nextFileNumber <= number {
+		vs.nextFileNumber = number + 1
+	}
+}
+
+// LogNumber returns the number of the oldest log file holding entries
+// missing from the tables of a column family, as of the MANIFEST.
+func (vs *VersionSet) LogNumber() uint64 {
+	var logNumber uint64
+	for i, cfd := range vs.liveColumnFamilies() {
+		if i == 0 || cfd.logNumber < logNumber {
+			logNumber = cfd.logNumber
+		}
+	}
+	return logNumber
+}
+
+// PrevLogNumber returns the log file number for the log file that is currently
+// being compacted, or zero if there is no such log file.
+func (vs *VersionSet) PrevLogNumber() uint64 {
+	return vs.prevLogNumber
+}
+
+// NeedsCompaction returns true iff some level of a column family needs a
+// compaction.
+func (vs *VersionSet) NeedsCompaction() bool {
+	for _, cfd := range vs.liveColumnFamilies() {
+		if cfd.current.compactionScore >= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// AddLiveFiles adds all files listed in any live version to live, the
+// versions of the dropped column families still pinned included.
+func (vs *VersionSet) AddLiveFiles(live map[uint64]struct{}) {
+	for _, cfd := range vs.columnFamilies {
+		for v := range cfd.versions {
+			for level := 0; level < kNumLevels; level++ {
+				for _, f := range v.files[level] {
+					live[f.number] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+// LogAndApply applies *edit to the current version of its column family to
+// form a new descriptor that is both saved to persistent state and installed
+// as the new current version.  Will release *mu while actually writing to
+// the file, the edits of concurrent calls are written one at a time.
+// REQUIRES: *mu is held on entry.
+func (vs *VersionSet) LogAndApply(edit *VersionEdit, mu *sync.Mutex) error {
+	cfd := vs.columnFamilies[edit.columnFamily]
+	if cfd == nil {
+		return Error(Code_InvalidArgument, fmt.Sprintf("unknown column family %d", edit.columnFamily))
+	}
+	return vs.logAndApply(cfd, edit, mu)
+}
+
+// logAndApply is LogAndApply for the column family cfd of edit, which is
+// registered once edit is saved if it creates the family.
+func (vs *VersionSet) logAndApply(cfd *columnFamilyData, edit *VersionEdit, mu *sync.Mutex) error {
+	if vs.manifestWritten == nil {
+		vs.manifestWritten = sync.NewCond(mu)
+	}
+	for vs.writingManifest {
+		vs.manifestWritten.Wait()
+	}
+	if cfd.dropped {
+		// The tables of the edit are obsolete along with the family
+		return nil
+	}
+	vs.writingManifest = true
+	defer func() {
+		vs.writingManifest = false
+		vs.manifestWritten.Broadcast()
+	}()
+
+	if edit.hasLogNumber {
+		if edit.logNumber < cfd.logNumber || edit.logNumber >= vs.nextFileNumber {
+			panic("invalid edit log number")
+		}
+	} else {
+		edit.SetLogNumber(cfd.logNumber)
+	}
+
+	if !edit.hasPrevLogNumber {
+		edit.SetPrevLogNumber(vs.prevLogNumber)
+	}
+
+	edit.SetNextFile(vs.nextFileNumber)
+	edit.SetLastSequence(vs.lastSequence)
+
+	v := newVersion(cfd)
+	builder := newVersionBuilder(cfd, cfd.current)
+	builder.Apply(edit)
+	builder.SaveTo(v)
+	vs.finalize(v)
+
+	// Initialize new descriptor log file if necessary by creating
+	// a temporary file that contains a snapshot of the current version.
+	var newManifestFile string
+	var err error
+	if vs.descriptorLog == nil {
+		// No reason to unlock *mu here since we only hit this path in the
+		// first call to LogAndApply (when opening the database).
+		newManifestFile = DescriptorFileName(vs.dbname, vs.manifestFileNumber)
+		vs.descriptorFile, err = vs.env.NewWritableFile(newManifestFile)
+		if err == nil {
+			vs.descriptorLog = NewLogWriter(vs.descriptorFile, vs.options.Format)
+			err = vs.writeSnapshot(vs.descriptorLog)
+		}
+	}
+
+	// Unlock during expensive MANIFEST log write
+	mu.Unlock()
+
+	// Write new record to MANIFEST log
+	if err == nil {
+		var record []byte
+		edit.encodeTo(&record, vs.options.Format)
+		err = vs.descriptorLog.AddRecord(record)
+		if err == nil {
+			err = vs.descriptorFile.Sync()
+		}
+	}
+
+	// If we just created a new descriptor file, install it by writing a
+	// new CURRENT file that points to it.
+	if err == nil && newManifestFile != "" {
+		err = setCurrentFile(vs.env, vs.dbname, vs.manifestFileNumber)
+	}
+
+	mu.Lock()
+
+	// Install the new version
+	if err == nil {
+		cfd.appendVersion(v)
+		cfd.logNumber = edit.logNumber
+		vs.prevLogNumber = edit.prevLogNumber
+		if edit.isColumnFamilyAdd {
+			vs.columnFamilies[cfd.id] = cfd
+		}
+		if edit.hasMaxColumnFamily && edit.maxColumnFamily > vs.maxColumnFamily {
+			vs.maxColumnFamily = edit.maxColumnFamily
+		}
+		if edit.isColumnFamilyDrop {
+			cfd.drop()
+		}
+	} else if newManifestFile != "" {
+		vs.descriptorLog = nil
+		vs.descriptorFile.Close()
+		vs.descriptorFile = nil
+		vs.env.RemoveFile(newManifestFile)
+	}
+
+	return err
+}
+
+// Recover the last saved descriptor from persistent storage, opening the
+// column families other than the default one with their options in
+// families.  All the column families of the DB have to be opened.
+// Returns whether a new MANIFEST has to be written.
+func (vs *VersionSet) Recover(families map[string]*ColumnFamilyOptions) (bool, error) {
+	// Read "CURRENT" file, which contains a pointer to the current manifest file
+	current, err := readFileToString(vs.env, CurrentFileName(vs.dbname))
+	if err != nil {
+		return false, err
+	}
+	if current == "" || current[len(current)-1] != '\n' {
+		return false, Error(Code_Corruption, "CURRENT file does not end with newline")
+	}
+	current = current[:len(current)-1]
+
+	dscname := vs.dbname + "/" + current
+	file, err := vs.env.NewSequentialFile(dscname)
+	if err != nil {
+		return false, Error(Code_Corruption, "CURRENT points to a non-existent file: "+err.Error())
+	}
+	defer file.Close()
+
+	var havePrevLogNumber, haveNextFile, haveLastSequence bool
+	var nextFile, prevLogNumber, maxColumnFamily uint64
+	var lastSequence SequenceNumber
+	builders := map[uint32]*versionBuilder{
+		kDefaultColumnFamily: newVersionBuilder(vs.defaultColumnFamily, vs.defaultColumnFamily.current),
+	}
+	logNumbers := make(map[uint32]uint64)
+	unopened := make(map[uint32]string) // The families missing from families
+	readRecords := 0
+
+	reader := NewLogReader(file, true, vs.options.Format)
+	for {
+		record, ok := reader.ReadRecord()
+		if !ok {
+			break
+		}
+		readRecords++
+		edit := NewVersionEdit()
+		if err = edit.decodeFrom(record, vs.options.Format); err != nil {
+			return false, err
+		}
+
+		id := edit.columnFamily
+		_, isUnopened := unopened[id]
+		if edit.isColumnFamilyAdd {
+			if builders[id] != nil || isUnopened {
+				return false, Error(Code_Corruption, "column family "+edit.columnFamilyName+" added twice")
+			}
+			if options, ok := families[edit.columnFamilyName]; ok {
+				cfd := newColumnFamilyData(id, edit.columnFamilyName, vs.dbname, vs.options, options)
+				vs.columnFamilies[id] = cfd
+				builders[id] = newVersionBuilder(cfd, cfd.current)
+			} else {
+				unopened[id] = edit.columnFamilyName
+				isUnopened = true
+			}
+		}
+		builder := builders[id]
+		if builder == nil && !isUnopened {
+			return false, Error(Code_Corruption, fmt.Sprintf("unknown column family %d", id))
+		}
+
+		if edit.isColumnFamilyDrop {
+			if id == kDefaultColumnFamily {
+				return false, Error(Code_Corruption, "default column family dropped")
+			}
+			delete(builders, id)
+			delete(vs.columnFamilies, id)
+			delete(logNumbers, id)
+			delete(unopened, id)
+		} else if builder != nil {
+			if edit.hasComparator && edit.comparator != builder.cfd.icmp.comparator.Name() {
+				return false, Error(Code_InvalidArgument,
+					edit.comparator+" does not match existing comparator "+builder.cfd.icmp.comparator.Name())
+			}
+			builder.Apply(edit)
+			if edit.hasLogNumber {
+				logNumbers[id] = edit.logNumber
+			}
+		}
+
+		if edit.hasPrevLogNumber {
+			prevLogNumber = edit.prevLogNumber
+			havePrevLogNumber = true
+		}
+		if edit.hasNextFileNumber {
+			nextFile = edit.nextFileNumber
+			haveNextFile = true
+		}
+		if edit.hasLastSequence {
+			lastSequence = edit.lastSequence
+			haveLastSequence = true
+		}
+		if edit.hasMaxColumnFamily && uint64(edit.maxColumnFamily) > maxColumnFamily {
+			maxColumnFamily = uint64(edit.maxColumnFamily)
+		}
+	}
+	if err := reader.Error(); err != nil {
+		return false, err
+	}
+
+	if !haveNextFile {
+		return false, Error(Code_Corruption, "no meta-nextfile entry in descriptor")
+	} else if !haveLastSequence {
+		return false, Error(Code_Corruption, "no last-sequence-number entry in descriptor")
+	}
+	for id := range builders {
+		if _, ok := logNumbers[id]; !ok {
+			return false, Error(Code_Corruption, "no meta-lognumber entry in descriptor")
+		}
+	}
+
+	if len(unopened) != 0 {
+		var names []string
+		for _, name := range unopened {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return false, Error(Code_InvalidArgument, "column families not opened: "+strings.Join(names, ", "))
+	}
+	for name := range families {
+		if vs.columnFamilyByName(name) == nil {
+			return false, Error(Code_InvalidArgument, "column family not found: "+name)
+		}
+	}
+
+	if !havePrevLogNumber {
+		prevLogNumber = 0
+	}
+
+	vs.MarkFileNumberUsed(prevLogNumber)
+	for id, builder := range builders {
+		cfd := builder.cfd
+		cfd.logNumber = logNumbers[id]
+		vs.MarkFileNumberUsed(cfd.logNumber)
+
+		v := newVersion(cfd)
+		builder.SaveTo(v)
+		// Install recovered version
+		vs.finalize(v)
+		cfd.appendVersion(v)
+	}
+	vs.manifestFileNumber = nextFile
+	vs.nextFileNumber = nextFile + 1
+	vs.lastSequence = lastSequence
+	vs.prevLogNumber = prevLogNumber
+	vs.maxColumnFamily = uint32(maxColumnFamily)
+
+	// TODO: reuse the existing MANIFEST like Options.ReuseLogs, a new one is
+	// written on every open for now.
+	return true, nil
+}
+
+func (vs *VersionSet) finalize(v *Version) {
+	// Precomputed best level for next compaction
+	bestLevel := -1
+	bestScore := -1.0
+
+	for level := 0; level < kNumLevels-1; level++ {
+		var score float64
+		if level == 0 {
+			// We treat level-0 specially by bounding the number of files
+			// instead of number of bytes for two reasons:
+			//
+			// (1) With larger write-buffer sizes, it is nice not to do too
+			// many level-0 compactions.
+			//
+			// (2) The files in level-0 are merged on every read and
+			// therefore we wish to avoid too many files when the individual
+			// file size is small (perhaps because of a small write-buffer
+			// setting, or very high compression ratios, or lots of
+			// overwrites/deletions).
+			score = float64(len(v.files[level])) / float64(kL0_CompactionTrigger)
+		} else {
+			// Compute the ratio of current size to size limit.
+			levelBytes := totalFileSize(v.files[level])
+			score = float64(levelBytes) / maxBytesForLevel(level)
+		}
+
+		if score > bestScore {
+			bestLevel = level
+			bestScore = score
+		}
+	}
+
+	v.compactionLevel = bestLevel
+	v.compactionScore = bestScore
+}
+
+// writeSnapshot saves current contents to log, an edit per column family
+func (vs *VersionSet) writeSnapshot(log *logWriter) error {
+	for _, cfd := range vs.liveColumnFamilies() {
+		// Save metadata
+		edit := NewVersionEdit()
+		edit.SetColumnFamily(cfd.id)
+		if cfd.id != kDefaultColumnFamily {
+			edit.AddColumnFamily(cfd.name)
+		} else {
+			edit.SetMaxColumnFamily(vs.maxColumnFamily)
+		}
+		edit.SetComparatorName(cfd.icmp.comparator.Name())
+		edit.SetLogNumber(cfd.logNumber)
+
+		// Save compaction pointers
+		for level := 0; level < kNumLevels; level++ {
+			if len(cfd.compactPointer[level]) != 0 {
+				edit.SetCompactPointer(level, cfd.compactPointer[level])
+			}
+		}
+
+		// Save files
+		for level := 0; level < kNumLevels; level++ {
+			for _, f := range cfd.current.files[level] {
+				edit.AddFile(level, f.number, f.fileSize, f.smallest, f.largest)
+			}
+		}
+
+		var record []byte
+		edit.encodeTo(&record, vs.options.Format)
+		if err := log.AddRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRange returns the minimum range that covers all entries in inputs.
+// REQUIRES: inputs is not empty
+func getRange(icmp *internalKeyComparator, inputs []*FileMetaData) ([]byte, []byte) {
+	if len(inputs) == 0 {
+		panic("inputs are empty")
+	}
+	var smallest, largest []byte
+	for i, f := range inputs {
+		if i == 0 {
+			smallest = f.smallest
+			largest = f.largest
+		} else {
+			if icmp.Compare(f.smallest, smallest) < 0 {
+				smallest = f.smallest
+			}
+			if icmp.Compare(f.largest, largest) > 0 {
+				largest = f.largest
+			}
+		}
+	}
+	return smallest, largest
+}
+
+// getRange2 returns the minimum range that covers all entries in inputs1 and inputs2.
+// REQUIRES: inputs is not empty
+func getRange2(icmp *internalKeyComparator, inputs1, inputs2 []*FileMetaData) ([]byte, []byte) {
+	all := append(append([]*FileMetaData{}, inputs1...), inputs2...)
+	return getRange(icmp, all)
+}
+
+// MakeInputIterator creates an iterator that reads over the compaction inputs for "c".
+func (vs *VersionSet) MakeInputIterator(c *Compaction) Iterator {
+	cfd := c.cfd
+	options := &ReadOptions{
+		VerifyChecksums: vs.options.ParanoidChecks,
+		FillCache:       false,
+	}
+
+	// Level-0 files have to be merged together.  For other levels,
+	// we will make a concatenating iterator per level.
+	var list []Iterator
+	for which := 0; which < 2; which++ {
+		if len(c.inputs[which]) != 0 {
+			if c.level+which == 0 {
+				for _, f := range c.inputs[which] {
+					list = append(list, cfd.tableCache.NewIterator(options, f.number, f.fileSize))
+				}
+			} else {
+				// Create concatenating iterator for the files from this level
+				list = append(list, NewTwoLevelIterator(
+					newLevelFileNumIterator(cfd.icmp, c.inputs[which]),
+					func(fileValue []byte) Iterator {
+						return cfd.tableCache.NewIterator(options,
+							util.DecodeUint64Fixed(fileValue), util.DecodeUint64Fixed(fileValue[8:]))
+					}))
+			}
+		}
+	}
+	return NewMergingIterator(cfd.icmp, list)
+}
+
+// PickCompaction picks level and inputs for a new compaction.
+// Returns nil if there is no compaction to be done.
+// Otherwise returns a pointer to a heap-allocated object that
+// describes the compaction.
+func (vs *VersionSet) PickCompaction() *Compaction {
+	// We prefer compactions triggered by too much data in a level over
+	// the compactions triggered by seeks.  The column family with the
+	// highest score is compacted first.
+	var cfd *columnFamilyData
+	for _, f := range vs.liveColumnFamilies() {
+		if f.current.compactionScore >= 1 && (cfd == nil || f.current.compactionScore > cfd.current.compactionScore) {
+			cfd = f
+		}
+	}
+	if cfd == nil {
+		return nil
+	}
+	current := cfd.current
+	level := current.compactionLevel
+	if level < 0 || level+1 >= kNumLevels {
+		panic("invalid compaction level")
+	}
+	c := newCompaction(cfd, level)
+
+	// Pick the first file that comes after compactPointer[level]
+	for _, f := range current.files[level] {
+		if len(cfd.compactPointer[level]) == 0 || cfd.icmp.Compare(f.largest, cfd.compactPointer[level]) > 0 {
+			c.inputs[0] = append(c.inputs[0], f)
+			break
+		}
+	}
+	if len(c.inputs[0]) == 0 {
+		// Wrap-around to the beginning of the key space
+		c.inputs[0] = append(c.inputs[0], current.files[level][0])
+	}
+
+	c.inputVersion = current
+	c.inputVersion.Ref()
+
+	// Files in level 0 may overlap each other, so pick up all overlapping ones
+	if level == 0 {
+		smallest, largest := getRange(cfd.icmp, c.inputs[0])
+		// Note that the next call will discard the file we placed in
+		// c.inputs[0] earlier and replace it with an overlapping set
+		// which will include the picked file.
+		c.inputs[0] = current.GetOverlappingInputs(0, smallest, largest)
+		if len(c.inputs[0]) == 0 {
+			panic("level-0 compaction inputs are empty")
+		}
+	}
+
+	vs.setupOtherInputs(c)
+	return c
+}
+
+// findLargestKey finds the largest key in a vector of files. Returns nil if files is empty.
+func findLargestKey(icmp *internalKeyComparator, files []*FileMetaData) []byte {
+	var largestKey []byte
+	for _, f := range files {
+		if largestKey == nil || icmp.Compare(f.largest, largestKey) > 0 {
+			largestKey = f.largest
+		}
+	}
+	return largestKey
+}
+
+// findSmallestBoundaryFile finds minimum file b2=(l2, u2) in level file for which l2 > u1 and
+// user_key(l2) = user_key(u1)
+func findSmallestBoundaryFile(icmp *internalKeyComparator, levelFiles []*FileMetaData, largestKey []byte) *FileMetaData {
+	userCmp := icmp.comparator
+	var smallestBoundaryFile *FileMetaData
+	for _, f := range levelFiles {
+		if icmp.Compare(f.smallest, largestKey) > 0 &&
+			userCmp.Compare(ExtractUserKey(f.smallest), ExtractUserKey(largestKey)) == 0 {
+			if smallestBoundaryFile == nil || icmp.Compare(f.smallest, smallestBoundaryFile.smallest) < 0 {
+				smallestBoundaryFile = f
+			}
+		}
+	}
+	return smallestBoundaryFile
+}
+
+// addBoundaryInputs extracts the largest file b1 from compactionFiles and then
+// searches for a b2 in levelFiles for which user_key(u1) = user_key(l2). If
+// it finds such a file b2 (known as a boundary file) it adds it to
+// compactionFiles and then searches again using this new upper bound.
+//
+// If there are two blocks, b1=(l1, u1) and b2=(l2, u2) and
+// user_key(u1) = user_key(l2), and if we compact b1 but not b2 then a
+// subsequent get operation will yield an incorrect result because it will
+// return the record from b2 in level i rather than from b1 because it searches
+// level by level for records matching the supplied user key.
+func addBoundaryInputs(icmp *internalKeyComparator, levelFiles []*FileMetaData, compactionFiles *[]*FileMetaData) {
+	largestKey := findLargestKey(icmp, *compactionFiles)
+	// Quick return if compactionFiles is empty.
+	if largestKey == nil {
+		return
+	}
+
+	for {
+		smallestBoundaryFile := findSmallestBoundaryFile(icmp, levelFiles, largestKey)
+
+		// If a boundary file was found advance largestKey, otherwise we're done.
+		if smallestBoundaryFile == nil {
+			return
+		}
+		*compactionFiles = append(*compactionFiles, smallestBoundaryFile)
+		largestKey = smallestBoundaryFile.largest
+	}
+}
+
+func (vs *VersionSet) setupOtherInputs(c *Compaction) {
+	level := c.level
+	cfd := c.cfd
+	current := c.inputVersion
+
+	addBoundaryInputs(cfd.icmp, current.files[level], &c.inputs[0])
+	smallest, largest := getRange(cfd.icmp, c.inputs[0])
+
+	c.inputs[1] = current.GetOverlappingInputs(level+1, smallest, largest)
+	addBoundaryInputs(cfd.icmp, current.files[level+1], &c.inputs[1])
+
+	// Get entire range covered by compaction
+	allStart, allLimit := getRange2(cfd.icmp, c.inputs[0], c.inputs[1])
+
+	// See if we can grow the number of inputs in "level" without
+	// changing the number of "level+1" files we pick up.
+	if len(c.inputs[1]) != 0 {
+		expanded0 := current.GetOverlappingInputs(level, allStart, allLimit)
+		addBoundaryInputs(cfd.icmp, current.files[level], &expanded0)
+		inputs0Size := totalFileSize(c.inputs[0])
+		inputs1Size := totalFileSize(c.inputs[1])
+		expanded0Size := totalFileSize(expanded0)
+		if len(expanded0) > len(c.inputs[0]) &&
+			inputs1Size+expanded0Size < expandedCompactionByteSizeLimit(cfd.options) {
+			newStart, newLimit := getRange(cfd.icmp, expanded0)
+			expanded1 := current.GetOverlappingInputs(level+1, newStart, newLimit)
+			addBoundaryInputs(cfd.icmp, current.files[level+1], &expanded1)
+			if len(expanded1) == len(c.inputs[1]) {
+				_ = inputs0Size
+				smallest = newStart
+				largest = newLimit
+				c.inputs[0] = expanded0
+				c.inputs[1] = expanded1
+				allStart, allLimit = getRange2(cfd.icmp, c.inputs[0], c.inputs[1])
+			}
+		}
+	}
+
+	// Compute the set of grandparent files that overlap this compaction
+	// (parent == level+1; grandparent == level+2)
+	if level+2 < kNumLevels {
+		c.grandparents = current.GetOverlappingInputs(level+2, allStart, allLimit)
+	}
+
+	// Update the place where we will do the next compaction for this level.
+	// We update this immediately instead of waiting for the VersionEdit
+	// to be applied so that if the compaction fails, we will try a different
+	// key range next time.
+	cfd.compactPointer[level] = append([]byte{}, largest...)
+	c.edit.SetCompactPointer(level, largest)
+}
+
+// LevelSummary returns a human-readable short (single-line) summary of the number
+// of files per level of the default column family.
+func (vs *VersionSet) LevelSummary() string {
+	var r strings.Builder
+	r.WriteString("files[")
+	for level := 0; level < kNumLevels; level++ {
+		fmt.Fprintf(&r, " %d", len(vs.Current().files[level]))
+	}
+	r.WriteString(" ]")
+	return r.String()
+}
+
+// versionBuilder is a helper class so we can efficiently apply a whole sequence
+// of edits to a particular state without creating intermediate
+// Versions that contain full copies of the intermediate state.
+type versionBuilder struct {
+	cfd    *columnFamilyData
+	base   *Version
+	levels [kNumLevels]struct {
+		deletedFiles map[uint64]struct{}
+		addedFiles   []*FileMetaData
+	}
+}
+
+func newVersionBuilder(cfd *columnFamilyData, base *Version) *versionBuilder {
+	b := &versionBuilder{
+		cfd:  cfd,
+		base: base,
+	}
+	for level := 0; level < kNumLevels; level++ {
+		b.levels[level].deletedFiles = make(map[uint64]struct{})
+	}
+	return b
+}
+
+// Apply all of the edits in edit to the current state.
+func (b *versionBuilder) Apply(edit *VersionEdit) {
+	// Update compaction pointers
+	for _, p := range edit.compactPointers {
+		b.cfd.compactPointer[p.level] = append([]byte{}, p.key...)
+	}
+
+	// Delete files
+	for f := range edit.deletedFiles {
+		b.levels[f.level].deletedFiles[f.number] = struct{}{}
+	}
+
+	// Add new files
+	for _, nf := range edit.newFiles {
+		f := *nf.meta
+		f.refs = 1
+
+		// We arrange to automatically compact this file after
+		// a certain number of seeks.  Let's assume:
+		//   (1) One seek costs 10ms
+		//   (2) Writing or reading 1MB costs 10ms (100MB/s)
+		//   (3) A compaction of 1MB does 25MB of IO:
+		//         1MB read from this level
+		//         10-12MB read from next level (boundaries may be misaligned)
+		//         10-12MB written to next level
+		// This implies that 25 seeks cost the same as the compaction
+		// of 1MB of data.  I.e., one seek costs approximately the
+		// same as the compaction of 40KB of data.  We are a little
+		// conservative and allow approximately one seek for every 16KB
+		// of data before triggering a compaction.
+		f.allowedSeeks = int(f.fileSize / 16384)
+		if f.allowedSeeks < 100 {
+			f.allowedSeeks = 100
+		}
+
+		delete(b.levels[nf.level].deletedFiles, f.number)
+		b.levels[nf.level].addedFiles = append(b.levels[nf.level].addedFiles, &f)
+	}
+}
+
+// SaveTo saves the current state in v.
+func (b *versionBuilder) SaveTo(v *Version) {
+	icmp := b.cfd.icmp
+	for level := 0; level < kNumLevels; level++ {
+		// Merge the set of added files with the set of pre-existing files.
+		// Drop any deleted files.  Store the result in v.
+		added := append([]*FileMetaData{}, b.levels[level].addedFiles...)
+		sort.SliceStable(added, func(i, j int) bool {
+			return bySmallestKey(icmp, added[i], added[j])
+		})
+		baseFiles := b.base.files[level]
+
+		merged := make([]*FileMetaData, 0, len(baseFiles)+len(added))
+		i, j := 0, 0
+		for i < len(baseFiles) || j < len(added) {
+			if j >= len(added) || (i < len(baseFiles) && !bySmallestKey(icmp, added[j], baseFiles[i])) {
+				merged = append(merged, baseFiles[i])
+				i++
+			} else {
+				merged = append(merged, added[j])
+				j++
+			}
+		}
+
+		for _, f := range merged {
+			b.maybeAddFile(v, level, f)
+		}
+
+		// Make sure there is no overlap in levels > 0
+		if level > 0 {
+			for i := 1; i < len(v.files[level]); i++ {
+				prevEnd := v.files[level][i-1].largest
+				thisBegin := v.files[level][i].smallest
+				if icmp.Compare(prevEnd, thisBegin) >= 0 {
+					panic(fmt.Sprintf("overlapping ranges in same level %s vs. %s",
+						util.EscapeString(prevEnd), util.EscapeString(thisBegin)))
+				}
+			}
+		}
+	}
+}
+
+func (b *versionBuilder) maybeAddFile(v *Version, level int, f *FileMetaData) {
+	if _, ok := b.levels[level].deletedFiles[f.number]; ok {
+		// File is deleted: do nothing
+		return
+	}
+	files := v.files[level]
+	if level > 0 && len(files) != 0 {
+		// Must not overlap
+		if b.cfd.icmp.Compare(files[len(files)-1].largest, f.smallest) >= 0 {
+			panic("new file overlaps with the previous one")
+		}
+	}
+	v.files[level] = append(files, f)
+}
+
+func bySmallestKey(icmp *internalKeyComparator, f1, f2 *FileMetaData) bool {
+	r := icmp.Compare(f1.smallest, f2.smallest)
+	if r != 0 {
+		return r < 0
+	}
+	// Break ties by file number
+	return f1.number < f2.number
+}
+
+// Compaction encapsulates information about a compaction.
+type Compaction struct {
+	cfd               *columnFamilyData // Column family being compacted
+	level             int
+	maxOutputFileSize uint64
+	inputVersion      *Version
+	edit              *VersionEdit
+
+	// Each compaction reads inputs from "level" and "level+1"
+	inputs [2][]*FileMetaData // The two sets of inputs
+
+	// State used to check for number of overlapping grandparent files
+	// (parent == level + 1, grandparent == level + 2)
+	grandparents     []*FileMetaData
+	grandparentIndex int    // Index in grandparent_starts_
+	seenKey          bool   // Some output key has been seen
+	overlappedBytes  uint64 // Bytes of overlap between current output
+	// and grandparent files
+
+	// State for implementing IsBaseLevelForKey
+
+	// levelPtrs holds indices into inputVersion.files: our state
+	// is that we are positioned at one of the file ranges for each
+	// higher level than the ones involved in this compaction (i.e. for
+	// all L >= level + 2).
+	levelPtrs [kNumLevels]int
+}
+
+func newCompaction(cfd *columnFamilyData, level int) *Compaction {
+	c := &Compaction{
+		cfd:               cfd,
+		level:             level,
+		maxOutputFileSize: maxFileSizeForLevel(cfd.options, level),
+		edit:              NewVersionEdit(),
+	}
+	c.edit.SetColumnFamily(cfd.id)
+	return c
+}
+
+// Level returns the level that is being compacted.  Inputs from "level"
+// and "level+1" will be merged to produce a set of "level+1" files.
+func (c *Compaction) Level() int {
+	return c.level
+}
+
+// Edit returns the object that holds the edits to the descriptor done
+// by this compaction.
+func (c *Compaction) Edit() *VersionEdit {
+	return c.edit
+}
+
+// NumInputFiles returns the number of input files, "which" must be either 0 or 1
+func (c *Compaction) NumInputFiles(which int) int {
+	return len(c.inputs[which])
+}
+
+// Input returns the ith input file at "level()+which" ("which" must be 0 or 1).
+func (c *Compaction) Input(which, i int) *FileMetaData {
+	return c.inputs[which][i]
+}
+
+// MaxOutputFileSize is the maximum size of files to build during this compaction.
+func (c *Compaction) MaxOutputFileSize() uint64 {
+	return c.maxOutputFileSize
+}
+
+// IsTrivialMove returns true if the compaction is trivial and can be
+// implemented by just moving a single input file to the next level
+// (no merging or splitting)
+func (c *Compaction) IsTrivialMove() bool {
+	// Avoid a move if there is lots of overlapping grandparent data.
+	// Otherwise, the move could create a parent file that will require
+	// a very expensive merge later on.
+	return c.NumInputFiles(0) == 1 && c.NumInputFiles(1) == 0 &&
+		totalFileSize(c.grandparents) <= maxGrandParentOverlapBytes(c.cfd.options)
+}
+
+// AddInputDeletions adds all inputs to this compaction as delete operations to edit.
+func (c *Compaction) AddInputDeletions(edit *VersionEdit) {
+	for which := 0; which < 2; which++ {
+		for _, f := range c.inputs[which] {
+			edit.RemoveFile(c.level+which, f.number)
+		}
+	}
+}
+
+// IsBaseLevelForKey returns true if the information we have available guarantees that
+// the compaction is producing data in "level+1" for which no data exists
+// in levels greater than "level+1".
+func (c *Compaction) IsBaseLevelForKey(userKey []byte) bool {
+	// Maybe use binary search to find right entry instead of linear search?
+	userCmp := c.cfd.icmp.comparator
+	for lvl := c.level + 2; lvl < kNumLevels; lvl++ {
+		files := c.inputVersion.files[lvl]
+		for c.levelPtrs[lvl] < len(files) {
+			f := files[c.levelPtrs[lvl]]
+			if userCmp.Compare(userKey, ExtractUserKey(f.largest)) <= 0 {
+				// We've advanced far enough
+				if userCmp.Compare(userKey, ExtractUserKey(f.smallest)) >= 0 {
+					// Key falls in this file's range, so definitely not base level
+					return false
+				}
+				break
+			}
+			c.levelPtrs[lvl]++
+		}
+	}
+	return true
+}
+
+// IsBaseLevelForRange returns true if the information we have available
+// guarantees that no data exists in levels greater than "level+1" for the
+// user keys in [smallestUserKey, largestUserKey].
+func (c *Compaction) IsBaseLevelForRange(smallestUserKey, largestUserKey []byte) bool {
+	for lvl := c.level + 2; lvl < kNumLevels; lvl++ {
+		if c.inputVersion.OverlapInLevel(lvl, smallestUserKey, largestUserKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// ShouldStopBefore returns true iff we should stop building the current output
+// before processing "internalKey".
+func (c *Compaction) ShouldStopBefore(internalKey []byte) bool {
+	// Scan to find earliest grandparent file that contains key.
+	icmp := c.cfd.icmp
+	for c.grandparentIndex < len(c.grandparents) &&
+		icmp.Compare(internalKey, c.grandparents[c.grandparentIndex].largest) > 0 {
+		if c.seenKey {
+			c.overlappedBytes += c.grandparents[c.grandparentIndex].fileSize
+		}
+		c.grandparentIndex++
+	}
+	c.seenKey = true
+
+	if c.overlappedBytes > maxGrandParentOverlapBytes(c.cfd.options) {
+		// Too much overlap for current output; start new output
+		c.overlappedBytes = 0
+		return true
+	}
+	return false
+}
+
+// ReleaseInputs releases the input version for the compaction, once the compaction
+// is successful.
+func (c *Compaction) ReleaseInputs() {
+	if c.inputVersion != nil {
+		c.inputVersion.Unref()
+		c.inputVersion = nil
+	}
+}
+
+// setCurrentFile makes the CURRENT file point to the descriptor file with the
+// specified number.
+func setCurrentFile(env *Env, dbname string, descriptorNumber uint64) error {
+	// Remove leading "dbname/" and add newline to manifest file name
+	manifest := DescriptorFileName(dbname, descriptorNumber)
+	contents := strings.TrimPrefix(manifest, dbname+"/")
+	tmp := TempFileName(dbname, descriptorNumber)
+	err := writeStringToFileSync(env, contents+"\n", tmp)
+	if err == nil {
+		err = env.RenameFile(tmp, CurrentFileName(dbname))
+	}
+	if err != nil {
+		env.RemoveFile(tmp)
+	}
+	return err
+}
+
+func writeStringToFileSync(env *Env, data, fname string) error {
+	file, err := env.NewWritableFile(fname)
+	if err != nil {
+		return err
+	}
+	err = file.Append([]byte(data))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		env.RemoveFile(fname)
+	}
+	return err
+}
+
+func readFileToString(env *Env, fname string) (string, error) {
+	file, err := env.NewSequentialFile(fname)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var r strings.Builder
+	buf := make([]byte, 8192)
+	for {
+		n, err := file.Read(buf)
+		r.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	return r.String(), nil
+}
@@ -0,0 +1,557 @@
+package leveldb
+
+import (
+	"math"
+
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+// WriteBatch holds a collection of updates to apply atomically to a DB.
+//
+// The updates are applied in the order in which they are added
+// to the WriteBatch.  For example, the value of "key" will be "v3"
+// after the following batch is written:
+//
+//	batch.Put("key", "v1")
+//	batch.Delete("key")
+//	batch.Put("key", "v2")
+//	batch.Put("key", "v3")
+//
+// Multiple goroutines can invoke const methods on a WriteBatch without
+// external synchronization, but if any of the goroutines may call a
+// non-const method, all goroutines accessing the same WriteBatch must use
+// external synchronization.
+//
+// WriteBatch rep has the following format:
+//
+//	rep :=
+//	   sequence: fixed64
+//	   count: fixed32
+//	   data: record[count]
+//	record :=
+//	   kTypeColumnFamily columnFamily entry |
+//	   entry
+//	entry :=
+//	   kTypeValue varstring varstring         |
+//	   kTypeDeletion varstring                |
+//	   kTypeValueWithExpiry varstring expiring |
+//	   kTypeMerge varstring varstring          |
+//	   kTypeRangeDeletion varstring varstring  |
+//	   kTypeSingleDeletion varstring
+//	columnFamily := varint32
+//	varstring :=
+//	   len: varint32
+//	   data: uint8[len]
+//	expiring :=
+//	   len: varint32
+//	   data: uint8[len-8]
+//	   expiry: fixed64
+type WriteBatch struct {
+	rep []byte
+	// The states of the batch at the savepoints, see SetSavePoint
+	savePoints []writeBatchSavePoint
+}
+
+type writeBatchSavePoint struct {
+	size  int // of rep
+	count int
+}
+
+// WriteBatch header has an 8-byte sequence number followed by a 4-byte count.
+const kWriteBatchHeader = Uint64Size + Uint32Size
+
+// kTypeColumnFamily prefixes the entries of the column families other than
+// the default one.  It only appears in batches, never in internal keys.
+const kTypeColumnFamily ValueType = 0x7f
+
+// WriteBatchHandler receives the records of a WriteBatch from Iterate, along
+// with the id of their column family
+type WriteBatchHandler interface {
+	Put(columnFamily uint32, key, value []byte)
+	Delete(columnFamily uint32, key []byte)
+	// PutWithExpiry receives the records of PutWithExpiry
+	PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64)
+	Merge(columnFamily uint32, key, operand []byte)
+	DeleteRange(columnFamily uint32, begin, end []byte)
+	SingleDelete(columnFamily uint32, key []byte)
+}
+
+func NewWriteBatch() *WriteBatch {
+	wb := &WriteBatch{}
+	wb.Clear()
+	return wb
+}
+
+// Put stores the mapping "key->value" in the database.
+func (wb *WriteBatch) Put(key, value []byte) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_Value, key, value)
+}
+
+// PutCF stores the mapping "key->value" in the column family cf.
+func (wb *WriteBatch) PutCF(cf *ColumnFamilyHandle, key, value []byte) {
+	wb.addRecord(cf.ID(), ValueType_Value, key, value)
+}
+
+// PutWithExpiry stores the mapping "key->value" in the database until
+// expiry, in micro-seconds since the Unix epoch.  The mapping is hidden
+// from reads at and after expiry and dropped by compactions.
+func (wb *WriteBatch) PutWithExpiry(key, value []byte, expiry uint64) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_ValueWithExpiry, key, appendExpiry(value, expiry))
+}
+
+// PutWithExpiryCF is PutWithExpiry in the column family cf.
+func (wb *WriteBatch) PutWithExpiryCF(cf *ColumnFamilyHandle, key, value []byte, expiry uint64) {
+	wb.addRecord(cf.ID(), ValueType_ValueWithExpiry, key, appendExpiry(value, expiry))
+}
+
+// Merge merges "operand" into the mapping of "key" in the database, with
+// the Options.MergeOperator of the DB.
+func (wb *WriteBatch) Merge(key, operand []byte) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_Merge, key, operand)
+}
+
+// MergeCF merges "operand" into the mapping of "key" in the column family
+// cf.
+func (wb *WriteBatch) MergeCF(cf *ColumnFamilyHandle, key, operand []byte) {
+	wb.addRecord(cf.ID(), ValueType_Merge, key, operand)
+}
+
+// DeleteRange erases the mappings for the keys in ["begin", "end") if the
+// database contains any.
+func (wb *WriteBatch) DeleteRange(begin, end []byte) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_RangeDeletion, begin, end)
+}
+
+// DeleteRangeCF erases the mappings for the keys in ["begin", "end") from
+// the column family cf.
+func (wb *WriteBatch) DeleteRangeCF(cf *ColumnFamilyHandle, begin, end []byte) {
+	wb.addRecord(cf.ID(), ValueType_RangeDeletion, begin, end)
+}
+
+// appendExpiry returns a copy of value followed by its expiry, the value of
+// the ValueType_ValueWithExpiry entries.
+func appendExpiry(value []byte, expiry uint64) []byte {
+	v := make([]byte, 0, len(value)+Uint64Size)
+	v = append(v, value...)
+	return append(v, util.EncodeUint64Fixed(expiry)...)
+}
+
+// Delete erases the mapping for "key" if the database contains it.
+func (wb *WriteBatch) Delete(key []byte) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_Deletion, key)
+}
+
+// DeleteCF erases the mapping for "key" if the column family cf contains it.
+func (wb *WriteBatch) DeleteCF(cf *ColumnFamilyHandle, key []byte) {
+	wb.addRecord(cf.ID(), ValueType_Deletion, key)
+}
+
+// SingleDelete erases the mapping for "key", written by a single Put, if the
+// database contains it.  See DB.SingleDelete.
+func (wb *WriteBatch) SingleDelete(key []byte) {
+	wb.addRecord(kDefaultColumnFamily, ValueType_SingleDeletion, key)
+}
+
+// SingleDeleteCF is SingleDelete in the column family cf.
+func (wb *WriteBatch) SingleDeleteCF(cf *ColumnFamilyHandle, key []byte) {
+	wb.addRecord(cf.ID(), ValueType_SingleDeletion, key)
+}
+
+// addRecord appends a record of type t with the length prefixed fields to
+// the batch, for the column family with id columnFamily.
+func (wb *WriteBatch) addRecord(columnFamily uint32, t ValueType, fields ...[]byte) {
+	wb.setCount(wb.Count() + 1)
+	if columnFamily != kDefaultColumnFamily {
+		wb.rep = append(wb.rep, byte(kTypeColumnFamily))
+		util.PutUvarint(&wb.rep, uint64(columnFamily))
+	}
+	wb.rep = append(wb.rep, byte(t))
+	for _, field := range fields {
+		util.PutVarLengthPrefixedBytes(&wb.rep, field)
+	}
+}
+
+// Clear all updates buffered in this batch, and its savepoints.
+func (wb *WriteBatch) Clear() {
+	wb.rep = make([]byte, kWriteBatchHeader)
+	wb.savePoints = nil
+}
+
+// SetSavePoint records the state of the batch, to which
+// RollbackToSavePoint returns.  Savepoints nest.
+func (wb *WriteBatch) SetSavePoint() {
+	wb.savePoints = append(wb.savePoints, writeBatchSavePoint{len(wb.rep), wb.Count()})
+}
+
+// RollbackToSavePoint removes the updates added since the last savepoint,
+// and the savepoint.  It returns a Code_NotFound error if there is none.
+func (wb *WriteBatch) RollbackToSavePoint() error {
+	if len(wb.savePoints) == 0 {
+		return Error(Code_NotFound, "no savepoint")
+	}
+	sp := wb.savePoints[len(wb.savePoints)-1]
+	wb.savePoints = wb.savePoints[:len(wb.savePoints)-1]
+	wb.rep = wb.rep[:sp.size]
+	wb.setCount(sp.count)
+	return nil
+}
+
+// ApproximateSize returns the size of the database changes caused by this batch.
+//
+// This number is tied to implementation details, and may change across
+// releases. It is intended for LevelDB usage metrics.
+func (wb *WriteBatch) ApproximateSize() int {
+	return len(wb.rep)
+}
+
+// Append copies the operations in "source" to this batch.
+//
+// This runs in O(source size) time. However, the constant factor is better
+// than calling Iterate() over the source batch with a Handler that replicates
+// the operations into this batch.
+func (wb *WriteBatch) Append(source *WriteBatch) {
+	wb.setCount(wb.Count() + source.Count())
+	wb.rep = append(wb.rep, source.rep[kWriteBatchHeader:]...)
+}
+
+// Count returns the number of entries in the batch.
+func (wb *WriteBatch) Count() int {
+	return int(util.DecodeUint32Fixed(wb.rep[Uint64Size:]))
+}
+
+// Iterate calls handler for every record of the batch in order.
+func (wb *WriteBatch) Iterate(handler WriteBatchHandler) error {
+	input := wb.rep
+	if len(input) < kWriteBatchHeader {
+		return Error(Code_Corruption, "malformed WriteBatch (too small)")
+	}
+
+	input = input[kWriteBatchHeader:]
+	var found int
+	for len(input) > 0 {
+		found++
+		tag := ValueType(input[0])
+		input = input[1:]
+		var columnFamily uint32 = kDefaultColumnFamily
+		if tag == kTypeColumnFamily {
+			id, n := util.DecodeUvarint(input)
+			if n <= 0 || id > math.MaxUint32 || n == len(input) {
+				return Error(Code_Corruption, "bad WriteBatch column family")
+			}
+			columnFamily = uint32(id)
+			tag = ValueType(input[n])
+			input = input[n+1:]
+		}
+		switch tag {
+		case ValueType_Value:
+			key, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch Put")
+			}
+			value, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch Put")
+			}
+			handler.Put(columnFamily, key, value)
+		case ValueType_Deletion:
+			key, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch Delete")
+			}
+			handler.Delete(columnFamily, key)
+		case ValueType_ValueWithExpiry:
+			key, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch PutWithExpiry")
+			}
+			value, ok := getLengthPrefixedSlice(&input)
+			if !ok || len(value) < Uint64Size {
+				return Error(Code_Corruption, "bad WriteBatch PutWithExpiry")
+			}
+			n := len(value) - Uint64Size
+			handler.PutWithExpiry(columnFamily, key, value[:n], util.DecodeUint64Fixed(value[n:]))
+		case ValueType_Merge:
+			key, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch Merge")
+			}
+			operand, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch Merge")
+			}
+			handler.Merge(columnFamily, key, operand)
+		case ValueType_RangeDeletion:
+			begin, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch DeleteRange")
+			}
+			end, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch DeleteRange")
+			}
+			handler.DeleteRange(columnFamily, begin, end)
+		case ValueType_SingleDeletion:
+			key, ok := getLengthPrefixedSlice(&input)
+			if !ok {
+				return Error(Code_Corruption, "bad WriteBatch SingleDelete")
+			}
+			handler.SingleDelete(columnFamily, key)
+		default:
+			return Error(Code_Corruption, "unknown WriteBatch tag")
+		}
+	}
+	if found != wb.Count() {
+		return Error(Code_Corruption, "WriteBatch has wrong count")
+	}
+	return nil
+}
+
+// sequence returns the sequence number for the start of this batch.
+func (wb *WriteBatch) sequence() SequenceNumber {
+	return SequenceNumber(util.DecodeUint64Fixed(wb.rep))
+}
+
+// setSequence stores the specified number as the sequence number for the start of
+// this batch.
+func (wb *WriteBatch) setSequence(seq SequenceNumber) {
+	copy(wb.rep, util.EncodeUint64Fixed(uint64(seq)))
+}
+
+func (wb *WriteBatch) setCount(n int) {
+	copy(wb.rep[Uint64Size:], util.EncodeUint32Fixed(uint32(n)))
+}
+
+func (wb *WriteBatch) contents() []byte {
+	return wb.rep
+}
+
+func (wb *WriteBatch) setContents(contents []byte) {
+	if len(contents) < kWriteBatchHeader {
+		panic("contents size < kWriteBatchHeader")
+	}
+	wb.rep = append(wb.rep[:0], contents...)
+}
+
+// encodedContents returns the batch as written to a log in format.
+func (wb *WriteBatch) encodedContents(format Format) []byte {
+	if format != Format_LevelDB {
+		return wb.rep
+	}
+	rep := append([]byte{}, wb.rep...)
+	swapWriteBatchHeader(rep)
+	return rep
+}
+
+// setEncodedContents sets the batch to contents read from a log in format.
+func (wb *WriteBatch) setEncodedContents(contents []byte, format Format) {
+	wb.setContents(contents)
+	if format == Format_LevelDB {
+		swapWriteBatchHeader(wb.rep)
+	}
+}
+
+// swapWriteBatchHeader reverses the byte order of the sequence and of the
+// count of rep.
+func swapWriteBatchHeader(rep []byte) {
+	reverseBytes(rep[:Uint64Size])
+	reverseBytes(rep[Uint64Size:kWriteBatchHeader])
+}
+
+// insertInto applies the batch to mems, the memtables of the column
+// families by id, the entries get consecutive sequence numbers starting at
+// wb.sequence().  The entries of the families missing from mems are
+// skipped: the family was dropped, or when recovering a log, its entries
+// in the log are in tables already.  For a concurrent memtable several
+// batches with disjoint sequence ranges may be inserted at once.
+func (wb *WriteBatch) insertInto(mems map[uint32]*MemTable) error {
+	return wb.insertIntoAt(wb.sequence(), mems)
+}
+
+// insertIntoAt is insertInto with the entries numbered from seq instead of
+// the sequence of the batch, which is left untouched.
+func (wb *WriteBatch) insertIntoAt(seq SequenceNumber, mems map[uint32]*MemTable) error {
+	inserter := &memTableInserter{
+		sequence: seq,
+		mems:     mems,
+	}
+	if err := wb.Iterate(inserter); err != nil {
+		return err
+	}
+	return inserter.err
+}
+
+type memTableInserter struct {
+	sequence SequenceNumber
+	mems     map[uint32]*MemTable
+	err      error // The first error of MemTable.Add
+}
+
+var _ WriteBatchHandler = (*memTableInserter)(nil)
+
+// add adds the entry to the memtable of its column family, with the next
+// sequence number.  The entries after a failed one are skipped.
+func (mi *memTableInserter) add(columnFamily uint32, t ValueType, key, value []byte) {
+	if mem, ok := mi.mems[columnFamily]; ok && mi.err == nil {
+		mi.err = mem.Add(mi.sequence, t, key, value)
+	}
+	mi.sequence++
+}
+
+func (mi *memTableInserter) Put(columnFamily uint32, key, value []byte) {
+	mi.add(columnFamily, ValueType_Value, key, value)
+}
+
+func (mi *memTableInserter) Delete(columnFamily uint32, key []byte) {
+	mi.add(columnFamily, ValueType_Deletion, key, []byte{})
+}
+
+func (mi *memTableInserter) PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64) {
+	mi.add(columnFamily, ValueType_ValueWithExpiry, key, appendExpiry(value, expiry))
+}
+
+func (mi *memTableInserter) Merge(columnFamily uint32, key, operand []byte) {
+	mi.add(columnFamily, ValueType_Merge, key, operand)
+}
+
+func (mi *memTableInserter) DeleteRange(columnFamily uint32, begin, end []byte) {
+	mi.add(columnFamily, ValueType_RangeDeletion, begin, end)
+}
+
+func (mi *memTableInserter) SingleDelete(columnFamily uint32, key []byte) {
+	mi.add(columnFamily, ValueType_SingleDeletion, key, []byte{})
+}
+
+// hasOnlyLevelDBRecords returns true if updates only has the Put and Delete
+// records of the C++ library.
+func hasOnlyLevelDBRecords(updates *WriteBatch) bool {
+	counter := &recordCounter{}
+	updates.Iterate(counter)
+	return counter.puts+counter.deletes == updates.Count()
+}
+
+// hasMerge returns true if updates has Merge records.
+func hasMerge(updates *WriteBatch) bool {
+	counter := &recordCounter{}
+	updates.Iterate(counter)
+	return counter.merges > 0
+}
+
+// hasRangeDeletion returns true if updates has DeleteRange records.
+func hasRangeDeletion(updates *WriteBatch) bool {
+	counter := &recordCounter{}
+	updates.Iterate(counter)
+	return counter.rangeDeletions > 0
+}
+
+// batchColumnFamilies returns the ids of the column families updates
+// writes to.
+func batchColumnFamilies(updates *WriteBatch) map[uint32]struct{} {
+	counter := &recordCounter{}
+	updates.Iterate(counter)
+	return counter.columnFamilies
+}
+
+// recordCounter counts the records of a WriteBatch by type, and collects
+// their column families.
+type recordCounter struct {
+	puts, deletes, putsWithExpiry, merges, rangeDeletions, singleDeletes int
+
+	columnFamilies map[uint32]struct{}
+}
+
+var _ WriteBatchHandler = (*recordCounter)(nil)
+
+func (rc *recordCounter) addColumnFamily(columnFamily uint32) {
+	if rc.columnFamilies == nil {
+		rc.columnFamilies = make(map[uint32]struct{})
+	}
+	rc.columnFamilies[columnFamily] = struct{}{}
+}
+
+func (rc *recordCounter) Put(columnFamily uint32, key, value []byte) {
+	rc.addColumnFamily(columnFamily)
+	rc.puts++
+}
+
+func (rc *recordCounter) Delete(columnFamily uint32, key []byte) {
+	rc.addColumnFamily(columnFamily)
+	rc.deletes++
+}
+
+func (rc *recordCounter) PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64) {
+	rc.addColumnFamily(columnFamily)
+	rc.putsWithExpiry++
+}
+
+func (rc *recordCounter) Merge(columnFamily uint32, key, operand []byte) {
+	rc.addColumnFamily(columnFamily)
+	rc.merges++
+}
+
+func (rc *recordCounter) DeleteRange(columnFamily uint32, begin, end []byte) {
+	rc.addColumnFamily(columnFamily)
+	rc.rangeDeletions++
+}
+
+func (rc *recordCounter) SingleDelete(columnFamily uint32, key []byte) {
+	rc.addColumnFamily(columnFamily)
+	rc.singleDeletes++
+}
+
+// withTimestamp returns a copy of the batch with ts appended to its keys.
+func (wb *WriteBatch) withTimestamp(ts []byte) (*WriteBatch, error) {
+	stamper := &timestampStamper{batch: NewWriteBatch(), timestamp: ts}
+	if err := wb.Iterate(stamper); err != nil {
+		return nil, err
+	}
+	return stamper.batch, nil
+}
+
+type timestampStamper struct {
+	batch     *WriteBatch
+	timestamp []byte
+	key       []byte
+}
+
+var _ WriteBatchHandler = (*timestampStamper)(nil)
+
+func (ts *timestampStamper) stamp(key []byte) []byte {
+	ts.key = append(append(ts.key[:0], key...), ts.timestamp...)
+	return ts.key
+}
+
+func (ts *timestampStamper) Put(columnFamily uint32, key, value []byte) {
+	ts.batch.addRecord(columnFamily, ValueType_Value, ts.stamp(key), value)
+}
+
+func (ts *timestampStamper) Delete(columnFamily uint32, key []byte) {
+	ts.batch.addRecord(columnFamily, ValueType_Deletion, ts.stamp(key))
+}
+
+func (ts *timestampStamper) PutWithExpiry(columnFamily uint32, key, value []byte, expiry uint64) {
+	ts.batch.addRecord(columnFamily, ValueType_ValueWithExpiry, ts.stamp(key), appendExpiry(value, expiry))
+}
+
+func (ts *timestampStamper) Merge(columnFamily uint32, key, operand []byte) {
+	ts.batch.addRecord(columnFamily, ValueType_Merge, ts.stamp(key), operand)
+}
+
+func (ts *timestampStamper) DeleteRange(columnFamily uint32, begin, end []byte) {
+	begin = append(append([]byte{}, begin...), ts.timestamp...)
+	ts.batch.addRecord(columnFamily, ValueType_RangeDeletion, begin, ts.stamp(end))
+}
+
+func (ts *timestampStamper) SingleDelete(columnFamily uint32, key []byte) {
+	ts.batch.addRecord(columnFamily, ValueType_SingleDeletion, ts.stamp(key))
+}
+
+// getLengthPrefixedSlice consumes a |size(var) + data| structure from input
+func getLengthPrefixedSlice(input *[]byte) ([]byte, bool) {
+	l, lsize := util.DecodeUvarint(*input)
+	if lsize <= 0 || uint64(len(*input)-lsize) < l {
+		return nil, false
+	}
+	result := (*input)[lsize : lsize+int(l)]
+	*input = (*input)[lsize+int(l):]
+	return result, true
+}
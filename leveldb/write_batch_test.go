@@ -0,0 +1,255 @@
+package leveldb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xufeisofly/leveldb-go/util"
+)
+
+func printContents(b *WriteBatch) string {
+	mem := NewMemTable(NewBytewiseComparator())
+	var state string
+	err := b.insertInto(map[uint32]*MemTable{kDefaultColumnFamily: mem})
+	var count int
+	iter := mem.NewIterator()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, perr := ParseInternalKey(iter.Key())
+		if perr != nil {
+			panic(perr)
+		}
+		switch ikey.Type {
+		case ValueType_Value:
+			state += fmt.Sprintf("Put(%s, %s)", ikey.UserKey, iter.Value())
+			count++
+		case ValueType_Deletion:
+			state += fmt.Sprintf("Delete(%s)", ikey.UserKey)
+			count++
+		case ValueType_Merge:
+			state += fmt.Sprintf("Merge(%s, %s)", ikey.UserKey, iter.Value())
+			count++
+		case ValueType_SingleDeletion:
+			state += fmt.Sprintf("SingleDelete(%s)", ikey.UserKey)
+			count++
+		case ValueType_ValueWithExpiry:
+			value := iter.Value()
+			n := len(value) - Uint64Size
+			state += fmt.Sprintf("PutWithExpiry(%s, %s, %d)", ikey.UserKey, value[:n],
+				util.DecodeUint64Fixed(value[n:]))
+			count++
+		}
+		state += fmt.Sprintf("@%d", ikey.Sequence)
+	}
+	rangeDelIter := mem.NewRangeTombstoneIterator()
+	for rangeDelIter.SeekToFirst(); rangeDelIter.Valid(); rangeDelIter.Next() {
+		ikey, perr := ParseInternalKey(rangeDelIter.Key())
+		if perr != nil {
+			panic(perr)
+		}
+		state += fmt.Sprintf("DeleteRange(%s, %s)@%d", ikey.UserKey, rangeDelIter.Value(), ikey.Sequence)
+		count++
+	}
+	if err != nil {
+		state += "ParseError()"
+	} else if count != b.Count() {
+		state += "CountMismatch()"
+	}
+	return state
+}
+
+func TestWriteBatch_Empty(t *testing.T) {
+	batch := NewWriteBatch()
+	assert.Equal(t, "", printContents(batch))
+	assert.Equal(t, 0, batch.Count())
+}
+
+func TestWriteBatch_Multiple(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("foo"), []byte("bar"))
+	batch.Delete([]byte("box"))
+	batch.Put([]byte("baz"), []byte("boo"))
+	batch.setSequence(100)
+	assert.Equal(t, SequenceNumber(100), batch.sequence())
+	assert.Equal(t, 3, batch.Count())
+	assert.Equal(t, "Put(baz, boo)@102"+
+		"Delete(box)@101"+
+		"Put(foo, bar)@100",
+		printContents(batch))
+}
+
+func TestWriteBatch_PutWithExpiry(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.PutWithExpiry([]byte("foo"), []byte("bar"), 12345)
+	batch.Put([]byte("baz"), []byte("boo"))
+	batch.setSequence(100)
+	assert.Equal(t, 2, batch.Count())
+	assert.Equal(t, "Put(baz, boo)@101"+
+		"PutWithExpiry(foo, bar, 12345)@100",
+		printContents(batch))
+}
+
+func TestWriteBatch_Merge(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("foo"), []byte("bar"))
+	batch.Merge([]byte("foo"), []byte("baz"))
+	batch.setSequence(100)
+	assert.Equal(t, 2, batch.Count())
+	assert.Equal(t, "Merge(foo, baz)@101"+
+		"Put(foo, bar)@100",
+		printContents(batch))
+}
+
+func TestWriteBatch_DeleteRange(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("foo"), []byte("bar"))
+	batch.DeleteRange([]byte("a"), []byte("g"))
+	batch.Delete([]byte("box"))
+	batch.setSequence(100)
+	assert.Equal(t, 3, batch.Count())
+	assert.Equal(t, "Delete(box)@102"+
+		"Put(foo, bar)@100"+
+		"DeleteRange(a, g)@101",
+		printContents(batch))
+}
+
+func TestWriteBatch_SingleDelete(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("foo"), []byte("bar"))
+	batch.SingleDelete([]byte("foo"))
+	batch.setSequence(100)
+	assert.Equal(t, 2, batch.Count())
+	assert.Equal(t, "SingleDelete(foo)@101"+
+		"Put(foo, bar)@100",
+		printContents(batch))
+}
+
+func TestWriteBatch_Corruption(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("foo"), []byte("bar"))
+	batch.Delete([]byte("box"))
+	batch.setSequence(200)
+	contents := batch.contents()
+	batch.setContents(contents[:len(contents)-1])
+	assert.Equal(t, "Put(foo, bar)@200"+
+		"ParseError()",
+		printContents(batch))
+}
+
+func TestWriteBatch_Append(t *testing.T) {
+	b1, b2 := NewWriteBatch(), NewWriteBatch()
+	b1.setSequence(200)
+	b2.setSequence(300)
+	b1.Append(b2)
+	assert.Equal(t, "", printContents(b1))
+	b2.Put([]byte("a"), []byte("va"))
+	b1.Append(b2)
+	assert.Equal(t, "Put(a, va)@200", printContents(b1))
+	b2.Clear()
+	b2.Put([]byte("b"), []byte("vb"))
+	b1.Append(b2)
+	assert.Equal(t, "Put(a, va)@200"+
+		"Put(b, vb)@201",
+		printContents(b1))
+	b2.Delete([]byte("foo"))
+	b1.Append(b2)
+	assert.Equal(t, "Put(a, va)@200"+
+		"Put(b, vb)@202"+
+		"Put(b, vb)@201"+
+		"Delete(foo)@203",
+		printContents(b1))
+}
+
+func TestWriteBatch_SavePoints(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.setSequence(100)
+	assert.True(t, batch.RollbackToSavePoint().(*LevelError).IsNotFound())
+
+	batch.Put([]byte("a"), []byte("va"))
+	batch.SetSavePoint()
+	batch.Put([]byte("b"), []byte("vb"))
+	batch.SetSavePoint()
+	batch.SetSavePoint()
+	batch.Delete([]byte("a"))
+	assert.NoError(t, batch.RollbackToSavePoint())
+	assert.Equal(t, "Put(a, va)@100"+
+		"Put(b, vb)@101",
+		printContents(batch))
+	assert.NoError(t, batch.RollbackToSavePoint())
+	assert.Equal(t, "Put(a, va)@100"+
+		"Put(b, vb)@101",
+		printContents(batch))
+	batch.Put([]byte("c"), []byte("vc"))
+	assert.NoError(t, batch.RollbackToSavePoint())
+	assert.Equal(t, "Put(a, va)@100", printContents(batch))
+	assert.True(t, batch.RollbackToSavePoint().(*LevelError).IsNotFound())
+
+	batch.SetSavePoint()
+	batch.Clear()
+	assert.True(t, batch.RollbackToSavePoint().(*LevelError).IsNotFound())
+}
+
+func TestWriteBatch_ApproximateSize(t *testing.T) {
+	batch := NewWriteBatch()
+	emptySize := batch.ApproximateSize()
+
+	batch.Put([]byte("foo"), []byte("bar"))
+	oneKeySize := batch.ApproximateSize()
+	assert.Less(t, emptySize, oneKeySize)
+
+	batch.Put([]byte("baz"), []byte("boo"))
+	twoKeysSize := batch.ApproximateSize()
+	assert.Less(t, oneKeySize, twoKeysSize)
+
+	batch.Delete([]byte("box"))
+	postDeleteSize := batch.ApproximateSize()
+	assert.Less(t, twoKeysSize, postDeleteSize)
+}
+
+// TestWriteBatch_ConcurrentInsert inserts batches with sequence numbers
+// assigned up front into a concurrent memtable from many goroutines.
+func TestWriteBatch_ConcurrentInsert(t *testing.T) {
+	writers := 8
+	batchesPerWriter := 100
+	entriesPerBatch := 10
+
+	mem := NewConcurrentMemTable(NewBytewiseComparator())
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batchesPerWriter; b++ {
+				batch := NewWriteBatch()
+				for e := 0; e < entriesPerBatch; e++ {
+					batch.Put([]byte(fmt.Sprintf("%02d-%04d-%02d", w, b, e)), []byte(fmt.Sprint(w)))
+				}
+				batch.setSequence(SequenceNumber(((w*batchesPerWriter)+b)*entriesPerBatch + 1))
+				assert.NoError(t, batch.insertInto(map[uint32]*MemTable{kDefaultColumnFamily: mem}))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var count int
+	var prev []byte
+	iter := mem.NewIterator()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if prev != nil {
+			assert.Less(t, string(ExtractUserKey(prev)), string(ExtractUserKey(iter.Key())))
+		}
+		prev = iter.Key()
+		count++
+	}
+	assert.Equal(t, writers*batchesPerWriter*entriesPerBatch, count)
+
+	for w := 0; w < writers; w++ {
+		lkey := NewLookupKey([]byte(fmt.Sprintf("%02d-%04d-%02d", w, 0, 0)), KMaxSequenceNumber)
+		ctx := newGetContext(mem.comparator, nil, lkey.UserKey(), KMaxSequenceNumber, 0)
+		assert.True(t, mem.Get(lkey, ctx))
+		value, err := ctx.result()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(w), string(value))
+	}
+}
@@ -0,0 +1,660 @@
+package leveldb
+
+import "github.com/xufeisofly/leveldb-go/util"
+
+// WriteBatchWithIndex is a WriteBatch indexed by key, so that the updates
+// it holds can be read back before it is written to the DB: the index is a
+// skiplist of the offsets of the records in the batch, ordered by column
+// family, key and offset.  GetFromBatchAndDB and NewIteratorWithBase see the
+// puts, deletions and merge operands of the batch over the state of the DB.
+//
+// Range deletions and entries with expiry are not supported.  The batch is
+// not safe for concurrent use.
+type WriteBatchWithIndex struct {
+	batch *WriteBatch
+	index *skiplist
+	arena *Arena
+	// Of the default column family
+	comparator    Comparator
+	mergeOperator MergeOperator
+	// The comparators of the other column families, taken from the handles
+	// the batch is written or read through
+	comparators map[uint32]Comparator
+}
+
+// NewWriteBatchWithIndex returns an empty batch for a DB opened with
+// options, whose comparator orders the keys of the default column family
+// and whose merge operator merges the operands of the batch.  A nil
+// comparator orders the keys bytewise, as in the DB.
+func NewWriteBatchWithIndex(options *Options) *WriteBatchWithIndex {
+	comparator := options.Comparator
+	if comparator == nil {
+		comparator = NewBytewiseComparator()
+	}
+	wb := &WriteBatchWithIndex{
+		batch:         NewWriteBatch(),
+		comparator:    comparator,
+		mergeOperator: options.MergeOperator,
+		comparators:   make(map[uint32]Comparator),
+	}
+	wb.resetIndex()
+	return wb
+}
+
+func (wb *WriteBatchWithIndex) resetIndex() {
+	wb.arena = NewArena()
+	wb.index = NewSkiplist(&batchIndexComparator{wb}, wb.arena)
+}
+
+// WriteBatch returns the batch of the updates, to write to the DB.  It
+// must not be modified directly.
+func (wb *WriteBatchWithIndex) WriteBatch() *WriteBatch {
+	return wb.batch
+}
+
+// Count returns the number of updates in the batch.
+func (wb *WriteBatchWithIndex) Count() int {
+	return wb.batch.Count()
+}
+
+// Clear removes all the updates of the batch.
+func (wb *WriteBatchWithIndex) Clear() {
+	wb.batch.Clear()
+	wb.resetIndex()
+}
+
+// Put stores the mapping "key->value" in the database.
+func (wb *WriteBatchWithIndex) Put(key, value []byte) {
+	wb.add(kDefaultColumnFamily, ValueType_Value, key, value)
+}
+
+// PutCF stores the mapping "key->value" in the column family cf.
+func (wb *WriteBatchWithIndex) PutCF(cf *ColumnFamilyHandle, key, value []byte) {
+	wb.addCF(cf, ValueType_Value, key, value)
+}
+
+// Delete erases the mapping for "key" if the database contains it.
+func (wb *WriteBatchWithIndex) Delete(key []byte) {
+	wb.add(kDefaultColumnFamily, ValueType_Deletion, key)
+}
+
+// DeleteCF erases the mapping for "key" if the column family cf contains it.
+func (wb *WriteBatchWithIndex) DeleteCF(cf *ColumnFamilyHandle, key []byte) {
+	wb.addCF(cf, ValueType_Deletion, key)
+}
+
+// SingleDelete erases the mapping for "key", written by a single Put, see
+// DB.SingleDelete.
+func (wb *WriteBatchWithIndex) SingleDelete(key []byte) {
+	wb.add(kDefaultColumnFamily, ValueType_SingleDeletion, key)
+}
+
+// SingleDeleteCF is SingleDelete in the column family cf.
+func (wb *WriteBatchWithIndex) SingleDeleteCF(cf *ColumnFamilyHandle, key []byte) {
+	wb.addCF(cf, ValueType_SingleDeletion, key)
+}
+
+// Merge merges "operand" into the mapping of "key" in the database.
+func (wb *WriteBatchWithIndex) Merge(key, operand []byte) {
+	wb.add(kDefaultColumnFamily, ValueType_Merge, key, operand)
+}
+
+// MergeCF merges "operand" into the mapping of "key" in the column family
+// cf.
+func (wb *WriteBatchWithIndex) MergeCF(cf *ColumnFamilyHandle, key, operand []byte) {
+	wb.addCF(cf, ValueType_Merge, key, operand)
+}
+
+func (wb *WriteBatchWithIndex) addCF(cf *ColumnFamilyHandle, t ValueType, key []byte, fields ...[]byte) {
+	wb.addComparator(cf)
+	wb.add(cf.ID(), t, key, fields...)
+}
+
+// addComparator records the comparator of the column family cf, before its
+// keys are written or looked up.  The one of the default column family is
+// the comparator of the options of the batch.
+func (wb *WriteBatchWithIndex) addComparator(cf *ColumnFamilyHandle) {
+	if cf.ID() != kDefaultColumnFamily {
+		wb.comparators[cf.ID()] = cf.cfd.icmp.comparator
+	}
+}
+
+// add appends the record to the batch and indexes it.
+func (wb *WriteBatchWithIndex) add(columnFamily uint32, t ValueType, key []byte, fields ...[]byte) {
+	offset := len(wb.batch.rep)
+	wb.batch.addRecord(columnFamily, t, append([][]byte{key}, fields...)...)
+	// The key follows the column family and the type of the record
+	if columnFamily != kDefaultColumnFamily {
+		offset += 1 + len(util.EncodeUvarint(uint64(columnFamily)))
+	}
+	offset++
+
+	entry := wb.arena.Allocate(kBatchIndexEntrySize)
+	copy(entry, util.EncodeUint32Fixed(columnFamily))
+	copy(entry[Uint32Size:], util.EncodeUint64Fixed(uint64(offset)))
+	wb.index.Insert(entry)
+}
+
+// columnFamilyComparator returns the comparator of the column family with id
+// columnFamily, which must have been added by addComparator unless it is the
+// default one.
+func (wb *WriteBatchWithIndex) columnFamilyComparator(columnFamily uint32) Comparator {
+	if columnFamily == kDefaultColumnFamily {
+		return wb.comparator
+	}
+	return wb.comparators[columnFamily]
+}
+
+// GetFromBatch returns the value of "key" made of the updates of the batch
+// alone.  It returns a Code_NotFound error if the batch has none or deletes
+// the key, and a Code_NotSupported error if its merge operands need the
+// value of the DB, see GetFromBatchAndDB.
+func (wb *WriteBatchWithIndex) GetFromBatch(key []byte) ([]byte, error) {
+	return wb.getFromBatch(kDefaultColumnFamily, key)
+}
+
+// GetFromBatchCF is GetFromBatch in the column family cf.
+func (wb *WriteBatchWithIndex) GetFromBatchCF(cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	wb.addComparator(cf)
+	return wb.getFromBatch(cf.ID(), key)
+}
+
+func (wb *WriteBatchWithIndex) getFromBatch(columnFamily uint32, key []byte) ([]byte, error) {
+	lookup := wb.lookup(columnFamily, key)
+	if lookup.state == batchEntryState_None {
+		if len(lookup.operands) == 0 {
+			return nil, Error(Code_NotFound, "")
+		}
+		return nil, Error(Code_NotSupported, "merge operands of the batch need the value of the DB")
+	}
+	return wb.resolve(key, &lookup, nil)
+}
+
+// GetFromBatchAndDB returns the value of "key" in db, as of options,
+// updated by the batch.
+func (wb *WriteBatchWithIndex) GetFromBatchAndDB(db *DB, options *ReadOptions, key []byte) ([]byte, error) {
+	return wb.GetFromBatchAndDBCF(db, options, db.defaultColumnFamily, key)
+}
+
+// GetFromBatchAndDBCF is GetFromBatchAndDB in the column family cf.
+func (wb *WriteBatchWithIndex) GetFromBatchAndDBCF(db *DB, options *ReadOptions, cf *ColumnFamilyHandle, key []byte) ([]byte, error) {
+	wb.addComparator(cf)
+	lookup := wb.lookup(cf.ID(), key)
+	if lookup.state != batchEntryState_None {
+		return wb.resolve(key, &lookup, nil)
+	}
+	value, err := db.GetCF(options, cf, key)
+	if len(lookup.operands) == 0 {
+		return value, err
+	}
+	if err != nil {
+		if !err.(*LevelError).IsNotFound() {
+			return nil, err
+		}
+		value = nil
+	}
+	return wb.resolve(key, &lookup, value)
+}
+
+// NewIteratorWithBase returns an iterator over the keys of base, an
+// iterator of the DB, updated by the batch: the keys the batch writes are
+// added, the ones it deletes are skipped.  The bounds of base do not apply
+// to the keys of the batch.  The batch must not be updated while the
+// iterator is in use.  Closing the iterator closes base.
+func (wb *WriteBatchWithIndex) NewIteratorWithBase(base Iterator) Iterator {
+	return wb.newIteratorWithBase(kDefaultColumnFamily, base)
+}
+
+// NewIteratorWithBaseCF is NewIteratorWithBase over the column family cf,
+// base iterates over it.
+func (wb *WriteBatchWithIndex) NewIteratorWithBaseCF(cf *ColumnFamilyHandle, base Iterator) Iterator {
+	wb.addComparator(cf)
+	return wb.newIteratorWithBase(cf.ID(), base)
+}
+
+func (wb *WriteBatchWithIndex) newIteratorWithBase(columnFamily uint32, base Iterator) Iterator {
+	return &baseDeltaIterator{
+		wb:         wb,
+		base:       base,
+		delta:      newBatchIndexCursor(wb, columnFamily),
+		comparator: wb.columnFamilyComparator(columnFamily),
+		forward:    true,
+	}
+}
+
+type batchEntryState int
+
+const (
+	batchEntryState_None    batchEntryState = iota // The batch has operands at most
+	batchEntryState_Value                          // On top of a value of the batch
+	batchEntryState_Deleted                        // On top of a deletion of the batch
+)
+
+// batchLookup is the state of a key made of the records of the batch.
+type batchLookup struct {
+	state    batchEntryState
+	value    []byte
+	operands [][]byte // The merge operands since the value or deletion, oldest first
+}
+
+// apply updates the state with the record of type t.
+func (l *batchLookup) apply(t ValueType, value []byte) {
+	switch t {
+	case ValueType_Value:
+		l.state = batchEntryState_Value
+		l.value = value
+		l.operands = nil
+	case ValueType_Deletion, ValueType_SingleDeletion:
+		l.state = batchEntryState_Deleted
+		l.value = nil
+		l.operands = nil
+	case ValueType_Merge:
+		l.operands = append(l.operands, value)
+	}
+}
+
+// hidden returns true if the batch deletes the key.
+func (l *batchLookup) hidden() bool {
+	return l.state == batchEntryState_Deleted && len(l.operands) == 0
+}
+
+// lookup returns the state of key in the column family with id
+// columnFamily made of the records of the batch.
+func (wb *WriteBatchWithIndex) lookup(columnFamily uint32, key []byte) batchLookup {
+	iter := NewSkiplistIterator(wb.index)
+	iter.Seek(batchIndexSearchEntry(columnFamily, key))
+	var lookup batchLookup
+	wb.scanGroup(iter, columnFamily, key, &lookup)
+	return lookup
+}
+
+// scanGroup applies the records of key from the one iter is at to lookup,
+// iter is left past them.
+func (wb *WriteBatchWithIndex) scanGroup(iter *skiplistIterator, columnFamily uint32, key []byte, lookup *batchLookup) {
+	ucmp := wb.columnFamilyComparator(columnFamily)
+	for ; iter.Valid(); iter.Next() {
+		entry := iter.Key()
+		if util.DecodeUint32Fixed(entry) != columnFamily || ucmp.Compare(wb.indexedKey(entry), key) != 0 {
+			break
+		}
+		t, value := wb.indexedRecord(entry)
+		lookup.apply(t, value)
+	}
+}
+
+// resolve returns the value of key made of lookup, over base if the batch
+// has merge operands only.
+func (wb *WriteBatchWithIndex) resolve(key []byte, lookup *batchLookup, base []byte) ([]byte, error) {
+	switch lookup.state {
+	case batchEntryState_Value:
+		base = lookup.value
+	case batchEntryState_Deleted:
+		base = nil
+	}
+	if len(lookup.operands) == 0 {
+		if lookup.state == batchEntryState_Deleted {
+			return nil, Error(Code_NotFound, "")
+		}
+		return append([]byte{}, base...), nil
+	}
+	if wb.mergeOperator == nil {
+		return nil, Error(Code_InvalidArgument, "merge operator not set")
+	}
+	return wb.mergeOperator.FullMerge(key, base, lookup.operands)
+}
+
+// The entries of the index are the fixed32 column family id of the record
+// followed by the fixed64 offset of its key in the batch.  The entries to
+// seek have offset 0, before all the records, followed by the key, or only
+// the column family id, before all its records.
+const kBatchIndexEntrySize = Uint32Size + Uint64Size
+
+func batchIndexSearchEntry(columnFamily uint32, key []byte) []byte {
+	entry := make([]byte, kBatchIndexEntrySize, kBatchIndexEntrySize+len(key))
+	copy(entry, util.EncodeUint32Fixed(columnFamily))
+	return append(entry, key...)
+}
+
+// indexedKey returns the key of the entry of the index.
+func (wb *WriteBatchWithIndex) indexedKey(entry []byte) []byte {
+	offset := util.DecodeUint64Fixed(entry[Uint32Size:])
+	if offset == 0 {
+		return entry[kBatchIndexEntrySize:]
+	}
+	key, _, _ := util.GetVarLengthPrefixedBytes(wb.batch.rep[offset:])
+	return key
+}
+
+// indexedRecord returns the type and the value of the record of the entry
+// of the index.
+func (wb *WriteBatchWithIndex) indexedRecord(entry []byte) (ValueType, []byte) {
+	offset := util.DecodeUint64Fixed(entry[Uint32Size:])
+	t := ValueType(wb.batch.rep[offset-1])
+	input := wb.batch.rep[offset:]
+	_, keyLen, keyLenSize := util.GetVarLengthPrefixedBytes(input)
+	if t != ValueType_Value && t != ValueType_Merge {
+		return t, nil
+	}
+	value, _, _ := util.GetVarLengthPrefixedBytes(input[keyLenSize+int(keyLen):])
+	return t, value
+}
+
+// batchIndexComparator orders the entries of the index of a batch by column
+// family, key with the comparator of the family, then offset in the batch.
+type batchIndexComparator struct {
+	wb *WriteBatchWithIndex
+}
+
+var _ Comparator = (*batchIndexComparator)(nil)
+
+func (c *batchIndexComparator) Compare(a, b []byte) int8 {
+	cfa, cfb := util.DecodeUint32Fixed(a), util.DecodeUint32Fixed(b)
+	if cfa != cfb {
+		if cfa < cfb {
+			return -1
+		}
+		return 1
+	}
+	// The entries of a column family id alone come first
+	if len(a) == Uint32Size || len(b) == Uint32Size {
+		if len(a) == len(b) {
+			return 0
+		}
+		if len(a) == Uint32Size {
+			return -1
+		}
+		return 1
+	}
+	if r := c.wb.columnFamilyComparator(cfa).Compare(c.wb.indexedKey(a), c.wb.indexedKey(b)); r != 0 {
+		return r
+	}
+	offsetA, offsetB := util.DecodeUint64Fixed(a[Uint32Size:]), util.DecodeUint64Fixed(b[Uint32Size:])
+	if offsetA < offsetB {
+		return -1
+	} else if offsetA > offsetB {
+		return 1
+	}
+	return 0
+}
+
+func (c *batchIndexComparator) Name() string {
+	return "leveldb.WriteBatchIndexComparator"
+}
+
+// index entries are never shortened
+func (c *batchIndexComparator) FindShortestSeparator(start *[]byte, limit []byte) error {
+	return nil
+}
+
+func (c *batchIndexComparator) FindShortSuccessor(key *[]byte) error {
+	return nil
+}
+
+// batchIndexCursor moves over the keys of a column family in the index of
+// a batch, each with the state the records of the batch make.
+type batchIndexCursor struct {
+	wb           *WriteBatchWithIndex
+	columnFamily uint32
+	iter         *skiplistIterator // At the first record of the key
+	end          skiplistIterator  // Past the records of the key
+	valid        bool
+	lookup       batchLookup
+}
+
+func newBatchIndexCursor(wb *WriteBatchWithIndex, columnFamily uint32) *batchIndexCursor {
+	return &batchIndexCursor{
+		wb:           wb,
+		columnFamily: columnFamily,
+		iter:         NewSkiplistIterator(wb.index),
+	}
+}
+
+func (c *batchIndexCursor) key() []byte {
+	return c.wb.indexedKey(c.iter.Key())
+}
+
+// settle makes the key of the record iter is at current, iter must be at
+// its first record.
+func (c *batchIndexCursor) settle() {
+	c.valid = c.iter.Valid() && util.DecodeUint32Fixed(c.iter.Key()) == c.columnFamily
+	c.lookup = batchLookup{}
+	if !c.valid {
+		return
+	}
+	c.end = *c.iter
+	c.wb.scanGroup(&c.end, c.columnFamily, c.key(), &c.lookup)
+}
+
+// settleBackward makes the key of the record iter is at current, iter may
+// be at any of its records.
+func (c *batchIndexCursor) settleBackward() {
+	if c.iter.Valid() && util.DecodeUint32Fixed(c.iter.Key()) == c.columnFamily {
+		c.iter.Seek(batchIndexSearchEntry(c.columnFamily, c.key()))
+	}
+	c.settle()
+}
+
+func (c *batchIndexCursor) seekToFirst() {
+	c.iter.Seek(util.EncodeUint32Fixed(c.columnFamily))
+	c.settle()
+}
+
+func (c *batchIndexCursor) seekToLast() {
+	c.seekBefore(util.EncodeUint32Fixed(c.columnFamily + 1))
+}
+
+// seek moves to the first key at or past target.
+func (c *batchIndexCursor) seek(target []byte) {
+	c.iter.Seek(batchIndexSearchEntry(c.columnFamily, target))
+	c.settle()
+}
+
+// seekBefore moves to the last key before the entry of the index target.
+func (c *batchIndexCursor) seekBefore(target []byte) {
+	c.iter.Seek(target)
+	if c.iter.Valid() {
+		c.iter.Prev()
+	} else {
+		c.iter.SeekToLast()
+	}
+	c.settleBackward()
+}
+
+func (c *batchIndexCursor) next() {
+	*c.iter = c.end
+	c.settle()
+}
+
+func (c *batchIndexCursor) prev() {
+	c.iter.Prev()
+	c.settleBackward()
+}
+
+// baseDeltaIterator merges the keys of a batch, the delta, over the ones of
+// a DB iterator, the base.  When both have the current key, the batch
+// decides its value.
+type baseDeltaIterator struct {
+	cleanupList
+	wb         *WriteBatchWithIndex
+	base       Iterator
+	delta      *batchIndexCursor
+	comparator Comparator
+
+	forward       bool
+	valid         bool
+	currentAtBase bool
+	equalKeys     bool   // base and delta are at the current key
+	value         []byte // Of the current key of the delta
+	err           error
+}
+
+var _ Iterator = (*baseDeltaIterator)(nil)
+
+func (it *baseDeltaIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *baseDeltaIterator) Key() []byte {
+	if it.currentAtBase {
+		return it.base.Key()
+	}
+	return it.delta.key()
+}
+
+func (it *baseDeltaIterator) Value() []byte {
+	if it.currentAtBase {
+		return it.base.Value()
+	}
+	return it.value
+}
+
+func (it *baseDeltaIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.base.Error()
+}
+
+func (it *baseDeltaIterator) Close() error {
+	err := it.base.Close()
+	it.runCleanups()
+	return err
+}
+
+func (it *baseDeltaIterator) SeekToFirst() {
+	it.forward = true
+	it.base.SeekToFirst()
+	it.delta.seekToFirst()
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) SeekToLast() {
+	it.forward = false
+	it.base.SeekToLast()
+	it.delta.seekToLast()
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) Seek(target []byte) {
+	it.forward = true
+	it.base.Seek(target)
+	it.delta.seek(target)
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) Next() {
+	if !it.valid {
+		panic("baseDeltaIterator is not valid")
+	}
+	if !it.forward {
+		// Move both past the current key
+		key := append([]byte{}, it.Key()...)
+		it.forward = true
+		it.base.Seek(key)
+		if it.base.Valid() && it.comparator.Compare(it.base.Key(), key) == 0 {
+			it.base.Next()
+		}
+		it.delta.seek(key)
+		if it.delta.valid && it.comparator.Compare(it.delta.key(), key) == 0 {
+			it.delta.next()
+		}
+	} else {
+		it.advance()
+	}
+	it.updateCurrent()
+}
+
+func (it *baseDeltaIterator) Prev() {
+	if !it.valid {
+		panic("baseDeltaIterator is not valid")
+	}
+	if it.forward {
+		// Move both before the current key
+		key := append([]byte{}, it.Key()...)
+		it.forward = false
+		it.base.Seek(key)
+		if it.base.Valid() {
+			it.base.Prev()
+		} else {
+			it.base.SeekToLast()
+		}
+		it.delta.seekBefore(batchIndexSearchEntry(it.delta.columnFamily, key))
+	} else {
+		it.advance()
+	}
+	it.updateCurrent()
+}
+
+// advance moves the iterators at the current key in the direction of the
+// iteration.
+func (it *baseDeltaIterator) advance() {
+	if it.equalKeys || it.currentAtBase {
+		it.advanceBase()
+	}
+	if it.equalKeys || !it.currentAtBase {
+		it.advanceDelta()
+	}
+}
+
+func (it *baseDeltaIterator) advanceBase() {
+	if it.forward {
+		it.base.Next()
+	} else {
+		it.base.Prev()
+	}
+}
+
+func (it *baseDeltaIterator) advanceDelta() {
+	if it.forward {
+		it.delta.next()
+	} else {
+		it.delta.prev()
+	}
+}
+
+// updateCurrent makes the first key of base and delta in the direction of
+// the iteration current, skipping the keys the batch deletes.
+func (it *baseDeltaIterator) updateCurrent() {
+	it.value = nil
+	for {
+		it.equalKeys = false
+		if it.err != nil || it.base.Error() != nil {
+			it.valid = false
+			return
+		}
+		if !it.delta.valid {
+			it.currentAtBase = true
+			it.valid = it.base.Valid()
+			return
+		}
+		if it.base.Valid() {
+			r := it.comparator.Compare(it.delta.key(), it.base.Key())
+			if !it.forward {
+				r = -r
+			}
+			if r > 0 {
+				it.currentAtBase = true
+				it.valid = true
+				return
+			}
+			it.equalKeys = r == 0
+		}
+
+		lookup := &it.delta.lookup
+		if lookup.hidden() {
+			if it.equalKeys {
+				it.advanceBase()
+			}
+			it.advanceDelta()
+			continue
+		}
+		var base []byte
+		if it.equalKeys {
+			base = it.base.Value()
+		}
+		it.value, it.err = it.wb.resolve(it.delta.key(), lookup, base)
+		it.currentAtBase = false
+		it.valid = it.err == nil
+		return
+	}
+}
@@ -0,0 +1,322 @@
+package leveldb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchGet(t *testing.T, wb *WriteBatchWithIndex, key string) string {
+	value, err := wb.GetFromBatch([]byte(key))
+	if err != nil {
+		if err.(*LevelError).IsNotSupported() {
+			return "MERGE"
+		}
+		assert.True(t, err.(*LevelError).IsNotFound())
+		return "NOT_FOUND"
+	}
+	return string(value)
+}
+
+func batchAndDBGet(t *testing.T, wb *WriteBatchWithIndex, db *DB, key string) string {
+	value, err := wb.GetFromBatchAndDB(db, DefaultReadOptions, []byte(key))
+	if err != nil {
+		assert.True(t, err.(*LevelError).IsNotFound())
+		return "NOT_FOUND"
+	}
+	return string(value)
+}
+
+func TestWriteBatchWithIndex_GetFromBatch(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	wb := NewWriteBatchWithIndex(options)
+	assert.Equal(t, "NOT_FOUND", batchGet(t, wb, "a"))
+
+	wb.Put([]byte("a"), []byte("v1"))
+	wb.Put([]byte("b"), []byte("v1"))
+	wb.Put([]byte("a"), []byte("v2"))
+	wb.Delete([]byte("b"))
+	wb.Merge([]byte("c"), []byte("m1"))
+	wb.Put([]byte("d"), []byte("v1"))
+	wb.Merge([]byte("d"), []byte("m1"))
+	wb.Merge([]byte("d"), []byte("m2"))
+	wb.SingleDelete([]byte("e"))
+	wb.Merge([]byte("e"), []byte("m1"))
+	assert.Equal(t, 10, wb.Count())
+
+	assert.Equal(t, "v2", batchGet(t, wb, "a"))
+	assert.Equal(t, "NOT_FOUND", batchGet(t, wb, "b"))
+	assert.Equal(t, "MERGE", batchGet(t, wb, "c"))
+	assert.Equal(t, "v1,m1,m2", batchGet(t, wb, "d"))
+	assert.Equal(t, "m1", batchGet(t, wb, "e"))
+	assert.Equal(t, "NOT_FOUND", batchGet(t, wb, "f"))
+	assert.Equal(t, "Put(a, v2)@2"+
+		"Put(a, v1)@0"+
+		"Delete(b)@3"+
+		"Put(b, v1)@1"+
+		"Merge(c, m1)@4"+
+		"Merge(d, m2)@7"+
+		"Merge(d, m1)@6"+
+		"Put(d, v1)@5"+
+		"Merge(e, m1)@9"+
+		"SingleDelete(e)@8",
+		printContents(wb.WriteBatch()))
+
+	wb.Clear()
+	assert.Equal(t, 0, wb.Count())
+	assert.Equal(t, "NOT_FOUND", batchGet(t, wb, "a"))
+	wb.Put([]byte("a"), []byte("v3"))
+	assert.Equal(t, "v3", batchGet(t, wb, "a"))
+}
+
+func TestWriteBatchWithIndex_GetFromBatchAndDB(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	db := openTestDB(t, options)
+	defer db.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte("db")))
+	}
+
+	wb := NewWriteBatchWithIndex(options)
+	wb.Put([]byte("a"), []byte("batch"))
+	wb.Delete([]byte("b"))
+	wb.Merge([]byte("c"), []byte("m1"))
+	wb.Merge([]byte("e"), []byte("m1"))
+	wb.Put([]byte("f"), []byte("batch"))
+	check := func() {
+		assert.Equal(t, "batch", batchAndDBGet(t, wb, db, "a"))
+		assert.Equal(t, "NOT_FOUND", batchAndDBGet(t, wb, db, "b"))
+		assert.Equal(t, "db,m1", batchAndDBGet(t, wb, db, "c"))
+		assert.Equal(t, "db", batchAndDBGet(t, wb, db, "d"))
+		assert.Equal(t, "m1", batchAndDBGet(t, wb, db, "e"))
+		assert.Equal(t, "batch", batchAndDBGet(t, wb, db, "f"))
+		assert.Equal(t, "NOT_FOUND", batchAndDBGet(t, wb, db, "g"))
+	}
+	check()
+	assert.Equal(t, "db", dbGet(t, db, "b"))
+
+	// The DB reads the same once the batch is written
+	assert.NoError(t, db.Write(DefaultWriteOptions, wb.WriteBatch()))
+	wb.Clear()
+	check()
+}
+
+func TestWriteBatchWithIndex_Iterator(t *testing.T) {
+	options := newTestOptions()
+	options.MergeOperator = NewStringAppendOperator([]byte(","))
+	db := openTestDB(t, options)
+	defer db.Close()
+	for _, key := range []string{"a", "c", "e", "g"} {
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(key), []byte("db")))
+	}
+
+	wb := NewWriteBatchWithIndex(options)
+	iter := wb.NewIteratorWithBase(db.NewIterator(DefaultReadOptions))
+	assert.Equal(t, []string{"a->db", "c->db", "e->db", "g->db"}, collectIterator(iter, false))
+	assert.NoError(t, iter.Close())
+
+	wb.Put([]byte("0"), []byte("batch"))
+	wb.Put([]byte("b"), []byte("batch"))
+	wb.Put([]byte("c"), []byte("batch"))
+	wb.Delete([]byte("e"))
+	wb.Delete([]byte("f"))
+	wb.Merge([]byte("g"), []byte("m1"))
+	wb.Merge([]byte("h"), []byte("m1"))
+	want := []string{"0->batch", "a->db", "b->batch", "c->batch", "g->db,m1", "h->m1"}
+	iter = wb.NewIteratorWithBase(db.NewIterator(DefaultReadOptions))
+	assert.Equal(t, want, collectIterator(iter, false))
+	var reversed []string
+	for i := len(want) - 1; i >= 0; i-- {
+		reversed = append(reversed, want[i])
+	}
+	assert.Equal(t, reversed, collectIterator(iter, true))
+
+	// Seeks and changes of direction
+	iter.Seek([]byte("d"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "g", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "c", string(iter.Key()))
+	assert.Equal(t, "batch", string(iter.Value()))
+	iter.Prev()
+	assert.Equal(t, "b", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "c", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "g", string(iter.Key()))
+	iter.Seek([]byte("e"))
+	assert.Equal(t, "g", string(iter.Key()))
+	iter.Seek([]byte("i"))
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Error())
+	assert.NoError(t, iter.Close())
+
+	// Without a merge operator the merges fail the iterator
+	wb = NewWriteBatchWithIndex(newTestOptions())
+	wb.Merge([]byte("g"), []byte("m1"))
+	iter = wb.NewIteratorWithBase(db.NewIterator(DefaultReadOptions))
+	assert.Equal(t, []string{"a->db", "c->db", "e->db"}, collectIterator(iter, false))
+	assert.True(t, iter.Error().(*LevelError).IsInvalidArgument())
+	assert.NoError(t, iter.Close())
+}
+
+func TestWriteBatchWithIndex_ColumnFamilies(t *testing.T) {
+	options := newTestOptions()
+	db := openTestDB(t, options)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily(&ColumnFamilyOptions{Comparator: NewReverseBytewiseComparator()}, "reverse")
+	assert.NoError(t, err)
+	assert.NoError(t, db.PutCF(DefaultWriteOptions, cf, []byte("b"), []byte("db")))
+	assert.NoError(t, db.PutCF(DefaultWriteOptions, cf, []byte("d"), []byte("db")))
+
+	wb := NewWriteBatchWithIndex(options)
+	wb.Put([]byte("a"), []byte("default"))
+	wb.PutCF(cf, []byte("a"), []byte("batch"))
+	wb.PutCF(cf, []byte("c"), []byte("batch"))
+	wb.DeleteCF(cf, []byte("d"))
+
+	value, err := wb.GetFromBatchCF(cf, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "batch", string(value))
+	value, err = wb.GetFromBatchAndDBCF(db, DefaultReadOptions, cf, []byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "db", string(value))
+	_, err = wb.GetFromBatchAndDBCF(db, DefaultReadOptions, cf, []byte("d"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+	assert.Equal(t, "default", batchAndDBGet(t, wb, db, "a"))
+
+	iter := wb.NewIteratorWithBaseCF(cf, db.NewIteratorCF(DefaultReadOptions, cf))
+	assert.Equal(t, []string{"c->batch", "b->db", "a->batch"}, collectIterator(iter, false))
+	assert.Equal(t, []string{"a->batch", "b->db", "c->batch"}, collectIterator(iter, true))
+	assert.NoError(t, iter.Close())
+	iter = wb.NewIteratorWithBase(db.NewIterator(DefaultReadOptions))
+	assert.Equal(t, []string{"a->default"}, collectIterator(iter, false))
+	assert.NoError(t, iter.Close())
+}
+
+func TestWriteBatchWithIndex_NilComparator(t *testing.T) {
+	// The keys are ordered bytewise, as in a DB opened with the options
+	wb := NewWriteBatchWithIndex(&Options{})
+	wb.Put([]byte("b"), []byte("batch"))
+	wb.Put([]byte("a"), []byte("batch"))
+	wb.Delete([]byte("b"))
+
+	assert.Equal(t, "batch", batchGet(t, wb, "a"))
+	_, err := wb.GetFromBatch([]byte("b"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+	iter := wb.NewIteratorWithBase(NewEmptyIterator())
+	assert.Equal(t, []string{"a->batch"}, collectIterator(iter, false))
+	assert.NoError(t, iter.Close())
+}
+
+// TestWriteBatchWithIndex_ReadColumnFamily checks that the keys of a column
+// family the batch holds no update of are ordered by its own comparator.
+func TestWriteBatchWithIndex_ReadColumnFamily(t *testing.T) {
+	options := newTestOptions()
+	db := openTestDB(t, options)
+	defer db.Close()
+	cf, err := db.CreateColumnFamily(&ColumnFamilyOptions{Comparator: NewReverseBytewiseComparator()}, "reverse")
+	assert.NoError(t, err)
+	for _, k := range []string{"b", "d", "f"} {
+		assert.NoError(t, db.PutCF(DefaultWriteOptions, cf, []byte(k), []byte("db")))
+	}
+
+	wb := NewWriteBatchWithIndex(options)
+	wb.Put([]byte("c"), []byte("default"))
+	_, err = wb.GetFromBatchCF(cf, []byte("b"))
+	assert.True(t, err.(*LevelError).IsNotFound())
+	value, err := wb.GetFromBatchAndDBCF(db, DefaultReadOptions, cf, []byte("d"))
+	assert.NoError(t, err)
+	assert.Equal(t, "db", string(value))
+	assert.Equal(t, cf.cfd.icmp.comparator, wb.columnFamilyComparator(cf.ID()))
+
+	iter := wb.NewIteratorWithBaseCF(cf, db.NewIteratorCF(DefaultReadOptions, cf))
+	assert.Equal(t, []string{"f->db", "d->db", "b->db"}, collectIterator(iter, false))
+	assert.Equal(t, []string{"b->db", "d->db", "f->db"}, collectIterator(iter, true))
+	iter.Seek([]byte("d"))
+	assert.Equal(t, "d", string(iter.Key()))
+	iter.Prev()
+	assert.Equal(t, "f", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "d", string(iter.Key()))
+	assert.NoError(t, iter.Close())
+
+	// Updates of the family written later are ordered alike
+	wb.PutCF(cf, []byte("e"), []byte("batch"))
+	iter = wb.NewIteratorWithBaseCF(cf, db.NewIteratorCF(DefaultReadOptions, cf))
+	assert.Equal(t, []string{"f->db", "e->batch", "d->db", "b->db"}, collectIterator(iter, false))
+	assert.NoError(t, iter.Close())
+}
+
+// TestWriteBatchWithIndex_Random checks the iterator against a model of the
+// DB updated by the batch, moving in random directions.
+func TestWriteBatchWithIndex_Random(t *testing.T) {
+	options := newTestOptions()
+	db := openTestDB(t, options)
+	defer db.Close()
+	rnd := rand.New(rand.NewSource(301))
+	key := func() string {
+		return fmt.Sprintf("%02d", rnd.Intn(40))
+	}
+
+	model := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		k := key()
+		assert.NoError(t, db.Put(DefaultWriteOptions, []byte(k), []byte("db"+k)))
+		model[k] = "db" + k
+	}
+	wb := NewWriteBatchWithIndex(options)
+	for i := 0; i < 60; i++ {
+		k := key()
+		if rnd.Intn(3) == 0 {
+			wb.Delete([]byte(k))
+			delete(model, k)
+		} else {
+			v := fmt.Sprintf("batch%d", i)
+			wb.Put([]byte(k), []byte(v))
+			model[k] = v
+		}
+	}
+	var keys []string
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	iter := wb.NewIteratorWithBase(db.NewIterator(DefaultReadOptions))
+	defer iter.Close()
+	pos := -1 // In keys, -1 or len(keys) if the iterator is not valid
+	for i := 0; i < 1000; i++ {
+		switch op := rnd.Intn(5); {
+		case op == 0:
+			iter.SeekToFirst()
+			pos = 0
+		case op == 1:
+			iter.SeekToLast()
+			pos = len(keys) - 1
+		case op == 2:
+			target := key()
+			iter.Seek([]byte(target))
+			pos = sort.SearchStrings(keys, target)
+		case op == 3 && pos >= 0 && pos < len(keys):
+			iter.Next()
+			pos++
+		case op == 4 && pos >= 0 && pos < len(keys):
+			iter.Prev()
+			pos--
+		}
+		if pos < 0 || pos >= len(keys) {
+			assert.False(t, iter.Valid())
+			continue
+		}
+		assert.True(t, iter.Valid())
+		assert.Equal(t, keys[pos], string(iter.Key()))
+		assert.Equal(t, model[keys[pos]], string(iter.Value()))
+	}
+	assert.NoError(t, iter.Error())
+}
@@ -53,6 +53,27 @@ func PutUint64Fixed(buf *[]byte, v uint64) {
 	*buf = append(*buf, EncodeUint64Fixed(v)...)
 }
 
+func EncodeUint32Fixed(v uint32) []byte {
+	bs := make([]byte, unsafe.Sizeof(v))
+	binary.BigEndian.PutUint32(bs, v)
+	return bs
+}
+
+func DecodeUint32Fixed(bs []byte) uint32 {
+	return binary.BigEndian.Uint32(bs)
+}
+
+// PutUint32Fixed puts encoded fixed uint32 into buffer
+func PutUint32Fixed(buf *[]byte, v uint32) {
+	*buf = append(*buf, EncodeUint32Fixed(v)...)
+}
+
+// PutVarLengthPrefixedBytes puts |size(var) + data| structure into buffer
+func PutVarLengthPrefixedBytes(buf *[]byte, v []byte) {
+	PutUvarint(buf, uint64(len(v)))
+	*buf = append(*buf, v...)
+}
+
 type Signed interface {
 	~int | ~int8 | ~int16 | ~int32 | ~int64
 }
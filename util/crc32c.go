@@ -0,0 +1,35 @@
+package util
+
+import "hash/crc32"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32CValue returns the crc32c of data
+func CRC32CValue(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// CRC32CExtend returns the crc32c of concat(A, data) where initCrc is the
+// crc32c of some string A.  Extend() is often used to maintain the
+// crc32c of a stream of data.
+func CRC32CExtend(initCrc uint32, data []byte) uint32 {
+	return crc32.Update(initCrc, crc32cTable, data)
+}
+
+const kMaskDelta = 0xa282ead8
+
+// CRC32CMask returns a masked representation of crc.
+//
+// Motivation: it is problematic to compute the CRC of a string that
+// contains embedded CRCs.  Therefore we recommend that CRCs stored
+// somewhere (e.g., in files) should be masked before being stored.
+func CRC32CMask(crc uint32) uint32 {
+	// Rotate right by 15 bits and add a constant.
+	return ((crc >> 15) | (crc << 17)) + kMaskDelta
+}
+
+// CRC32CUnmask returns the crc whose masked representation is maskedCrc.
+func CRC32CUnmask(maskedCrc uint32) uint32 {
+	rot := maskedCrc - kMaskDelta
+	return (rot >> 17) | (rot << 15)
+}
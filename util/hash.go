@@ -52,3 +52,32 @@ func Hash(data []byte, seed uint32) (h uint32) {
 
 	return
 }
+
+// LevelDBHash is the hash of the C++ LevelDB library, a variant of
+// MurmurHash its bloom filters are built with.
+func LevelDBHash(data []byte, seed uint32) uint32 {
+	h := seed ^ (uint32(len(data)) * M)
+
+	// Pick up four bytes at a time
+	for ; len(data) >= 4; data = data[4:] {
+		w := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		h += w
+		h *= M
+		h ^= h >> 16
+	}
+
+	// Pick up remaining bytes
+	switch len(data) {
+	case 3:
+		h += uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(data[0])
+		h *= M
+		h ^= h >> R
+	}
+	return h
+}
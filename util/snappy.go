@@ -0,0 +1,87 @@
+package util
+
+import "errors"
+
+var ErrSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// SnappyDecode returns the decompressed form of the snappy block src, the
+// block compression of the C++ LevelDB library.
+//
+// The format is the varint length of the decompressed data followed by
+// elements, each a literal or a copy of earlier output:
+//
+//	tag & 3 == 0: literal of (tag >> 2) + 1 bytes, or for (tag >> 2) >= 60
+//	              of 1 + the (tag >> 2) - 59 next bytes as a little-endian
+//	              length
+//	tag & 3 == 1: copy of 4 + ((tag >> 2) & 7) bytes at offset
+//	              (tag >> 5) << 8 | next byte
+//	tag & 3 == 2: copy of (tag >> 2) + 1 bytes at a 2-byte offset
+//	tag & 3 == 3: copy of (tag >> 2) + 1 bytes at a 4-byte offset
+func SnappyDecode(src []byte) ([]byte, error) {
+	dLen, n := DecodeUvarint(src)
+	if n <= 0 || dLen > 0xffffffff {
+		return nil, ErrSnappyCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, dLen)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				w := length - 59
+				if len(src) < w {
+					return nil, ErrSnappyCorrupt
+				}
+				length = 0
+				for i := w - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[w:]
+			}
+			length++
+			if length > len(src) || len(dst)+length > int(dLen) {
+				return nil, ErrSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(src[1]) | int(src[2])<<8
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, ErrSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(src[1]) | int(src[2])<<8 | int(src[3])<<16 | int(src[4])<<24
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(dLen) {
+			return nil, ErrSnappyCorrupt
+		}
+		// The copy may overlap its own output, copy byte by byte
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(dLen) {
+		return nil, ErrSnappyCorrupt
+	}
+	return dst, nil
+}